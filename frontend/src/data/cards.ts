@@ -1,3 +1,61 @@
+export type RateLimit = {
+  rpm?: number // 每分钟请求数（0 = 不限制）
+  inputTpm?: number // 每分钟输入 tokens（0 = 不限制）
+}
+
+// APIKeyEntry key 池中的一个 API Key（与后端 services.APIKeyEntry 对应）
+export type APIKeyEntry = {
+  label: string
+  key: string
+  weight?: number
+  enabled: boolean
+}
+
+// BodyRule 请求体改写规则（与后端 services.BodyRule 对应）
+export type BodyRule = {
+  op: 'remove' | 'set' | 'rename' | 'default' | 'filter_header'
+  path: string // JSON 路径（# 表示数组每个元素）；filter_header 时为 Header 名
+  to?: string // rename 的目标路径
+  value?: unknown // set / default 的值
+  items?: string[] // filter_header 要移除的值（支持 * 通配符）
+}
+
+// BalanceProbe 余额查询配置（与后端 services.BalanceProbe 对应）
+export type BalanceProbe = {
+  enabled: boolean
+  url: string // 余额接口地址，以 / 开头时拼接在 apiUrl 之后
+  method?: 'GET' | 'POST'
+  authType?: string // bearer（默认）/ x-api-key / none / 自定义 Header 名
+  token?: string // 认证凭据（留空使用 apiKey）
+  headers?: Record<string, string>
+  body?: string
+  balancePath: string // 剩余额度的 gjson 路径
+  currencyPath?: string
+  currency?: string
+  divisor?: number // 额度换算除数（如 new-api 的 quota 以 500000 为 $1）
+  minBalance?: number // 余额低于该值时跳过该供应商（0 只记录）
+}
+
+// NetworkConfig 出站网络配置（与后端 services.NetworkConfig 对应）
+export type NetworkConfig = {
+  proxyUrl?: string // http:// https:// socks5:// socks5h://
+  proxyUsername?: string
+  proxyPassword?: string
+  caCert?: string // 额外信任的 CA（PEM 内容或文件路径）
+  clientCert?: string // 客户端证书（PEM 内容或文件路径）
+  clientKey?: string
+  serverName?: string // 覆盖 TLS SNI
+  resolveIp?: string // 固定连接的 IP
+}
+
+// TimeoutConfig 上游超时（秒，与后端 services.TimeoutConfig 对应，0 = 使用默认值）
+export type TimeoutConfig = {
+  connectSeconds?: number // 建立连接超时（默认 30）
+  firstByteSeconds?: number // 首字节超时（流式为首个内容事件）
+  idleSeconds?: number // 流式响应两次数据之间的最大间隔
+  totalSeconds?: number // 单次请求总超时
+}
+
 export type AutomationCard = {
   id: number
   name: string
@@ -12,10 +70,38 @@ export type AutomationCard = {
   supportedModels?: Record<string, boolean>
   // 模型映射：external model -> internal model
   modelMapping?: Record<string, string>
+  // 响应模型名还原：将响应中的 model 改写回客户端请求的模型名
+  rewriteResponseModel?: boolean
   // 优先级分组：数字越小优先级越高（1-10，默认 1）
   level?: number
+  // 负载均衡权重：同 Level 使用加权随机 / 最少并发策略时按比例分配请求（默认 1）
+  weight?: number
+  // 价格倍率：相对官方定价的折扣（如 0.3 表示三折），成本优先策略据此估算费用（默认 1）
+  priceMultiplier?: number
+  // 并发限制：最大并发数（0 不限制）、并发已满时的排队长度（0 直接切换）、排队超时秒数（默认 30）
+  maxConcurrency?: number
+  concurrencyQueueSize?: number
+  concurrencyQueueTimeout?: number
+  // 本地限流：按 provider + 实际模型的每分钟请求数与输入 tokens，额度用尽时切换到下一个 provider
+  rateLimit?: RateLimit
+  // 上下文窗口：供应商实际支持的最大输入 tokens（0 按内置模型表），估算输入超出时跳过该供应商
+  maxInputTokens?: number
+  // 请求体改写规则：按顺序执行，在模型映射之后发送之前应用
+  bodyRules?: BodyRule[]
+  // key 池：多个 key 按权重轮换，单个 key 失效或限流时只暂停该 key
+  apiKeys?: APIKeyEntry[]
+  // 出站网络：代理、额外 CA、客户端证书、SNI 覆盖、固定 IP（每种配置使用独立连接池）
+  network?: NetworkConfig
+  // 上游超时：连接、首字节、流式空闲间隔与总超时，流式响应中途停滞时中断并切换
+  timeouts?: TimeoutConfig
+  // 余额查询：定期请求中转站余额接口并记录历史，余额低于 minBalance 时跳过该供应商
+  balanceProbe?: BalanceProbe
+  // 标签：供路由规则按标签选择供应商（如 long-context、cheap）
+  tags?: string[]
   // API 端点路径（可选）：覆盖平台默认端点
   apiEndpoint?: string
+  // 上游协议格式（可选）：anthropic / openai-chat / openai-responses，留空自动判断
+  wireFormat?: string
   // CLI 配置：存储供应商关联的 CLI 可编辑配置
   cliConfig?: Record<string, any>
 
@@ -8,6 +8,32 @@ export type AppSettings = {
   auto_connectivity_test: boolean
   enable_switch_notify: boolean // 供应商切换通知开关
   enable_round_robin: boolean   // 同 Level 轮询负载均衡开关
+  enable_hedging: boolean       // 对冲请求开关
+  hedge_delay_ms: number        // 对冲延迟（毫秒）
+  enable_stream_keepalive: boolean // 流式保活开关
+  stream_keepalive_seconds: number // 保活间隔（秒）
+  level_strategies?: Record<string, string> // 各 Level 负载均衡策略
+  error_policies?: Record<string, ErrorClassPolicy> // 各错误分类的切换与处罚策略
+}
+
+// 错误分类：client / auth / ratelimit / overload / server / network / stall
+export const ERROR_CLASSES = ['client', 'auth', 'ratelimit', 'overload', 'server', 'network', 'stall'] as const
+export type ErrorClass = (typeof ERROR_CLASSES)[number]
+
+export type ErrorClassPolicy = {
+  failover: boolean                       // 是否切换到其他 provider 重试
+  penalty: 'none' | 'count' | 'cooldown'  // 不处罚 / 计入失败次数 / 冷却
+}
+
+// 与后端 DefaultErrorPolicies 保持一致
+export const DEFAULT_ERROR_POLICIES: Record<ErrorClass, ErrorClassPolicy> = {
+  client: { failover: false, penalty: 'none' },
+  auth: { failover: true, penalty: 'count' },
+  ratelimit: { failover: true, penalty: 'cooldown' },
+  overload: { failover: true, penalty: 'cooldown' },
+  server: { failover: true, penalty: 'count' },
+  network: { failover: true, penalty: 'count' },
+  stall: { failover: true, penalty: 'count' },
 }
 
 const DEFAULT_SETTINGS: AppSettings = {
@@ -18,6 +44,12 @@ const DEFAULT_SETTINGS: AppSettings = {
   auto_connectivity_test: false,
   enable_switch_notify: true,  // 默认开启
   enable_round_robin: false,   // 默认关闭轮询
+  enable_hedging: false,       // 默认关闭对冲
+  hedge_delay_ms: 5000,
+  enable_stream_keepalive: false, // 默认关闭流式保活
+  stream_keepalive_seconds: 15,
+  level_strategies: {},
+  error_policies: {},
 }
 
 export const fetchAppSettings = async (): Promise<AppSettings> => {
@@ -15,6 +15,17 @@ export interface BlacklistStatus {
   blacklistLevel: number          // 当前黑名单等级 (0-5)
   lastRecoveredAt?: string        // 最后恢复时间（ISO 时间字符串）
   forgivenessRemaining: number    // 距离宽恕还剩多少秒（3小时倒计时）
+
+  // 上游 429 / 529 冷却（按 Retry-After 计时，不增加失败计数和拉黑等级）
+  cooldowns?: CooldownStatus[]
+}
+
+// 冷却状态接口
+export interface CooldownStatus {
+  model: string             // 为空表示整个 provider 冷却
+  statusCode: number        // 触发冷却的上游状态码（429 / 529 / 503）
+  until: string             // ISO 时间字符串
+  remainingSeconds: number  // 剩余冷却时间（秒）
 }
 
 // 黑名单配置接口
@@ -14,6 +14,7 @@ export type RequestLog = {
   cache_read_tokens: number
   reasoning_tokens: number
   is_stream?: boolean | number
+  hedged?: boolean
   duration_sec?: number
   created_at: string
   total_cost?: number
@@ -0,0 +1,13 @@
+import { Call } from '@wailsio/runtime'
+
+// Provider 实时并发（只包含有进行中或排队请求的 provider）
+export type ProviderConcurrency = {
+  provider: string
+  in_flight: number
+  queued: number
+}
+
+export const fetchProviderConcurrency = async (platform: string): Promise<ProviderConcurrency[]> => {
+  const result = await Call.ByName('codeswitch/services.ProviderRelayService.GetProviderConcurrency', platform)
+  return (result as ProviderConcurrency[] | null) ?? []
+}
@@ -0,0 +1,19 @@
+import { Call } from '@wailsio/runtime'
+
+// 自适应路由评分（按 provider + 实际模型统计，仅内存，应用重启后重新学习）
+export type ProviderScore = {
+  platform: string
+  provider: string
+  model: string
+  ttfb_ms: number
+  error_rate: number
+  score: number
+  samples: number
+  cold: boolean
+  last_attempt: number
+}
+
+export const fetchProviderScores = async (platform: string = ''): Promise<ProviderScore[]> => {
+  const result = await Call.ByName('codeswitch/services.ProviderRelayService.GetProviderScores', platform)
+  return (result as ProviderScore[] | null) ?? []
+}
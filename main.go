@@ -113,6 +113,8 @@ func main() {
 	codexSettings := services.NewCodexSettingsService(providerRelay.Addr())
 	cliConfigService := services.NewCliConfigService(providerRelay.Addr())
 	logService := services.NewLogService()
+	budgetService := services.NewBudgetService(notificationService)
+	providerRelay.SetBudgetService(budgetService)
 	updateService := services.NewUpdateService(AppVersion)
 	mcpService := services.NewMCPService()
 	skillService := services.NewSkillService()
@@ -127,6 +129,12 @@ func main() {
 	if err := healthCheckService.Start(); err != nil {
 		log.Fatalf("初始化健康检查服务失败: %v", err)
 	}
+	balanceService := services.NewBalanceService(providerService)
+	// 初始化余额历史表
+	if err := balanceService.Start(); err != nil {
+		log.Fatalf("初始化余额查询服务失败: %v", err)
+	}
+	providerRelay.SetBalanceService(balanceService)
 	dockService := dock.New()
 	versionService := NewVersionService()
 	consoleService := services.NewConsoleService()
@@ -197,6 +205,9 @@ func main() {
 		} else {
 			log.Println("ℹ️  自动可用性监控已禁用（可在设置中开启）")
 		}
+
+		// 余额查询只请求启用了余额查询的供应商，始终启动
+		balanceService.StartBackgroundPolling()
 	}()
 
 	//fmt.Println(clipboardService)
@@ -218,6 +229,7 @@ func main() {
 			application.NewService(codexSettings),
 			application.NewService(cliConfigService),
 			application.NewService(logService),
+			application.NewService(budgetService),
 			application.NewService(appSettings),
 			application.NewService(updateService),
 			application.NewService(mcpService),
@@ -229,6 +241,7 @@ func main() {
 			application.NewService(speedTestService),
 			application.NewService(connectivityTestService),
 			application.NewService(healthCheckService),
+			application.NewService(balanceService),
 			application.NewService(dockService),
 			application.NewService(versionService),
 			application.NewService(geminiService),
@@ -258,6 +271,7 @@ func main() {
 		// 2. 停止健康检查轮询
 		healthCheckService.StopBackgroundPolling()
 		log.Println("✅ 健康检查服务已停止")
+		balanceService.StopBackgroundPolling()
 
 		// 3. 停止更新定时器
 		updateService.StopDailyCheck()
@@ -28,16 +28,30 @@ type Service struct {
 
 // PricingEntry 映射 JSON 内的字段。
 type PricingEntry struct {
-	InputCostPerToken                   float64 `json:"input_cost_per_token"`
-	OutputCostPerToken                  float64 `json:"output_cost_per_token"`
-	OutputCostPerReasoningToken         float64 `json:"output_cost_per_reasoning_token"`
-	CacheCreationInputTokenCost         float64 `json:"cache_creation_input_token_cost"`
-	CacheCreationInputTokenCostAbove1Hr float64 `json:"cache_creation_input_token_cost_above_1hr"`
-	CacheCreationInputTokenCostAbove200 float64 `json:"cache_creation_input_token_cost_above_200k_tokens"`
-	CacheReadInputTokenCost             float64 `json:"cache_read_input_token_cost"`
-	InputCostPerTokenAbove200k          float64 `json:"input_cost_per_token_above_200k_tokens"`
-	InputCostPerTokenAbove128k          float64 `json:"input_cost_per_token_above_128k_tokens"`
-	OutputCostPerTokenAbove200k         float64 `json:"output_cost_per_token_above_200k_tokens"`
+	InputCostPerToken                   float64    `json:"input_cost_per_token"`
+	OutputCostPerToken                  float64    `json:"output_cost_per_token"`
+	OutputCostPerReasoningToken         float64    `json:"output_cost_per_reasoning_token"`
+	CacheCreationInputTokenCost         float64    `json:"cache_creation_input_token_cost"`
+	CacheCreationInputTokenCostAbove1Hr float64    `json:"cache_creation_input_token_cost_above_1hr"`
+	CacheCreationInputTokenCostAbove200 float64    `json:"cache_creation_input_token_cost_above_200k_tokens"`
+	CacheReadInputTokenCost             float64    `json:"cache_read_input_token_cost"`
+	InputCostPerTokenAbove200k          float64    `json:"input_cost_per_token_above_200k_tokens"`
+	InputCostPerTokenAbove128k          float64    `json:"input_cost_per_token_above_128k_tokens"`
+	OutputCostPerTokenAbove200k         float64    `json:"output_cost_per_token_above_200k_tokens"`
+	MaxInputTokens                      tokenLimit `json:"max_input_tokens"`
+}
+
+// tokenLimit 兼容 JSON 中的整数、浮点数（如 2000000.0）以及 sample_spec 中的说明文字。
+type tokenLimit int
+
+func (t *tokenLimit) UnmarshalJSON(data []byte) error {
+	var value float64
+	if err := json.Unmarshal(data, &value); err != nil {
+		*t = 0
+		return nil
+	}
+	*t = tokenLimit(value)
+	return nil
 }
 
 // UsageSnapshot 描述一次请求的 token 用量。
@@ -149,6 +163,27 @@ func (s *Service) CalculateCost(model string, usage UsageSnapshot) CostBreakdown
 	return breakdown
 }
 
+// ContextWindow 返回模型的最大输入 tokens。
+// 仅做精确 / 去前缀 / 归一化匹配，不做模糊匹配，避免误判窗口导致请求被跳过。
+func (s *Service) ContextWindow(model string) (int, bool) {
+	if s == nil || model == "" {
+		return 0, false
+	}
+	if strings.Contains(strings.ToLower(model), "[1m]") {
+		return 1000000, true
+	}
+	withoutRegion := stripRegionPrefix(model)
+	for _, name := range []string{model, withoutRegion, strings.TrimPrefix(withoutRegion, "anthropic.")} {
+		if entry, ok := s.pricingMap[name]; ok && entry.MaxInputTokens > 0 {
+			return int(entry.MaxInputTokens), true
+		}
+	}
+	if key, ok := s.normalized[normalizeName(model)]; ok && s.pricingMap[key].MaxInputTokens > 0 {
+		return int(s.pricingMap[key].MaxInputTokens), true
+	}
+	return 0, false
+}
+
 func (s *Service) getPricing(model string) (*PricingEntry, bool) {
 	if model == "" {
 		return nil, false
@@ -0,0 +1,276 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// apiKeyAuthBench key 认证失败（401 / 402 / 403：失效、余额不足或无权限）后的暂停时长
+const apiKeyAuthBench = 30 * time.Minute
+
+// errAPIKeysExhausted provider 的 key 池中没有可用的 key（全部停用或暂停中），切换到下一个 provider，不计入失败次数
+var errAPIKeysExhausted = fmt.Errorf("%w: all api keys benched", errProviderSkipped)
+
+// APIKeyEntry key 池中的一个 API Key
+// 同一厂商的多个 key 各有独立额度：按 weight 轮换使用，单个 key 认证失败或被限流时只暂停该 key
+type APIKeyEntry struct {
+	Label   string `json:"label"`            // 标签（唯一，写入 request_log 用于按 key 统计用量）
+	Key     string `json:"key"`              // API Key
+	Weight  int    `json:"weight,omitempty"` // 轮换权重（默认 1）
+	Enabled bool   `json:"enabled"`
+}
+
+// apiKeyChoice 本次请求使用的 key
+type apiKeyChoice struct {
+	label  string // 未配置 key 池时为空（使用 Provider.APIKey）
+	key    string
+	pooled bool // 来自 key 池，失败时可单独暂停
+}
+
+// pooledAPIKeys 返回启用且非空的池中 key
+func (p *Provider) pooledAPIKeys() []APIKeyEntry {
+	keys := make([]APIKeyEntry, 0, len(p.APIKeys))
+	for _, entry := range p.APIKeys {
+		if entry.Enabled && strings.TrimSpace(entry.Key) != "" {
+			keys = append(keys, entry)
+		}
+	}
+	return keys
+}
+
+// hasAPIKey provider 是否配置了可用的 key（单个 APIKey 或 key 池中启用的 key）
+func (p *Provider) hasAPIKey() bool {
+	return p.APIKey != "" || len(p.pooledAPIKeys()) > 0
+}
+
+// primaryAPIKey 返回健康检查等单次请求使用的 key：优先 APIKey，其次 key 池中第一个启用的 key
+func (p *Provider) primaryAPIKey() string {
+	if p.APIKey != "" {
+		return p.APIKey
+	}
+	if keys := p.pooledAPIKeys(); len(keys) > 0 {
+		return keys[0].Key
+	}
+	return ""
+}
+
+// validateAPIKeys 校验 key 池配置，返回错误信息列表
+func (p *Provider) validateAPIKeys() []string {
+	var errs []string
+	seen := make(map[string]bool, len(p.APIKeys))
+	for _, entry := range p.APIKeys {
+		label := strings.TrimSpace(entry.Label)
+		switch {
+		case label == "":
+			errs = append(errs, "API Key 标签不能为空")
+		case seen[label]:
+			errs = append(errs, fmt.Sprintf("API Key 标签重复：'%s'", label))
+		case strings.TrimSpace(entry.Key) == "":
+			errs = append(errs, fmt.Sprintf("API Key '%s' 的 key 不能为空", label))
+		case entry.Weight < 0:
+			errs = append(errs, fmt.Sprintf("API Key '%s' 的权重不能为负数", label))
+		}
+		seen[label] = true
+	}
+	return errs
+}
+
+// apiKeyState 单个 key 的轮换与健康状态
+type apiKeyState struct {
+	current      int       // 平滑加权轮询的当前权重
+	successes    int       // 累计成功次数
+	failures     int       // 累计失败次数（仅统计归因于 key 的失败）
+	consecutive  int       // 连续失败次数
+	benchedUntil time.Time // 暂停截止时间
+	lastStatus   int       // 最近一次导致暂停的状态码
+}
+
+// apiKeyPool 按 platform + provider + label 维护 key 状态（仅内存，重启后所有 key 恢复可用）
+type apiKeyPool struct {
+	mu     sync.Mutex
+	states map[string]*apiKeyState
+	now    func() time.Time
+}
+
+func newAPIKeyPool() *apiKeyPool {
+	return &apiKeyPool{states: make(map[string]*apiKeyState), now: time.Now}
+}
+
+func apiKeyStateKey(platform, provider, label string) string {
+	return platform + "|" + provider + "|" + label
+}
+
+// stateLocked 返回 key 的状态（不存在时创建，调用方需持有锁）
+func (p *apiKeyPool) stateLocked(platform, provider, label string) *apiKeyState {
+	key := apiKeyStateKey(platform, provider, label)
+	state := p.states[key]
+	if state == nil {
+		state = &apiKeyState{}
+		p.states[key] = state
+	}
+	return state
+}
+
+// pick 按平滑加权轮询（同 nginx）从未暂停的 key 中选择一个
+// 未配置 key 池时返回 Provider.APIKey；池中 key 全部暂停时返回 errAPIKeysExhausted
+func (p *apiKeyPool) pick(platform string, provider *Provider) (apiKeyChoice, error) {
+	keys := provider.pooledAPIKeys()
+	if len(keys) == 0 {
+		return apiKeyChoice{key: provider.APIKey}, nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := p.now()
+	var best *apiKeyState
+	var bestEntry APIKeyEntry
+	total := 0
+	for _, entry := range keys {
+		state := p.stateLocked(platform, provider.Name, entry.Label)
+		if now.Before(state.benchedUntil) {
+			continue
+		}
+		weight := normalizeWeight(entry.Weight)
+		state.current += weight
+		total += weight
+		if best == nil || state.current > best.current {
+			best, bestEntry = state, entry
+		}
+	}
+	if best == nil {
+		return apiKeyChoice{}, fmt.Errorf("%w: provider %s 的 %d 个 key 均已暂停", errAPIKeysExhausted, provider.Name, len(keys))
+	}
+	best.current -= total
+	return apiKeyChoice{label: bestEntry.Label, key: bestEntry.Key, pooled: true}, nil
+}
+
+// report 记录一次请求的结果
+// 认证失败（401 / 402 / 403）与上游限流（429）归因于 key：暂停该 key，其余错误由 provider 级策略处理
+// 返回 true 表示 key 已暂停且池中还有其他可用 key，调用方可换 key 重试同一 provider
+func (p *apiKeyPool) report(platform string, provider *Provider, choice apiKeyChoice, err error) bool {
+	if !choice.pooled {
+		return false
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	state := p.stateLocked(platform, provider.Name, choice.label)
+	if err == nil {
+		state.successes++
+		state.consecutive = 0
+		return false
+	}
+
+	var statusErr *upstreamStatusError
+	if !errors.As(err, &statusErr) {
+		return false
+	}
+	bench := time.Duration(0)
+	switch classifyStatus(statusErr.status, statusErr.retryAfter) {
+	case ErrorClassAuth:
+		bench = apiKeyAuthBench
+	case ErrorClassRateLimit:
+		bench = defaultRateLimitCooldown
+		if statusErr.retryAfter > 0 {
+			bench = statusErr.retryAfter
+		}
+		if bench > maxUpstreamCooldown {
+			bench = maxUpstreamCooldown
+		}
+	default:
+		return false
+	}
+
+	now := p.now()
+	state.failures++
+	state.consecutive++
+	state.lastStatus = statusErr.status
+	state.benchedUntil = now.Add(bench)
+	fmt.Printf("[WARN] 🔑 Provider %s 的 key %s 返回 %d，暂停 %s\n", provider.Name, choice.label, statusErr.status, bench.Round(time.Second))
+
+	for _, entry := range provider.pooledAPIKeys() {
+		if !now.Before(p.stateLocked(platform, provider.Name, entry.Label).benchedUntil) {
+			return true
+		}
+	}
+	return false
+}
+
+// responseStatusError 把旁路请求（count_tokens、/v1/models）的响应转为 report 所需的结果，2xx 返回 nil
+func responseStatusError(resp *http.Response) error {
+	if resp.StatusCode >= http.StatusOK && resp.StatusCode < http.StatusMultipleChoices {
+		return nil
+	}
+	return &upstreamStatusError{status: resp.StatusCode, retryAfter: parseRetryAfter(resp.Header, time.Now())}
+}
+
+// APIKeyStatus key 池中单个 key 的实时状态（前端展示用，不包含 key 本身）
+type APIKeyStatus struct {
+	Label        string `json:"label"`
+	Enabled      bool   `json:"enabled"`
+	Weight       int    `json:"weight"`
+	Successes    int    `json:"successes"`
+	Failures     int    `json:"failures"`
+	Benched      bool   `json:"benched"`
+	BenchedUntil string `json:"benchedUntil,omitempty"`
+	LastStatus   int    `json:"lastStatus,omitempty"`
+}
+
+// status 返回 provider 各 key 的状态（按配置顺序）
+func (p *apiKeyPool) status(platform string, provider *Provider) []APIKeyStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := p.now()
+	result := make([]APIKeyStatus, 0, len(provider.APIKeys))
+	for _, entry := range provider.APIKeys {
+		item := APIKeyStatus{Label: entry.Label, Enabled: entry.Enabled, Weight: normalizeWeight(entry.Weight)}
+		if state := p.states[apiKeyStateKey(platform, provider.Name, entry.Label)]; state != nil {
+			item.Successes = state.successes
+			item.Failures = state.failures
+			item.LastStatus = state.lastStatus
+			if now.Before(state.benchedUntil) {
+				item.Benched = true
+				item.BenchedUntil = state.benchedUntil.Format(time.RFC3339)
+			}
+		}
+		result = append(result, item)
+	}
+	return result
+}
+
+// reset 解除 key 的暂停（用户更换或充值 key 后手动恢复）
+func (p *apiKeyPool) reset(platform, provider, label string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if state := p.states[apiKeyStateKey(platform, provider, label)]; state != nil {
+		state.benchedUntil = time.Time{}
+		state.consecutive = 0
+	}
+}
+
+// GetAPIKeyStatus 返回 provider key 池中各 key 的暂停状态与成败次数
+func (prs *ProviderRelayService) GetAPIKeyStatus(platform string, providerName string) ([]APIKeyStatus, error) {
+	providers, err := prs.providerService.LoadProviders(platform)
+	if err != nil {
+		return nil, err
+	}
+	for i := range providers {
+		if providers[i].Name == providerName {
+			return prs.apiKeys.status(platform, &providers[i]), nil
+		}
+	}
+	return nil, fmt.Errorf("provider '%s' 不存在", providerName)
+}
+
+// ResetAPIKey 手动解除 key 的暂停
+func (prs *ProviderRelayService) ResetAPIKey(platform string, providerName string, label string) {
+	prs.apiKeys.reset(platform, providerName, label)
+	fmt.Printf("[INFO] 🔑 已恢复 Provider %s 的 key %s\n", providerName, label)
+}
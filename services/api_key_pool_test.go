@@ -0,0 +1,170 @@
+package services
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// ==================== key 池测试 ====================
+
+func TestAPIKeyPool_Pick(t *testing.T) {
+	pool := newAPIKeyPool()
+	provider := &Provider{Name: "relay", APIKeys: []APIKeyEntry{
+		{Label: "a", Key: "ka", Weight: 2, Enabled: true},
+		{Label: "b", Key: "kb", Enabled: true},
+		{Label: "off", Key: "koff", Weight: 10, Enabled: false},
+	}}
+
+	var labels []string
+	for i := 0; i < 6; i++ {
+		choice, err := pool.pick("claude", provider)
+		if err != nil {
+			t.Fatalf("选择 key 失败: %v", err)
+		}
+		labels = append(labels, choice.label)
+	}
+	if got := strings.Join(labels, ","); got != "a,b,a,a,b,a" {
+		t.Errorf("应按权重平滑轮换且跳过停用的 key，实际 %s", got)
+	}
+
+	// 未配置 key 池时使用 APIKey
+	choice, err := pool.pick("claude", &Provider{Name: "single", APIKey: "k"})
+	if err != nil || choice.key != "k" || choice.pooled {
+		t.Errorf("未配置 key 池时应使用 APIKey，实际 %+v, %v", choice, err)
+	}
+}
+
+func TestAPIKeyPool_Report(t *testing.T) {
+	now := time.Now()
+	pool := newAPIKeyPool()
+	pool.now = func() time.Time { return now }
+	provider := &Provider{Name: "relay", APIKeys: []APIKeyEntry{
+		{Label: "a", Key: "ka", Enabled: true},
+		{Label: "b", Key: "kb", Enabled: true},
+	}}
+	a := apiKeyChoice{label: "a", key: "ka", pooled: true}
+	b := apiKeyChoice{label: "b", key: "kb", pooled: true}
+
+	if pool.report("claude", provider, a, &upstreamStatusError{status: http.StatusInternalServerError}) {
+		t.Errorf("5xx 不应归因于 key")
+	}
+	if !pool.report("claude", provider, a, &upstreamStatusError{status: http.StatusUnauthorized}) {
+		t.Errorf("401 应暂停 key 并允许换 key 重试")
+	}
+	for i := 0; i < 3; i++ {
+		if choice, _ := pool.pick("claude", provider); choice.label != "b" {
+			t.Fatalf("暂停的 key 不应被选中，实际 %s", choice.label)
+		}
+	}
+
+	if pool.report("claude", provider, b, &upstreamStatusError{status: http.StatusTooManyRequests, retryAfter: time.Minute}) {
+		t.Errorf("全部 key 暂停后不应再重试")
+	}
+	if _, err := pool.pick("claude", provider); !errors.Is(err, errAPIKeysExhausted) || !errors.Is(err, errProviderSkipped) {
+		t.Errorf("全部 key 暂停时应跳过 provider，实际 %v", err)
+	}
+
+	// 429 按 Retry-After 暂停，到期后恢复
+	now = now.Add(2 * time.Minute)
+	if choice, err := pool.pick("claude", provider); err != nil || choice.label != "b" {
+		t.Errorf("限流暂停到期后应恢复，实际 %+v, %v", choice, err)
+	}
+
+	status := pool.status("claude", provider)
+	if len(status) != 2 || !status[0].Benched || status[0].LastStatus != http.StatusUnauthorized || status[1].Benched {
+		t.Errorf("key 状态不符合预期: %+v", status)
+	}
+	pool.reset("claude", "relay", "a")
+	if pool.status("claude", provider)[0].Benched {
+		t.Errorf("手动恢复后不应再暂停")
+	}
+}
+
+func TestProvider_ValidateAPIKeys(t *testing.T) {
+	tests := []struct {
+		name    string
+		keys    []APIKeyEntry
+		wantErr string
+	}{
+		{"合法", []APIKeyEntry{{Label: "a", Key: "ka", Enabled: true}, {Label: "b", Key: "kb"}}, ""},
+		{"缺少标签", []APIKeyEntry{{Key: "ka"}}, "标签不能为空"},
+		{"标签重复", []APIKeyEntry{{Label: "a", Key: "ka"}, {Label: "a", Key: "kb"}}, "标签重复"},
+		{"key 为空", []APIKeyEntry{{Label: "a"}}, "不能为空"},
+		{"权重为负数", []APIKeyEntry{{Label: "a", Key: "ka", Weight: -1}}, "权重不能为负数"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := Provider{Name: "p", APIKeys: tt.keys}
+			errs := strings.Join(provider.ValidateConfiguration(), "; ")
+			if tt.wantErr == "" && errs != "" {
+				t.Errorf("期望验证通过，实际 %s", errs)
+			}
+			if tt.wantErr != "" && !strings.Contains(errs, tt.wantErr) {
+				t.Errorf("期望错误包含 %q，实际 %q", tt.wantErr, errs)
+			}
+		})
+	}
+
+	if !(&Provider{APIKeys: []APIKeyEntry{{Label: "a", Key: "ka", Enabled: true}}}).hasAPIKey() {
+		t.Errorf("只配置 key 池时也应视为有凭据")
+	}
+	if (&Provider{APIKeys: []APIKeyEntry{{Label: "a", Key: "ka"}}}).hasAPIKey() {
+		t.Errorf("key 池全部停用时应视为缺少凭据")
+	}
+}
+
+// TestForwardRequest_APIKeyPool 某个 key 失效时换用下一个 key 重试同一 provider
+func TestForwardRequest_APIKeyPool(t *testing.T) {
+	var seen []string
+	upstreamServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("X-Api-Key")
+		seen = append(seen, key)
+		if key == "expired" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error":{"type":"authentication_error"}}`))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id":"msg_1"}`))
+	}))
+	defer upstreamServer.Close()
+
+	relayService := NewProviderRelayService(NewProviderService(), nil, nil, nil, nil, "")
+	provider := Provider{Name: "pooled", APIURL: upstreamServer.URL, Enabled: true, APIKeys: []APIKeyEntry{
+		{Label: "old", Key: "expired", Weight: 2, Enabled: true},
+		{Label: "new", Key: "valid", Enabled: true},
+	}}
+
+	c, w := newHedgeTestContext()
+	ok, err := relayService.forwardRequest(c, "claude", provider, "/v1/messages", nil, http.Header{},
+		[]byte(`{"model":"claude-sonnet-4"}`), false, "claude-sonnet-4", AuthMethodXAPIKey)
+	if !ok || w.Code != http.StatusOK {
+		t.Fatalf("换 key 后请求应成功: %v", err)
+	}
+	if strings.Join(seen, ",") != "expired,valid" {
+		t.Errorf("应先使用失效的 key 再换用下一个 key，实际 %v", seen)
+	}
+
+	status := relayService.apiKeys.status("claude", &provider)
+	if !status[0].Benched || status[1].Successes != 1 {
+		t.Errorf("失效的 key 应被暂停，实际 %+v", status)
+	}
+}
+
+func TestSummarizeAPIKeyUsage(t *testing.T) {
+	usage := summarizeAPIKeyUsage([]RequestLog{
+		{APIKeyLabel: "b", HttpCode: 200, InputTokens: 10, OutputTokens: 5, TotalCost: 0.1},
+		{APIKeyLabel: "a", HttpCode: 401},
+		{APIKeyLabel: "b", HttpCode: 200, InputTokens: 20, OutputTokens: 5, TotalCost: 0.2},
+	})
+	if len(usage) != 2 || usage[0].Label != "a" || usage[0].Failures != 1 {
+		t.Fatalf("汇总结果不符合预期: %+v", usage)
+	}
+	if b := usage[1]; b.Requests != 2 || b.InputTokens != 30 || b.OutputTokens != 10 || b.Failures != 0 {
+		t.Errorf("key b 的汇总不符合预期: %+v", b)
+	}
+}
@@ -25,6 +25,21 @@ type AppSettings struct {
 	AutoConnectivityTest bool `json:"auto_connectivity_test"`
 	EnableSwitchNotify   bool `json:"enable_switch_notify"`   // 供应商切换通知开关
 	EnableRoundRobin     bool `json:"enable_round_robin"`     // 同 Level 轮询负载均衡开关（默认关闭）
+	EnableHedging        bool `json:"enable_hedging"`         // 对冲请求开关：慢响应时并发请求同 Level 下一个 provider（默认关闭）
+	HedgeDelayMs         int  `json:"hedge_delay_ms"`         // 对冲延迟（毫秒），primary 超过该时间未响应才发起对冲
+
+	// 各 Level 的负载均衡策略（order / round_robin / weighted_random / least_inflight / adaptive / cost）
+	// 未配置的 Level 沿用 EnableRoundRobin 开关
+	LevelStrategies map[int]string `json:"level_strategies,omitempty"`
+
+	// 各错误分类（client / auth / ratelimit / overload / server / network）的切换与处罚策略
+	// 未配置的分类使用 DefaultErrorPolicies
+	ErrorPolicies map[string]ErrorClassPolicy `json:"error_policies,omitempty"`
+
+	// 流式保活：等待上游首包、重试或切换 provider 期间定期向客户端发送保活帧，避免代理 / WSL 网络断开空闲连接
+	// Anthropic 客户端发送 ping 事件，其他协议发送 SSE 注释（默认关闭，间隔 15 秒）
+	EnableStreamKeepAlive  bool `json:"enable_stream_keepalive"`
+	StreamKeepAliveSeconds int  `json:"stream_keepalive_seconds"`
 }
 
 type AppSettingsService struct {
@@ -144,6 +159,11 @@ func (as *AppSettingsService) defaultSettings() AppSettings {
 		AutoConnectivityTest: true,  // 默认开启自动可用性监控（开箱即用）
 		EnableSwitchNotify:   true,  // 默认开启切换通知
 		EnableRoundRobin:     false, // 默认关闭轮询（使用顺序降级）
+		EnableHedging:        false, // 默认关闭对冲（避免额外的上游消耗）
+		HedgeDelayMs:         int(defaultHedgeDelay / time.Millisecond),
+
+		EnableStreamKeepAlive:  false, // 默认关闭流式保活（保活帧写出后响应状态码固定为 200，错误改为 SSE 事件返回）
+		StreamKeepAliveSeconds: int(defaultStreamKeepAliveInterval / time.Second),
 	}
 }
 
@@ -0,0 +1,556 @@
+// services/balanceservice.go
+// 余额查询服务 - 定期请求中转站的余额接口，记录历史并在余额不足时跳过 provider
+
+package services
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/daodao97/xgo/xdb"
+	"github.com/tidwall/gjson"
+)
+
+// 默认配置常量
+const (
+	DefaultBalancePollInterval = 10 * time.Minute // 默认查询间隔（余额变化慢，避免频繁请求中转站接口）
+	DefaultBalanceTimeout      = 15 * time.Second // 单次查询超时
+	MaxBalanceHistory          = 100              // GetBalanceHistory 默认返回条数
+	maxBalanceResponseBytes    = 1 << 20          // 余额接口响应体读取上限
+)
+
+// BalanceProbe 余额查询配置（Provider 级别，可选）
+// 大多数第三方中转站都提供"剩余额度"接口：配置 URL 与 gjson 路径后即可定期读取余额
+// 示例（new-api）：{url: "/api/user/self", authType: "bearer", token: "<系统令牌>", headers: {"New-Api-User": "1"}, balancePath: "data.quota", divisor: 500000}
+type BalanceProbe struct {
+	Enabled      bool              `json:"enabled"`
+	URL          string            `json:"url"`                    // 余额接口地址，以 / 开头时拼接在 provider 的 APIURL 之后
+	Method       string            `json:"method,omitempty"`       // GET（默认）/ POST
+	AuthType     string            `json:"authType,omitempty"`     // bearer（默认）/ x-api-key / none / 自定义 Header 名
+	Token        string            `json:"token,omitempty"`        // 认证凭据（留空使用 provider 的 API Key）
+	Headers      map[string]string `json:"headers,omitempty"`      // 额外请求头
+	Body         string            `json:"body,omitempty"`         // POST 请求体
+	BalancePath  string            `json:"balancePath"`            // 剩余额度的 gjson 路径，如 "data.balance"
+	CurrencyPath string            `json:"currencyPath,omitempty"` // 币种的 gjson 路径（可选）
+	Currency     string            `json:"currency,omitempty"`     // 固定币种（未配置 currencyPath 或取不到时使用）
+	Divisor      float64           `json:"divisor,omitempty"`      // 额度换算除数（如 new-api 的 quota 以 500000 为 $1），0 表示不换算
+	MinBalance   float64           `json:"minBalance,omitempty"`   // 余额低于该值时跳过 provider（0 表示只记录不跳过）
+}
+
+// Validate 校验余额查询配置（未启用时不校验）
+func (b *BalanceProbe) Validate() []string {
+	if b == nil || !b.Enabled {
+		return nil
+	}
+	errors := make([]string, 0)
+	url := strings.TrimSpace(b.URL)
+	if url == "" {
+		errors = append(errors, "余额接口地址不能为空")
+	} else if !strings.HasPrefix(url, "/") && !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		errors = append(errors, fmt.Sprintf("余额接口地址无效：'%s'（需以 http(s):// 或 / 开头）", url))
+	}
+	switch strings.ToUpper(b.Method) {
+	case "", http.MethodGet, http.MethodPost:
+	default:
+		errors = append(errors, fmt.Sprintf("余额接口请求方法无效：'%s'（支持 GET / POST）", b.Method))
+	}
+	if strings.TrimSpace(b.BalancePath) == "" {
+		errors = append(errors, "余额的 JSON 路径不能为空")
+	}
+	if b.Divisor < 0 {
+		errors = append(errors, "额度换算除数不能为负数")
+	}
+	if b.MinBalance < 0 {
+		errors = append(errors, "最低余额不能为负数")
+	}
+	return errors
+}
+
+// targetURL 返回余额接口的完整地址
+func (b *BalanceProbe) targetURL(provider *Provider) string {
+	url := strings.TrimSpace(b.URL)
+	if strings.HasPrefix(url, "/") {
+		return strings.TrimSuffix(provider.APIURL, "/") + url
+	}
+	return url
+}
+
+// BalanceResult 一次余额查询的结果
+type BalanceResult struct {
+	ID           int64     `json:"id"`
+	ProviderID   int64     `json:"providerId"`
+	ProviderName string    `json:"providerName"`
+	Platform     string    `json:"platform"`
+	Success      bool      `json:"success"`
+	Balance      float64   `json:"balance"`
+	Currency     string    `json:"currency,omitempty"`
+	MinBalance   float64   `json:"minBalance,omitempty"`
+	BelowFloor   bool      `json:"belowFloor"`   // 余额低于 MinBalance（relay 会跳过该 provider）
+	ErrorMessage string    `json:"errorMessage"` // 错误消息
+	LatencyMs    int       `json:"latencyMs"`
+	CheckedAt    time.Time `json:"checkedAt"`
+}
+
+// BalanceService 余额查询服务
+type BalanceService struct {
+	providerService *ProviderService
+
+	mu     sync.RWMutex
+	latest map[string]*BalanceResult // key: platform:providerName
+
+	// 后台轮询
+	running      bool
+	stopChan     chan struct{}
+	pollInterval time.Duration
+
+	client *http.Client
+}
+
+// NewBalanceService 创建余额查询服务
+func NewBalanceService(providerService *ProviderService) *BalanceService {
+	return &BalanceService{
+		providerService: providerService,
+		latest:          make(map[string]*BalanceResult),
+		pollInterval:    DefaultBalancePollInterval,
+		client: &http.Client{
+			Timeout: DefaultBalanceTimeout,
+			Transport: &http.Transport{
+				MaxIdleConns:        10,
+				IdleConnTimeout:     30 * time.Second,
+				MaxIdleConnsPerHost: 2,
+			},
+		},
+	}
+}
+
+// Start 初始化余额历史表
+func (bs *BalanceService) Start() error {
+	if err := bs.ensureTable(); err != nil {
+		return fmt.Errorf("初始化余额历史表失败: %w", err)
+	}
+	return nil
+}
+
+// Stop 停止后台查询
+func (bs *BalanceService) Stop() error {
+	bs.StopBackgroundPolling()
+	return nil
+}
+
+// ensureTable 确保余额历史表存在
+func (bs *BalanceService) ensureTable() error {
+	db, err := xdb.DB("default")
+	if err != nil {
+		return fmt.Errorf("获取数据库连接失败: %w", err)
+	}
+
+	const createTableSQL = `CREATE TABLE IF NOT EXISTS balance_history (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		provider_id INTEGER NOT NULL,
+		provider_name TEXT NOT NULL,
+		platform TEXT NOT NULL,
+		success INTEGER NOT NULL DEFAULT 0,
+		balance REAL,
+		currency TEXT,
+		error_message TEXT,
+		latency_ms INTEGER,
+		checked_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`
+	if _, err := db.Exec(createTableSQL); err != nil {
+		return fmt.Errorf("创建 balance_history 表失败: %w", err)
+	}
+
+	const createIndexSQL = `CREATE INDEX IF NOT EXISTS idx_balance_provider ON balance_history(platform, provider_name, checked_at)`
+	if _, err := db.Exec(createIndexSQL); err != nil {
+		log.Printf("[Balance] 创建索引警告: %v", err)
+	}
+	return nil
+}
+
+// probe 请求 provider 的余额接口并解析余额
+func (bs *BalanceService) probe(ctx context.Context, platform string, provider *Provider) *BalanceResult {
+	cfg := provider.BalanceProbe
+	result := &BalanceResult{
+		ProviderID:   provider.ID,
+		ProviderName: provider.Name,
+		Platform:     platform,
+		Currency:     cfg.Currency,
+		MinBalance:   cfg.MinBalance,
+		CheckedAt:    time.Now(),
+	}
+
+	method := strings.ToUpper(cfg.Method)
+	if method == "" {
+		method = http.MethodGet
+	}
+	var body io.Reader
+	if method == http.MethodPost && cfg.Body != "" {
+		body = strings.NewReader(cfg.Body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, cfg.targetURL(provider), body)
+	if err != nil {
+		result.ErrorMessage = fmt.Sprintf("创建请求失败: %v", err)
+		return result
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	token := cfg.Token
+	if token == "" {
+		token = provider.primaryAPIKey()
+	}
+	if token != "" {
+		authType := strings.TrimSpace(cfg.AuthType)
+		switch strings.ToLower(authType) {
+		case "", "bearer":
+			req.Header.Set("Authorization", "Bearer "+token)
+		case "x-api-key":
+			req.Header.Set("x-api-key", token)
+		case "none":
+		default:
+			req.Header.Set(authType, token)
+		}
+	}
+	for key, value := range cfg.Headers {
+		req.Header.Set(key, value)
+	}
+
+	client, err := providerHTTPClient(provider, bs.client)
+	if err != nil {
+		result.ErrorMessage = err.Error()
+		return result
+	}
+	start := time.Now()
+	resp, err := client.Do(req)
+	result.LatencyMs = int(time.Since(start).Milliseconds())
+	if err != nil {
+		result.ErrorMessage = fmt.Sprintf("网络错误: %v", err)
+		return result
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxBalanceResponseBytes))
+	if err != nil {
+		result.ErrorMessage = fmt.Sprintf("读取响应失败: %v", err)
+		return result
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		result.ErrorMessage = fmt.Sprintf("HTTP %d: %s", resp.StatusCode, truncateString(string(data), 200))
+		return result
+	}
+
+	balance, err := parseBalance(data, cfg.BalancePath)
+	if err != nil {
+		result.ErrorMessage = err.Error()
+		return result
+	}
+	if cfg.Divisor > 0 {
+		balance /= cfg.Divisor
+	}
+	if cfg.CurrencyPath != "" {
+		if currency := gjson.GetBytes(data, cfg.CurrencyPath).String(); currency != "" {
+			result.Currency = currency
+		}
+	}
+
+	result.Success = true
+	result.Balance = balance
+	result.BelowFloor = cfg.MinBalance > 0 && balance < cfg.MinBalance
+	return result
+}
+
+// parseBalance 按 gjson 路径读取余额（兼容字符串形式的数字，如 "12.34" 或 "$12.34"）
+func parseBalance(data []byte, path string) (float64, error) {
+	value := gjson.GetBytes(data, path)
+	switch value.Type {
+	case gjson.Number:
+		return value.Float(), nil
+	case gjson.String:
+		text := strings.TrimSpace(strings.TrimLeft(value.String(), "$¥￥ "))
+		balance, err := strconv.ParseFloat(strings.ReplaceAll(text, ",", ""), 64)
+		if err != nil {
+			return 0, fmt.Errorf("路径 %s 的值不是数字: %q", path, value.String())
+		}
+		return balance, nil
+	default:
+		return 0, fmt.Errorf("响应中未找到路径 %s: %s", path, truncateString(string(data), 200))
+	}
+}
+
+// truncateString 截断过长的字符串（用于错误信息）
+func truncateString(s string, limit int) string {
+	if len(s) <= limit {
+		return s
+	}
+	return s[:limit] + "..."
+}
+
+// RunBalanceCheck 手动查询单个 Provider 的余额
+func (bs *BalanceService) RunBalanceCheck(platform string, providerID int64) (*BalanceResult, error) {
+	providers, err := bs.providerService.LoadProviders(platform)
+	if err != nil {
+		return nil, fmt.Errorf("加载供应商失败: %w", err)
+	}
+	for i := range providers {
+		if providers[i].ID != providerID {
+			continue
+		}
+		if providers[i].BalanceProbe == nil || !providers[i].BalanceProbe.Enabled {
+			return nil, fmt.Errorf("供应商 %s 未启用余额查询", providers[i].Name)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), DefaultBalanceTimeout)
+		defer cancel()
+		result := bs.probe(ctx, platform, &providers[i])
+		bs.record(result)
+		return result, nil
+	}
+	return nil, fmt.Errorf("未找到供应商 ID: %d", providerID)
+}
+
+// RunAllBalanceChecks 手动查询全部启用余额查询的供应商
+func (bs *BalanceService) RunAllBalanceChecks() map[string][]BalanceResult {
+	results := make(map[string][]BalanceResult)
+	for _, platform := range []string{"claude", "codex"} {
+		results[platform] = bs.checkAllProviders(platform)
+	}
+	return results
+}
+
+// checkAllProviders 查询指定平台所有启用余额查询的供应商
+func (bs *BalanceService) checkAllProviders(platform string) []BalanceResult {
+	providers, err := bs.providerService.LoadProviders(platform)
+	if err != nil {
+		log.Printf("[Balance] 加载 %s 供应商失败: %v", platform, err)
+		return nil
+	}
+
+	var results []BalanceResult
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	sem := make(chan struct{}, MaxConcurrentChecks)
+
+	for _, provider := range providers {
+		if provider.BalanceProbe == nil || !provider.BalanceProbe.Enabled {
+			continue
+		}
+
+		wg.Add(1)
+		go func(p Provider) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			ctx, cancel := context.WithTimeout(context.Background(), DefaultBalanceTimeout)
+			defer cancel()
+			result := bs.probe(ctx, platform, &p)
+			bs.record(result)
+
+			mu.Lock()
+			results = append(results, *result)
+			mu.Unlock()
+		}(provider)
+	}
+
+	wg.Wait()
+	return results
+}
+
+// record 保存查询结果并更新缓存
+func (bs *BalanceService) record(result *BalanceResult) {
+	if result.Success {
+		log.Printf("[Balance] %s/%s: balance=%.4f %s", result.Platform, result.ProviderName, result.Balance, result.Currency)
+		if result.BelowFloor {
+			log.Printf("[Balance] ⚠️ %s/%s 余额 %.4f 低于 %.4f，将跳过该供应商", result.Platform, result.ProviderName, result.Balance, result.MinBalance)
+		}
+	} else {
+		log.Printf("[Balance] %s/%s 查询失败: %s", result.Platform, result.ProviderName, result.ErrorMessage)
+	}
+
+	if err := bs.saveResult(result); err != nil {
+		log.Printf("[Balance] 保存结果失败: %v", err)
+	}
+
+	bs.mu.Lock()
+	bs.latest[result.Platform+":"+result.ProviderName] = result
+	bs.mu.Unlock()
+}
+
+// saveResult 保存查询结果到数据库
+func (bs *BalanceService) saveResult(result *BalanceResult) error {
+	if GlobalDBQueue == nil {
+		return fmt.Errorf("数据库写入队列未初始化")
+	}
+
+	const insertSQL = `
+		INSERT INTO balance_history (provider_id, provider_name, platform, success, balance, currency, error_message, latency_ms, checked_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+
+	success := 0
+	if result.Success {
+		success = 1
+	}
+	return GlobalDBQueue.Exec(insertSQL,
+		result.ProviderID,
+		result.ProviderName,
+		result.Platform,
+		success,
+		result.Balance,
+		result.Currency,
+		result.ErrorMessage,
+		result.LatencyMs,
+		result.CheckedAt,
+	)
+}
+
+// GetLatestBalances 获取各供应商最近一次的余额查询结果（按平台分组）
+func (bs *BalanceService) GetLatestBalances() map[string][]BalanceResult {
+	bs.mu.RLock()
+	defer bs.mu.RUnlock()
+
+	results := make(map[string][]BalanceResult)
+	for _, result := range bs.latest {
+		results[result.Platform] = append(results[result.Platform], *result)
+	}
+	return results
+}
+
+// GetBalanceHistory 获取供应商的余额历史（按时间倒序）
+func (bs *BalanceService) GetBalanceHistory(platform, providerName string, limit int) ([]BalanceResult, error) {
+	db, err := xdb.DB("default")
+	if err != nil {
+		return nil, fmt.Errorf("获取数据库连接失败: %w", err)
+	}
+
+	if limit <= 0 {
+		limit = MaxBalanceHistory
+	}
+
+	query := `
+		SELECT id, provider_id, provider_name, platform, success, balance, currency, error_message, latency_ms, checked_at
+		FROM balance_history
+		WHERE platform = ? AND provider_name = ?
+		ORDER BY checked_at DESC
+		LIMIT ?
+	`
+
+	rows, err := db.Query(query, platform, providerName, limit)
+	if err != nil {
+		if isNoSuchTableErr(err) {
+			return []BalanceResult{}, nil
+		}
+		return nil, fmt.Errorf("查询余额历史失败: %w", err)
+	}
+	defer rows.Close()
+
+	history := make([]BalanceResult, 0)
+	for rows.Next() {
+		var r BalanceResult
+		var success int
+		var balance sql.NullFloat64
+		var currency, errorMsg sql.NullString
+		var latencyMs sql.NullInt64
+
+		if err := rows.Scan(
+			&r.ID, &r.ProviderID, &r.ProviderName, &r.Platform,
+			&success, &balance, &currency, &errorMsg, &latencyMs, &r.CheckedAt,
+		); err != nil {
+			continue
+		}
+
+		r.Success = success == 1
+		r.Balance = balance.Float64
+		r.Currency = currency.String
+		r.ErrorMessage = errorMsg.String
+		r.LatencyMs = int(latencyMs.Int64)
+		history = append(history, r)
+	}
+	return history, nil
+}
+
+// belowFloor 返回 provider 最近一次查询到的余额是否低于 MinBalance
+// 查询失败或尚未查询时不跳过（余额接口故障不应影响正常转发）
+func (bs *BalanceService) belowFloor(platform string, provider *Provider) (*BalanceResult, bool) {
+	if bs == nil || provider.BalanceProbe == nil || !provider.BalanceProbe.Enabled || provider.BalanceProbe.MinBalance <= 0 {
+		return nil, false
+	}
+
+	bs.mu.RLock()
+	result := bs.latest[platform+":"+provider.Name]
+	bs.mu.RUnlock()
+	if result == nil || !result.Success {
+		return nil, false
+	}
+	// 使用当前配置的最低余额判断（修改配置后立即生效，无需等待下一次查询）
+	return result, result.Balance < provider.BalanceProbe.MinBalance
+}
+
+// StartBackgroundPolling 启动后台定时查询
+func (bs *BalanceService) StartBackgroundPolling() {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+
+	if bs.running {
+		return
+	}
+
+	bs.stopChan = make(chan struct{})
+	bs.running = true
+	stopChan := bs.stopChan
+
+	go func() {
+		// 立即执行一次
+		bs.RunAllBalanceChecks()
+
+		ticker := time.NewTicker(bs.pollInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				bs.RunAllBalanceChecks()
+			case <-stopChan:
+				log.Println("[Balance] 后台余额查询已停止")
+				return
+			}
+		}
+	}()
+
+	log.Printf("[Balance] 后台余额查询已启动（间隔: %v）", bs.pollInterval)
+}
+
+// StopBackgroundPolling 停止后台查询
+func (bs *BalanceService) StopBackgroundPolling() {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+
+	if !bs.running {
+		return
+	}
+
+	close(bs.stopChan)
+	bs.running = false
+}
+
+// lowBalanceProvider 返回 provider 余额低于最低余额时的查询结果（未配置余额服务时返回 nil）
+func (prs *ProviderRelayService) lowBalanceProvider(kind string, provider *Provider) *BalanceResult {
+	if result, below := prs.balances.belowFloor(kind, provider); below {
+		return result
+	}
+	return nil
+}
+
+// SetBalanceService 设置余额查询服务（用于在请求路径上跳过余额不足的 provider）
+func (prs *ProviderRelayService) SetBalanceService(balances *BalanceService) {
+	prs.balances = balances
+}
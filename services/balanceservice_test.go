@@ -0,0 +1,167 @@
+package services
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// ==================== 余额查询测试 ====================
+
+func TestBalanceService_Probe(t *testing.T) {
+	upstreamServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/user/self":
+			if r.Header.Get("Authorization") != "Bearer sys-token" || r.Header.Get("New-Api-User") != "1" {
+				w.WriteHeader(http.StatusUnauthorized)
+				w.Write([]byte(`{"success":false}`))
+				return
+			}
+			w.Write([]byte(`{"data":{"quota":2500000}}`))
+		case "/v1/balance":
+			if r.Header.Get("X-Token") != "k" || r.Method != http.MethodPost {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			body, _ := io.ReadAll(r.Body)
+			if string(body) != `{"scope":"all"}` {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			w.Write([]byte(`{"balance_infos":[{"currency":"CNY","total_balance":"1,234.50"}]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer upstreamServer.Close()
+
+	tests := []struct {
+		name         string
+		probe        BalanceProbe
+		wantSuccess  bool
+		wantBalance  float64
+		wantCurrency string
+		wantErr      string
+	}{
+		{
+			name: "new-api 额度换算",
+			probe: BalanceProbe{Enabled: true, URL: "/api/user/self", Token: "sys-token", Headers: map[string]string{"New-Api-User": "1"},
+				BalancePath: "data.quota", Divisor: 500000, Currency: "USD"},
+			wantSuccess: true, wantBalance: 5, wantCurrency: "USD",
+		},
+		{
+			name: "自定义 Header 与 POST 请求体",
+			probe: BalanceProbe{Enabled: true, URL: upstreamServer.URL + "/v1/balance", Method: "post", AuthType: "X-Token", Body: `{"scope":"all"}`,
+				BalancePath: "balance_infos.0.total_balance", CurrencyPath: "balance_infos.0.currency"},
+			wantSuccess: true, wantBalance: 1234.5, wantCurrency: "CNY",
+		},
+		{
+			name:    "HTTP 错误",
+			probe:   BalanceProbe{Enabled: true, URL: "/api/user/self", BalancePath: "data.quota"},
+			wantErr: "HTTP 401",
+		},
+		{
+			name:    "路径不存在",
+			probe:   BalanceProbe{Enabled: true, URL: "/api/user/self", Token: "sys-token", Headers: map[string]string{"New-Api-User": "1"}, BalancePath: "data.balance"},
+			wantErr: "未找到路径",
+		},
+	}
+
+	bs := NewBalanceService(NewProviderService())
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := &Provider{ID: 1, Name: "relay", APIURL: upstreamServer.URL + "/", APIKey: "k", BalanceProbe: &tt.probe}
+			result := bs.probe(context.Background(), "claude", provider)
+			if result.Success != tt.wantSuccess {
+				t.Fatalf("期望 success=%v，实际 %+v", tt.wantSuccess, result)
+			}
+			if tt.wantSuccess && (result.Balance != tt.wantBalance || result.Currency != tt.wantCurrency) {
+				t.Errorf("期望余额 %v %s，实际 %v %s", tt.wantBalance, tt.wantCurrency, result.Balance, result.Currency)
+			}
+			if tt.wantErr != "" && !strings.Contains(result.ErrorMessage, tt.wantErr) {
+				t.Errorf("期望错误包含 %q，实际 %q", tt.wantErr, result.ErrorMessage)
+			}
+		})
+	}
+}
+
+func TestBalanceService_BelowFloor(t *testing.T) {
+	bs := NewBalanceService(NewProviderService())
+	provider := &Provider{Name: "relay", BalanceProbe: &BalanceProbe{Enabled: true, URL: "/balance", BalancePath: "balance", MinBalance: 2}}
+
+	if _, below := bs.belowFloor("claude", provider); below {
+		t.Errorf("尚未查询时不应跳过")
+	}
+
+	bs.record(&BalanceResult{Platform: "claude", ProviderName: "relay", Success: false, ErrorMessage: "网络错误"})
+	if _, below := bs.belowFloor("claude", provider); below {
+		t.Errorf("查询失败时不应跳过")
+	}
+
+	bs.record(&BalanceResult{Platform: "claude", ProviderName: "relay", Success: true, Balance: 1.5})
+	if _, below := bs.belowFloor("claude", provider); !below {
+		t.Errorf("余额低于最低余额时应跳过")
+	}
+	if _, below := bs.belowFloor("codex", provider); below {
+		t.Errorf("不同平台的同名 provider 不应受影响")
+	}
+
+	provider.BalanceProbe.MinBalance = 1
+	if _, below := bs.belowFloor("claude", provider); below {
+		t.Errorf("调低最低余额后应立即恢复")
+	}
+
+	var nilService *BalanceService
+	if _, below := nilService.belowFloor("claude", provider); below {
+		t.Errorf("未配置余额服务时不应跳过")
+	}
+}
+
+func TestBalanceProbe_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		probe   *BalanceProbe
+		wantErr string
+	}{
+		{"未配置", nil, ""},
+		{"未启用不校验", &BalanceProbe{URL: "bad"}, ""},
+		{"相对路径", &BalanceProbe{Enabled: true, URL: "/api/user/self", BalancePath: "data.quota"}, ""},
+		{"地址为空", &BalanceProbe{Enabled: true, BalancePath: "data.quota"}, "地址不能为空"},
+		{"地址无效", &BalanceProbe{Enabled: true, URL: "example.com/balance", BalancePath: "b"}, "地址无效"},
+		{"方法无效", &BalanceProbe{Enabled: true, URL: "/b", Method: "PUT", BalancePath: "b"}, "请求方法无效"},
+		{"缺少路径", &BalanceProbe{Enabled: true, URL: "/b"}, "JSON 路径不能为空"},
+		{"最低余额为负数", &BalanceProbe{Enabled: true, URL: "/b", BalancePath: "b", MinBalance: -1}, "最低余额不能为负数"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := Provider{Name: "p", BalanceProbe: tt.probe}
+			errs := strings.Join(provider.ValidateConfiguration(), "; ")
+			if tt.wantErr == "" && errs != "" {
+				t.Errorf("期望验证通过，实际 %s", errs)
+			}
+			if tt.wantErr != "" && !strings.Contains(errs, tt.wantErr) {
+				t.Errorf("期望错误包含 %q，实际 %q", tt.wantErr, errs)
+			}
+		})
+	}
+}
+
+// TestPinnedProvider_LowBalance 固定的 provider 余额不足时直接返回错误
+func TestPinnedProvider_LowBalance(t *testing.T) {
+	blacklistService := NewBlacklistService(NewSettingsService(), NewNotificationService(nil))
+	relayService := NewProviderRelayService(NewProviderService(), nil, blacklistService, nil, nil, "")
+	balances := NewBalanceService(NewProviderService())
+	relayService.SetBalanceService(balances)
+
+	provider := &Provider{Name: "relay", APIURL: "http://127.0.0.1:1", APIKey: "k", Enabled: true,
+		BalanceProbe: &BalanceProbe{Enabled: true, URL: "/balance", BalancePath: "balance", MinBalance: 5}}
+	balances.record(&BalanceResult{Platform: "claude", ProviderName: "relay", Success: true, Balance: 0.8})
+
+	errMsg := relayService.pinnedProviderUnavailable("claude", provider, "claude-sonnet-4", 10)
+	if !strings.Contains(errMsg, "below the configured floor") {
+		t.Errorf("余额不足时应返回错误，实际 %q", errMsg)
+	}
+}
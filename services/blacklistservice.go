@@ -4,6 +4,7 @@ import (
 	"database/sql"
 	"fmt"
 	"log"
+	"sort"
 	"time"
 
 	"github.com/daodao97/xgo/xdb"
@@ -13,6 +14,7 @@ import (
 type BlacklistService struct {
 	settingsService     *SettingsService
 	notificationService *NotificationService
+	cooldowns           *cooldownTracker // 上游限流 / 过载冷却（不影响拉黑等级）
 }
 
 // BlacklistStatus 黑名单状态（用于前端展示）
@@ -30,12 +32,16 @@ type BlacklistStatus struct {
 	BlacklistLevel       int        `json:"blacklistLevel"`       // 当前黑名单等级 (0-5)
 	LastRecoveredAt      *time.Time `json:"lastRecoveredAt"`      // 最后恢复时间
 	ForgivenessRemaining int        `json:"forgivenessRemaining"` // 距离宽恕还剩多少秒（3小时倒计时）
+
+	// 上游 429 / 529 冷却（按 Retry-After 计时，不增加失败计数和拉黑等级）
+	Cooldowns []CooldownStatus `json:"cooldowns"`
 }
 
 func NewBlacklistService(settingsService *SettingsService, notificationService *NotificationService) *BlacklistService {
 	return &BlacklistService{
 		settingsService:     settingsService,
 		notificationService: notificationService,
+		cooldowns:           newCooldownTracker(),
 	}
 }
 
@@ -489,6 +495,9 @@ func (bs *BlacklistService) ManualUnblockAndReset(platform string, providerName
 
 	now := time.Now()
 
+	// 同时清除上游限流 / 过载冷却
+	cooled := bs.cooldowns.clear(platform, providerName)
+
 	// 先检查记录是否存在
 	var exists int
 	err = db.QueryRow(`
@@ -497,6 +506,10 @@ func (bs *BlacklistService) ManualUnblockAndReset(platform string, providerName
 	`, platform, providerName).Scan(&exists)
 
 	if err == sql.ErrNoRows {
+		if cooled {
+			log.Printf("✅ 手动解除冷却: %s/%s", platform, providerName)
+			return nil
+		}
 		return fmt.Errorf("provider %s/%s 不在黑名单中", platform, providerName)
 	} else if err != nil {
 		return fmt.Errorf("查询黑名单记录失败: %w", err)
@@ -739,6 +752,23 @@ func (bs *BlacklistService) GetBlacklistStatus(platform string) ([]BlacklistStat
 		statuses = append(statuses, s)
 	}
 
+	// 合并上游冷却（没有黑名单记录的 provider 单独追加）
+	cooldowns := bs.cooldowns.list(platform)
+	for i := range statuses {
+		if items, ok := cooldowns[statuses[i].ProviderName]; ok {
+			statuses[i].Cooldowns = items
+			delete(cooldowns, statuses[i].ProviderName)
+		}
+	}
+	names := make([]string, 0, len(cooldowns))
+	for name := range cooldowns {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		statuses = append(statuses, BlacklistStatus{Platform: platform, ProviderName: name, Cooldowns: cooldowns[name]})
+	}
+
 	return statuses, nil
 }
 
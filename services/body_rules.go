@@ -0,0 +1,254 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// 请求体改写操作
+const (
+	BodyRuleRemove       = "remove"        // 删除字段
+	BodyRuleSet          = "set"           // 设置字段（存在则覆盖）
+	BodyRuleRename       = "rename"        // 重命名字段（Path -> To）
+	BodyRuleDefault      = "default"       // 字段不存在时设置默认值
+	BodyRuleFilterHeader = "filter_header" // 从逗号分隔的 Header 值中移除指定项（如 anthropic-beta）
+)
+
+// BodyRule 请求体改写规则（Provider 级别，按顺序执行）
+// Path 使用 gjson 路径语法，"#" 表示数组的每个元素，如 "tools.#.cache_control"
+// filter_header 时 Path 为 Header 名，Items 为要移除的值（支持 * 通配符）
+//
+// 示例：
+//
+//	{"op": "remove", "path": "tools.#.cache_control"}
+//	{"op": "remove", "path": "thinking.budget_tokens"}
+//	{"op": "default", "path": "max_tokens", "value": 8192}
+//	{"op": "filter_header", "path": "anthropic-beta", "items": ["context-1m-*"]}
+type BodyRule struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	To    string          `json:"to,omitempty"`    // rename 的目标路径
+	Value json.RawMessage `json:"value,omitempty"` // set / default 的 JSON 值
+	Items []string        `json:"items,omitempty"` // filter_header 要移除的值
+}
+
+// Validate 校验规则，返回错误信息（空表示合法）
+func (r *BodyRule) Validate() string {
+	if strings.TrimSpace(r.Path) == "" {
+		return fmt.Sprintf("请求体规则 %s 缺少 path", r.Op)
+	}
+	switch r.Op {
+	case BodyRuleRemove:
+	case BodyRuleSet, BodyRuleDefault:
+		if len(r.Value) == 0 || !json.Valid(r.Value) {
+			return fmt.Sprintf("请求体规则 %s '%s' 的 value 不是合法的 JSON", r.Op, r.Path)
+		}
+	case BodyRuleRename:
+		if strings.TrimSpace(r.To) == "" {
+			return fmt.Sprintf("请求体规则 rename '%s' 缺少 to", r.Path)
+		}
+		if strings.Contains(r.Path, "#") != strings.Contains(r.To, "#") {
+			return fmt.Sprintf("请求体规则 rename '%s' -> '%s' 的 # 必须成对出现", r.Path, r.To)
+		}
+	case BodyRuleFilterHeader:
+		if len(r.Items) == 0 {
+			return fmt.Sprintf("请求体规则 filter_header '%s' 缺少 items", r.Path)
+		}
+	default:
+		return fmt.Sprintf("请求体规则操作无效：'%s'", r.Op)
+	}
+	return ""
+}
+
+// applyBodyRules 按顺序对请求体和请求头执行改写规则
+// 请求体不是 JSON 时只执行 filter_header；单条规则失败时记录日志并继续执行后续规则
+func applyBodyRules(rules []BodyRule, body []byte, headers http.Header) []byte {
+	isJSON := gjson.ValidBytes(body)
+	for _, rule := range rules {
+		if rule.Op == BodyRuleFilterHeader {
+			filterHeaderItems(headers, rule.Path, rule.Items)
+			continue
+		}
+		if !isJSON {
+			continue
+		}
+		modified, err := applyBodyRule(rule, body)
+		if err != nil {
+			fmt.Printf("[WARN] 请求体规则 %s '%s' 执行失败: %v\n", rule.Op, rule.Path, err)
+			continue
+		}
+		body = modified
+	}
+	return body
+}
+
+// applyBodyRule 执行单条请求体规则
+func applyBodyRule(rule BodyRule, body []byte) ([]byte, error) {
+	var err error
+	switch rule.Op {
+	case BodyRuleRemove:
+		paths := expandBodyPath(body, rule.Path)
+		// 倒序删除，避免删除数组元素后下标错位
+		for i := len(paths) - 1; i >= 0; i-- {
+			if body, err = sjson.DeleteBytes(body, paths[i]); err != nil {
+				return nil, err
+			}
+		}
+	case BodyRuleSet, BodyRuleDefault:
+		for _, path := range expandBodyPath(body, rule.Path) {
+			if rule.Op == BodyRuleDefault && gjson.GetBytes(body, path).Exists() {
+				continue
+			}
+			if body, err = sjson.SetRawBytes(body, path, rule.Value); err != nil {
+				return nil, err
+			}
+		}
+	case BodyRuleRename:
+		paths := expandBodyPath(body, rule.Path)
+		for i := len(paths) - 1; i >= 0; i-- {
+			value := gjson.GetBytes(body, paths[i])
+			if !value.Exists() {
+				continue
+			}
+			target := renameTargetPath(paths[i], rule.Path, rule.To)
+			if body, err = sjson.DeleteBytes(body, paths[i]); err != nil {
+				return nil, err
+			}
+			if body, err = sjson.SetRawBytes(body, target, []byte(value.Raw)); err != nil {
+				return nil, err
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unknown op %q", rule.Op)
+	}
+	return body, nil
+}
+
+// expandBodyPath 将路径中的 "#"（数组每个元素）展开为具体下标路径
+// 不含 "#" 时原样返回（set / default 可以创建不存在的字段）
+func expandBodyPath(body []byte, path string) []string {
+	segments := strings.Split(path, ".")
+	idx := -1
+	for i, segment := range segments {
+		if segment == "#" {
+			idx = i
+			break
+		}
+	}
+	if idx < 0 {
+		return []string{path}
+	}
+
+	prefix := strings.Join(segments[:idx], ".")
+	rest := strings.Join(segments[idx+1:], ".")
+	array := gjson.GetBytes(body, prefix)
+	if prefix == "" {
+		array = gjson.ParseBytes(body)
+	}
+	if !array.IsArray() {
+		return nil
+	}
+
+	var paths []string
+	for i := range array.Array() {
+		elem := strconv.Itoa(i)
+		if prefix != "" {
+			elem = prefix + "." + elem
+		}
+		if rest == "" {
+			paths = append(paths, elem)
+			continue
+		}
+		paths = append(paths, expandBodyPath(body, elem+"."+rest)...)
+	}
+	return paths
+}
+
+// renameTargetPath 把展开后源路径中的数组下标依次填入目标路径的 "#"
+// 如 path=tools.2.input_schema，pattern=tools.#.input_schema，to=tools.#.parameters -> tools.2.parameters
+func renameTargetPath(path string, pattern string, to string) string {
+	if !strings.Contains(to, "#") {
+		return to
+	}
+	pathSegments := strings.Split(path, ".")
+	var indexes []string
+	for i, segment := range strings.Split(pattern, ".") {
+		// 每个 "#" 恰好展开为一个下标，源路径与 pattern 的段一一对应
+		if segment == "#" && i < len(pathSegments) {
+			indexes = append(indexes, pathSegments[i])
+		}
+	}
+	toSegments := strings.Split(to, ".")
+	for i, segment := range toSegments {
+		if segment == "#" && len(indexes) > 0 {
+			toSegments[i] = indexes[0]
+			indexes = indexes[1:]
+		}
+	}
+	return strings.Join(toSegments, ".")
+}
+
+// filterHeaderItems 从逗号分隔的 Header 值中移除匹配的项，全部移除时删除该 Header
+func filterHeaderItems(headers http.Header, name string, items []string) {
+	values := headers.Values(name)
+	if len(values) == 0 {
+		return
+	}
+	var kept []string
+	for _, value := range values {
+		for _, item := range strings.Split(value, ",") {
+			item = strings.TrimSpace(item)
+			if item == "" || matchAnyGlob(items, item, true) {
+				continue
+			}
+			kept = append(kept, item)
+		}
+	}
+	headers.Del(name)
+	if len(kept) > 0 {
+		headers.Set(name, strings.Join(kept, ","))
+	}
+}
+
+// BodyRulePreview 请求体规则试运行结果
+type BodyRulePreview struct {
+	RequestBody string            `json:"request_body"`
+	Headers     map[string]string `json:"headers"`
+}
+
+// PreviewBodyRules 用缓存的请求详情试运行请求体规则（不发送请求）
+func (s *RequestDetailService) PreviewBodyRules(sequenceID int64, rules []BodyRule) (*BodyRulePreview, error) {
+	detail := s.GetDetail(sequenceID)
+	if detail == nil {
+		return nil, fmt.Errorf("请求详情 %d 不存在或已过期", sequenceID)
+	}
+	return previewBodyRules(detail, rules)
+}
+
+// previewBodyRules 对请求详情执行请求体规则
+func previewBodyRules(detail *RequestDetail, rules []BodyRule) (*BodyRulePreview, error) {
+	if detail.Truncated && detail.RequestSize > len(detail.RequestBody) {
+		return nil, fmt.Errorf("请求体已截断（%d 字节），无法试运行", detail.RequestSize)
+	}
+	for i := range rules {
+		if errMsg := rules[i].Validate(); errMsg != "" {
+			return nil, fmt.Errorf("%s", errMsg)
+		}
+	}
+	headers := make(http.Header, len(detail.Headers))
+	for k, v := range detail.Headers {
+		headers.Set(k, v)
+	}
+	body := applyBodyRules(rules, []byte(detail.RequestBody), headers)
+	preview := &BodyRulePreview{RequestBody: string(body), Headers: make(map[string]string, len(headers))}
+	for k := range headers {
+		preview.Headers[k] = headers.Get(k)
+	}
+	return preview, nil
+}
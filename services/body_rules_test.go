@@ -0,0 +1,180 @@
+package services
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+// ==================== 请求体改写规则测试 ====================
+
+func TestApplyBodyRules(t *testing.T) {
+	body := `{"model":"claude-sonnet-4","metadata":{"user_id":"u1"},"thinking":{"type":"enabled","budget_tokens":2048},` +
+		`"tools":[{"name":"a","input_schema":{},"cache_control":{"type":"ephemeral"}},{"name":"b","input_schema":{}}]}`
+
+	tests := []struct {
+		name   string
+		rule   BodyRule
+		check  string // gjson 路径
+		expect string // 期望的 Raw 值（空表示字段不存在）
+	}{
+		{"删除字段", BodyRule{Op: BodyRuleRemove, Path: "metadata"}, "metadata", ""},
+		{"删除嵌套字段", BodyRule{Op: BodyRuleRemove, Path: "thinking.budget_tokens"}, "thinking.budget_tokens", ""},
+		{"删除数组每个元素的字段", BodyRule{Op: BodyRuleRemove, Path: "tools.#.cache_control"}, "tools.0.cache_control", ""},
+		{"设置字段", BodyRule{Op: BodyRuleSet, Path: "thinking.type", Value: json.RawMessage(`"disabled"`)}, "thinking.type", `"disabled"`},
+		{"默认值不覆盖已有字段", BodyRule{Op: BodyRuleDefault, Path: "thinking.budget_tokens", Value: json.RawMessage(`1024`)}, "thinking.budget_tokens", `2048`},
+		{"默认值补充缺失字段", BodyRule{Op: BodyRuleDefault, Path: "max_tokens", Value: json.RawMessage(`8192`)}, "max_tokens", `8192`},
+		{"重命名字段", BodyRule{Op: BodyRuleRename, Path: "metadata", To: "extra.metadata"}, "extra.metadata.user_id", `"u1"`},
+		{"按数组下标重命名", BodyRule{Op: BodyRuleRename, Path: "tools.#.input_schema", To: "tools.#.parameters"}, "tools.1.parameters", `{}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if errMsg := tt.rule.Validate(); errMsg != "" {
+				t.Fatalf("规则应合法: %s", errMsg)
+			}
+			result := applyBodyRules([]BodyRule{tt.rule}, []byte(body), http.Header{})
+			if !gjson.ValidBytes(result) {
+				t.Fatalf("改写后应仍是合法 JSON: %s", result)
+			}
+			got := gjson.GetBytes(result, tt.check)
+			if tt.expect == "" {
+				if got.Exists() {
+					t.Errorf("%s 应被删除，实际 %s", tt.check, got.Raw)
+				}
+				return
+			}
+			if got.Raw != tt.expect {
+				t.Errorf("%s 期望 %s，实际 %s", tt.check, tt.expect, got.Raw)
+			}
+		})
+	}
+
+	// 其他元素与字段不受影响
+	result := applyBodyRules([]BodyRule{{Op: BodyRuleRemove, Path: "tools.#.cache_control"}}, []byte(body), http.Header{})
+	if gjson.GetBytes(result, "tools.#").Int() != 2 || gjson.GetBytes(result, "tools.1.name").String() != "b" {
+		t.Errorf("删除字段不应影响数组其他内容: %s", result)
+	}
+}
+
+func TestApplyBodyRules_Order(t *testing.T) {
+	rules := []BodyRule{
+		{Op: BodyRuleRename, Path: "metadata.user_id", To: "user"},
+		{Op: BodyRuleRemove, Path: "metadata"},
+	}
+	result := applyBodyRules(rules, []byte(`{"metadata":{"user_id":"u1"}}`), http.Header{})
+	if gjson.GetBytes(result, "user").String() != "u1" || gjson.GetBytes(result, "metadata").Exists() {
+		t.Errorf("规则应按顺序执行，实际 %s", result)
+	}
+
+	// 非 JSON 请求体不改写
+	raw := []byte("not json")
+	if got := applyBodyRules(rules, raw, http.Header{}); string(got) != "not json" {
+		t.Errorf("非 JSON 请求体不应改写，实际 %s", got)
+	}
+}
+
+func TestFilterHeaderItems(t *testing.T) {
+	headers := http.Header{}
+	headers.Set("Anthropic-Beta", "claude-code-20250219, context-1m-2025-08-07,interleaved-thinking-2025-05-14")
+	applyBodyRules([]BodyRule{{Op: BodyRuleFilterHeader, Path: "anthropic-beta", Items: []string{"context-1m-*"}}}, []byte(`{}`), headers)
+	if got := headers.Get("Anthropic-Beta"); got != "claude-code-20250219,interleaved-thinking-2025-05-14" {
+		t.Errorf("应只移除匹配的 beta，实际 %q", got)
+	}
+
+	filterHeaderItems(headers, "Anthropic-Beta", []string{"*"})
+	if _, ok := headers["Anthropic-Beta"]; ok {
+		t.Errorf("全部移除后应删除该 Header")
+	}
+}
+
+func TestBodyRule_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		rule    BodyRule
+		wantErr bool
+	}{
+		{"合法删除", BodyRule{Op: BodyRuleRemove, Path: "metadata"}, false},
+		{"缺少 path", BodyRule{Op: BodyRuleRemove}, true},
+		{"未知操作", BodyRule{Op: "drop", Path: "metadata"}, true},
+		{"set 缺少 value", BodyRule{Op: BodyRuleSet, Path: "a"}, true},
+		{"set value 非法", BodyRule{Op: BodyRuleSet, Path: "a", Value: json.RawMessage(`{bad`)}, true},
+		{"rename 缺少 to", BodyRule{Op: BodyRuleRename, Path: "a"}, true},
+		{"rename # 不成对", BodyRule{Op: BodyRuleRename, Path: "tools.#.a", To: "b"}, true},
+		{"filter_header 缺少 items", BodyRule{Op: BodyRuleFilterHeader, Path: "anthropic-beta"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.rule.Validate() != ""; got != tt.wantErr {
+				t.Errorf("期望 wantErr=%v，实际 %q", tt.wantErr, tt.rule.Validate())
+			}
+		})
+	}
+
+	provider := Provider{Name: "p", BodyRules: []BodyRule{{Op: "drop", Path: "metadata"}}}
+	if errs := provider.ValidateConfiguration(); len(errs) == 0 {
+		t.Errorf("非法请求体规则应导致 provider 配置验证失败")
+	}
+}
+
+// TestPreviewBodyRules 请求体规则可以针对缓存的请求详情试运行
+func TestPreviewBodyRules(t *testing.T) {
+	detail := &RequestDetail{
+		RequestBody: `{"model":"claude-sonnet-4","metadata":{"user_id":"u1"}}`,
+		Headers:     map[string]string{"Anthropic-Beta": "a,b"},
+		RequestSize: 55,
+	}
+	preview, err := previewBodyRules(detail, []BodyRule{
+		{Op: BodyRuleRemove, Path: "metadata"},
+		{Op: BodyRuleFilterHeader, Path: "anthropic-beta", Items: []string{"b"}},
+	})
+	if err != nil {
+		t.Fatalf("试运行失败: %v", err)
+	}
+	if strings.Contains(preview.RequestBody, "metadata") || preview.Headers["Anthropic-Beta"] != "a" {
+		t.Errorf("试运行结果不符合预期: %+v", preview)
+	}
+
+	truncated := &RequestDetail{RequestBody: `{"a":`, Truncated: true, RequestSize: 1 << 20}
+	if _, err := previewBodyRules(truncated, nil); err == nil {
+		t.Errorf("截断的请求体不应试运行")
+	}
+}
+
+// TestForwardRequest_BodyRules 规则作用于模型映射后实际发往上游的请求体
+func TestForwardRequest_BodyRules(t *testing.T) {
+	var gotBody []byte
+	var gotBeta string
+	upstreamServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotBody, _ = io.ReadAll(r.Body)
+		gotBeta = r.Header.Get("Anthropic-Beta")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id":"msg_1"}`))
+	}))
+	defer upstreamServer.Close()
+
+	relayService := NewProviderRelayService(NewProviderService(), nil, nil, nil, nil, "")
+	provider := Provider{Name: "strict", APIURL: upstreamServer.URL, APIKey: "k", Enabled: true, BodyRules: []BodyRule{
+		{Op: BodyRuleRemove, Path: "tools.#.cache_control"},
+		{Op: BodyRuleFilterHeader, Path: "anthropic-beta", Items: []string{"context-1m-*"}},
+	}}
+	clientHeaders := http.Header{}
+	clientHeaders.Set("Anthropic-Beta", "context-1m-2025-08-07,fine-grained-tool-streaming-2025-05-14")
+
+	c, _ := newHedgeTestContext()
+	ok, err := relayService.forwardRequest(c, "claude", provider, "/v1/messages", nil, clientHeaders,
+		[]byte(`{"model":"vendor-sonnet","tools":[{"name":"a","cache_control":{"type":"ephemeral"}}]}`), false, "vendor-sonnet", AuthMethodXAPIKey)
+	if !ok {
+		t.Fatalf("请求应成功: %v", err)
+	}
+	if gjson.GetBytes(gotBody, "tools.0.cache_control").Exists() || gjson.GetBytes(gotBody, "model").String() != "vendor-sonnet" {
+		t.Errorf("上游收到的请求体不符合预期: %s", gotBody)
+	}
+	if gotBeta != "fine-grained-tool-streaming-2025-05-14" {
+		t.Errorf("上游收到的 anthropic-beta 不符合预期: %q", gotBeta)
+	}
+}
@@ -0,0 +1,506 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	modelpricing "codeswitch/resources/model-pricing"
+
+	"github.com/daodao97/xgo/xdb"
+)
+
+// 预算周期
+const (
+	BudgetPeriodDaily   = "daily"   // 自然日（本地时间 0 点重置）
+	BudgetPeriodMonthly = "monthly" // 自然月（每月 1 日 0 点重置）
+)
+
+// 预算用尽后的处理方式
+const (
+	BudgetActionSkip   = "skip"   // 跳过匹配的 provider，切换到其他 provider（默认）
+	BudgetActionReject = "reject" // 直接拒绝请求并返回预算已用尽的错误
+)
+
+// 预算币种（request_log 的费用按官方美元单价计算，人民币预算按汇率换算）
+const (
+	BudgetCurrencyUSD = "USD"
+	BudgetCurrencyCNY = "CNY"
+)
+
+const (
+	defaultCNYPerUSD      = 7.2
+	budgetRefreshInterval = 30 * time.Second // 已花费金额的缓存时间（请求路径上最多每 30 秒查询一次 request_log）
+)
+
+// defaultBudgetThresholds 默认通知阈值（百分比）
+var defaultBudgetThresholds = []int{50, 80, 100}
+
+// Budget 花费预算：按周期累计 request_log 中匹配请求的费用，用尽后跳过 provider 或拒绝请求
+// Platform / Provider / Model 为空表示不限；Model 为实际模型名通配符（如 "*opus*"）
+// 示例："relay-X 每天 ¥50" => {provider: "relay-X", period: "daily", amount: 50, currency: "CNY"}
+type Budget struct {
+	Name     string  `json:"name"`
+	Enabled  bool    `json:"enabled"`
+	Period   string  `json:"period"`
+	Platform string  `json:"platform,omitempty"`
+	Provider string  `json:"provider,omitempty"`
+	Model    string  `json:"model,omitempty"`
+	Amount   float64 `json:"amount"`
+	Currency string  `json:"currency,omitempty"` // USD（默认）/ CNY
+	Action   string  `json:"action,omitempty"`   // skip（默认）/ reject
+}
+
+// BudgetConfig 预算配置（~/.code-switch/budgets.json）
+type BudgetConfig struct {
+	Budgets          []Budget `json:"budgets"`
+	NotifyThresholds []int    `json:"notifyThresholds,omitempty"` // 通知阈值百分比（默认 50 / 80 / 100）
+	CNYPerUSD        float64  `json:"cnyPerUsd,omitempty"`        // 人民币预算的换算汇率（默认 7.2）
+}
+
+// BudgetStatus 预算当前周期的使用情况（金额均为预算币种）
+type BudgetStatus struct {
+	Name      string  `json:"name"`
+	Period    string  `json:"period"`
+	Platform  string  `json:"platform,omitempty"`
+	Provider  string  `json:"provider,omitempty"`
+	Model     string  `json:"model,omitempty"`
+	Currency  string  `json:"currency"`
+	Action    string  `json:"action"`
+	Amount    float64 `json:"amount"`
+	Spent     float64 `json:"spent"`
+	Percent   float64 `json:"percent"`
+	Exhausted bool    `json:"exhausted"`
+}
+
+// Validate 校验预算配置
+func (b *Budget) Validate() []string {
+	errors := make([]string, 0)
+	if strings.TrimSpace(b.Name) == "" {
+		errors = append(errors, "预算名称不能为空")
+	}
+	if b.Period != BudgetPeriodDaily && b.Period != BudgetPeriodMonthly {
+		errors = append(errors, fmt.Sprintf("预算周期无效：'%s'（支持 daily / monthly）", b.Period))
+	}
+	if b.Amount <= 0 {
+		errors = append(errors, "预算金额必须大于 0")
+	}
+	if b.Currency != "" && b.Currency != BudgetCurrencyUSD && b.Currency != BudgetCurrencyCNY {
+		errors = append(errors, fmt.Sprintf("币种无效：'%s'（支持 USD / CNY）", b.Currency))
+	}
+	if b.Action != "" && b.Action != BudgetActionSkip && b.Action != BudgetActionReject {
+		errors = append(errors, fmt.Sprintf("处理方式无效：'%s'（支持 skip / reject）", b.Action))
+	}
+	if b.Platform != "" && b.Platform != "gemini" && !isRoutablePlatform(b.Platform) {
+		errors = append(errors, fmt.Sprintf("平台无效：'%s'（支持 claude / codex / gemini / custom:{toolId}）", b.Platform))
+	}
+	return errors
+}
+
+// matches 请求（平台 + provider + 实际模型）是否计入该预算
+func (b *Budget) matches(platform, provider, model string) bool {
+	if b.Platform != "" && b.Platform != platform {
+		return false
+	}
+	if b.Provider != "" && b.Provider != provider {
+		return false
+	}
+	return b.Model == "" || matchGlob(strings.ToLower(b.Model), strings.ToLower(model))
+}
+
+func (b *Budget) currency() string {
+	if b.Currency == "" {
+		return BudgetCurrencyUSD
+	}
+	return b.Currency
+}
+
+func (b *Budget) action() string {
+	if b.Action == "" {
+		return BudgetActionSkip
+	}
+	return b.Action
+}
+
+// budgetPeriodStart 返回 now 所在预算周期的起始时间
+func budgetPeriodStart(period string, now time.Time) time.Time {
+	if period == BudgetPeriodMonthly {
+		y, m, _ := now.Date()
+		return time.Date(y, m, 1, 0, 0, 0, 0, now.Location())
+	}
+	return startOfDay(now)
+}
+
+// spendRecord request_log 中一次请求的费用（美元）
+type spendRecord struct {
+	platform string
+	provider string
+	model    string
+	cost     float64
+	at       time.Time
+}
+
+// BudgetService 预算管理：保存预算配置、按 request_log 统计已花费金额并在达到阈值时通知
+type BudgetService struct {
+	notificationService *NotificationService
+	pricing             *modelpricing.Service
+
+	mu          sync.Mutex
+	statuses    []BudgetStatus
+	refreshedAt time.Time
+	notified    map[string]int // 预算名|周期起点 -> 已通知的最高阈值（仅内存，重启后重新通知）
+
+	refreshMu   sync.Mutex // 保证同一时间只有一个请求在刷新统计，其他请求使用上一次的结果
+	now         func() time.Time
+	loadRecords func(since time.Time) ([]spendRecord, error)
+}
+
+// NewBudgetService 创建预算服务
+func NewBudgetService(notificationService *NotificationService) *BudgetService {
+	bs := &BudgetService{
+		notificationService: notificationService,
+		notified:            make(map[string]int),
+		now:                 time.Now,
+	}
+	if pricing, err := modelpricing.DefaultService(); err == nil {
+		bs.pricing = pricing
+	}
+	bs.loadRecords = bs.querySpendRecords
+	return bs
+}
+
+func budgetsFilePath() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(home, ".code-switch")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "budgets.json"), nil
+}
+
+// LoadBudgets 读取预算配置
+func (bs *BudgetService) LoadBudgets() (BudgetConfig, error) {
+	config := BudgetConfig{Budgets: []Budget{}}
+	path, err := budgetsFilePath()
+	if err != nil {
+		return config, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return config, nil
+		}
+		return config, err
+	}
+	if len(data) == 0 {
+		return config, nil
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return config, err
+	}
+	return config, nil
+}
+
+// SaveBudgets 校验并保存预算配置，保存后立即重新统计
+func (bs *BudgetService) SaveBudgets(config BudgetConfig) error {
+	validationErrors := make([]string, 0)
+	names := make(map[string]bool, len(config.Budgets))
+	for i := range config.Budgets {
+		label := config.Budgets[i].Name
+		if label == "" {
+			label = fmt.Sprintf("#%d", i+1)
+		}
+		for _, errMsg := range config.Budgets[i].Validate() {
+			validationErrors = append(validationErrors, fmt.Sprintf("[%s] %s", label, errMsg))
+		}
+		if names[config.Budgets[i].Name] {
+			validationErrors = append(validationErrors, fmt.Sprintf("[%s] 预算名称重复", label))
+		}
+		names[config.Budgets[i].Name] = true
+	}
+	for _, threshold := range config.NotifyThresholds {
+		if threshold <= 0 || threshold > 1000 {
+			validationErrors = append(validationErrors, fmt.Sprintf("通知阈值无效：%d%%", threshold))
+		}
+	}
+	if config.CNYPerUSD < 0 {
+		validationErrors = append(validationErrors, "汇率不能为负数")
+	}
+	if len(validationErrors) > 0 {
+		return fmt.Errorf("预算验证失败：\n  - %s", strings.Join(validationErrors, "\n  - "))
+	}
+
+	path, err := budgetsFilePath()
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+
+	bs.mu.Lock()
+	bs.refreshedAt = time.Time{}
+	bs.mu.Unlock()
+	return nil
+}
+
+// GetBudgetStatus 返回各预算当前周期的使用情况
+func (bs *BudgetService) GetBudgetStatus() ([]BudgetStatus, error) {
+	config, err := bs.LoadBudgets()
+	if err != nil {
+		return nil, err
+	}
+	records, err := bs.loadRecords(bs.earliestPeriodStart(config))
+	if err != nil {
+		return nil, err
+	}
+	statuses := bs.evaluate(config, records)
+	bs.mu.Lock()
+	bs.statuses = statuses
+	bs.refreshedAt = bs.now()
+	bs.mu.Unlock()
+	return statuses, nil
+}
+
+// exhausted 返回请求命中的第一个已用尽预算（reject 优先于 skip），nil 表示未超出
+// 统计结果缓存 budgetRefreshInterval，两次刷新之间的花费不计入，可能小幅超出预算
+func (bs *BudgetService) exhausted(platform, provider, model string) *BudgetStatus {
+	if bs == nil {
+		return nil
+	}
+	bs.refreshIfStale()
+
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	var found *BudgetStatus
+	for i := range bs.statuses {
+		status := &bs.statuses[i]
+		budget := Budget{Platform: status.Platform, Provider: status.Provider, Model: status.Model}
+		if !status.Exhausted || !budget.matches(platform, provider, model) {
+			continue
+		}
+		if status.Action == BudgetActionReject {
+			copied := *status
+			return &copied
+		}
+		if found == nil {
+			copied := *status
+			found = &copied
+		}
+	}
+	return found
+}
+
+// refreshIfStale 统计结果过期时重新统计（其他请求正在刷新时直接使用旧结果）
+func (bs *BudgetService) refreshIfStale() {
+	bs.mu.Lock()
+	fresh := bs.now().Sub(bs.refreshedAt) < budgetRefreshInterval
+	bs.mu.Unlock()
+	if fresh || !bs.refreshMu.TryLock() {
+		return
+	}
+	defer bs.refreshMu.Unlock()
+
+	if _, err := bs.GetBudgetStatus(); err != nil {
+		fmt.Printf("[WARN] 统计预算花费失败: %v\n", err)
+		// 失败时同样推迟下一次刷新，避免每个请求都查询数据库
+		bs.mu.Lock()
+		bs.refreshedAt = bs.now()
+		bs.mu.Unlock()
+	}
+}
+
+// earliestPeriodStart 返回所有启用预算中最早的周期起点
+func (bs *BudgetService) earliestPeriodStart(config BudgetConfig) time.Time {
+	now := bs.now()
+	earliest := budgetPeriodStart(BudgetPeriodDaily, now)
+	for _, budget := range config.Budgets {
+		if budget.Enabled && budget.Period == BudgetPeriodMonthly {
+			return budgetPeriodStart(BudgetPeriodMonthly, now)
+		}
+	}
+	return earliest
+}
+
+// evaluate 按请求记录计算各启用预算的使用情况，并对新跨过的阈值发送通知
+func (bs *BudgetService) evaluate(config BudgetConfig, records []spendRecord) []BudgetStatus {
+	now := bs.now()
+	rate := config.CNYPerUSD
+	if rate <= 0 {
+		rate = defaultCNYPerUSD
+	}
+	thresholds := config.NotifyThresholds
+	if thresholds == nil {
+		thresholds = defaultBudgetThresholds
+	}
+
+	statuses := make([]BudgetStatus, 0, len(config.Budgets))
+	for i := range config.Budgets {
+		budget := &config.Budgets[i]
+		if !budget.Enabled || budget.Amount <= 0 {
+			continue
+		}
+		start := budgetPeriodStart(budget.Period, now)
+		spentUSD := 0.0
+		for _, record := range records {
+			if !record.at.Before(start) && budget.matches(record.platform, record.provider, record.model) {
+				spentUSD += record.cost
+			}
+		}
+		spent := spentUSD
+		if budget.currency() == BudgetCurrencyCNY {
+			spent = spentUSD * rate
+		}
+		status := BudgetStatus{
+			Name:      budget.Name,
+			Period:    budget.Period,
+			Platform:  budget.Platform,
+			Provider:  budget.Provider,
+			Model:     budget.Model,
+			Currency:  budget.currency(),
+			Action:    budget.action(),
+			Amount:    budget.Amount,
+			Spent:     spent,
+			Percent:   spent / budget.Amount * 100,
+			Exhausted: spent >= budget.Amount,
+		}
+		statuses = append(statuses, status)
+		bs.notifyThreshold(status, start, thresholds)
+	}
+	return statuses
+}
+
+// notifyThreshold 每个周期内每个阈值只通知一次（只通知新跨过的最高阈值）
+func (bs *BudgetService) notifyThreshold(status BudgetStatus, periodStart time.Time, thresholds []int) {
+	crossed := 0
+	for _, threshold := range thresholds {
+		if status.Percent >= float64(threshold) && threshold > crossed {
+			crossed = threshold
+		}
+	}
+	if crossed == 0 {
+		return
+	}
+	key := status.Name + "|" + periodStart.Format("2006-01-02")
+	bs.mu.Lock()
+	if bs.notified[key] >= crossed {
+		bs.mu.Unlock()
+		return
+	}
+	bs.notified[key] = crossed
+	bs.mu.Unlock()
+
+	fmt.Printf("[WARN] 💰 预算 %s 已使用 %.0f%%（%s）\n", status.Name, status.Percent, formatBudgetSpend(status))
+	if bs.notificationService != nil {
+		bs.notificationService.NotifyBudgetThreshold(status, crossed)
+	}
+}
+
+// querySpendRecords 从 request_log 读取 since 之后的请求并按官方单价计算费用
+func (bs *BudgetService) querySpendRecords(since time.Time) ([]spendRecord, error) {
+	model := xdb.New("request_log")
+	records, err := model.Selects(
+		// created_at 可能以 UTC 存储，多查一天后按解析出的本地时间过滤
+		xdb.WhereGte("created_at", since.Add(-24*time.Hour).Format(timeLayout)),
+		xdb.Field(
+			"platform",
+			"provider",
+			"model",
+			"input_tokens",
+			"output_tokens",
+			"reasoning_tokens",
+			"cache_create_tokens",
+			"cache_read_tokens",
+			"created_at",
+		),
+	)
+	if err != nil {
+		if errors.Is(err, xdb.ErrNotFound) || isNoSuchTableErr(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	result := make([]spendRecord, 0, len(records))
+	for _, record := range records {
+		createdAt, hasTime := parseCreatedAt(record)
+		if !hasTime || createdAt.Before(since) {
+			continue
+		}
+		cost := 0.0
+		if bs.pricing != nil {
+			cost = bs.pricing.CalculateCost(record.GetString("model"), modelpricing.UsageSnapshot{
+				InputTokens:       record.GetInt("input_tokens"),
+				OutputTokens:      record.GetInt("output_tokens"),
+				ReasoningTokens:   record.GetInt("reasoning_tokens"),
+				CacheCreateTokens: record.GetInt("cache_create_tokens"),
+				CacheReadTokens:   record.GetInt("cache_read_tokens"),
+			}).TotalCost
+		}
+		if cost <= 0 {
+			continue
+		}
+		result = append(result, spendRecord{
+			platform: record.GetString("platform"),
+			provider: record.GetString("provider"),
+			model:    record.GetString("model"),
+			cost:     cost,
+			at:       createdAt,
+		})
+	}
+	return result, nil
+}
+
+// formatBudgetSpend 格式化预算使用金额，如 "$201.30 / $200.00 本月"
+func formatBudgetSpend(status BudgetStatus) string {
+	symbol := "$"
+	if status.Currency == BudgetCurrencyCNY {
+		symbol = "¥"
+	}
+	period := "今日"
+	if status.Period == BudgetPeriodMonthly {
+		period = "本月"
+	}
+	return fmt.Sprintf("%s%.2f / %s%.2f %s", symbol, status.Spent, symbol, status.Amount, period)
+}
+
+// budgetExhaustedMessage 预算用尽时返回给客户端的错误信息
+func budgetExhaustedMessage(status *BudgetStatus) string {
+	symbol := "$"
+	if status.Currency == BudgetCurrencyCNY {
+		symbol = "¥"
+	}
+	period := "today"
+	if status.Period == BudgetPeriodMonthly {
+		period = "this month"
+	}
+	return fmt.Sprintf("budget '%s' exhausted: spent %s%.2f of %s%.2f %s", status.Name, symbol, status.Spent, symbol, status.Amount, period)
+}
+
+// exhaustedBudget 返回 provider 处理该请求时命中的已用尽预算（未配置预算服务时返回 nil）
+func (prs *ProviderRelayService) exhaustedBudget(kind, providerName, model string) *BudgetStatus {
+	if prs.budgets == nil {
+		return nil
+	}
+	return prs.budgets.exhausted(kind, providerName, model)
+}
+
+// SetBudgetService 设置预算服务（用于在请求路径上检查预算）
+func (prs *ProviderRelayService) SetBudgetService(budgets *BudgetService) {
+	prs.budgets = budgets
+}
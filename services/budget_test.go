@@ -0,0 +1,257 @@
+package services
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ==================== 花费预算测试 ====================
+
+func TestBudget_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		budget  Budget
+		wantErr string
+	}{
+		{"合法", Budget{Name: "daily", Period: BudgetPeriodDaily, Amount: 10}, ""},
+		{"人民币月预算", Budget{Name: "m", Period: BudgetPeriodMonthly, Amount: 500, Currency: BudgetCurrencyCNY, Action: BudgetActionReject}, ""},
+		{"缺少名称", Budget{Period: BudgetPeriodDaily, Amount: 10}, "名称不能为空"},
+		{"周期无效", Budget{Name: "w", Period: "weekly", Amount: 10}, "周期无效"},
+		{"金额为 0", Budget{Name: "z", Period: BudgetPeriodDaily}, "必须大于 0"},
+		{"币种无效", Budget{Name: "e", Period: BudgetPeriodDaily, Amount: 1, Currency: "EUR"}, "币种无效"},
+		{"处理方式无效", Budget{Name: "a", Period: BudgetPeriodDaily, Amount: 1, Action: "block"}, "处理方式无效"},
+		{"Gemini 预算", Budget{Name: "g", Period: BudgetPeriodDaily, Amount: 1, Platform: "gemini"}, ""},
+		{"平台无效", Budget{Name: "p", Period: BudgetPeriodDaily, Amount: 1, Platform: "unknown"}, "平台无效"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errs := strings.Join(tt.budget.Validate(), "; ")
+			if tt.wantErr == "" && errs != "" {
+				t.Errorf("期望验证通过，实际 %s", errs)
+			}
+			if tt.wantErr != "" && !strings.Contains(errs, tt.wantErr) {
+				t.Errorf("期望错误包含 %q，实际 %q", tt.wantErr, errs)
+			}
+		})
+	}
+}
+
+func TestBudget_Matches(t *testing.T) {
+	budget := Budget{Platform: "claude", Provider: "relay-x", Model: "*opus*"}
+	tests := []struct {
+		platform, provider, model string
+		expect                    bool
+	}{
+		{"claude", "relay-x", "claude-opus-4-1", true},
+		{"claude", "relay-x", "Claude-OPUS-4", true},
+		{"claude", "relay-x", "claude-sonnet-4", false},
+		{"codex", "relay-x", "claude-opus-4-1", false},
+		{"claude", "relay-y", "claude-opus-4-1", false},
+	}
+	for _, tt := range tests {
+		if got := budget.matches(tt.platform, tt.provider, tt.model); got != tt.expect {
+			t.Errorf("matches(%s, %s, %s) 期望 %v，实际 %v", tt.platform, tt.provider, tt.model, tt.expect, got)
+		}
+	}
+	if !(&Budget{}).matches("codex", "any", "gpt-5") {
+		t.Errorf("未限定范围的预算应匹配所有请求")
+	}
+}
+
+// newTestBudgetService 创建使用临时配置目录与固定请求记录的预算服务
+func newTestBudgetService(t *testing.T, config BudgetConfig, now time.Time, records []spendRecord) *BudgetService {
+	t.Helper()
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+
+	bs := NewBudgetService(nil)
+	bs.now = func() time.Time { return now }
+	bs.loadRecords = func(since time.Time) ([]spendRecord, error) {
+		result := make([]spendRecord, 0, len(records))
+		for _, record := range records {
+			if !record.at.Before(since) {
+				result = append(result, record)
+			}
+		}
+		return result, nil
+	}
+	if err := bs.SaveBudgets(config); err != nil {
+		t.Fatalf("保存预算失败: %v", err)
+	}
+	return bs
+}
+
+func TestBudgetService_Exhausted(t *testing.T) {
+	now := time.Date(2026, 3, 15, 12, 0, 0, 0, time.Local)
+	records := []spendRecord{
+		{platform: "claude", provider: "relay-x", model: "claude-opus-4-1", cost: 4, at: now.Add(-time.Hour)},
+		{platform: "claude", provider: "relay-x", model: "claude-sonnet-4", cost: 2, at: now.Add(-2 * time.Hour)},
+		{platform: "claude", provider: "relay-x", model: "claude-opus-4-1", cost: 30, at: now.AddDate(0, 0, -3)},
+		{platform: "claude", provider: "relay-y", model: "claude-sonnet-4", cost: 1, at: now.Add(-time.Hour)},
+		{platform: "claude", provider: "relay-y", model: "claude-sonnet-4", cost: 9, at: now.AddDate(0, -1, 0)},
+	}
+	bs := newTestBudgetService(t, BudgetConfig{Budgets: []Budget{
+		{Name: "relay-x 日预算", Enabled: true, Period: BudgetPeriodDaily, Provider: "relay-x", Amount: 6},
+		{Name: "opus 月预算", Enabled: true, Period: BudgetPeriodMonthly, Model: "*opus*", Amount: 40, Action: BudgetActionReject},
+		{Name: "relay-y 人民币", Enabled: true, Period: BudgetPeriodMonthly, Provider: "relay-y", Amount: 10, Currency: BudgetCurrencyCNY},
+		{Name: "停用", Enabled: false, Period: BudgetPeriodDaily, Amount: 0.01},
+	}}, now, records)
+
+	status, err := bs.GetBudgetStatus()
+	if err != nil {
+		t.Fatalf("统计失败: %v", err)
+	}
+	if len(status) != 3 {
+		t.Fatalf("停用的预算不应统计，实际 %+v", status)
+	}
+	if status[0].Spent != 6 || !status[0].Exhausted || status[0].Action != BudgetActionSkip {
+		t.Errorf("日预算只应统计今天的花费: %+v", status[0])
+	}
+	if status[1].Spent != 34 || status[1].Exhausted || status[1].Percent != 85 {
+		t.Errorf("月预算应统计本月的 opus 花费: %+v", status[1])
+	}
+	if status[2].Spent != 7.2 || status[2].Currency != BudgetCurrencyCNY {
+		t.Errorf("人民币预算应按默认汇率换算且不含上月花费: %+v", status[2])
+	}
+
+	if budget := bs.exhausted("claude", "relay-x", "claude-sonnet-4"); budget == nil || budget.Name != "relay-x 日预算" {
+		t.Errorf("relay-x 应命中已用尽的日预算，实际 %+v", budget)
+	}
+	if budget := bs.exhausted("claude", "relay-y", "claude-sonnet-4"); budget != nil {
+		t.Errorf("relay-y 未超出预算，实际 %+v", budget)
+	}
+}
+
+// TestBudgetService_RefreshAndNotify 统计结果按间隔刷新，每个阈值每个周期只通知一次
+func TestBudgetService_RefreshAndNotify(t *testing.T) {
+	now := time.Date(2026, 3, 15, 12, 0, 0, 0, time.Local)
+	var records []spendRecord
+	bs := newTestBudgetService(t, BudgetConfig{Budgets: []Budget{
+		{Name: "daily", Enabled: true, Period: BudgetPeriodDaily, Amount: 10},
+	}}, now, nil)
+	bs.now = func() time.Time { return now }
+	bs.loadRecords = func(time.Time) ([]spendRecord, error) { return records, nil }
+
+	spend := func(cost float64) {
+		records = append(records, spendRecord{platform: "claude", provider: "p", model: "m", cost: cost, at: now})
+	}
+
+	spend(6)
+	if bs.exhausted("claude", "p", "m") != nil {
+		t.Fatalf("未超出预算时不应跳过")
+	}
+	if bs.notified["daily|2026-03-15"] != 50 {
+		t.Errorf("花费 60%% 时应通知 50%% 阈值，实际 %d", bs.notified["daily|2026-03-15"])
+	}
+
+	spend(5)
+	if bs.exhausted("claude", "p", "m") != nil {
+		t.Errorf("刷新间隔内应使用缓存的统计结果")
+	}
+	now = now.Add(budgetRefreshInterval)
+	if bs.exhausted("claude", "p", "m") == nil {
+		t.Errorf("刷新后应识别预算已用尽")
+	}
+	if bs.notified["daily|2026-03-15"] != 100 {
+		t.Errorf("花费 110%% 时应通知 100%% 阈值，实际 %d", bs.notified["daily|2026-03-15"])
+	}
+
+	// 第二天预算重置
+	now = now.Add(24 * time.Hour)
+	records = nil
+	if bs.exhausted("claude", "p", "m") != nil {
+		t.Errorf("新周期预算应重置")
+	}
+}
+
+func TestBudgetService_SaveValidation(t *testing.T) {
+	bs := newTestBudgetService(t, BudgetConfig{}, time.Now(), nil)
+	err := bs.SaveBudgets(BudgetConfig{
+		Budgets: []Budget{
+			{Name: "a", Period: BudgetPeriodDaily, Amount: 1},
+			{Name: "a", Period: BudgetPeriodDaily, Amount: 2},
+		},
+		NotifyThresholds: []int{80, 0},
+	})
+	if err == nil || !strings.Contains(err.Error(), "预算名称重复") || !strings.Contains(err.Error(), "通知阈值无效") {
+		t.Errorf("期望名称重复与阈值无效的错误，实际 %v", err)
+	}
+}
+
+// TestProxyHandler_BudgetExhausted 预算用尽时跳过 provider；reject 预算直接拒绝请求
+func TestProxyHandler_BudgetExhausted(t *testing.T) {
+	now := time.Now()
+	records := []spendRecord{{platform: "claude", provider: "over", model: "claude-sonnet-4", cost: 5, at: now}}
+
+	for _, action := range []string{BudgetActionSkip, BudgetActionReject} {
+		t.Run(action, func(t *testing.T) {
+			bs := newTestBudgetService(t, BudgetConfig{Budgets: []Budget{
+				{Name: "over-daily", Enabled: true, Period: BudgetPeriodDaily, Provider: "over", Amount: 1, Action: action},
+			}}, now, records)
+			blacklistService := NewBlacklistService(NewSettingsService(), NewNotificationService(nil))
+			relayService := NewProviderRelayService(NewProviderService(), nil, blacklistService, nil, nil, "")
+			relayService.SetBudgetService(bs)
+			if err := relayService.providerService.SaveProviders("claude", []Provider{
+				{ID: 1, Name: "over", APIURL: "http://127.0.0.1:1", APIKey: "k", Enabled: true},
+			}); err != nil {
+				t.Fatalf("保存 provider 失败: %v", err)
+			}
+
+			c, w := newHedgeTestContext()
+			relayService.proxyHandler("claude", "/v1/messages")(c)
+
+			if action == BudgetActionReject {
+				if w.Code != http.StatusPaymentRequired || !strings.Contains(w.Body.String(), "budget 'over-daily' exhausted") {
+					t.Errorf("reject 预算应返回 402 与预算信息，实际 %d %s", w.Code, w.Body.String())
+				}
+				return
+			}
+			if w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), "1 over spend budget") {
+				t.Errorf("skip 预算应跳过 provider，实际 %d %s", w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
+// TestGeminiProxyHandler_BudgetExhausted Gemini 请求同样受预算约束：skip 跳过 provider，reject 直接拒绝
+func TestGeminiProxyHandler_BudgetExhausted(t *testing.T) {
+	now := time.Now()
+	records := []spendRecord{{platform: "gemini", provider: "over", model: "gemini-2.5-pro", cost: 5, at: now}}
+
+	for _, action := range []string{BudgetActionSkip, BudgetActionReject} {
+		t.Run(action, func(t *testing.T) {
+			bs := newTestBudgetService(t, BudgetConfig{Budgets: []Budget{
+				{Name: "gemini-daily", Enabled: true, Period: BudgetPeriodDaily, Platform: "gemini", Amount: 1, Action: action},
+			}}, now, records)
+			geminiService := NewGeminiService("")
+			if err := geminiService.AddProvider(GeminiProvider{ID: "g1", Name: "over", BaseURL: "http://127.0.0.1:1", APIKey: "k", Enabled: true}); err != nil {
+				t.Fatalf("保存 provider 失败: %v", err)
+			}
+			blacklistService := NewBlacklistService(NewSettingsService(), NewNotificationService(nil))
+			relayService := NewProviderRelayService(NewProviderService(), geminiService, blacklistService, nil, nil, "")
+			relayService.SetBudgetService(bs)
+
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest("POST", "/v1beta/models/gemini-2.5-pro:generateContent", strings.NewReader(`{"contents":[]}`))
+			c.Params = gin.Params{{Key: "any", Value: "/models/gemini-2.5-pro:generateContent"}}
+			relayService.geminiProxyHandler("/v1beta")(c)
+
+			if action == BudgetActionReject {
+				if w.Code != http.StatusPaymentRequired || !strings.Contains(w.Body.String(), "budget 'gemini-daily' exhausted") {
+					t.Errorf("reject 预算应返回 402 与预算信息，实际 %d %s", w.Code, w.Body.String())
+				}
+				return
+			}
+			if w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), "over budget") {
+				t.Errorf("skip 预算应跳过 provider，实际 %d %s", w.Code, w.Body.String())
+			}
+		})
+	}
+}
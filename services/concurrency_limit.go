@@ -0,0 +1,200 @@
+package services
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultConcurrencyQueueTimeout 未配置排队超时时的默认值
+const defaultConcurrencyQueueTimeout = 30 * time.Second
+
+// errConcurrencyLimit provider 并发已满（排队已满或排队超时），切换到下一个 provider，不计入失败次数
+var errConcurrencyLimit = fmt.Errorf("%w: concurrency limit reached", errProviderSkipped)
+
+// concurrencyPolicy provider 的并发限制配置
+type concurrencyPolicy struct {
+	maxConcurrency int           // 最大并发数（<=0 不限制）
+	queueSize      int           // 并发已满时最多排队的请求数（<=0 不排队）
+	queueTimeout   time.Duration // 排队超时
+}
+
+func newConcurrencyPolicy(maxConcurrency, queueSize, queueTimeoutSec int) concurrencyPolicy {
+	timeout := defaultConcurrencyQueueTimeout
+	if queueTimeoutSec > 0 {
+		timeout = time.Duration(queueTimeoutSec) * time.Second
+	}
+	return concurrencyPolicy{maxConcurrency: maxConcurrency, queueSize: queueSize, queueTimeout: timeout}
+}
+
+// concurrencyPolicy 返回 Provider 的并发限制配置
+func (p *Provider) concurrencyPolicy() concurrencyPolicy {
+	return newConcurrencyPolicy(p.MaxConcurrency, p.ConcurrencyQueueSize, p.ConcurrencyQueueTimeout)
+}
+
+// concurrencyPolicy 返回 GeminiProvider 的并发限制配置
+func (p *GeminiProvider) concurrencyPolicy() concurrencyPolicy {
+	return newConcurrencyPolicy(p.MaxConcurrency, p.ConcurrencyQueueSize, p.ConcurrencyQueueTimeout)
+}
+
+// concurrencySlots 单个 provider 的并发槽位与 FIFO 等待队列
+type concurrencySlots struct {
+	active  int
+	waiters *list.List // 元素为 chan struct{}，关闭即表示轮到该请求
+}
+
+// concurrencyLimiter 按 provider 限制并发（key="platform:providerName"）
+// 槽位释放时直接移交给队首的等待者，保证先到先得
+type concurrencyLimiter struct {
+	mu    sync.Mutex
+	slots map[string]*concurrencySlots
+}
+
+func newConcurrencyLimiter() *concurrencyLimiter {
+	return &concurrencyLimiter{slots: make(map[string]*concurrencySlots)}
+}
+
+// acquire 获取一个并发槽位，返回的函数用于请求结束时释放
+// 并发已满时：排队未满则按 FIFO 等待，直到轮到、超时或客户端断开；排队已满直接返回 errConcurrencyLimit
+func (l *concurrencyLimiter) acquire(ctx context.Context, platform string, providerName string, policy concurrencyPolicy) (func(), error) {
+	if policy.maxConcurrency <= 0 {
+		return func() {}, nil
+	}
+	key := fmt.Sprintf("%s:%s", platform, providerName)
+
+	l.mu.Lock()
+	slots := l.slots[key]
+	if slots == nil {
+		slots = &concurrencySlots{waiters: list.New()}
+		l.slots[key] = slots
+	}
+	if slots.active < policy.maxConcurrency && slots.waiters.Len() == 0 {
+		slots.active++
+		l.mu.Unlock()
+		return l.releaser(key), nil
+	}
+	if slots.waiters.Len() >= policy.queueSize {
+		active, queued := slots.active, slots.waiters.Len()
+		l.mu.Unlock()
+		return nil, fmt.Errorf("%w: 并发 %d/%d，排队 %d/%d", errConcurrencyLimit, active, policy.maxConcurrency, queued, policy.queueSize)
+	}
+	ready := make(chan struct{})
+	elem := slots.waiters.PushBack(ready)
+	l.mu.Unlock()
+
+	timer := time.NewTimer(policy.queueTimeout)
+	defer timer.Stop()
+
+	var waitErr error
+	select {
+	case <-ready:
+		return l.releaser(key), nil
+	case <-timer.C:
+		waitErr = fmt.Errorf("%w: 排队超过 %s", errConcurrencyLimit, policy.queueTimeout)
+	case <-ctx.Done():
+		waitErr = fmt.Errorf("%w: %v", errClientAbort, ctx.Err())
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	select {
+	case <-ready:
+		// 超时的同时恰好轮到：槽位已移交给本请求，转交给下一个等待者
+		l.releaseLocked(key)
+	default:
+		slots.waiters.Remove(elem)
+		l.cleanupLocked(key)
+	}
+	return nil, waitErr
+}
+
+// releaser 返回只会生效一次的释放函数
+func (l *concurrencyLimiter) releaser(key string) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			l.releaseLocked(key)
+		})
+	}
+}
+
+// releaseLocked 释放一个槽位：有等待者时直接移交给队首，否则并发数 -1（调用方需持有锁）
+func (l *concurrencyLimiter) releaseLocked(key string) {
+	slots := l.slots[key]
+	if slots == nil {
+		return
+	}
+	if front := slots.waiters.Front(); front != nil {
+		slots.waiters.Remove(front)
+		close(front.Value.(chan struct{}))
+		return
+	}
+	if slots.active > 0 {
+		slots.active--
+	}
+	l.cleanupLocked(key)
+}
+
+func (l *concurrencyLimiter) cleanupLocked(key string) {
+	if slots := l.slots[key]; slots != nil && slots.active == 0 && slots.waiters.Len() == 0 {
+		delete(l.slots, key)
+	}
+}
+
+// acquireProviderSlot 按 provider 的并发限制获取槽位（客户端断开时停止排队）
+func (prs *ProviderRelayService) acquireProviderSlot(ctx context.Context, platform string, providerName string, policy concurrencyPolicy) (func(), error) {
+	release, err := prs.concurrency.acquire(ctx, platform, providerName, policy)
+	if errors.Is(err, errConcurrencyLimit) {
+		fmt.Printf("[INFO] Provider %s 并发已满，切换到下一个: %v\n", providerName, err)
+	}
+	return release, err
+}
+
+// ProviderConcurrency provider 实时并发状态（前端展示用）
+type ProviderConcurrency struct {
+	Provider string `json:"provider"`
+	InFlight int    `json:"in_flight"` // 进行中的请求数
+	Queued   int    `json:"queued"`    // 排队等待的请求数
+}
+
+// GetProviderConcurrency 返回平台下各 provider 的实时并发（只包含有进行中或排队请求的 provider）
+func (prs *ProviderRelayService) GetProviderConcurrency(platform string) []ProviderConcurrency {
+	byName := make(map[string]*ProviderConcurrency)
+	entry := func(name string) *ProviderConcurrency {
+		if byName[name] == nil {
+			byName[name] = &ProviderConcurrency{Provider: name}
+		}
+		return byName[name]
+	}
+
+	prefix := platform + ":"
+	prs.inFlight.mu.Lock()
+	for key, count := range prs.inFlight.counts {
+		if name, ok := strings.CutPrefix(key, prefix); ok {
+			entry(name).InFlight = count
+		}
+	}
+	prs.inFlight.mu.Unlock()
+
+	prs.concurrency.mu.Lock()
+	for key, slots := range prs.concurrency.slots {
+		if name, ok := strings.CutPrefix(key, prefix); ok && slots.waiters.Len() > 0 {
+			entry(name).Queued = slots.waiters.Len()
+		}
+	}
+	prs.concurrency.mu.Unlock()
+
+	result := make([]ProviderConcurrency, 0, len(byName))
+	for _, item := range byName {
+		result = append(result, *item)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Provider < result[j].Provider })
+	return result
+}
@@ -0,0 +1,161 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// ==================== 并发限制测试 ====================
+
+func TestConcurrencyLimiter_NoQueue(t *testing.T) {
+	limiter := newConcurrencyLimiter()
+	policy := concurrencyPolicy{maxConcurrency: 2, queueTimeout: time.Second}
+
+	release1, err := limiter.acquire(context.Background(), "claude", "p1", policy)
+	if err != nil {
+		t.Fatalf("第 1 个请求不应被限制: %v", err)
+	}
+	release2, err := limiter.acquire(context.Background(), "claude", "p1", policy)
+	if err != nil {
+		t.Fatalf("第 2 个请求不应被限制: %v", err)
+	}
+	if _, err := limiter.acquire(context.Background(), "claude", "p1", policy); !errors.Is(err, errConcurrencyLimit) {
+		t.Fatalf("并发已满且不排队时应返回 errConcurrencyLimit，实际: %v", err)
+	}
+	if _, err := limiter.acquire(context.Background(), "codex", "p1", policy); err != nil {
+		t.Errorf("不同平台应分开计数: %v", err)
+	}
+
+	release1()
+	release1() // 重复释放不应多释放槽位
+	release3, err := limiter.acquire(context.Background(), "claude", "p1", policy)
+	if err != nil {
+		t.Fatalf("释放后应能获取槽位: %v", err)
+	}
+	if _, err := limiter.acquire(context.Background(), "claude", "p1", policy); !errors.Is(err, errConcurrencyLimit) {
+		t.Errorf("重复释放后并发上限被突破: %v", err)
+	}
+	release2()
+	release3()
+
+	// 不限制时总是放行
+	if _, err := limiter.acquire(context.Background(), "claude", "p2", concurrencyPolicy{}); err != nil {
+		t.Errorf("maxConcurrency=0 不应限制: %v", err)
+	}
+}
+
+func TestConcurrencyLimiter_FIFOQueue(t *testing.T) {
+	limiter := newConcurrencyLimiter()
+	policy := concurrencyPolicy{maxConcurrency: 1, queueSize: 2, queueTimeout: 5 * time.Second}
+
+	release, err := limiter.acquire(context.Background(), "claude", "p1", policy)
+	if err != nil {
+		t.Fatalf("首个请求不应被限制: %v", err)
+	}
+
+	order := make(chan int, 2)
+	for i := 1; i <= 2; i++ {
+		go func(id int) {
+			r, err := limiter.acquire(context.Background(), "claude", "p1", policy)
+			if err != nil {
+				t.Errorf("排队请求 %d 不应失败: %v", id, err)
+				return
+			}
+			order <- id
+			time.Sleep(10 * time.Millisecond)
+			r()
+		}(i)
+		// 等待该请求进入队列，保证入队顺序
+		waitForQueued(t, limiter, i)
+	}
+
+	if _, err := limiter.acquire(context.Background(), "claude", "p1", policy); !errors.Is(err, errConcurrencyLimit) {
+		t.Fatalf("排队已满时应立即返回 errConcurrencyLimit，实际: %v", err)
+	}
+
+	release()
+	if first, second := <-order, <-order; first != 1 || second != 2 {
+		t.Errorf("期望按入队顺序 1、2 获得槽位，实际 %d、%d", first, second)
+	}
+}
+
+func TestConcurrencyLimiter_QueueTimeoutAndCancel(t *testing.T) {
+	limiter := newConcurrencyLimiter()
+	policy := concurrencyPolicy{maxConcurrency: 1, queueSize: 1, queueTimeout: 20 * time.Millisecond}
+
+	release, _ := limiter.acquire(context.Background(), "claude", "p1", policy)
+	defer release()
+
+	if _, err := limiter.acquire(context.Background(), "claude", "p1", policy); !errors.Is(err, errConcurrencyLimit) {
+		t.Fatalf("排队超时应返回 errConcurrencyLimit，实际: %v", err)
+	}
+	if queued := limiter.slots["claude:p1"].waiters.Len(); queued != 0 {
+		t.Errorf("超时后应移出队列，实际队列长度 %d", queued)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	policy.queueTimeout = 5 * time.Second
+	if _, err := limiter.acquire(ctx, "claude", "p1", policy); !errors.Is(err, errClientAbort) {
+		t.Errorf("客户端断开时应返回 errClientAbort，实际: %v", err)
+	}
+}
+
+// TestForwardRequest_ConcurrencyLimit 并发已满时不请求上游，返回 errConcurrencyLimit（由调用方切换到下一个 provider）
+func TestForwardRequest_ConcurrencyLimit(t *testing.T) {
+	var hits int32
+	upstreamServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer upstreamServer.Close()
+
+	relayService := NewProviderRelayService(NewProviderService(), nil, nil, nil, nil, "")
+	provider := Provider{Name: "limited", APIURL: upstreamServer.URL, APIKey: "k", Enabled: true, MaxConcurrency: 1}
+
+	release, err := relayService.concurrency.acquire(context.Background(), "claude", provider.Name, provider.concurrencyPolicy())
+	if err != nil {
+		t.Fatalf("占用槽位失败: %v", err)
+	}
+	defer release()
+
+	c, _ := newHedgeTestContext()
+	ok, err := relayService.forwardRequest(c, "claude", provider, "/v1/messages", nil, http.Header{},
+		[]byte(`{"model":"claude-sonnet-4"}`), false, "claude-sonnet-4", AuthMethodXAPIKey)
+	if ok || !errors.Is(err, errConcurrencyLimit) {
+		t.Fatalf("期望 errConcurrencyLimit，实际 ok=%v err=%v", ok, err)
+	}
+	if atomic.LoadInt32(&hits) != 0 {
+		t.Errorf("并发已满时不应请求上游")
+	}
+
+	concurrency := relayService.GetProviderConcurrency("claude")
+	if len(concurrency) != 0 {
+		t.Errorf("未转发的请求不应计入进行中，实际 %+v", concurrency)
+	}
+}
+
+// waitForQueued 等待队列长度达到期望值
+func waitForQueued(t *testing.T, limiter *concurrencyLimiter, expected int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		limiter.mu.Lock()
+		slots := limiter.slots["claude:p1"]
+		queued := 0
+		if slots != nil {
+			queued = slots.waiters.Len()
+		}
+		limiter.mu.Unlock()
+		if queued >= expected {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("等待队列长度达到 %d 超时", expected)
+}
@@ -133,21 +133,27 @@ func (cts *ConnectivityTestService) TestProvider(ctx context.Context, provider P
 
 	// 设置 Headers
 	req.Header.Set("Content-Type", "application/json")
-	if provider.APIKey != "" {
+	if apiKey := provider.primaryAPIKey(); apiKey != "" {
 		// authType 已通过 getEffectiveAuthType 标准化为小写（auto/空→平台默认，未知→bearer+警告）
 		switch authType {
 		case "x-api-key":
-			req.Header.Set("x-api-key", provider.APIKey)
+			req.Header.Set("x-api-key", apiKey)
 			req.Header.Set("anthropic-version", GetAnthropicAPIVersion())
 		default:
 			// bearer 或其他（getEffectiveAuthType 已处理未知值并记录警告）
-			req.Header.Set("Authorization", "Bearer "+provider.APIKey)
+			req.Header.Set("Authorization", "Bearer "+apiKey)
 		}
 	}
 
 	// 发送请求并计时
+	client, err := providerHTTPClient(&provider, cts.client)
+	if err != nil {
+		result.Message = err.Error()
+		result.SubStatus = SubStatusClientError
+		return result
+	}
 	start := time.Now()
-	resp, err := cts.client.Do(req)
+	resp, err := client.Do(req)
 	latencyMs := int(time.Since(start).Milliseconds())
 	result.LatencyMs = latencyMs
 
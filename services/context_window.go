@@ -0,0 +1,30 @@
+package services
+
+import (
+	modelpricing "codeswitch/resources/model-pricing"
+)
+
+// contextWindowFor 返回 provider 对实际模型可接收的最大输入 tokens（0 = 未知，不做过滤）
+// 取 provider 声明的上限与内置模型表 max_input_tokens 中较小的一个：中转商常把上下文限制得比模型本身更小
+func (p *Provider) contextWindowFor(model string) int {
+	window := 0
+	if pricing, err := modelpricing.DefaultService(); err == nil {
+		if known, ok := pricing.ContextWindow(model); ok {
+			window = known
+		}
+	}
+	if p.MaxInputTokens > 0 && (window == 0 || p.MaxInputTokens < window) {
+		window = p.MaxInputTokens
+	}
+	return window
+}
+
+// exceedsContextWindow 估算的输入 tokens 是否超过 provider 对该模型的上下文窗口
+// 返回窗口大小（0 = 未知）与是否超出
+func exceedsContextWindow(provider *Provider, model string, estimatedTokens int) (int, bool) {
+	if estimatedTokens <= 0 {
+		return 0, false
+	}
+	window := provider.contextWindowFor(model)
+	return window, window > 0 && estimatedTokens > window
+}
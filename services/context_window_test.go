@@ -0,0 +1,59 @@
+package services
+
+import (
+	"strings"
+	"testing"
+)
+
+// ==================== 上下文窗口过滤测试 ====================
+
+func TestProvider_ContextWindowFor(t *testing.T) {
+	tests := []struct {
+		name     string
+		provider Provider
+		model    string
+		expected int
+	}{
+		{"使用内置模型表", Provider{}, "claude-opus-4-1", 200000},
+		{"去除区域前缀", Provider{}, "us.anthropic.claude-opus-4-1", 200000},
+		{"[1m] 模型按 1M 计算", Provider{}, "claude-sonnet-4-5[1m]", 1000000},
+		{"provider 声明更小的上限", Provider{MaxInputTokens: 128000}, "claude-opus-4-1", 128000},
+		{"provider 声明超过模型上限时以模型为准", Provider{MaxInputTokens: 500000}, "claude-opus-4-1", 200000},
+		{"未知模型使用 provider 声明", Provider{MaxInputTokens: 64000}, "my-private-model", 64000},
+		{"未知模型且未声明时不限制", Provider{}, "my-private-model", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.provider.contextWindowFor(tt.model); got != tt.expected {
+				t.Errorf("期望 %d，实际 %d", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestExceedsContextWindow(t *testing.T) {
+	provider := &Provider{Name: "short", MaxInputTokens: 128000}
+	if _, exceeded := exceedsContextWindow(provider, "claude-opus-4-1", 100000); exceeded {
+		t.Errorf("未超出窗口不应跳过")
+	}
+	if window, exceeded := exceedsContextWindow(provider, "claude-opus-4-1", 150000); !exceeded || window != 128000 {
+		t.Errorf("超出 provider 声明的窗口应跳过，实际 window=%d exceeded=%v", window, exceeded)
+	}
+	if _, exceeded := exceedsContextWindow(&Provider{}, "my-private-model", 10000000); exceeded {
+		t.Errorf("窗口未知时不应跳过")
+	}
+	if _, exceeded := exceedsContextWindow(provider, "claude-opus-4-1", 0); exceeded {
+		t.Errorf("无法估算时不应跳过")
+	}
+}
+
+func TestBuildNoProviderError_ContextTooSmall(t *testing.T) {
+	reasons := skipReasons{contextTooSmall: 2}
+	if reasons.total() != 2 {
+		t.Errorf("total 应包含上下文窗口跳过数，实际 %d", reasons.total())
+	}
+	msg := buildNoProviderError("claude-sonnet-4", "claude", reasons)
+	if !strings.Contains(msg, "2 with context window too small") {
+		t.Errorf("错误信息应包含上下文窗口跳过数量，实际: %s", msg)
+	}
+}
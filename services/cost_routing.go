@@ -0,0 +1,68 @@
+package services
+
+import (
+	"sort"
+
+	modelpricing "codeswitch/resources/model-pricing"
+)
+
+// costReferenceOutputTokens 估算单次请求费用时假定的输出 token 数
+// 输出单价通常是输入的数倍，只按输入估算会低估输出贵的模型
+const costReferenceOutputTokens = 1000
+
+// normalizePriceMultiplier 未配置或非法的价格倍率视为 1（按官方价计费）
+func normalizePriceMultiplier(multiplier float64) float64 {
+	if multiplier <= 0 {
+		return 1
+	}
+	return multiplier
+}
+
+// expectedRequestCost 按内置价格表估算一次请求在某个 provider 上的费用（美元）
+// 模型不在价格表中时返回 false
+func expectedRequestCost(pricing *modelpricing.Service, model string, inputTokens int, multiplier float64) (float64, bool) {
+	if pricing == nil {
+		return 0, false
+	}
+	cost := pricing.CalculateCost(model, modelpricing.UsageSnapshot{
+		InputTokens:  inputTokens,
+		OutputTokens: costReferenceOutputTokens,
+	})
+	if !cost.HasPricing || cost.TotalCost <= 0 {
+		return 0, false
+	}
+	return cost.TotalCost * normalizePriceMultiplier(multiplier), true
+}
+
+// costIndexes 计算成本优先策略下的尝试顺序（下标序列）
+// models 为各 provider 映射后的实际模型，bodyBytes 用于估算输入 token 数
+func costIndexes(models []string, multipliers []float64, bodyBytes []byte) []int {
+	pricing, err := modelpricing.DefaultService()
+	if err != nil {
+		pricing = nil
+	}
+	inputTokens := estimateRequestTokens(bodyBytes)
+
+	costs := make([]float64, len(models))
+	priced := make([]bool, len(models))
+	for i, model := range models {
+		costs[i], priced[i] = expectedRequestCost(pricing, model, inputTokens, multipliers[i])
+	}
+	return cheapestFirstOrder(costs, priced)
+}
+
+// cheapestFirstOrder 按估算费用升序排列；无定价的 provider 排在最后并保持用户排序
+func cheapestFirstOrder(costs []float64, priced []bool) []int {
+	order := make([]int, len(costs))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		ia, ib := order[a], order[b]
+		if priced[ia] != priced[ib] {
+			return priced[ia]
+		}
+		return priced[ia] && costs[ia] < costs[ib]
+	})
+	return order
+}
@@ -0,0 +1,69 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+)
+
+// ==================== 成本优先路由测试 ====================
+
+func TestCheapestFirstOrder(t *testing.T) {
+	tests := []struct {
+		name     string
+		costs    []float64
+		priced   []bool
+		expected []int
+	}{
+		{"按费用升序", []float64{0.3, 0.1, 0.2}, []bool{true, true, true}, []int{1, 2, 0}},
+		{"费用相同保持原顺序", []float64{0.1, 0.1, 0.1}, []bool{true, true, true}, []int{0, 1, 2}},
+		{"无定价排在最后", []float64{0, 0.2, 0, 0.1}, []bool{false, true, false, true}, []int{3, 1, 0, 2}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := cheapestFirstOrder(tt.costs, tt.priced); !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("期望顺序 %v，实际 %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestOrderLevelProviders_Cost(t *testing.T) {
+	relayService := NewProviderRelayService(NewProviderService(), nil, nil, nil, nil, "")
+	body := []byte(`{"model":"claude-opus-4-1","messages":[{"role":"user","content":"hello"}]}`)
+	providers := []Provider{
+		{Name: "official"},
+		{Name: "relay-opus", PriceMultiplier: 0.5},
+		{Name: "unknown-model", ModelMapping: map[string]string{"claude-opus-4-1": "my-private-model"}},
+		{Name: "relay-haiku", ModelMapping: map[string]string{"claude-opus-4-1": "claude-haiku-4-5"}},
+	}
+
+	ordered := relayService.orderLevelProviders("claude", 1, LoadBalanceCost, "claude-opus-4-1", body, providers)
+	names := make([]string, len(ordered))
+	for i, p := range ordered {
+		names[i] = p.Name
+	}
+	// haiku 官方价远低于 opus 半价；映射到价格表外模型的 provider 排在最后
+	expected := []string{"relay-haiku", "relay-opus", "official", "unknown-model"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("期望顺序 %v，实际 %v", expected, names)
+	}
+}
+
+// TestOrderLevelGeminiProviders_Cost Gemini 与其他平台共用同一套策略，endpoint 未指定模型时使用 provider 默认模型
+func TestOrderLevelGeminiProviders_Cost(t *testing.T) {
+	relayService := NewProviderRelayService(NewProviderService(), nil, nil, nil, nil, "")
+	body := []byte(`{"contents":[{"role":"user","parts":[{"text":"hello"}]}]}`)
+	providers := []GeminiProvider{
+		{Name: "pro", Model: "gemini-2.5-pro"},
+		{Name: "flash", Model: "gemini-2.5-flash"},
+	}
+
+	ordered := relayService.orderLevelGeminiProviders(1, LoadBalanceCost, "", body, providers)
+	if ordered[0].Name != "flash" || ordered[1].Name != "pro" {
+		t.Errorf("期望 flash 优先，实际 [%s %s]", ordered[0].Name, ordered[1].Name)
+	}
+	if got := relayService.orderLevelGeminiProviders(1, LoadBalanceOrder, "", body, providers); got[0].Name != "pro" {
+		t.Errorf("顺序降级应保持原顺序，实际 %s", got[0].Name)
+	}
+}
@@ -0,0 +1,227 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ErrorClass 上游错误分类
+type ErrorClass string
+
+const (
+	ErrorClassClient    ErrorClass = "client"    // 400 / 413 / 422 等：请求本身有问题（如 prompt is too long），换 provider 也不会成功
+	ErrorClassAuth      ErrorClass = "auth"      // 401 / 402 / 403：key 失效、余额不足或无权限
+	ErrorClassRateLimit ErrorClass = "ratelimit" // 429：上游限流
+	ErrorClassOverload  ErrorClass = "overload"  // 529 / 带 Retry-After 的 503：上游过载
+	ErrorClassServer    ErrorClass = "server"    // 其他 5xx、404 以及流式首包前中断
+	ErrorClassNetwork   ErrorClass = "network"   // 连接失败、超时等没有拿到上游响应的错误
+	ErrorClassStall     ErrorClass = "stall"     // 首字节超时或流式响应中途停滞（超过空闲超时未收到数据）
+)
+
+// ErrorClasses 全部错误分类（前端按此顺序展示）
+var ErrorClasses = []ErrorClass{
+	ErrorClassClient, ErrorClassAuth, ErrorClassRateLimit, ErrorClassOverload, ErrorClassServer, ErrorClassNetwork, ErrorClassStall,
+}
+
+// 错误处罚方式
+const (
+	ErrorPenaltyNone     = "none"     // 不计入失败次数
+	ErrorPenaltyCount    = "count"    // 计入失败次数（可能触发拉黑）
+	ErrorPenaltyCooldown = "cooldown" // 按 Retry-After 冷却，不增加拉黑等级
+)
+
+// ErrorClassPolicy 单个错误分类的处理策略
+type ErrorClassPolicy struct {
+	Failover bool   `json:"failover"` // 是否切换到其他 provider 重试；false 时直接把错误返回给客户端
+	Penalty  string `json:"penalty"`  // none / count / cooldown
+}
+
+// DefaultErrorPolicies 默认错误策略：客户端错误直接返回且不处罚，限流 / 过载冷却，其他错误计入失败次数
+func DefaultErrorPolicies() map[ErrorClass]ErrorClassPolicy {
+	return map[ErrorClass]ErrorClassPolicy{
+		ErrorClassClient:    {Failover: false, Penalty: ErrorPenaltyNone},
+		ErrorClassAuth:      {Failover: true, Penalty: ErrorPenaltyCount},
+		ErrorClassRateLimit: {Failover: true, Penalty: ErrorPenaltyCooldown},
+		ErrorClassOverload:  {Failover: true, Penalty: ErrorPenaltyCooldown},
+		ErrorClassServer:    {Failover: true, Penalty: ErrorPenaltyCount},
+		ErrorClassNetwork:   {Failover: true, Penalty: ErrorPenaltyCount},
+		ErrorClassStall:     {Failover: true, Penalty: ErrorPenaltyCount},
+	}
+}
+
+// isValidErrorPenalty 检查处罚方式是否合法
+func isValidErrorPenalty(penalty string) bool {
+	switch penalty {
+	case ErrorPenaltyNone, ErrorPenaltyCount, ErrorPenaltyCooldown:
+		return true
+	}
+	return false
+}
+
+// classifyStatus 按上游状态码分类
+func classifyStatus(status int, retryAfter time.Duration) ErrorClass {
+	switch {
+	case status == http.StatusTooManyRequests:
+		return ErrorClassRateLimit
+	case status == statusOverloaded, status == http.StatusServiceUnavailable && retryAfter > 0:
+		return ErrorClassOverload
+	case status == http.StatusUnauthorized, status == http.StatusPaymentRequired, status == http.StatusForbidden:
+		return ErrorClassAuth
+	case status == http.StatusRequestTimeout:
+		return ErrorClassNetwork
+	case status == http.StatusNotFound:
+		// 404 多为该 provider 不支持此端点或模型，换 provider 可能成功
+		return ErrorClassServer
+	case status >= 400 && status < 500:
+		return ErrorClassClient
+	default:
+		return ErrorClassServer
+	}
+}
+
+// classifyUpstreamError 对 forwardRequest 返回的错误分类
+func classifyUpstreamError(err error) ErrorClass {
+	var statusErr *upstreamStatusError
+	if errors.As(err, &statusErr) {
+		return classifyStatus(statusErr.status, statusErr.retryAfter)
+	}
+	if errors.Is(err, errUpstreamStalled) {
+		return ErrorClassStall
+	}
+	if errors.Is(err, errStreamBeforeContent) {
+		return ErrorClassServer
+	}
+	return ErrorClassNetwork
+}
+
+// errorPolicy 返回错误分类的处理策略（用户配置优先，非法配置回退默认值）
+func (prs *ProviderRelayService) errorPolicy(class ErrorClass) ErrorClassPolicy {
+	policy := DefaultErrorPolicies()[class]
+	if prs.appSettings == nil {
+		return policy
+	}
+	settings, err := prs.appSettings.GetAppSettings()
+	if err != nil {
+		return policy
+	}
+	if custom, ok := settings.ErrorPolicies[string(class)]; ok && isValidErrorPenalty(custom.Penalty) {
+		return custom
+	}
+	return policy
+}
+
+// providerFailure 一次失败的分类与处理结果
+type providerFailure struct {
+	class       ErrorClass
+	policy      ErrorClassPolicy
+	coolingDown bool // 已进入冷却，调用方应切换到下一个 provider 而不是重试同一 provider
+}
+
+// recordProviderFailure 按错误分类和策略记录 provider 失败：
+// 冷却（不提升拉黑等级）、计入失败次数（可能触发拉黑）或不处罚
+func (prs *ProviderRelayService) recordProviderFailure(kind string, providerName string, model string, err error) providerFailure {
+	class := classifyUpstreamError(err)
+	failure := providerFailure{class: class, policy: prs.errorPolicy(class)}
+
+	switch failure.policy.Penalty {
+	case ErrorPenaltyNone:
+		fmt.Printf("[INFO] Provider %s 错误分类为 %s，不计入失败次数\n", providerName, class)
+	case ErrorPenaltyCooldown:
+		cooldown, ok := upstreamCooldownFromError(err)
+		if !ok {
+			// 非限流 / 过载状态码也配置了冷却：使用默认时长，冷却整个 provider
+			cooldown = upstreamCooldown{duration: defaultRateLimitCooldown}
+			var statusErr *upstreamStatusError
+			if errors.As(err, &statusErr) {
+				cooldown.statusCode = statusErr.status
+				if statusErr.retryAfter > 0 {
+					cooldown.duration = statusErr.retryAfter
+				}
+			}
+		}
+		if cooldown.duration > maxUpstreamCooldown {
+			cooldown.duration = maxUpstreamCooldown
+		}
+		scopeModel := ""
+		if cooldown.modelScoped {
+			scopeModel = model
+		}
+		failure.coolingDown = prs.blacklistService.RecordCooldown(kind, providerName, scopeModel, cooldown.duration, cooldown.statusCode)
+	default:
+		if recErr := prs.blacklistService.RecordFailure(kind, providerName); recErr != nil {
+			fmt.Printf("[ERROR] 记录失败到黑名单失败: %v\n", recErr)
+		}
+	}
+	return failure
+}
+
+// respondWithoutFailover 错误策略不允许切换 provider 时，把错误直接返回给客户端
+// 上游有响应时原样透传状态码和响应体（如 400 prompt is too long），否则返回 502
+func respondWithoutFailover(c *gin.Context, class ErrorClass, err error) {
+	fmt.Printf("[INFO] 错误分类为 %s，策略为不切换 provider，直接返回给客户端\n", class)
+	writeUpstreamError(c, class, err)
+}
+
+// writeUpstreamError 把上游错误写回客户端：有上游响应时透传状态码和响应体，否则返回 502
+// 协议转换时上游错误体是上游协议的格式，转为客户端协议的错误格式后返回
+func writeUpstreamError(c *gin.Context, class ErrorClass, err error) {
+	var statusErr *upstreamStatusError
+	if errors.As(err, &statusErr) {
+		if statusErr.clientFormat != "" {
+			message := http.StatusText(statusErr.status)
+			if statusErr.contentEncoding == "" {
+				if msg := errorMessageFromBody(statusErr.respBody); msg != "" {
+					message = msg
+				}
+			}
+			c.JSON(statusErr.status, clientErrorPayload(statusErr.clientFormat, statusErr.status, message))
+			return
+		}
+		contentType := statusErr.contentType
+		if contentType == "" {
+			contentType = "application/json"
+		}
+		if statusErr.contentEncoding != "" {
+			c.Header("Content-Encoding", statusErr.contentEncoding)
+		}
+		c.Data(statusErr.status, contentType, statusErr.respBody)
+		return
+	}
+	errMsg := "未知错误"
+	if err != nil {
+		errMsg = err.Error()
+	}
+	c.JSON(http.StatusBadGateway, gin.H{"error": errMsg, "errorClass": class})
+}
+
+// clientErrorPayload 按客户端协议构造错误响应体
+func clientErrorPayload(format WireFormat, status int, message string) gin.H {
+	switch format {
+	case WireFormatAnthropic:
+		return gin.H{"type": "error", "error": gin.H{"type": anthropicErrorType(status), "message": message}}
+	case WireFormatGemini:
+		return gin.H{"error": gin.H{"code": status, "message": message, "status": http.StatusText(status)}}
+	default:
+		return gin.H{"error": gin.H{"type": "api_error", "code": status, "message": message}}
+	}
+}
+
+// decodeErrorBody 按 Content-Encoding 解压上游错误响应体（客户端的 Accept-Encoding 原样转发时上游可能压缩返回）
+// 返回解压后的响应体；不支持的编码或解压失败时返回原始数据及其编码
+func decodeErrorBody(encoding string, body []byte) ([]byte, string) {
+	encoding = strings.TrimSpace(encoding)
+	if encoding == "" || strings.EqualFold(encoding, "identity") || len(body) == 0 {
+		return body, ""
+	}
+	if strings.EqualFold(encoding, "gzip") {
+		if decompressed, err := decompressGzip(body); err == nil {
+			return decompressed, ""
+		}
+	}
+	return body, encoding
+}
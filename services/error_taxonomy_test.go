@@ -0,0 +1,182 @@
+package services
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+// ==================== 上游错误分类测试 ====================
+
+func TestClassifyUpstreamError(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected ErrorClass
+	}{
+		{"400 请求过长", &upstreamStatusError{status: http.StatusBadRequest}, ErrorClassClient},
+		{"413 请求体过大", &upstreamStatusError{status: http.StatusRequestEntityTooLarge}, ErrorClassClient},
+		{"422 参数错误", &upstreamStatusError{status: http.StatusUnprocessableEntity}, ErrorClassClient},
+		{"401 key 失效", &upstreamStatusError{status: http.StatusUnauthorized}, ErrorClassAuth},
+		{"402 余额不足", &upstreamStatusError{status: http.StatusPaymentRequired}, ErrorClassAuth},
+		{"403 无权限", &upstreamStatusError{status: http.StatusForbidden}, ErrorClassAuth},
+		{"404 provider 不支持", &upstreamStatusError{status: http.StatusNotFound}, ErrorClassServer},
+		{"408 超时", &upstreamStatusError{status: http.StatusRequestTimeout}, ErrorClassNetwork},
+		{"429 限流", &upstreamStatusError{status: http.StatusTooManyRequests}, ErrorClassRateLimit},
+		{"529 过载", &upstreamStatusError{status: statusOverloaded}, ErrorClassOverload},
+		{"503 带 Retry-After", &upstreamStatusError{status: http.StatusServiceUnavailable, retryAfter: time.Second}, ErrorClassOverload},
+		{"503 无 Retry-After", &upstreamStatusError{status: http.StatusServiceUnavailable}, ErrorClassServer},
+		{"500 服务端错误", &upstreamStatusError{status: http.StatusInternalServerError}, ErrorClassServer},
+		{"包装的状态码错误", fmt.Errorf("wrapped: %w", &upstreamStatusError{status: http.StatusBadRequest}), ErrorClassClient},
+		{"流式首包前中断", fmt.Errorf("%w: stream closed", errStreamBeforeContent), ErrorClassServer},
+		{"请求超时", fmt.Errorf("request timeout: %w", context.DeadlineExceeded), ErrorClassNetwork},
+		{"流式中途停滞", fmt.Errorf("%w: 流式响应超过 30s 未收到数据", errUpstreamStalled), ErrorClassStall},
+		{"连接失败", errors.New("dial tcp: connection refused"), ErrorClassNetwork},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := classifyUpstreamError(tt.err); got != tt.expected {
+				t.Errorf("期望 %s，实际 %s", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestErrorPolicy(t *testing.T) {
+	relayService := NewProviderRelayService(NewProviderService(), nil, nil, nil, nil, "")
+	if policy := relayService.errorPolicy(ErrorClassClient); policy.Failover || policy.Penalty != ErrorPenaltyNone {
+		t.Errorf("客户端错误默认应直接返回且不处罚，实际 %+v", policy)
+	}
+	if policy := relayService.errorPolicy(ErrorClassRateLimit); !policy.Failover || policy.Penalty != ErrorPenaltyCooldown {
+		t.Errorf("限流默认应切换并冷却，实际 %+v", policy)
+	}
+	for _, class := range ErrorClasses {
+		if _, ok := DefaultErrorPolicies()[class]; !ok {
+			t.Errorf("错误分类 %s 缺少默认策略", class)
+		}
+	}
+
+	// 用户配置覆盖默认值，非法配置回退默认值
+	relayService.appSettings = &AppSettingsService{path: filepath.Join(t.TempDir(), "app.json")}
+	_, err := relayService.appSettings.SaveAppSettings(AppSettings{ErrorPolicies: map[string]ErrorClassPolicy{
+		"client": {Failover: true, Penalty: ErrorPenaltyCount},
+		"server": {Failover: false, Penalty: "unknown"},
+	}})
+	if err != nil {
+		t.Fatalf("保存配置失败: %v", err)
+	}
+	if policy := relayService.errorPolicy(ErrorClassClient); !policy.Failover || policy.Penalty != ErrorPenaltyCount {
+		t.Errorf("应使用用户配置，实际 %+v", policy)
+	}
+	if policy := relayService.errorPolicy(ErrorClassServer); !policy.Failover || policy.Penalty != ErrorPenaltyCount {
+		t.Errorf("非法配置应回退默认值，实际 %+v", policy)
+	}
+}
+
+// TestRespondWithoutFailover 客户端错误原样返回上游状态码和响应体
+func TestRespondWithoutFailover(t *testing.T) {
+	body := `{"type":"error","error":{"type":"invalid_request_error","message":"prompt is too long"}}`
+	c, w := newHedgeTestContext()
+	respondWithoutFailover(c, ErrorClassClient, &upstreamStatusError{
+		status:      http.StatusBadRequest,
+		respBody:    []byte(body),
+		contentType: "application/json",
+	})
+	if w.Code != http.StatusBadRequest || w.Body.String() != body {
+		t.Errorf("应原样返回上游错误，实际 %d %s", w.Code, w.Body.String())
+	}
+
+	c, w = newHedgeTestContext()
+	respondWithoutFailover(c, ErrorClassNetwork, errors.New("connection refused"))
+	if w.Code != http.StatusBadGateway {
+		t.Errorf("没有上游响应时应返回 502，实际 %d", w.Code)
+	}
+}
+
+// TestWriteUpstreamError_BridgedAndEncoded 协议转换时错误转为客户端协议格式；压缩的错误体解压后返回
+func TestWriteUpstreamError_BridgedAndEncoded(t *testing.T) {
+	openAIBody := []byte(`{"error":{"message":"context_length_exceeded","type":"invalid_request_error"}}`)
+	c, w := newHedgeTestContext()
+	writeUpstreamError(c, ErrorClassClient, &upstreamStatusError{
+		status:       http.StatusBadRequest,
+		respBody:     openAIBody,
+		contentType:  "application/json",
+		clientFormat: WireFormatAnthropic,
+	})
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("状态码应保持 400，实际 %d", w.Code)
+	}
+	if got := gjson.Get(w.Body.String(), "error.type").String(); got != "invalid_request_error" || gjson.Get(w.Body.String(), "type").String() != "error" {
+		t.Errorf("应转为 Anthropic 错误格式，实际 %s", w.Body.String())
+	}
+	if got := gjson.Get(w.Body.String(), "error.message").String(); got != "context_length_exceeded" {
+		t.Errorf("错误信息应保留，实际 %q", got)
+	}
+
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	gz.Write([]byte(`{"error":"bad request"}`))
+	gz.Close()
+	decoded, encoding := decodeErrorBody("gzip", compressed.Bytes())
+	if string(decoded) != `{"error":"bad request"}` || encoding != "" {
+		t.Errorf("gzip 错误体应解压，实际 %q %q", decoded, encoding)
+	}
+
+	// 无法解压的编码原样返回并带上 Content-Encoding
+	decoded, encoding = decodeErrorBody("br", []byte{0x0b, 0x02})
+	c, w = newHedgeTestContext()
+	writeUpstreamError(c, ErrorClassClient, &upstreamStatusError{status: http.StatusBadRequest, respBody: decoded, contentEncoding: encoding})
+	if w.Header().Get("Content-Encoding") != "br" || !bytes.Equal(w.Body.Bytes(), []byte{0x0b, 0x02}) {
+		t.Errorf("无法解压时应带原始编码透传，实际 %q %q", w.Header().Get("Content-Encoding"), w.Body.Bytes())
+	}
+}
+
+// TestForwardRequest_CompressedClientError 客户端要求压缩时，上游 gzip 压缩的 4xx 错误体解压后返回给客户端
+func TestForwardRequest_CompressedClientError(t *testing.T) {
+	upstreamServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Encoding", "gzip")
+		w.WriteHeader(http.StatusBadRequest)
+		gz := gzip.NewWriter(w)
+		gz.Write([]byte(`{"type":"error","error":{"type":"invalid_request_error","message":"prompt is too long"}}`))
+		gz.Close()
+	}))
+	defer upstreamServer.Close()
+
+	relayService := NewProviderRelayService(NewProviderService(), nil, nil, nil, nil, "")
+	c, w := newHedgeTestContext()
+	provider := Provider{Name: "p", APIURL: upstreamServer.URL, APIKey: "k", Enabled: true}
+	_, err := relayService.forwardRequest(c, "claude", provider, "/v1/messages", nil, http.Header{"Accept-Encoding": []string{"gzip"}},
+		[]byte(`{"model":"claude-sonnet-4"}`), false, "claude-sonnet-4", AuthMethodXAPIKey)
+	respondWithoutFailover(c, classifyUpstreamError(err), err)
+
+	if w.Code != http.StatusBadRequest || gjson.Get(w.Body.String(), "error.message").String() != "prompt is too long" {
+		t.Errorf("应返回解压后的错误体，实际 %d %q", w.Code, w.Body.String())
+	}
+	if w.Header().Get("Content-Encoding") != "" {
+		t.Errorf("已解压的错误体不应带 Content-Encoding，实际 %q", w.Header().Get("Content-Encoding"))
+	}
+}
+
+// TestRecordProviderFailure_ClientErrorNotPenalized 客户端错误不计入失败、不冷却，且不切换 provider
+func TestRecordProviderFailure_ClientErrorNotPenalized(t *testing.T) {
+	blacklistService := NewBlacklistService(NewSettingsService(), NewNotificationService(nil))
+	relayService := NewProviderRelayService(NewProviderService(), nil, blacklistService, nil, nil, "")
+
+	failure := relayService.recordProviderFailure("claude", "p1", "claude-sonnet-4", &upstreamStatusError{status: http.StatusBadRequest})
+	if failure.class != ErrorClassClient || failure.policy.Failover || failure.coolingDown {
+		t.Errorf("客户端错误处理不符合预期: %+v", failure)
+	}
+	if coolingDown, _ := blacklistService.IsCoolingDown("claude", "p1", "claude-sonnet-4"); coolingDown {
+		t.Errorf("客户端错误不应冷却")
+	}
+}
@@ -0,0 +1,561 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+// ==================== Anthropic Messages -> OpenAI Chat Completions（请求） ====================
+
+// anthropicToChatRequest 将 Anthropic Messages 请求体转换为 Chat Completions 请求体
+func anthropicToChatRequest(body []byte, isStream bool) ([]byte, error) {
+	req := gjson.ParseBytes(body)
+
+	messages := make([]interface{}, 0, len(req.Get("messages").Array())+1)
+	if system := anthropicSystemText(req.Get("system")); system != "" {
+		messages = append(messages, map[string]interface{}{
+			"role":    "system",
+			"content": system,
+		})
+	}
+	for _, msg := range req.Get("messages").Array() {
+		messages = append(messages, anthropicMessageToChat(msg)...)
+	}
+
+	out := map[string]interface{}{
+		"model":    req.Get("model").String(),
+		"messages": messages,
+	}
+
+	// Chat Completions 已弃用 max_tokens，o 系列与 gpt-5 等推理模型只接受 max_completion_tokens
+	if v := req.Get("max_tokens"); v.Exists() {
+		out["max_completion_tokens"] = v.Int()
+	}
+	if v := req.Get("temperature"); v.Exists() {
+		out["temperature"] = v.Float()
+	}
+	if v := req.Get("top_p"); v.Exists() {
+		out["top_p"] = v.Float()
+	}
+	if v := req.Get("stop_sequences"); v.IsArray() && len(v.Array()) > 0 {
+		stops := make([]string, 0, len(v.Array()))
+		for _, s := range v.Array() {
+			stops = append(stops, s.String())
+		}
+		out["stop"] = stops
+	}
+	if v := req.Get("metadata.user_id"); v.Exists() && v.String() != "" {
+		out["user"] = v.String()
+	}
+
+	// thinking -> reasoning_effort
+	if req.Get("thinking.type").String() == "enabled" {
+		out["reasoning_effort"] = thinkingBudgetToEffort(req.Get("thinking.budget_tokens").Int())
+	}
+
+	// tools / tool_choice
+	if tools := req.Get("tools"); tools.IsArray() && len(tools.Array()) > 0 {
+		chatTools := make([]interface{}, 0, len(tools.Array()))
+		for _, tool := range tools.Array() {
+			// 服务端工具（如 web_search）没有 input_schema，Chat Completions 无法表达，跳过
+			if !tool.Get("input_schema").Exists() {
+				continue
+			}
+			fn := map[string]interface{}{
+				"name":       tool.Get("name").String(),
+				"parameters": json.RawMessage(tool.Get("input_schema").Raw),
+			}
+			if desc := tool.Get("description").String(); desc != "" {
+				fn["description"] = desc
+			}
+			chatTools = append(chatTools, map[string]interface{}{
+				"type":     "function",
+				"function": fn,
+			})
+		}
+		if len(chatTools) > 0 {
+			out["tools"] = chatTools
+		}
+	}
+	if choice := req.Get("tool_choice"); choice.Exists() {
+		switch choice.Get("type").String() {
+		case "auto":
+			out["tool_choice"] = "auto"
+		case "any":
+			out["tool_choice"] = "required"
+		case "none":
+			out["tool_choice"] = "none"
+		case "tool":
+			out["tool_choice"] = map[string]interface{}{
+				"type":     "function",
+				"function": map[string]interface{}{"name": choice.Get("name").String()},
+			}
+		}
+		if choice.Get("disable_parallel_tool_use").Bool() {
+			out["parallel_tool_calls"] = false
+		}
+	}
+
+	if isStream {
+		out["stream"] = true
+		// 要求上游在最后一个 chunk 返回 usage，用于统计 token
+		out["stream_options"] = map[string]interface{}{"include_usage": true}
+	}
+
+	return json.Marshal(out)
+}
+
+// anthropicSystemText 提取 system 字段文本（支持字符串和内容块数组）
+func anthropicSystemText(system gjson.Result) string {
+	if !system.Exists() {
+		return ""
+	}
+	if system.Type == gjson.String {
+		return system.String()
+	}
+	var parts []string
+	for _, block := range system.Array() {
+		if text := block.Get("text").String(); text != "" {
+			parts = append(parts, text)
+		}
+	}
+	return strings.Join(parts, "\n\n")
+}
+
+// anthropicMessageToChat 将一条 Anthropic 消息转换为一条或多条 Chat 消息
+// tool_result 块会拆分为独立的 role=tool 消息，并放在用户其他内容之前
+func anthropicMessageToChat(msg gjson.Result) []interface{} {
+	role := msg.Get("role").String()
+	content := msg.Get("content")
+
+	if content.Type == gjson.String {
+		return []interface{}{map[string]interface{}{"role": role, "content": content.String()}}
+	}
+
+	if role == "assistant" {
+		return []interface{}{anthropicAssistantToChat(content)}
+	}
+
+	var result []interface{}
+	var parts []map[string]interface{}
+	for _, block := range content.Array() {
+		switch block.Get("type").String() {
+		case "text":
+			parts = append(parts, map[string]interface{}{"type": "text", "text": block.Get("text").String()})
+		case "image":
+			if url := anthropicImageURL(block.Get("source")); url != "" {
+				parts = append(parts, map[string]interface{}{
+					"type":      "image_url",
+					"image_url": map[string]interface{}{"url": url},
+				})
+			}
+		case "tool_result":
+			result = append(result, map[string]interface{}{
+				"role":         "tool",
+				"tool_call_id": block.Get("tool_use_id").String(),
+				"content":      toolResultText(block),
+			})
+		}
+	}
+
+	if len(parts) > 0 {
+		result = append(result, map[string]interface{}{"role": role, "content": simplifyChatParts(parts)})
+	}
+	return result
+}
+
+// anthropicAssistantToChat 转换 assistant 消息：text 合并为 content，tool_use 转为 tool_calls
+// 历史中的 thinking 块上游无法识别，直接丢弃
+func anthropicAssistantToChat(content gjson.Result) map[string]interface{} {
+	var texts []string
+	var toolCalls []interface{}
+	for _, block := range content.Array() {
+		switch block.Get("type").String() {
+		case "text":
+			texts = append(texts, block.Get("text").String())
+		case "tool_use":
+			args := block.Get("input").Raw
+			if args == "" {
+				args = "{}"
+			}
+			toolCalls = append(toolCalls, map[string]interface{}{
+				"id":   block.Get("id").String(),
+				"type": "function",
+				"function": map[string]interface{}{
+					"name":      block.Get("name").String(),
+					"arguments": args,
+				},
+			})
+		}
+	}
+
+	msg := map[string]interface{}{"role": "assistant"}
+	if len(texts) > 0 {
+		msg["content"] = strings.Join(texts, "")
+	} else {
+		msg["content"] = nil
+	}
+	if len(toolCalls) > 0 {
+		msg["tool_calls"] = toolCalls
+	}
+	return msg
+}
+
+// anthropicImageURL 将图片 source 转换为 URL（base64 转为 data URL）
+func anthropicImageURL(source gjson.Result) string {
+	switch source.Get("type").String() {
+	case "base64":
+		return fmt.Sprintf("data:%s;base64,%s", source.Get("media_type").String(), source.Get("data").String())
+	case "url":
+		return source.Get("url").String()
+	}
+	return ""
+}
+
+// toolResultText 提取 tool_result 的文本内容
+func toolResultText(block gjson.Result) string {
+	content := block.Get("content")
+	text := content.String()
+	if content.IsArray() {
+		var parts []string
+		for _, item := range content.Array() {
+			if item.Get("type").String() == "text" {
+				parts = append(parts, item.Get("text").String())
+			}
+		}
+		text = strings.Join(parts, "\n")
+	}
+	if block.Get("is_error").Bool() && text != "" {
+		text = "[error] " + text
+	}
+	return text
+}
+
+// simplifyChatParts 纯文本内容合并为字符串，兼容不支持数组 content 的上游
+func simplifyChatParts(parts []map[string]interface{}) interface{} {
+	texts := make([]string, 0, len(parts))
+	for _, part := range parts {
+		if part["type"] != "text" {
+			return parts
+		}
+		texts = append(texts, part["text"].(string))
+	}
+	return strings.Join(texts, "\n")
+}
+
+// thinkingBudgetToEffort 将 thinking.budget_tokens 映射为 reasoning_effort
+func thinkingBudgetToEffort(budget int64) string {
+	switch {
+	case budget < 4096:
+		return "low"
+	case budget < 16384:
+		return "medium"
+	default:
+		return "high"
+	}
+}
+
+// ==================== OpenAI Chat Completions -> Anthropic Messages（响应） ====================
+
+// chatFinishToAnthropicStop 映射结束原因
+func chatFinishToAnthropicStop(reason string) string {
+	switch reason {
+	case "length":
+		return "max_tokens"
+	case "tool_calls", "function_call":
+		return "tool_use"
+	default:
+		return "end_turn"
+	}
+}
+
+// chatUsage Chat Completions 用量（已拆分缓存命中部分）
+type chatUsage struct {
+	InputTokens       int
+	OutputTokens      int
+	CacheCreateTokens int
+	CacheReadTokens   int
+	ReasoningTokens   int
+}
+
+// parseChatUsage 解析 Chat usage：prompt_tokens 包含缓存命中部分，需扣除后才是 Anthropic 语义的 input_tokens
+// prompt_tokens_details.cache_creation_tokens 是 Anthropic 上游转换为 Chat 时携带的扩展字段，用于保留缓存写入用量
+func parseChatUsage(usage gjson.Result) chatUsage {
+	cached := int(usage.Get("prompt_tokens_details.cached_tokens").Int())
+	cacheCreate := int(usage.Get("prompt_tokens_details.cache_creation_tokens").Int())
+	input := int(usage.Get("prompt_tokens").Int()) - cached - cacheCreate
+	if input < 0 {
+		input = 0
+	}
+	return chatUsage{
+		InputTokens:       input,
+		OutputTokens:      int(usage.Get("completion_tokens").Int()),
+		CacheCreateTokens: cacheCreate,
+		CacheReadTokens:   cached,
+		ReasoningTokens:   int(usage.Get("completion_tokens_details.reasoning_tokens").Int()),
+	}
+}
+
+// record 将用量累加到 requestLog
+func (u chatUsage) record(requestLog *RequestLog) {
+	if requestLog == nil {
+		return
+	}
+	requestLog.InputTokens += u.InputTokens
+	requestLog.OutputTokens += u.OutputTokens
+	requestLog.CacheCreateTokens += u.CacheCreateTokens
+	requestLog.CacheReadTokens += u.CacheReadTokens
+	requestLog.ReasoningTokens += u.ReasoningTokens
+}
+
+// anthropicUsagePayload 生成 Anthropic usage 对象
+func (u chatUsage) anthropicUsagePayload() map[string]interface{} {
+	return map[string]interface{}{
+		"input_tokens":                u.InputTokens,
+		"output_tokens":               u.OutputTokens,
+		"cache_creation_input_tokens": u.CacheCreateTokens,
+		"cache_read_input_tokens":     u.CacheReadTokens,
+	}
+}
+
+// anthropicMessageID 生成 Anthropic 风格的消息 ID
+func anthropicMessageID(id string) string {
+	if id == "" {
+		return fmt.Sprintf("msg_%d", time.Now().UnixNano())
+	}
+	if strings.HasPrefix(id, "msg_") {
+		return id
+	}
+	return "msg_" + id
+}
+
+// chatToAnthropicResponse 将非流式 Chat Completions 响应转换为 Anthropic Messages 响应，并记录用量
+func chatToAnthropicResponse(data []byte, requestLog *RequestLog) ([]byte, error) {
+	resp := gjson.ParseBytes(data)
+	if !resp.IsObject() {
+		return nil, fmt.Errorf("上游响应不是合法的 JSON 对象")
+	}
+
+	message := resp.Get("choices.0.message")
+	content := make([]interface{}, 0, 2)
+
+	reasoning := message.Get("reasoning_content").String()
+	if reasoning == "" {
+		reasoning = message.Get("reasoning").String()
+	}
+	if reasoning != "" {
+		content = append(content, map[string]interface{}{"type": "thinking", "thinking": reasoning, "signature": ""})
+	}
+	if text := message.Get("content").String(); text != "" {
+		content = append(content, map[string]interface{}{"type": "text", "text": text})
+	}
+	for _, tc := range message.Get("tool_calls").Array() {
+		content = append(content, map[string]interface{}{
+			"type":  "tool_use",
+			"id":    tc.Get("id").String(),
+			"name":  tc.Get("function.name").String(),
+			"input": toolArgumentsToInput(tc.Get("function.arguments").String()),
+		})
+	}
+
+	usage := parseChatUsage(resp.Get("usage"))
+	usage.record(requestLog)
+
+	out := map[string]interface{}{
+		"id":            anthropicMessageID(resp.Get("id").String()),
+		"type":          "message",
+		"role":          "assistant",
+		"model":         resp.Get("model").String(),
+		"content":       content,
+		"stop_reason":   chatFinishToAnthropicStop(resp.Get("choices.0.finish_reason").String()),
+		"stop_sequence": nil,
+		"usage":         usage.anthropicUsagePayload(),
+	}
+	return json.Marshal(out)
+}
+
+// toolArgumentsToInput 将工具参数 JSON 字符串转换为对象，非法 JSON 时返回空对象
+func toolArgumentsToInput(args string) interface{} {
+	if strings.TrimSpace(args) == "" || !json.Valid([]byte(args)) {
+		return map[string]interface{}{}
+	}
+	return json.RawMessage(args)
+}
+
+// ==================== OpenAI Chat Completions -> Anthropic Messages（流式） ====================
+
+// chatToAnthropicStream 将 Chat Completions SSE chunk 转换为 Anthropic SSE 事件
+// 用量在流结束时写入 requestLog，并在 message_delta 中输出给客户端
+type chatToAnthropicStream struct {
+	requestLog   *RequestLog
+	started      bool
+	finished     bool
+	blockIndex   int    // 下一个内容块的索引
+	blockType    string // 当前打开的内容块类型：thinking / text / tool_use，空表示无
+	toolIndex    int64  // 当前 tool_use 块对应的 Chat tool_calls 索引
+	finishReason string
+	usage        chatUsage
+}
+
+func newChatToAnthropicStream(requestLog *RequestLog) *chatToAnthropicStream {
+	return &chatToAnthropicStream{requestLog: requestLog, toolIndex: -1}
+}
+
+func (s *chatToAnthropicStream) onEvent(event string, data string) []byte {
+	if s.finished {
+		return nil
+	}
+	if strings.TrimSpace(data) == "[DONE]" {
+		return s.finishStream()
+	}
+
+	chunk := gjson.Parse(data)
+	if errObj := chunk.Get("error"); errObj.Exists() {
+		s.finished = true
+		msg := errObj.Get("message").String()
+		if msg == "" {
+			msg = errObj.String()
+		}
+		return formatSSEEvent("error", map[string]interface{}{
+			"type":  "error",
+			"error": map[string]interface{}{"type": "api_error", "message": msg},
+		})
+	}
+
+	var out []byte
+	if !s.started {
+		out = append(out, s.startMessage(chunk.Get("id").String(), chunk.Get("model").String())...)
+	}
+
+	if usage := chunk.Get("usage"); usage.IsObject() {
+		s.usage = parseChatUsage(usage)
+	}
+
+	choice := chunk.Get("choices.0")
+	if !choice.Exists() {
+		return out
+	}
+	delta := choice.Get("delta")
+
+	reasoning := delta.Get("reasoning_content").String()
+	if reasoning == "" {
+		reasoning = delta.Get("reasoning").String()
+	}
+	if reasoning != "" {
+		out = append(out, s.ensureBlock("thinking", map[string]interface{}{"type": "thinking", "thinking": "", "signature": ""})...)
+		out = append(out, s.blockDelta(map[string]interface{}{"type": "thinking_delta", "thinking": reasoning})...)
+	}
+
+	if text := delta.Get("content").String(); text != "" {
+		out = append(out, s.ensureBlock("text", map[string]interface{}{"type": "text", "text": ""})...)
+		out = append(out, s.blockDelta(map[string]interface{}{"type": "text_delta", "text": text})...)
+	}
+
+	for _, tc := range delta.Get("tool_calls").Array() {
+		idx := tc.Get("index").Int()
+		if s.blockType != "tool_use" || idx != s.toolIndex {
+			out = append(out, s.closeBlock()...)
+			s.toolIndex = idx
+			out = append(out, s.openBlock("tool_use", map[string]interface{}{
+				"type":  "tool_use",
+				"id":    tc.Get("id").String(),
+				"name":  tc.Get("function.name").String(),
+				"input": map[string]interface{}{},
+			})...)
+		}
+		if args := tc.Get("function.arguments").String(); args != "" {
+			out = append(out, s.blockDelta(map[string]interface{}{"type": "input_json_delta", "partial_json": args})...)
+		}
+	}
+
+	if reason := choice.Get("finish_reason").String(); reason != "" {
+		s.finishReason = reason
+	}
+	return out
+}
+
+func (s *chatToAnthropicStream) finish() []byte {
+	if s.finished {
+		return nil
+	}
+	return s.finishStream()
+}
+
+// startMessage 输出 message_start 与 ping
+func (s *chatToAnthropicStream) startMessage(id, model string) []byte {
+	s.started = true
+	out := formatSSEEvent("message_start", map[string]interface{}{
+		"type": "message_start",
+		"message": map[string]interface{}{
+			"id":            anthropicMessageID(id),
+			"type":          "message",
+			"role":          "assistant",
+			"model":         model,
+			"content":       []interface{}{},
+			"stop_reason":   nil,
+			"stop_sequence": nil,
+			"usage":         map[string]interface{}{"input_tokens": 0, "output_tokens": 0},
+		},
+	})
+	return append(out, formatSSEEvent("ping", map[string]interface{}{"type": "ping"})...)
+}
+
+// ensureBlock 确保当前打开的是指定类型的内容块
+func (s *chatToAnthropicStream) ensureBlock(blockType string, contentBlock map[string]interface{}) []byte {
+	if s.blockType == blockType {
+		return nil
+	}
+	out := s.closeBlock()
+	return append(out, s.openBlock(blockType, contentBlock)...)
+}
+
+func (s *chatToAnthropicStream) openBlock(blockType string, contentBlock map[string]interface{}) []byte {
+	s.blockType = blockType
+	return formatSSEEvent("content_block_start", map[string]interface{}{
+		"type":          "content_block_start",
+		"index":         s.blockIndex,
+		"content_block": contentBlock,
+	})
+}
+
+func (s *chatToAnthropicStream) blockDelta(delta map[string]interface{}) []byte {
+	return formatSSEEvent("content_block_delta", map[string]interface{}{
+		"type":  "content_block_delta",
+		"index": s.blockIndex,
+		"delta": delta,
+	})
+}
+
+func (s *chatToAnthropicStream) closeBlock() []byte {
+	if s.blockType == "" {
+		return nil
+	}
+	out := formatSSEEvent("content_block_stop", map[string]interface{}{
+		"type":  "content_block_stop",
+		"index": s.blockIndex,
+	})
+	s.blockType = ""
+	s.blockIndex++
+	return out
+}
+
+// finishStream 输出 message_delta（含 stop_reason 与用量）和 message_stop
+func (s *chatToAnthropicStream) finishStream() []byte {
+	s.finished = true
+	s.usage.record(s.requestLog)
+	var out []byte
+	if !s.started {
+		out = append(out, s.startMessage("", "")...)
+	}
+	out = append(out, s.closeBlock()...)
+	out = append(out, formatSSEEvent("message_delta", map[string]interface{}{
+		"type":  "message_delta",
+		"delta": map[string]interface{}{"stop_reason": chatFinishToAnthropicStop(s.finishReason), "stop_sequence": nil},
+		"usage": s.usage.anthropicUsagePayload(),
+	})...)
+	return append(out, formatSSEEvent("message_stop", map[string]interface{}{"type": "message_stop"})...)
+}
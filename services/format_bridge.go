@@ -0,0 +1,549 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// WireFormat 表示请求/响应使用的协议格式
+type WireFormat string
+
+const (
+	WireFormatAnthropic  WireFormat = "anthropic"        // Anthropic Messages API (/v1/messages)
+	WireFormatOpenAIChat WireFormat = "openai-chat"      // OpenAI Chat Completions (/v1/chat/completions)
+	WireFormatResponses  WireFormat = "openai-responses" // OpenAI Responses API (/responses)
+	WireFormatGemini     WireFormat = "gemini"           // Gemini generateContent / streamGenerateContent
+)
+
+// isKnownWireFormat 判断是否为支持的协议格式
+func isKnownWireFormat(format WireFormat) bool {
+	switch format {
+	case WireFormatAnthropic, WireFormatOpenAIChat, WireFormatResponses, WireFormatGemini:
+		return true
+	}
+	return false
+}
+
+// defaultEndpointForWireFormat 返回协议格式的标准端点，未知格式返回空字符串
+func defaultEndpointForWireFormat(format WireFormat) string {
+	switch format {
+	case WireFormatAnthropic:
+		return "/v1/messages"
+	case WireFormatOpenAIChat:
+		return "/v1/chat/completions"
+	case WireFormatResponses:
+		return "/responses"
+	case WireFormatGemini:
+		// {model} 在转发时替换为实际模型名
+		return "/v1beta/models/{model}:generateContent"
+	}
+	return ""
+}
+
+// ingressWireFormat 返回平台原生入口使用的协议格式
+// codex 走 /responses，claude 与自定义 CLI 工具走 /v1/messages
+func ingressWireFormat(kind string) WireFormat {
+	if kind == "codex" {
+		return WireFormatResponses
+	}
+	return WireFormatAnthropic
+}
+
+// clientWireFormatKey gin.Context 中记录客户端实际协议格式的键
+// 由 /v1/chat/completions 等非原生入口在进入 proxyHandler 前设置
+const clientWireFormatKey = "codeswitch.clientWireFormat"
+
+// clientWireFormat 返回当前请求的客户端协议格式，未设置时为平台原生格式
+func clientWireFormat(c *gin.Context, kind string) WireFormat {
+	if c != nil {
+		if v, ok := c.Get(clientWireFormatKey); ok {
+			if format, ok := v.(WireFormat); ok && isKnownWireFormat(format) {
+				return format
+			}
+		}
+	}
+	return ingressWireFormat(kind)
+}
+
+// upstreamWireFormat 根据 Provider 配置推断上游使用的协议格式
+// 优先使用 Provider.WireFormat；未配置时 Claude 入口的有效端点以 /chat/completions 结尾视为 OpenAI Chat Completions
+func upstreamWireFormat(kind string, provider *Provider, endpoint string) WireFormat {
+	if provider != nil && isKnownWireFormat(WireFormat(provider.WireFormat)) {
+		return WireFormat(provider.WireFormat)
+	}
+	if ingressWireFormat(kind) != WireFormatAnthropic {
+		return ingressWireFormat(kind)
+	}
+	path := endpoint
+	if idx := strings.Index(path, "?"); idx >= 0 {
+		path = path[:idx]
+	}
+	if strings.HasSuffix(strings.TrimSuffix(path, "/"), "/chat/completions") {
+		return WireFormatOpenAIChat
+	}
+	return ingressWireFormat(kind)
+}
+
+// formatBridge 协议转换桥：负责把客户端格式的请求转换为上游格式，并把上游响应转换回客户端格式
+// Chat Completions 作为中间格式，新增协议只需实现与 Chat Completions 之间的互转
+type formatBridge struct {
+	ingress  WireFormat
+	upstream WireFormat
+	// platform 平台原生格式，决定 Chat 入口的用量统计口径（codex 的 input_tokens 包含缓存命中部分）
+	platform WireFormat
+	// includeUsage Chat 入口的客户端是否要求流式返回 usage chunk
+	includeUsage bool
+}
+
+// newFormatBridge 创建协议转换桥，两端格式相同时返回 nil（直接透传）
+func newFormatBridge(ingress, upstream WireFormat) *formatBridge {
+	if ingress == upstream {
+		return nil
+	}
+	return &formatBridge{ingress: ingress, upstream: upstream, platform: ingress}
+}
+
+// newRequestFormatBridge 根据请求入口与 Provider 配置创建协议转换桥
+// Chat Completions 入口即使上游同为 Chat 也需要经过转换桥：平台的 RequestLogHook 无法解析 Chat 用量
+func newRequestFormatBridge(c *gin.Context, kind string, provider *Provider, endpoint string) *formatBridge {
+	ingress := clientWireFormat(c, kind)
+	upstream := upstreamWireFormat(kind, provider, endpoint)
+	if ingress == WireFormatOpenAIChat {
+		return &formatBridge{ingress: ingress, upstream: upstream, platform: ingressWireFormat(kind)}
+	}
+	bridge := newFormatBridge(ingress, upstream)
+	if bridge != nil {
+		bridge.platform = ingressWireFormat(kind)
+	}
+	return bridge
+}
+
+// String 返回用于日志的转换方向描述
+func (b *formatBridge) String() string {
+	return fmt.Sprintf("%s -> %s", b.ingress, b.upstream)
+}
+
+// convertRequest 将客户端请求体转换为上游格式
+// 转换分两段：客户端格式 -> Chat Completions -> 上游格式
+func (b *formatBridge) convertRequest(body []byte, isStream bool) ([]byte, error) {
+	if !json.Valid(body) {
+		return nil, fmt.Errorf("请求体不是合法的 JSON")
+	}
+
+	var chatBody []byte
+	var err error
+	switch b.ingress {
+	case WireFormatAnthropic:
+		chatBody, err = anthropicToChatRequest(body, isStream)
+	case WireFormatResponses:
+		chatBody, err = responsesToChatRequest(body, isStream)
+	case WireFormatOpenAIChat:
+		chatBody = body
+		b.includeUsage = gjson.GetBytes(body, "stream_options.include_usage").Bool()
+	default:
+		return nil, fmt.Errorf("不支持的协议转换: %s", b)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch b.upstream {
+	case WireFormatOpenAIChat:
+		if isStream && b.ingress == WireFormatOpenAIChat && !b.includeUsage {
+			// 要求上游返回 usage chunk 用于统计，客户端未要求时在响应中剔除
+			return sjson.SetBytes(chatBody, "stream_options.include_usage", true)
+		}
+		return chatBody, nil
+	case WireFormatAnthropic:
+		return chatToAnthropicRequest(chatBody, isStream)
+	case WireFormatResponses:
+		return chatToResponsesRequest(chatBody, isStream)
+	case WireFormatGemini:
+		return chatToGeminiRequest(chatBody)
+	}
+	return nil, fmt.Errorf("不支持的协议转换: %s", b)
+}
+
+// wrapResponse 将上游 2xx 响应体包装为客户端格式的响应体
+// 转换分两段：上游格式 -> Chat Completions -> 客户端格式
+// 转换过程中直接把上游用量写入 requestLog（调用方无需再挂 RequestLogHook，避免重复统计）
+func (b *formatBridge) wrapResponse(body io.Reader, isStream bool, requestLog *RequestLog) (io.Reader, error) {
+	chatBody := body
+	switch b.upstream {
+	case WireFormatOpenAIChat:
+	case WireFormatAnthropic:
+		if isStream {
+			chatBody = newSSETranslateReader(body, newAnthropicToChatStream())
+		} else {
+			converted, err := convertWholeBody(body, anthropicToChatResponse)
+			if err != nil {
+				return nil, err
+			}
+			chatBody = converted
+		}
+	case WireFormatResponses:
+		if isStream {
+			chatBody = newSSETranslateReader(body, newResponsesToChatStream())
+		} else {
+			converted, err := convertWholeBody(body, responsesToChatResponse)
+			if err != nil {
+				return nil, err
+			}
+			chatBody = converted
+		}
+	case WireFormatGemini:
+		if isStream {
+			chatBody = newSSETranslateReader(body, newGeminiToChatStream())
+		} else {
+			converted, err := convertWholeBody(body, geminiToChatResponse)
+			if err != nil {
+				return nil, err
+			}
+			chatBody = converted
+		}
+	default:
+		return nil, fmt.Errorf("不支持的协议转换: %s", b)
+	}
+
+	switch b.ingress {
+	case WireFormatOpenAIChat:
+		if isStream {
+			return newSSETranslateReader(chatBody, newChatUsageRecorder(requestLog, b.platform, b.includeUsage)), nil
+		}
+		return convertWholeBody(chatBody, func(data []byte) ([]byte, error) {
+			recordChatUsage(parseChatUsage(gjson.GetBytes(data, "usage")), requestLog, b.platform)
+			return data, nil
+		})
+	case WireFormatAnthropic:
+		if isStream {
+			return newSSETranslateReader(chatBody, newChatToAnthropicStream(requestLog)), nil
+		}
+		return convertWholeBody(chatBody, func(data []byte) ([]byte, error) {
+			return chatToAnthropicResponse(data, requestLog)
+		})
+	case WireFormatResponses:
+		if isStream {
+			return newSSETranslateReader(chatBody, newChatToResponsesStream(requestLog)), nil
+		}
+		return convertWholeBody(chatBody, func(data []byte) ([]byte, error) {
+			return chatToResponsesResponse(data, requestLog)
+		})
+	}
+	return nil, fmt.Errorf("不支持的协议转换: %s", b)
+}
+
+// upstreamEndpoint 返回上游实际请求的端点与查询参数
+// Gemini 的端点包含模型名，且流式请求使用 streamGenerateContent + alt=sse
+func (b *formatBridge) upstreamEndpoint(endpoint string, query map[string]string, model string, isStream bool) (string, map[string]string) {
+	if b.upstream != WireFormatGemini {
+		return endpoint, query
+	}
+	endpoint = strings.ReplaceAll(endpoint, "{model}", url.PathEscape(model))
+	// 客户端协议的查询参数（如 Claude 的 beta=true）对 Gemini 无意义，不再透传
+	geminiQuery := map[string]string{}
+	if isStream {
+		endpoint = strings.Replace(endpoint, ":generateContent", ":streamGenerateContent", 1)
+		geminiQuery["alt"] = "sse"
+	}
+	return endpoint, geminiQuery
+}
+
+// wrapHTTPResponse 替换上游响应体为转换后的响应体，并修正响应头
+// 原始响应体仍由调用方负责关闭
+func (b *formatBridge) wrapHTTPResponse(httpResp *http.Response, isStream bool, requestLog *RequestLog) error {
+	body, err := b.wrapResponse(httpResp.Body, isStream, requestLog)
+	if err != nil {
+		return err
+	}
+	httpResp.Body = io.NopCloser(body)
+	httpResp.ContentLength = -1
+	httpResp.Header.Del("Content-Length")
+	httpResp.Header.Del("Content-Encoding")
+	httpResp.Header.Set("Content-Type", b.responseContentType(isStream))
+	return nil
+}
+
+// prepareHeaders 调整转发请求头以适配上游协议
+// - 移除 Accept-Encoding，由 Go Transport 自动处理压缩，保证转换器拿到明文
+// - 非 Anthropic 上游移除 anthropic-* 专有头；Anthropic 上游补齐必需的 anthropic-version
+func (b *formatBridge) prepareHeaders(headers http.Header) {
+	headers.Del("Accept-Encoding")
+	if b.upstream == WireFormatAnthropic {
+		if headers.Get("Anthropic-Version") == "" {
+			headers.Set("Anthropic-Version", GetAnthropicAPIVersion())
+		}
+	} else {
+		for key := range headers {
+			if strings.HasPrefix(strings.ToLower(key), "anthropic-") {
+				headers.Del(key)
+			}
+		}
+	}
+}
+
+// authMethod 返回上游协议应使用的认证方式
+// Provider 显式配置认证方式时尊重配置，否则按上游协议的惯例选择
+func (b *formatBridge) authMethod(provider *Provider, fallback AuthMethod) AuthMethod {
+	authType := strings.TrimSpace(strings.ToLower(provider.ConnectivityAuthType))
+	if authType != "" && authType != "auto" {
+		return fallback
+	}
+	switch b.upstream {
+	case WireFormatOpenAIChat, WireFormatResponses:
+		return AuthMethodBearer
+	case WireFormatGemini:
+		return AuthMethodGoogAPIKey
+	case WireFormatAnthropic:
+		// 客户端使用的是其他协议的认证方式（如 Chat 的 Bearer），按 Anthropic 惯例使用 x-api-key
+		return AuthMethodXAPIKey
+	}
+	return fallback
+}
+
+// responseContentType 返回转换后响应的 Content-Type
+func (b *formatBridge) responseContentType(isStream bool) string {
+	if isStream {
+		return "text/event-stream"
+	}
+	return "application/json"
+}
+
+// convertWholeBody 读取完整响应体并整体转换（非流式响应）
+func convertWholeBody(body io.Reader, convert func([]byte) ([]byte, error)) (io.Reader, error) {
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, fmt.Errorf("读取上游响应失败: %w", err)
+	}
+	converted, err := convert(data)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(converted), nil
+}
+
+// ==================== SSE 转换基础设施 ====================
+
+// sseTranslator 将上游 SSE 事件转换为客户端协议的 SSE 字节流
+type sseTranslator interface {
+	// onEvent 处理一个完整的上游 SSE 事件，返回需要写给客户端的数据
+	onEvent(event string, data string) []byte
+	// finish 上游流正常结束时调用，返回收尾数据（如 message_stop）
+	finish() []byte
+}
+
+// sseTranslateReader 按事件解析上游 SSE 流，并输出转换后的 SSE 流
+// 实现 io.Reader，可直接替换 http.Response.Body 复用现有的复制/收集逻辑
+type sseTranslateReader struct {
+	src     *bufio.Reader
+	tr      sseTranslator
+	pending bytes.Buffer
+	event   string
+	data    strings.Builder
+	hasData bool
+	done    bool
+	err     error
+}
+
+func newSSETranslateReader(src io.Reader, tr sseTranslator) *sseTranslateReader {
+	return &sseTranslateReader{
+		src: bufio.NewReaderSize(src, responseBufferSize),
+		tr:  tr,
+	}
+}
+
+// Read 实现 io.Reader
+func (r *sseTranslateReader) Read(p []byte) (int, error) {
+	for r.pending.Len() == 0 && !r.done {
+		line, err := r.src.ReadString('\n')
+		if len(line) > 0 {
+			r.processLine(strings.TrimRight(line, "\r\n"))
+		}
+		if err != nil {
+			r.dispatch()
+			r.done = true
+			if err == io.EOF {
+				r.pending.Write(r.tr.finish())
+			} else {
+				r.err = err
+			}
+		}
+	}
+
+	if r.pending.Len() > 0 {
+		return r.pending.Read(p)
+	}
+	if r.err != nil {
+		return 0, r.err
+	}
+	return 0, io.EOF
+}
+
+// processLine 处理单行 SSE 数据
+func (r *sseTranslateReader) processLine(line string) {
+	switch {
+	case line == "":
+		r.dispatch()
+	case strings.HasPrefix(line, ":"):
+		// SSE 注释（心跳），忽略
+	case strings.HasPrefix(line, "event:"):
+		r.event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+	case strings.HasPrefix(line, "data:"):
+		value := strings.TrimPrefix(line, "data:")
+		value = strings.TrimPrefix(value, " ")
+		if r.hasData {
+			r.data.WriteByte('\n')
+		}
+		r.data.WriteString(value)
+		r.hasData = true
+	}
+}
+
+// dispatch 分发已累积的事件
+func (r *sseTranslateReader) dispatch() {
+	if !r.hasData && r.event == "" {
+		return
+	}
+	r.pending.Write(r.tr.onEvent(r.event, r.data.String()))
+	r.event = ""
+	r.data.Reset()
+	r.hasData = false
+}
+
+// formatSSEEvent 序列化一个 SSE 事件（event 为空时只输出 data 行）
+func formatSSEEvent(event string, payload interface{}) []byte {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil
+	}
+	var buf bytes.Buffer
+	if event != "" {
+		buf.WriteString("event: ")
+		buf.WriteString(event)
+		buf.WriteByte('\n')
+	}
+	buf.WriteString("data: ")
+	buf.Write(data)
+	buf.WriteString("\n\n")
+	return buf.Bytes()
+}
+
+// ==================== Chat Completions 入口用量统计 ====================
+
+// recordChatUsage 按平台口径将 Chat 用量写入 requestLog
+func recordChatUsage(usage chatUsage, requestLog *RequestLog, platform WireFormat) {
+	if platform == WireFormatResponses {
+		usage.recordResponses(requestLog)
+		return
+	}
+	usage.record(requestLog)
+}
+
+// chatUsageRecorder Chat 入口的最后一段转换：原样输出 chunk，同时统计用量
+// 客户端未要求 include_usage 时剔除仅携带 usage 的 chunk，保持与直连上游一致
+type chatUsageRecorder struct {
+	requestLog   *RequestLog
+	platform     WireFormat
+	includeUsage bool
+	usage        chatUsage
+	recorded     bool
+}
+
+func newChatUsageRecorder(requestLog *RequestLog, platform WireFormat, includeUsage bool) *chatUsageRecorder {
+	return &chatUsageRecorder{requestLog: requestLog, platform: platform, includeUsage: includeUsage}
+}
+
+func (r *chatUsageRecorder) onEvent(event string, data string) []byte {
+	if strings.TrimSpace(data) == "[DONE]" {
+		r.record()
+		return chatStreamDone
+	}
+
+	chunk := gjson.Parse(data)
+	if usage := chunk.Get("usage"); usage.IsObject() {
+		r.usage = parseChatUsage(usage)
+		if !r.includeUsage && len(chunk.Get("choices").Array()) == 0 {
+			return nil
+		}
+	}
+
+	var buf bytes.Buffer
+	if event != "" {
+		buf.WriteString("event: " + event + "\n")
+	}
+	buf.WriteString("data: " + data + "\n\n")
+	return buf.Bytes()
+}
+
+func (r *chatUsageRecorder) finish() []byte {
+	r.record()
+	return nil
+}
+
+func (r *chatUsageRecorder) record() {
+	if r.recorded {
+		return
+	}
+	r.recorded = true
+	recordChatUsage(r.usage, r.requestLog, r.platform)
+}
+
+// ==================== Chat Completions 流式输出辅助 ====================
+
+// chatStreamDone Chat Completions 流结束标记
+var chatStreamDone = []byte("data: [DONE]\n\n")
+
+// chatCompletionID 生成 Chat 风格的 ID
+func chatCompletionID(id string) string {
+	if id == "" {
+		return fmt.Sprintf("chatcmpl-%d", time.Now().UnixNano())
+	}
+	if strings.HasPrefix(id, "chatcmpl-") {
+		return id
+	}
+	return "chatcmpl-" + id
+}
+
+// chatChunkBuilder 构造 Chat Completions 流式 chunk，供各上游 -> Chat 的流式转换器复用
+type chatChunkBuilder struct {
+	id      string
+	model   string
+	created int64
+}
+
+// chunk 输出一个带 delta 的 chunk
+func (b *chatChunkBuilder) chunk(delta map[string]interface{}, finishReason interface{}) []byte {
+	return formatSSEEvent("", map[string]interface{}{
+		"id":      b.id,
+		"object":  "chat.completion.chunk",
+		"created": b.created,
+		"model":   b.model,
+		"choices": []interface{}{map[string]interface{}{
+			"index":         0,
+			"delta":         delta,
+			"finish_reason": finishReason,
+		}},
+	})
+}
+
+// usageChunk 输出只携带 usage 的 chunk（与 stream_options.include_usage 的格式一致）
+func (b *chatChunkBuilder) usageChunk(usage map[string]interface{}) []byte {
+	return formatSSEEvent("", map[string]interface{}{
+		"id":      b.id,
+		"object":  "chat.completion.chunk",
+		"created": b.created,
+		"model":   b.model,
+		"choices": []interface{}{},
+		"usage":   usage,
+	})
+}
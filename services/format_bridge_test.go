@@ -0,0 +1,613 @@
+package services
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+// ==================== upstreamWireFormat 测试 ====================
+
+func TestUpstreamWireFormat(t *testing.T) {
+	tests := []struct {
+		name     string
+		kind     string
+		endpoint string
+		expected WireFormat
+	}{
+		{"Claude 默认端点", "claude", "/v1/messages", WireFormatAnthropic},
+		{"Claude 指向 Chat Completions", "claude", "/v1/chat/completions", WireFormatOpenAIChat},
+		{"带查询参数与尾斜杠", "claude", "/api/paas/v4/chat/completions/?beta=true", WireFormatOpenAIChat},
+		{"自定义 CLI 指向 Chat Completions", "custom:mycli", "/v1/chat/completions", WireFormatOpenAIChat},
+		{"Codex 不做转换", "codex", "/v1/chat/completions", WireFormatResponses},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := upstreamWireFormat(tt.kind, &Provider{Name: "test"}, tt.endpoint)
+			if got != tt.expected {
+				t.Errorf("期望 %s, 实际 %s", tt.expected, got)
+			}
+		})
+	}
+}
+
+// ==================== Anthropic -> Chat 请求转换测试 ====================
+
+func TestAnthropicToChatRequest(t *testing.T) {
+	body := `{
+		"model": "glm-4.6",
+		"max_tokens": 1024,
+		"stream": true,
+		"system": [{"type": "text", "text": "你是助手"}],
+		"thinking": {"type": "enabled", "budget_tokens": 8000},
+		"tools": [{"name": "get_weather", "description": "查询天气", "input_schema": {"type": "object"}}],
+		"tool_choice": {"type": "any", "disable_parallel_tool_use": true},
+		"messages": [
+			{"role": "user", "content": [
+				{"type": "text", "text": "看图"},
+				{"type": "image", "source": {"type": "base64", "media_type": "image/png", "data": "AAAA"}}
+			]},
+			{"role": "assistant", "content": [
+				{"type": "thinking", "thinking": "思考中"},
+				{"type": "text", "text": "我查一下"},
+				{"type": "tool_use", "id": "toolu_1", "name": "get_weather", "input": {"city": "北京"}}
+			]},
+			{"role": "user", "content": [
+				{"type": "tool_result", "tool_use_id": "toolu_1", "content": [{"type": "text", "text": "晴"}]},
+				{"type": "text", "text": "继续"}
+			]}
+		]
+	}`
+
+	out, err := anthropicToChatRequest([]byte(body), true)
+	if err != nil {
+		t.Fatalf("转换失败: %v", err)
+	}
+	result := gjson.ParseBytes(out)
+
+	checks := map[string]string{
+		"messages.0.role":                            "system",
+		"messages.0.content":                         "你是助手",
+		"messages.1.content.1.image_url.url":         "data:image/png;base64,AAAA",
+		"messages.2.content":                         "我查一下",
+		"messages.2.tool_calls.0.function.name":      "get_weather",
+		"messages.2.tool_calls.0.function.arguments": `{"city": "北京"}`,
+		"messages.3.role":                            "tool",
+		"messages.3.tool_call_id":                    "toolu_1",
+		"messages.3.content":                         "晴",
+		"messages.4.content":                         "继续",
+		"tools.0.function.name":                      "get_weather",
+		"tool_choice":                                "required",
+		"parallel_tool_calls":                        "false",
+		"reasoning_effort":                           "medium",
+		"stream_options.include_usage":               "true",
+		"max_completion_tokens":                      "1024",
+	}
+	for path, expected := range checks {
+		if got := result.Get(path).String(); got != expected {
+			t.Errorf("%s: 期望 %q, 实际 %q", path, expected, got)
+		}
+	}
+	if result.Get("max_tokens").Exists() {
+		t.Errorf("不应再发送已弃用的 max_tokens: %s", result.Raw)
+	}
+}
+
+// ==================== Chat -> Anthropic 响应转换测试 ====================
+
+func TestChatToAnthropicResponse(t *testing.T) {
+	body := `{
+		"id": "chatcmpl-1",
+		"model": "glm-4.6",
+		"choices": [{"message": {"role": "assistant", "content": "你好", "tool_calls": [
+			{"id": "call_1", "type": "function", "function": {"name": "ls", "arguments": "{\"path\":\".\"}"}}
+		]}, "finish_reason": "tool_calls"}],
+		"usage": {"prompt_tokens": 100, "completion_tokens": 20, "prompt_tokens_details": {"cached_tokens": 30}}
+	}`
+
+	requestLog := &RequestLog{}
+	out, err := chatToAnthropicResponse([]byte(body), requestLog)
+	if err != nil {
+		t.Fatalf("转换失败: %v", err)
+	}
+	result := gjson.ParseBytes(out)
+
+	if result.Get("id").String() != "msg_chatcmpl-1" {
+		t.Errorf("id 错误: %s", result.Get("id").String())
+	}
+	if result.Get("stop_reason").String() != "tool_use" {
+		t.Errorf("stop_reason 错误: %s", result.Get("stop_reason").String())
+	}
+	if result.Get("content.0.text").String() != "你好" {
+		t.Errorf("text 错误: %s", result.Get("content.0.text").String())
+	}
+	if result.Get("content.1.input.path").String() != "." {
+		t.Errorf("tool_use input 错误: %s", result.Get("content.1.input").Raw)
+	}
+	if requestLog.InputTokens != 70 || requestLog.CacheReadTokens != 30 || requestLog.OutputTokens != 20 {
+		t.Errorf("用量错误: input=%d cacheRead=%d output=%d", requestLog.InputTokens, requestLog.CacheReadTokens, requestLog.OutputTokens)
+	}
+}
+
+func TestChatToAnthropicStream(t *testing.T) {
+	upstream := strings.Join([]string{
+		`data: {"id":"c1","model":"glm-4.6","choices":[{"delta":{"role":"assistant","reasoning_content":"想"}}]}`,
+		`data: {"id":"c1","choices":[{"delta":{"content":"你"}}]}`,
+		`data: {"id":"c1","choices":[{"delta":{"content":"好"}}]}`,
+		`data: {"id":"c1","choices":[{"delta":{"tool_calls":[{"index":0,"id":"call_1","function":{"name":"ls","arguments":""}}]}}]}`,
+		`data: {"id":"c1","choices":[{"delta":{"tool_calls":[{"index":0,"function":{"arguments":"{}"}}]},"finish_reason":"tool_calls"}]}`,
+		`data: {"id":"c1","choices":[],"usage":{"prompt_tokens":10,"completion_tokens":5}}`,
+		`data: [DONE]`,
+		``,
+	}, "\n\n")
+
+	requestLog := &RequestLog{}
+	bridge := newFormatBridge(WireFormatAnthropic, WireFormatOpenAIChat)
+	reader, err := bridge.wrapResponse(strings.NewReader(upstream), true, requestLog)
+	if err != nil {
+		t.Fatalf("包装失败: %v", err)
+	}
+	out, _ := io.ReadAll(reader)
+	text := string(out)
+
+	expectedEvents := []string{
+		"event: message_start",
+		`"type":"thinking_delta"`,
+		`"text":"你"`,
+		`"type":"tool_use"`,
+		`"partial_json":"{}"`,
+		`"stop_reason":"tool_use"`,
+		"event: message_stop",
+	}
+	last := -1
+	for _, ev := range expectedEvents {
+		idx := strings.Index(text, ev)
+		if idx < 0 {
+			t.Fatalf("输出缺少 %s\n%s", ev, text)
+		}
+		if idx < last {
+			t.Errorf("事件顺序错误: %s", ev)
+		}
+		last = idx
+	}
+	if strings.Count(text, "event: content_block_start") != 3 || strings.Count(text, "event: content_block_stop") != 3 {
+		t.Errorf("内容块开闭数量错误:\n%s", text)
+	}
+	if requestLog.InputTokens != 10 || requestLog.OutputTokens != 5 {
+		t.Errorf("用量错误: input=%d output=%d", requestLog.InputTokens, requestLog.OutputTokens)
+	}
+}
+
+func TestFormatBridge_PrepareHeaders(t *testing.T) {
+	headers := http.Header{
+		"Anthropic-Version": {"2023-06-01"},
+		"Anthropic-Beta":    {"x"},
+		"Accept-Encoding":   {"gzip"},
+		"Content-Type":      {"application/json"},
+	}
+	newFormatBridge(WireFormatAnthropic, WireFormatOpenAIChat).prepareHeaders(headers)
+
+	for _, key := range []string{"Anthropic-Version", "Anthropic-Beta", "Accept-Encoding"} {
+		if headers.Get(key) != "" {
+			t.Errorf("%s 应被移除", key)
+		}
+	}
+	if headers.Get("Content-Type") != "application/json" {
+		t.Errorf("Content-Type 不应被移除")
+	}
+}
+
+// ==================== Responses <-> Chat 转换测试 ====================
+
+func TestUpstreamWireFormat_ProviderOverride(t *testing.T) {
+	provider := &Provider{Name: "test", WireFormat: string(WireFormatOpenAIChat)}
+	if got := upstreamWireFormat("codex", provider, "/responses"); got != WireFormatOpenAIChat {
+		t.Errorf("期望 %s, 实际 %s", WireFormatOpenAIChat, got)
+	}
+	if got := provider.GetEffectiveEndpoint("/responses"); got != "/v1/chat/completions" {
+		t.Errorf("未配置端点时应使用协议标准端点, 实际 %s", got)
+	}
+
+	provider.APIEndpoint = "/api/v3/chat/completions"
+	if got := provider.GetEffectiveEndpoint("/responses"); got != "/api/v3/chat/completions" {
+		t.Errorf("显式端点应优先, 实际 %s", got)
+	}
+}
+
+func TestResponsesToChatRequest(t *testing.T) {
+	body := `{
+		"model": "qwen3-coder",
+		"instructions": "你是 Codex",
+		"stream": true,
+		"reasoning": {"effort": "minimal"},
+		"tools": [
+			{"type": "function", "name": "shell", "description": "执行命令", "parameters": {"type": "object"}},
+			{"type": "web_search"}
+		],
+		"input": [
+			{"type": "message", "role": "developer", "content": [{"type": "input_text", "text": "规则"}]},
+			{"type": "message", "role": "user", "content": [{"type": "input_text", "text": "列出文件"}]},
+			{"type": "reasoning", "summary": []},
+			{"type": "function_call", "call_id": "call_1", "name": "shell", "arguments": "{\"cmd\":\"ls\"}"},
+			{"type": "function_call", "call_id": "call_2", "name": "shell", "arguments": "{\"cmd\":\"pwd\"}"},
+			{"type": "function_call_output", "call_id": "call_1", "output": "a.go"},
+			{"type": "function_call_output", "call_id": "call_2", "output": "/root"}
+		]
+	}`
+
+	out, err := responsesToChatRequest([]byte(body), true)
+	if err != nil {
+		t.Fatalf("转换失败: %v", err)
+	}
+	result := gjson.ParseBytes(out)
+
+	checks := map[string]string{
+		"messages.0.role":              "system",
+		"messages.0.content":           "你是 Codex",
+		"messages.1.role":              "system",
+		"messages.1.content":           "规则",
+		"messages.2.content":           "列出文件",
+		"messages.3.role":              "assistant",
+		"messages.3.tool_calls.#":      "2",
+		"messages.3.tool_calls.1.id":   "call_2",
+		"messages.4.tool_call_id":      "call_1",
+		"messages.5.content":           "/root",
+		"tools.#":                      "1",
+		"tools.0.function.name":        "shell",
+		"reasoning_effort":             "low",
+		"stream_options.include_usage": "true",
+	}
+	for path, expected := range checks {
+		if got := result.Get(path).String(); got != expected {
+			t.Errorf("%s: 期望 %q, 实际 %q", path, expected, got)
+		}
+	}
+}
+
+func TestChatToResponsesStream(t *testing.T) {
+	upstream := strings.Join([]string{
+		`data: {"id":"c2","model":"qwen3-coder","choices":[{"delta":{"content":"好"}}]}`,
+		`data: {"id":"c2","choices":[{"delta":{"tool_calls":[{"index":0,"id":"call_9","function":{"name":"shell","arguments":"{\"cmd\""}}]}}]}`,
+		`data: {"id":"c2","choices":[{"delta":{"tool_calls":[{"index":0,"function":{"arguments":":\"ls\"}"}}]},"finish_reason":"tool_calls"}]}`,
+		`data: {"id":"c2","choices":[],"usage":{"prompt_tokens":50,"completion_tokens":8,"prompt_tokens_details":{"cached_tokens":20}}}`,
+		`data: [DONE]`,
+		``,
+	}, "\n\n")
+
+	requestLog := &RequestLog{}
+	bridge := newFormatBridge(WireFormatResponses, WireFormatOpenAIChat)
+	reader, err := bridge.wrapResponse(strings.NewReader(upstream), true, requestLog)
+	if err != nil {
+		t.Fatalf("包装失败: %v", err)
+	}
+	out, _ := io.ReadAll(reader)
+	text := string(out)
+
+	for _, ev := range []string{
+		"event: response.created",
+		"event: response.output_text.delta",
+		"event: response.function_call_arguments.delta",
+		"event: response.function_call_arguments.done",
+		"event: response.completed",
+	} {
+		if !strings.Contains(text, ev) {
+			t.Fatalf("输出缺少 %s\n%s", ev, text)
+		}
+	}
+
+	var completed string
+	for _, line := range strings.Split(text, "\n") {
+		if strings.Contains(line, `"type":"response.completed"`) {
+			completed = strings.TrimPrefix(line, "data: ")
+		}
+	}
+	resp := gjson.Get(completed, "response")
+	if resp.Get("output.1.arguments").String() != `{"cmd":"ls"}` {
+		t.Errorf("函数参数拼接错误: %s", resp.Get("output.1.arguments").String())
+	}
+	if resp.Get("usage.input_tokens").Int() != 50 || resp.Get("usage.input_tokens_details.cached_tokens").Int() != 20 {
+		t.Errorf("usage 错误: %s", resp.Get("usage").Raw)
+	}
+
+	// 与 CodexParseTokenUsageFromResponse 的统计口径一致
+	parsed := &RequestLog{}
+	CodexParseTokenUsageFromResponse(completed, parsed)
+	if requestLog.InputTokens != parsed.InputTokens || requestLog.CacheReadTokens != parsed.CacheReadTokens || requestLog.OutputTokens != parsed.OutputTokens {
+		t.Errorf("用量口径不一致: bridge=%+v parser=%+v", requestLog, parsed)
+	}
+}
+
+// ==================== Gemini 转换测试 ====================
+
+func TestFormatBridge_GeminiEndpoint(t *testing.T) {
+	provider := &Provider{Name: "gemini", WireFormat: string(WireFormatGemini)}
+	bridge := newFormatBridge(WireFormatAnthropic, upstreamWireFormat("claude", provider, ""))
+
+	endpoint, query := bridge.upstreamEndpoint(provider.GetEffectiveEndpoint("/v1/messages"), map[string]string{"beta": "true"}, "gemini-2.5-pro", true)
+	if endpoint != "/v1beta/models/gemini-2.5-pro:streamGenerateContent" {
+		t.Errorf("流式端点错误: %s", endpoint)
+	}
+	if len(query) != 1 || query["alt"] != "sse" {
+		t.Errorf("查询参数错误: %v", query)
+	}
+
+	endpoint, query = bridge.upstreamEndpoint(provider.GetEffectiveEndpoint("/v1/messages"), nil, "gemini-2.5-flash", false)
+	if endpoint != "/v1beta/models/gemini-2.5-flash:generateContent" || len(query) != 0 {
+		t.Errorf("非流式端点错误: %s %v", endpoint, query)
+	}
+	if bridge.authMethod(provider, AuthMethodXAPIKey) != AuthMethodGoogAPIKey {
+		t.Errorf("Gemini 上游应使用 x-goog-api-key 认证")
+	}
+}
+
+func TestAnthropicToGeminiRequest(t *testing.T) {
+	body := `{
+		"model": "gemini-2.5-pro",
+		"max_tokens": 2048,
+		"system": "你是助手",
+		"tools": [{"name": "read", "input_schema": {"$schema": "http://json-schema.org/draft-07/schema#", "type": "object", "additionalProperties": false, "properties": {"path": {"type": ["string", "null"]}}}}],
+		"messages": [
+			{"role": "user", "content": "读文件"},
+			{"role": "assistant", "content": [{"type": "tool_use", "id": "toolu_1", "name": "read", "input": {"path": "a.go"}}]},
+			{"role": "user", "content": [{"type": "tool_result", "tool_use_id": "toolu_1", "content": "package main"}]}
+		]
+	}`
+
+	bridge := newFormatBridge(WireFormatAnthropic, WireFormatGemini)
+	out, err := bridge.convertRequest([]byte(body), false)
+	if err != nil {
+		t.Fatalf("转换失败: %v", err)
+	}
+	result := gjson.ParseBytes(out)
+
+	checks := map[string]string{
+		"systemInstruction.parts.0.text":                                     "你是助手",
+		"contents.0.role":                                                    "user",
+		"contents.1.role":                                                    "model",
+		"contents.1.parts.0.functionCall.args.path":                          "a.go",
+		"contents.2.parts.0.functionResponse.name":                           "read",
+		"contents.2.parts.0.functionResponse.response.content":               "package main",
+		"generationConfig.maxOutputTokens":                                   "2048",
+		"tools.0.functionDeclarations.0.parameters.properties.path.type":     "string",
+		"tools.0.functionDeclarations.0.parameters.properties.path.nullable": "true",
+	}
+	for path, expected := range checks {
+		if got := result.Get(path).String(); got != expected {
+			t.Errorf("%s: 期望 %q, 实际 %q", path, expected, got)
+		}
+	}
+	for _, path := range []string{"model", "tools.0.functionDeclarations.0.parameters.$schema", "tools.0.functionDeclarations.0.parameters.additionalProperties"} {
+		if result.Get(path).Exists() {
+			t.Errorf("%s 不应出现在 Gemini 请求中", path)
+		}
+	}
+}
+
+func TestGeminiToAnthropicStream(t *testing.T) {
+	upstream := strings.Join([]string{
+		`data: {"candidates":[{"content":{"role":"model","parts":[{"text":"思考","thought":true}]}}],"usageMetadata":{"promptTokenCount":100},"modelVersion":"gemini-2.5-pro","responseId":"r1"}`,
+		`data: {"candidates":[{"content":{"role":"model","parts":[{"text":"你好"}]}}],"usageMetadata":{"promptTokenCount":100,"candidatesTokenCount":3}}`,
+		`data: {"candidates":[{"content":{"role":"model","parts":[{"functionCall":{"name":"read","args":{"path":"a.go"}}}]},"finishReason":"STOP"}],"usageMetadata":{"promptTokenCount":100,"candidatesTokenCount":12,"thoughtsTokenCount":5,"cachedContentTokenCount":40}}`,
+		``,
+	}, "\n\n")
+
+	requestLog := &RequestLog{}
+	bridge := newFormatBridge(WireFormatAnthropic, WireFormatGemini)
+	reader, err := bridge.wrapResponse(strings.NewReader(upstream), true, requestLog)
+	if err != nil {
+		t.Fatalf("包装失败: %v", err)
+	}
+	out, _ := io.ReadAll(reader)
+	text := string(out)
+
+	for _, ev := range []string{`"type":"thinking_delta"`, `"text":"你好"`, `"name":"read"`, `"partial_json":"{\"path\":\"a.go\"}"`, `"stop_reason":"tool_use"`, "event: message_stop"} {
+		if !strings.Contains(text, ev) {
+			t.Fatalf("输出缺少 %s\n%s", ev, text)
+		}
+	}
+	if requestLog.InputTokens != 60 || requestLog.CacheReadTokens != 40 || requestLog.OutputTokens != 17 || requestLog.ReasoningTokens != 5 {
+		t.Errorf("用量错误: %+v", requestLog)
+	}
+}
+
+// ==================== Chat Completions 入口转换测试 ====================
+
+func TestChatToAnthropicRequest(t *testing.T) {
+	body := []byte(`{
+		"model": "claude-sonnet-4",
+		"messages": [
+			{"role": "system", "content": "You are helpful"},
+			{"role": "user", "content": [{"type":"text","text":"看图"},{"type":"image_url","image_url":{"url":"data:image/png;base64,AAAA"}}]},
+			{"role": "assistant", "content": null, "tool_calls": [{"id":"call_1","type":"function","function":{"name":"read","arguments":"{\"path\":\"a.go\"}"}}]},
+			{"role": "tool", "tool_call_id": "call_1", "content": "package main"},
+			{"role": "user", "content": "继续"}
+		],
+		"tools": [{"type":"function","function":{"name":"read","parameters":{"type":"object"}}}],
+		"tool_choice": "required",
+		"parallel_tool_calls": false,
+		"reasoning_effort": "medium",
+		"temperature": 0.2,
+		"stop": "END",
+		"stream": true
+	}`)
+
+	out, err := chatToAnthropicRequest(body, true)
+	if err != nil {
+		t.Fatalf("转换失败: %v", err)
+	}
+	req := gjson.ParseBytes(out)
+
+	if req.Get("system").String() != "You are helpful" {
+		t.Errorf("system 错误: %s", req.Get("system").Raw)
+	}
+	if n := len(req.Get("messages").Array()); n != 3 {
+		t.Fatalf("期望 3 条消息（tool 结果与后续用户消息合并），实际 %d: %s", n, req.Get("messages").Raw)
+	}
+	if req.Get("messages.0.content.1.source.media_type").String() != "image/png" {
+		t.Errorf("图片转换错误: %s", req.Get("messages.0.content.1").Raw)
+	}
+	if req.Get("messages.1.content.0.input.path").String() != "a.go" {
+		t.Errorf("tool_use 转换错误: %s", req.Get("messages.1").Raw)
+	}
+	if req.Get("messages.2.content.0.type").String() != "tool_result" || req.Get("messages.2.content.1.text").String() != "继续" {
+		t.Errorf("tool_result 合并错误: %s", req.Get("messages.2").Raw)
+	}
+	if req.Get("tool_choice.type").String() != "any" || !req.Get("tool_choice.disable_parallel_tool_use").Bool() {
+		t.Errorf("tool_choice 错误: %s", req.Get("tool_choice").Raw)
+	}
+	if req.Get("thinking.budget_tokens").Int() != 8192 || req.Get("max_tokens").Int() <= 8192 {
+		t.Errorf("thinking/max_tokens 错误: %s %d", req.Get("thinking").Raw, req.Get("max_tokens").Int())
+	}
+	if req.Get("temperature").Exists() {
+		t.Errorf("开启 thinking 时不应透传 temperature")
+	}
+	if req.Get("stop_sequences.0").String() != "END" || !req.Get("stream").Bool() {
+		t.Errorf("stop/stream 错误: %s", string(out))
+	}
+}
+
+func TestAnthropicToChatStream(t *testing.T) {
+	upstream := strings.Join([]string{
+		"event: message_start\ndata: {\"type\":\"message_start\",\"message\":{\"id\":\"msg_1\",\"model\":\"claude-sonnet-4\",\"usage\":{\"input_tokens\":10,\"cache_creation_input_tokens\":5,\"cache_read_input_tokens\":20,\"output_tokens\":1}}}",
+		"event: content_block_start\ndata: {\"type\":\"content_block_start\",\"index\":0,\"content_block\":{\"type\":\"text\",\"text\":\"\"}}",
+		"event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"text_delta\",\"text\":\"你好\"}}",
+		"event: content_block_start\ndata: {\"type\":\"content_block_start\",\"index\":1,\"content_block\":{\"type\":\"tool_use\",\"id\":\"toolu_1\",\"name\":\"read\",\"input\":{}}}",
+		"event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":1,\"delta\":{\"type\":\"input_json_delta\",\"partial_json\":\"{\\\"path\\\":1}\"}}",
+		"event: message_delta\ndata: {\"type\":\"message_delta\",\"delta\":{\"stop_reason\":\"tool_use\"},\"usage\":{\"output_tokens\":7}}",
+		"event: message_stop\ndata: {\"type\":\"message_stop\"}",
+		"",
+	}, "\n\n")
+
+	requestLog := &RequestLog{}
+	bridge := &formatBridge{ingress: WireFormatOpenAIChat, upstream: WireFormatAnthropic, platform: WireFormatAnthropic}
+	reader, err := bridge.wrapResponse(strings.NewReader(upstream), true, requestLog)
+	if err != nil {
+		t.Fatalf("包装失败: %v", err)
+	}
+	out, _ := io.ReadAll(reader)
+	text := string(out)
+
+	for _, want := range []string{`"role":"assistant"`, `"content":"你好"`, `"name":"read"`, `"arguments":"{\"path\":1}"`, `"finish_reason":"tool_calls"`, "data: [DONE]"} {
+		if !strings.Contains(text, want) {
+			t.Fatalf("输出缺少 %s\n%s", want, text)
+		}
+	}
+	// 客户端未要求 include_usage，usage chunk 不应输出
+	if strings.Contains(text, `"usage"`) {
+		t.Errorf("未要求 include_usage 时不应输出 usage chunk\n%s", text)
+	}
+	if requestLog.InputTokens != 10 || requestLog.CacheCreateTokens != 5 || requestLog.CacheReadTokens != 20 || requestLog.OutputTokens != 7 {
+		t.Errorf("用量错误: %+v", requestLog)
+	}
+}
+
+func TestChatToResponsesRequest(t *testing.T) {
+	body := []byte(`{
+		"model": "gpt-5-codex",
+		"messages": [
+			{"role": "developer", "content": "be brief"},
+			{"role": "user", "content": "列出文件"},
+			{"role": "assistant", "content": "", "tool_calls": [{"id":"call_1","type":"function","function":{"name":"shell","arguments":"{\"cmd\":\"ls\"}"}}]},
+			{"role": "tool", "tool_call_id": "call_1", "content": "a.go"}
+		],
+		"tools": [{"type":"function","function":{"name":"shell","parameters":{"type":"object"}}}],
+		"tool_choice": {"type":"function","function":{"name":"shell"}},
+		"max_completion_tokens": 256,
+		"reasoning_effort": "high",
+		"response_format": {"type":"json_object"}
+	}`)
+
+	out, err := chatToResponsesRequest(body, true)
+	if err != nil {
+		t.Fatalf("转换失败: %v", err)
+	}
+	req := gjson.ParseBytes(out)
+
+	if req.Get("instructions").String() != "be brief" {
+		t.Errorf("instructions 错误: %s", req.Get("instructions").Raw)
+	}
+	items := req.Get("input").Array()
+	if len(items) != 3 || items[0].Get("content.0.type").String() != "input_text" ||
+		items[1].Get("type").String() != "function_call" || items[2].Get("type").String() != "function_call_output" {
+		t.Fatalf("input 转换错误: %s", req.Get("input").Raw)
+	}
+	if req.Get("tools.0.name").String() != "shell" || req.Get("tool_choice.name").String() != "shell" {
+		t.Errorf("tools 转换错误: %s", string(out))
+	}
+	if req.Get("max_output_tokens").Int() != 256 || req.Get("reasoning.effort").String() != "high" ||
+		req.Get("text.format.type").String() != "json_object" || !req.Get("stream").Bool() {
+		t.Errorf("参数转换错误: %s", string(out))
+	}
+}
+
+func TestResponsesToChatStream(t *testing.T) {
+	upstream := strings.Join([]string{
+		`data: {"type":"response.created","response":{"id":"resp_1","model":"gpt-5-codex","created_at":1700000000}}`,
+		`data: {"type":"response.output_text.delta","output_index":0,"delta":"好"}`,
+		`data: {"type":"response.output_item.added","output_index":1,"item":{"type":"function_call","call_id":"call_9","name":"shell","arguments":""}}`,
+		`data: {"type":"response.function_call_arguments.delta","output_index":1,"delta":"{\"cmd\":\"ls\"}"}`,
+		`data: {"type":"response.completed","response":{"id":"resp_1","status":"completed","usage":{"input_tokens":50,"input_tokens_details":{"cached_tokens":20},"output_tokens":8,"output_tokens_details":{"reasoning_tokens":3}}}}`,
+		``,
+	}, "\n\n")
+
+	requestLog := &RequestLog{}
+	bridge := &formatBridge{ingress: WireFormatOpenAIChat, upstream: WireFormatResponses, platform: WireFormatResponses, includeUsage: true}
+	reader, err := bridge.wrapResponse(strings.NewReader(upstream), true, requestLog)
+	if err != nil {
+		t.Fatalf("包装失败: %v", err)
+	}
+	out, _ := io.ReadAll(reader)
+	text := string(out)
+
+	for _, want := range []string{`"content":"好"`, `"id":"call_9"`, `"arguments":"{\"cmd\":\"ls\"}"`, `"finish_reason":"tool_calls"`, `"prompt_tokens":50`, "data: [DONE]"} {
+		if !strings.Contains(text, want) {
+			t.Fatalf("输出缺少 %s\n%s", want, text)
+		}
+	}
+	// codex 平台口径：input_tokens 包含缓存命中部分
+	if requestLog.InputTokens != 50 || requestLog.CacheReadTokens != 20 || requestLog.OutputTokens != 8 || requestLog.ReasoningTokens != 3 {
+		t.Errorf("用量错误: %+v", requestLog)
+	}
+}
+
+func TestChatIngressPassthroughBridge(t *testing.T) {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Set(clientWireFormatKey, WireFormatOpenAIChat)
+	bridge := newRequestFormatBridge(c, "claude", &Provider{Name: "chat", WireFormat: string(WireFormatOpenAIChat)}, "/v1/chat/completions")
+	if bridge == nil {
+		t.Fatalf("Chat 入口应始终经过转换桥以统计用量")
+	}
+
+	out, err := bridge.convertRequest([]byte(`{"model":"m","messages":[],"stream":true}`), true)
+	if err != nil {
+		t.Fatalf("转换失败: %v", err)
+	}
+	if !gjson.GetBytes(out, "stream_options.include_usage").Bool() {
+		t.Errorf("应要求上游返回 usage: %s", string(out))
+	}
+
+	upstream := strings.Join([]string{
+		`data: {"id":"c1","choices":[{"delta":{"content":"hi"}}]}`,
+		`data: {"id":"c1","choices":[],"usage":{"prompt_tokens":30,"completion_tokens":4,"prompt_tokens_details":{"cached_tokens":10}}}`,
+		`data: [DONE]`,
+		``,
+	}, "\n\n")
+	requestLog := &RequestLog{}
+	reader, err := bridge.wrapResponse(strings.NewReader(upstream), true, requestLog)
+	if err != nil {
+		t.Fatalf("包装失败: %v", err)
+	}
+	text, _ := io.ReadAll(reader)
+	if strings.Contains(string(text), `"usage"`) || !strings.Contains(string(text), `"content":"hi"`) {
+		t.Errorf("透传输出错误:\n%s", string(text))
+	}
+	if requestLog.InputTokens != 20 || requestLog.CacheReadTokens != 10 || requestLog.OutputTokens != 4 {
+		t.Errorf("用量错误: %+v", requestLog)
+	}
+}
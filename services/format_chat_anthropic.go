@@ -0,0 +1,449 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+// anthropicDefaultMaxTokens Chat 请求未指定 max_tokens 时使用的默认值（Anthropic 要求必填）
+const anthropicDefaultMaxTokens = 8192
+
+// ==================== OpenAI Chat Completions -> Anthropic Messages（请求） ====================
+
+// chatToAnthropicRequest 将 Chat Completions 请求体转换为 Anthropic Messages 请求体
+// system/developer 消息合并为 system；role=tool 消息转为 user 消息中的 tool_result 块
+func chatToAnthropicRequest(body []byte, isStream bool) ([]byte, error) {
+	req := gjson.ParseBytes(body)
+
+	var systemParts []string
+	messages := make([]map[string]interface{}, 0, len(req.Get("messages").Array()))
+	for _, msg := range req.Get("messages").Array() {
+		switch msg.Get("role").String() {
+		case "system", "developer":
+			if text := chatContentText(msg.Get("content")); text != "" {
+				systemParts = append(systemParts, text)
+			}
+		case "assistant":
+			messages = appendAnthropicMessage(messages, "assistant", chatAssistantToAnthropic(msg))
+		case "tool":
+			messages = appendAnthropicMessage(messages, "user", []interface{}{map[string]interface{}{
+				"type":        "tool_result",
+				"tool_use_id": msg.Get("tool_call_id").String(),
+				"content":     chatContentText(msg.Get("content")),
+			}})
+		default:
+			messages = appendAnthropicMessage(messages, "user", chatContentToAnthropic(msg.Get("content")))
+		}
+	}
+
+	maxTokens := req.Get("max_completion_tokens").Int()
+	if maxTokens <= 0 {
+		maxTokens = req.Get("max_tokens").Int()
+	}
+	if maxTokens <= 0 {
+		maxTokens = anthropicDefaultMaxTokens
+	}
+
+	out := map[string]interface{}{
+		"model":    req.Get("model").String(),
+		"messages": messages,
+	}
+	if len(systemParts) > 0 {
+		out["system"] = strings.Join(systemParts, "\n\n")
+	}
+
+	// reasoning_effort -> thinking（开启 thinking 时 Anthropic 不允许自定义 temperature/top_p）
+	if budget := reasoningEffortToThinkingBudget(req.Get("reasoning_effort").String()); budget > 0 {
+		out["thinking"] = map[string]interface{}{"type": "enabled", "budget_tokens": budget}
+		if maxTokens <= int64(budget) {
+			maxTokens = int64(budget) + anthropicDefaultMaxTokens
+		}
+	} else {
+		if v := req.Get("temperature"); v.Exists() {
+			out["temperature"] = v.Float()
+		}
+		if v := req.Get("top_p"); v.Exists() {
+			out["top_p"] = v.Float()
+		}
+	}
+	out["max_tokens"] = maxTokens
+
+	if stop := req.Get("stop"); stop.Exists() {
+		var stops []string
+		if stop.IsArray() {
+			for _, s := range stop.Array() {
+				stops = append(stops, s.String())
+			}
+		} else if stop.String() != "" {
+			stops = append(stops, stop.String())
+		}
+		if len(stops) > 0 {
+			out["stop_sequences"] = stops
+		}
+	}
+	if v := req.Get("user"); v.Exists() && v.String() != "" {
+		out["metadata"] = map[string]interface{}{"user_id": v.String()}
+	}
+
+	// tools / tool_choice
+	if tools := req.Get("tools"); tools.IsArray() {
+		anthropicTools := make([]interface{}, 0, len(tools.Array()))
+		for _, tool := range tools.Array() {
+			if tool.Get("type").String() != "function" {
+				continue
+			}
+			fn := tool.Get("function")
+			schema := json.RawMessage(`{"type":"object","properties":{}}`)
+			if params := fn.Get("parameters"); params.Exists() {
+				schema = json.RawMessage(params.Raw)
+			}
+			anthropicTool := map[string]interface{}{
+				"name":         fn.Get("name").String(),
+				"input_schema": schema,
+			}
+			if desc := fn.Get("description").String(); desc != "" {
+				anthropicTool["description"] = desc
+			}
+			anthropicTools = append(anthropicTools, anthropicTool)
+		}
+		if len(anthropicTools) > 0 {
+			out["tools"] = anthropicTools
+		}
+	}
+	var toolChoice map[string]interface{}
+	if choice := req.Get("tool_choice"); choice.Exists() {
+		switch {
+		case choice.Type == gjson.String && choice.String() == "required":
+			toolChoice = map[string]interface{}{"type": "any"}
+		case choice.Type == gjson.String && choice.String() == "none":
+			toolChoice = map[string]interface{}{"type": "none"}
+		case choice.Type == gjson.String:
+			toolChoice = map[string]interface{}{"type": "auto"}
+		case choice.Get("function.name").String() != "":
+			toolChoice = map[string]interface{}{"type": "tool", "name": choice.Get("function.name").String()}
+		}
+	}
+	if v := req.Get("parallel_tool_calls"); v.Exists() && !v.Bool() && out["tools"] != nil {
+		if toolChoice == nil {
+			toolChoice = map[string]interface{}{"type": "auto"}
+		}
+		toolChoice["disable_parallel_tool_use"] = true
+	}
+	if toolChoice != nil && out["tools"] != nil {
+		out["tool_choice"] = toolChoice
+	}
+
+	if isStream {
+		out["stream"] = true
+	}
+
+	return json.Marshal(out)
+}
+
+// appendAnthropicMessage 追加消息，与上一条同角色时合并内容块（Anthropic 要求 user/assistant 交替）
+func appendAnthropicMessage(messages []map[string]interface{}, role string, blocks []interface{}) []map[string]interface{} {
+	if len(blocks) == 0 {
+		return messages
+	}
+	if n := len(messages); n > 0 && messages[n-1]["role"] == role {
+		existing, _ := messages[n-1]["content"].([]interface{})
+		messages[n-1]["content"] = append(existing, blocks...)
+		return messages
+	}
+	return append(messages, map[string]interface{}{"role": role, "content": blocks})
+}
+
+// chatContentToAnthropic 转换用户消息内容：文本转为 text 块，图片转为 image 块
+func chatContentToAnthropic(content gjson.Result) []interface{} {
+	if content.Type == gjson.String {
+		if content.String() == "" {
+			return nil
+		}
+		return []interface{}{map[string]interface{}{"type": "text", "text": content.String()}}
+	}
+
+	var blocks []interface{}
+	for _, part := range content.Array() {
+		switch part.Get("type").String() {
+		case "text":
+			if text := part.Get("text").String(); text != "" {
+				blocks = append(blocks, map[string]interface{}{"type": "text", "text": text})
+			}
+		case "image_url":
+			url := part.Get("image_url.url").String()
+			if mimeType, data, ok := parseDataURL(url); ok {
+				blocks = append(blocks, map[string]interface{}{
+					"type":   "image",
+					"source": map[string]interface{}{"type": "base64", "media_type": mimeType, "data": data},
+				})
+			} else if url != "" {
+				blocks = append(blocks, map[string]interface{}{
+					"type":   "image",
+					"source": map[string]interface{}{"type": "url", "url": url},
+				})
+			}
+		}
+	}
+	return blocks
+}
+
+// chatAssistantToAnthropic 转换 assistant 消息：content 转为 text 块，tool_calls 转为 tool_use 块
+func chatAssistantToAnthropic(msg gjson.Result) []interface{} {
+	var blocks []interface{}
+	if text := chatContentText(msg.Get("content")); text != "" {
+		blocks = append(blocks, map[string]interface{}{"type": "text", "text": text})
+	}
+	for _, tc := range msg.Get("tool_calls").Array() {
+		blocks = append(blocks, map[string]interface{}{
+			"type":  "tool_use",
+			"id":    tc.Get("id").String(),
+			"name":  tc.Get("function.name").String(),
+			"input": toolArgumentsToInput(tc.Get("function.arguments").String()),
+		})
+	}
+	return blocks
+}
+
+// reasoningEffortToThinkingBudget 将 reasoning_effort 映射为 thinking.budget_tokens，0 表示不开启
+// 取值与 thinkingBudgetToEffort 的区间对应
+func reasoningEffortToThinkingBudget(effort string) int {
+	switch effort {
+	case "low":
+		return 2048
+	case "medium":
+		return 8192
+	case "high":
+		return 24576
+	}
+	return 0
+}
+
+// ==================== Anthropic Messages -> OpenAI Chat Completions（响应） ====================
+
+// anthropicStopToChatFinish 映射结束原因
+func anthropicStopToChatFinish(reason string) string {
+	switch reason {
+	case "max_tokens":
+		return "length"
+	case "tool_use":
+		return "tool_calls"
+	case "refusal":
+		return "content_filter"
+	default:
+		return "stop"
+	}
+}
+
+// anthropicUsageToChat 将 Anthropic usage 转换为 Chat usage
+// prompt_tokens 按 Chat 语义包含缓存读写部分，缓存写入通过扩展字段 cache_creation_tokens 保留
+func anthropicUsageToChat(input, output, cacheCreate, cacheRead int64) map[string]interface{} {
+	prompt := input + cacheCreate + cacheRead
+	return map[string]interface{}{
+		"prompt_tokens":     prompt,
+		"completion_tokens": output,
+		"total_tokens":      prompt + output,
+		"prompt_tokens_details": map[string]interface{}{
+			"cached_tokens":         cacheRead,
+			"cache_creation_tokens": cacheCreate,
+		},
+	}
+}
+
+// anthropicToChatResponse 将非流式 Anthropic Messages 响应转换为 Chat Completions 响应
+func anthropicToChatResponse(data []byte) ([]byte, error) {
+	resp := gjson.ParseBytes(data)
+	if !resp.IsObject() {
+		return nil, fmt.Errorf("上游响应不是合法的 JSON 对象")
+	}
+
+	var text, reasoning strings.Builder
+	var toolCalls []interface{}
+	for _, block := range resp.Get("content").Array() {
+		switch block.Get("type").String() {
+		case "text":
+			text.WriteString(block.Get("text").String())
+		case "thinking":
+			reasoning.WriteString(block.Get("thinking").String())
+		case "tool_use":
+			args := block.Get("input").Raw
+			if args == "" {
+				args = "{}"
+			}
+			toolCalls = append(toolCalls, map[string]interface{}{
+				"index": len(toolCalls),
+				"id":    block.Get("id").String(),
+				"type":  "function",
+				"function": map[string]interface{}{
+					"name":      block.Get("name").String(),
+					"arguments": args,
+				},
+			})
+		}
+	}
+
+	message := map[string]interface{}{"role": "assistant", "content": text.String()}
+	if reasoning.Len() > 0 {
+		message["reasoning_content"] = reasoning.String()
+	}
+	if len(toolCalls) > 0 {
+		message["tool_calls"] = toolCalls
+	}
+
+	usage := resp.Get("usage")
+	out := map[string]interface{}{
+		"id":      chatCompletionID(resp.Get("id").String()),
+		"object":  "chat.completion",
+		"created": time.Now().Unix(),
+		"model":   resp.Get("model").String(),
+		"choices": []interface{}{map[string]interface{}{
+			"index":         0,
+			"message":       message,
+			"finish_reason": anthropicStopToChatFinish(resp.Get("stop_reason").String()),
+		}},
+		"usage": anthropicUsageToChat(
+			usage.Get("input_tokens").Int(),
+			usage.Get("output_tokens").Int(),
+			usage.Get("cache_creation_input_tokens").Int(),
+			usage.Get("cache_read_input_tokens").Int(),
+		),
+	}
+	return json.Marshal(out)
+}
+
+// ==================== Anthropic Messages -> OpenAI Chat Completions（流式） ====================
+
+// anthropicToChatStream 将 Anthropic SSE 事件转换为 Chat Completions SSE chunk
+// 输入用量来自 message_start，输出用量来自 message_delta（累计值），在流结束时统一输出
+type anthropicToChatStream struct {
+	chatChunkBuilder
+	roleSent     bool
+	finished     bool
+	toolIndexes  map[int64]int // Anthropic 内容块索引 -> Chat tool_calls 索引
+	finishReason string
+	input        int64
+	output       int64
+	cacheCreate  int64
+	cacheRead    int64
+}
+
+func newAnthropicToChatStream() *anthropicToChatStream {
+	return &anthropicToChatStream{
+		chatChunkBuilder: chatChunkBuilder{created: time.Now().Unix()},
+		toolIndexes:      make(map[int64]int),
+	}
+}
+
+// delta 输出一个内容 chunk，首个 chunk 附带 role
+func (s *anthropicToChatStream) delta(delta map[string]interface{}) []byte {
+	if !s.roleSent {
+		s.roleSent = true
+		delta["role"] = "assistant"
+	}
+	return s.chunk(delta, nil)
+}
+
+func (s *anthropicToChatStream) onEvent(event string, data string) []byte {
+	if s.finished {
+		return nil
+	}
+	payload := gjson.Parse(data)
+	eventType := payload.Get("type").String()
+	if eventType == "" {
+		eventType = event
+	}
+
+	switch eventType {
+	case "message_start":
+		message := payload.Get("message")
+		s.id = chatCompletionID(message.Get("id").String())
+		s.model = message.Get("model").String()
+		s.input = message.Get("usage.input_tokens").Int()
+		s.output = message.Get("usage.output_tokens").Int()
+		s.cacheCreate = message.Get("usage.cache_creation_input_tokens").Int()
+		s.cacheRead = message.Get("usage.cache_read_input_tokens").Int()
+		return nil
+
+	case "content_block_start":
+		block := payload.Get("content_block")
+		if block.Get("type").String() != "tool_use" {
+			return nil
+		}
+		toolIndex := len(s.toolIndexes)
+		s.toolIndexes[payload.Get("index").Int()] = toolIndex
+		return s.delta(map[string]interface{}{"tool_calls": []interface{}{map[string]interface{}{
+			"index":    toolIndex,
+			"id":       block.Get("id").String(),
+			"type":     "function",
+			"function": map[string]interface{}{"name": block.Get("name").String(), "arguments": ""},
+		}}})
+
+	case "content_block_delta":
+		delta := payload.Get("delta")
+		switch delta.Get("type").String() {
+		case "text_delta":
+			return s.delta(map[string]interface{}{"content": delta.Get("text").String()})
+		case "thinking_delta":
+			return s.delta(map[string]interface{}{"reasoning_content": delta.Get("thinking").String()})
+		case "input_json_delta":
+			toolIndex, ok := s.toolIndexes[payload.Get("index").Int()]
+			if !ok {
+				return nil
+			}
+			return s.delta(map[string]interface{}{"tool_calls": []interface{}{map[string]interface{}{
+				"index":    toolIndex,
+				"function": map[string]interface{}{"arguments": delta.Get("partial_json").String()},
+			}}})
+		}
+		return nil
+
+	case "message_delta":
+		if reason := payload.Get("delta.stop_reason").String(); reason != "" {
+			s.finishReason = reason
+		}
+		usage := payload.Get("usage")
+		if v := usage.Get("output_tokens"); v.Exists() {
+			s.output = v.Int()
+		}
+		// 部分兼容实现只在 message_delta 中返回输入用量
+		if v := usage.Get("input_tokens").Int(); v > 0 {
+			s.input = v
+		}
+		if v := usage.Get("cache_creation_input_tokens").Int(); v > 0 {
+			s.cacheCreate = v
+		}
+		if v := usage.Get("cache_read_input_tokens").Int(); v > 0 {
+			s.cacheRead = v
+		}
+		return nil
+
+	case "message_stop":
+		return s.finishStream()
+
+	case "error":
+		s.finished = true
+		return formatSSEEvent("", map[string]interface{}{"error": payload.Get("error").Value()})
+	}
+	return nil
+}
+
+func (s *anthropicToChatStream) finish() []byte {
+	if s.finished {
+		return nil
+	}
+	return s.finishStream()
+}
+
+// finishStream 输出 finish_reason chunk、usage chunk 和 [DONE]
+func (s *anthropicToChatStream) finishStream() []byte {
+	s.finished = true
+	if s.id == "" {
+		s.id = chatCompletionID("")
+	}
+	out := s.chunk(map[string]interface{}{}, anthropicStopToChatFinish(s.finishReason))
+	out = append(out, s.usageChunk(anthropicUsageToChat(s.input, s.output, s.cacheCreate, s.cacheRead))...)
+	return append(out, chatStreamDone...)
+}
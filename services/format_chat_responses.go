@@ -0,0 +1,379 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+// ==================== OpenAI Chat Completions -> OpenAI Responses（请求） ====================
+
+// chatToResponsesRequest 将 Chat Completions 请求体转换为 Responses API 请求体
+// system/developer 消息合并为 instructions，tool_calls / role=tool 消息转为 function_call / function_call_output 条目
+func chatToResponsesRequest(body []byte, isStream bool) ([]byte, error) {
+	req := gjson.ParseBytes(body)
+
+	var instructions []string
+	input := make([]interface{}, 0, len(req.Get("messages").Array()))
+	for _, msg := range req.Get("messages").Array() {
+		role := msg.Get("role").String()
+		switch role {
+		case "system", "developer":
+			if text := chatContentText(msg.Get("content")); text != "" {
+				instructions = append(instructions, text)
+			}
+		case "assistant":
+			if text := chatContentText(msg.Get("content")); text != "" {
+				input = append(input, map[string]interface{}{
+					"type":    "message",
+					"role":    "assistant",
+					"content": []interface{}{map[string]interface{}{"type": "output_text", "text": text}},
+				})
+			}
+			for _, tc := range msg.Get("tool_calls").Array() {
+				input = append(input, map[string]interface{}{
+					"type":      "function_call",
+					"call_id":   tc.Get("id").String(),
+					"name":      tc.Get("function.name").String(),
+					"arguments": tc.Get("function.arguments").String(),
+				})
+			}
+		case "tool":
+			input = append(input, map[string]interface{}{
+				"type":    "function_call_output",
+				"call_id": msg.Get("tool_call_id").String(),
+				"output":  chatContentText(msg.Get("content")),
+			})
+		default:
+			if content := chatContentToResponses(msg.Get("content")); len(content) > 0 {
+				input = append(input, map[string]interface{}{
+					"type":    "message",
+					"role":    "user",
+					"content": content,
+				})
+			}
+		}
+	}
+
+	out := map[string]interface{}{
+		"model": req.Get("model").String(),
+		"input": input,
+	}
+	if len(instructions) > 0 {
+		out["instructions"] = strings.Join(instructions, "\n\n")
+	}
+
+	if v := req.Get("max_completion_tokens"); v.Exists() {
+		out["max_output_tokens"] = v.Int()
+	} else if v := req.Get("max_tokens"); v.Exists() {
+		out["max_output_tokens"] = v.Int()
+	}
+	if v := req.Get("temperature"); v.Exists() {
+		out["temperature"] = v.Float()
+	}
+	if v := req.Get("top_p"); v.Exists() {
+		out["top_p"] = v.Float()
+	}
+	if v := req.Get("user"); v.Exists() && v.String() != "" {
+		out["user"] = v.String()
+	}
+	if v := req.Get("parallel_tool_calls"); v.Exists() {
+		out["parallel_tool_calls"] = v.Bool()
+	}
+	if effort := req.Get("reasoning_effort").String(); effort != "" {
+		out["reasoning"] = map[string]interface{}{"effort": effort}
+	}
+
+	// response_format -> text.format
+	switch format := req.Get("response_format"); format.Get("type").String() {
+	case "json_schema":
+		schema := format.Get("json_schema")
+		textFormat := map[string]interface{}{
+			"type":   "json_schema",
+			"name":   schema.Get("name").String(),
+			"schema": json.RawMessage(schema.Get("schema").Raw),
+		}
+		if strict := schema.Get("strict"); strict.Exists() {
+			textFormat["strict"] = strict.Bool()
+		}
+		out["text"] = map[string]interface{}{"format": textFormat}
+	case "json_object":
+		out["text"] = map[string]interface{}{"format": map[string]interface{}{"type": "json_object"}}
+	}
+
+	// tools / tool_choice
+	if tools := req.Get("tools"); tools.IsArray() {
+		responsesTools := make([]interface{}, 0, len(tools.Array()))
+		for _, tool := range tools.Array() {
+			if tool.Get("type").String() != "function" {
+				continue
+			}
+			fn := tool.Get("function")
+			responsesTool := map[string]interface{}{"type": "function", "name": fn.Get("name").String()}
+			if desc := fn.Get("description").String(); desc != "" {
+				responsesTool["description"] = desc
+			}
+			if params := fn.Get("parameters"); params.Exists() {
+				responsesTool["parameters"] = json.RawMessage(params.Raw)
+			}
+			if strict := fn.Get("strict"); strict.Exists() {
+				responsesTool["strict"] = strict.Bool()
+			}
+			responsesTools = append(responsesTools, responsesTool)
+		}
+		if len(responsesTools) > 0 {
+			out["tools"] = responsesTools
+		}
+	}
+	if choice := req.Get("tool_choice"); choice.Exists() {
+		if choice.Type == gjson.String {
+			out["tool_choice"] = choice.String()
+		} else if name := choice.Get("function.name").String(); name != "" {
+			out["tool_choice"] = map[string]interface{}{"type": "function", "name": name}
+		}
+	}
+
+	if isStream {
+		out["stream"] = true
+	}
+
+	return json.Marshal(out)
+}
+
+// chatContentToResponses 转换用户消息内容为 input_text / input_image 分段
+func chatContentToResponses(content gjson.Result) []interface{} {
+	if content.Type == gjson.String {
+		if content.String() == "" {
+			return nil
+		}
+		return []interface{}{map[string]interface{}{"type": "input_text", "text": content.String()}}
+	}
+
+	var parts []interface{}
+	for _, part := range content.Array() {
+		switch part.Get("type").String() {
+		case "text":
+			parts = append(parts, map[string]interface{}{"type": "input_text", "text": part.Get("text").String()})
+		case "image_url":
+			image := map[string]interface{}{"type": "input_image", "image_url": part.Get("image_url.url").String()}
+			if detail := part.Get("image_url.detail").String(); detail != "" {
+				image["detail"] = detail
+			}
+			parts = append(parts, image)
+		}
+	}
+	return parts
+}
+
+// ==================== OpenAI Responses -> OpenAI Chat Completions（响应） ====================
+
+// responsesFinishToChat 根据响应状态映射结束原因
+func responsesFinishToChat(resp gjson.Result, hasToolCalls bool) string {
+	if hasToolCalls {
+		return "tool_calls"
+	}
+	if resp.Get("status").String() == "incomplete" {
+		if resp.Get("incomplete_details.reason").String() == "content_filter" {
+			return "content_filter"
+		}
+		return "length"
+	}
+	return "stop"
+}
+
+// responsesUsageToChat 将 Responses usage 转换为 Chat usage（两者的输入用量都包含缓存命中部分）
+func responsesUsageToChat(usage gjson.Result) map[string]interface{} {
+	input := usage.Get("input_tokens").Int()
+	output := usage.Get("output_tokens").Int()
+	return map[string]interface{}{
+		"prompt_tokens":             input,
+		"completion_tokens":         output,
+		"total_tokens":              input + output,
+		"prompt_tokens_details":     map[string]interface{}{"cached_tokens": usage.Get("input_tokens_details.cached_tokens").Int()},
+		"completion_tokens_details": map[string]interface{}{"reasoning_tokens": usage.Get("output_tokens_details.reasoning_tokens").Int()},
+	}
+}
+
+// responsesToChatResponse 将非流式 Responses 响应转换为 Chat Completions 响应
+func responsesToChatResponse(data []byte) ([]byte, error) {
+	resp := gjson.ParseBytes(data)
+	if !resp.IsObject() {
+		return nil, fmt.Errorf("上游响应不是合法的 JSON 对象")
+	}
+
+	var text, reasoning strings.Builder
+	var toolCalls []interface{}
+	for _, item := range resp.Get("output").Array() {
+		switch item.Get("type").String() {
+		case "message":
+			for _, part := range item.Get("content").Array() {
+				if part.Get("type").String() == "output_text" {
+					text.WriteString(part.Get("text").String())
+				}
+			}
+		case "reasoning":
+			for _, summary := range item.Get("summary").Array() {
+				reasoning.WriteString(summary.Get("text").String())
+			}
+		case "function_call":
+			toolCalls = append(toolCalls, map[string]interface{}{
+				"index": len(toolCalls),
+				"id":    item.Get("call_id").String(),
+				"type":  "function",
+				"function": map[string]interface{}{
+					"name":      item.Get("name").String(),
+					"arguments": item.Get("arguments").String(),
+				},
+			})
+		}
+	}
+
+	message := map[string]interface{}{"role": "assistant", "content": text.String()}
+	if reasoning.Len() > 0 {
+		message["reasoning_content"] = reasoning.String()
+	}
+	if len(toolCalls) > 0 {
+		message["tool_calls"] = toolCalls
+	}
+
+	created := resp.Get("created_at").Int()
+	if created == 0 {
+		created = time.Now().Unix()
+	}
+	out := map[string]interface{}{
+		"id":      chatCompletionID(resp.Get("id").String()),
+		"object":  "chat.completion",
+		"created": created,
+		"model":   resp.Get("model").String(),
+		"choices": []interface{}{map[string]interface{}{
+			"index":         0,
+			"message":       message,
+			"finish_reason": responsesFinishToChat(resp, len(toolCalls) > 0),
+		}},
+		"usage": responsesUsageToChat(resp.Get("usage")),
+	}
+	return json.Marshal(out)
+}
+
+// ==================== OpenAI Responses -> OpenAI Chat Completions（流式） ====================
+
+// responsesToChatStream 将 Responses SSE 事件转换为 Chat Completions SSE chunk
+// 用量与结束原因来自 response.completed / response.incomplete
+type responsesToChatStream struct {
+	chatChunkBuilder
+	roleSent    bool
+	finished    bool
+	toolIndexes map[int64]int // output_index -> Chat tool_calls 索引
+}
+
+func newResponsesToChatStream() *responsesToChatStream {
+	return &responsesToChatStream{
+		chatChunkBuilder: chatChunkBuilder{created: time.Now().Unix()},
+		toolIndexes:      make(map[int64]int),
+	}
+}
+
+// delta 输出一个内容 chunk，首个 chunk 附带 role
+func (s *responsesToChatStream) delta(delta map[string]interface{}) []byte {
+	if !s.roleSent {
+		s.roleSent = true
+		delta["role"] = "assistant"
+	}
+	return s.chunk(delta, nil)
+}
+
+func (s *responsesToChatStream) onEvent(event string, data string) []byte {
+	if s.finished {
+		return nil
+	}
+	payload := gjson.Parse(data)
+	eventType := payload.Get("type").String()
+	if eventType == "" {
+		eventType = event
+	}
+
+	switch eventType {
+	case "response.created":
+		resp := payload.Get("response")
+		s.id = chatCompletionID(resp.Get("id").String())
+		s.model = resp.Get("model").String()
+		if created := resp.Get("created_at").Int(); created > 0 {
+			s.created = created
+		}
+		return nil
+
+	case "response.output_text.delta":
+		return s.delta(map[string]interface{}{"content": payload.Get("delta").String()})
+
+	case "response.reasoning_summary_text.delta", "response.reasoning_text.delta":
+		return s.delta(map[string]interface{}{"reasoning_content": payload.Get("delta").String()})
+
+	case "response.output_item.added":
+		item := payload.Get("item")
+		if item.Get("type").String() != "function_call" {
+			return nil
+		}
+		toolIndex := len(s.toolIndexes)
+		s.toolIndexes[payload.Get("output_index").Int()] = toolIndex
+		return s.delta(map[string]interface{}{"tool_calls": []interface{}{map[string]interface{}{
+			"index": toolIndex,
+			"id":    item.Get("call_id").String(),
+			"type":  "function",
+			"function": map[string]interface{}{
+				"name":      item.Get("name").String(),
+				"arguments": item.Get("arguments").String(),
+			},
+		}}})
+
+	case "response.function_call_arguments.delta":
+		toolIndex, ok := s.toolIndexes[payload.Get("output_index").Int()]
+		if !ok {
+			return nil
+		}
+		return s.delta(map[string]interface{}{"tool_calls": []interface{}{map[string]interface{}{
+			"index":    toolIndex,
+			"function": map[string]interface{}{"arguments": payload.Get("delta").String()},
+		}}})
+
+	case "response.completed", "response.incomplete":
+		return s.finishStream(payload.Get("response"))
+
+	case "response.failed":
+		s.finished = true
+		errObj := payload.Get("response.error")
+		if !errObj.Exists() {
+			return formatSSEEvent("", map[string]interface{}{"error": map[string]interface{}{"message": "upstream response failed"}})
+		}
+		return formatSSEEvent("", map[string]interface{}{"error": errObj.Value()})
+
+	case "error":
+		s.finished = true
+		errObj := payload.Get("error")
+		if !errObj.Exists() {
+			errObj = payload
+		}
+		return formatSSEEvent("", map[string]interface{}{"error": errObj.Value()})
+	}
+	return nil
+}
+
+func (s *responsesToChatStream) finish() []byte {
+	if s.finished {
+		return nil
+	}
+	return s.finishStream(gjson.Result{})
+}
+
+// finishStream 输出 finish_reason chunk、usage chunk 和 [DONE]
+func (s *responsesToChatStream) finishStream(resp gjson.Result) []byte {
+	s.finished = true
+	if s.id == "" {
+		s.id = chatCompletionID(resp.Get("id").String())
+	}
+	out := s.chunk(map[string]interface{}{}, responsesFinishToChat(resp, len(s.toolIndexes) > 0))
+	out = append(out, s.usageChunk(responsesUsageToChat(resp.Get("usage")))...)
+	return append(out, chatStreamDone...)
+}
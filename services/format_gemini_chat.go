@@ -0,0 +1,473 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+// ==================== OpenAI Chat Completions -> Gemini generateContent（请求） ====================
+
+// chatToGeminiRequest 将 Chat Completions 请求体转换为 Gemini generateContent 请求体
+// 模型名与流式标志体现在 URL 中（见 formatBridge.upstreamEndpoint），请求体中不包含
+func chatToGeminiRequest(body []byte) ([]byte, error) {
+	req := gjson.ParseBytes(body)
+
+	var systemParts []interface{}
+	contents := make([]map[string]interface{}, 0)
+	toolNames := make(map[string]string) // tool_call_id -> 函数名，functionResponse 需要函数名
+
+	for _, msg := range req.Get("messages").Array() {
+		role := msg.Get("role").String()
+		switch role {
+		case "system", "developer":
+			if text := chatContentText(msg.Get("content")); text != "" {
+				systemParts = append(systemParts, map[string]interface{}{"text": text})
+			}
+
+		case "user":
+			contents = appendGeminiContent(contents, "user", chatContentToGeminiParts(msg.Get("content")))
+
+		case "assistant":
+			var parts []interface{}
+			if text := chatContentText(msg.Get("content")); text != "" {
+				parts = append(parts, map[string]interface{}{"text": text})
+			}
+			for _, tc := range msg.Get("tool_calls").Array() {
+				name := tc.Get("function.name").String()
+				toolNames[tc.Get("id").String()] = name
+				parts = append(parts, map[string]interface{}{
+					"functionCall": map[string]interface{}{
+						"name": name,
+						"args": toolArgumentsToInput(tc.Get("function.arguments").String()),
+					},
+				})
+			}
+			contents = appendGeminiContent(contents, "model", parts)
+
+		case "tool":
+			callID := msg.Get("tool_call_id").String()
+			name := toolNames[callID]
+			if name == "" {
+				name = callID
+			}
+			contents = appendGeminiContent(contents, "user", []interface{}{
+				map[string]interface{}{
+					"functionResponse": map[string]interface{}{
+						"name":     name,
+						"response": map[string]interface{}{"content": chatContentText(msg.Get("content"))},
+					},
+				},
+			})
+		}
+	}
+
+	out := map[string]interface{}{"contents": contents}
+	if len(systemParts) > 0 {
+		out["systemInstruction"] = map[string]interface{}{"parts": systemParts}
+	}
+
+	genConfig := map[string]interface{}{}
+	if v := req.Get("max_tokens"); v.Exists() {
+		genConfig["maxOutputTokens"] = v.Int()
+	} else if v := req.Get("max_completion_tokens"); v.Exists() {
+		genConfig["maxOutputTokens"] = v.Int()
+	}
+	if v := req.Get("temperature"); v.Exists() {
+		genConfig["temperature"] = v.Float()
+	}
+	if v := req.Get("top_p"); v.Exists() {
+		genConfig["topP"] = v.Float()
+	}
+	if v := req.Get("stop"); v.Exists() {
+		var stops []string
+		if v.IsArray() {
+			for _, s := range v.Array() {
+				stops = append(stops, s.String())
+			}
+		} else if v.String() != "" {
+			stops = append(stops, v.String())
+		}
+		if len(stops) > 0 {
+			genConfig["stopSequences"] = stops
+		}
+	}
+	if effort := req.Get("reasoning_effort").String(); effort != "" {
+		genConfig["thinkingConfig"] = map[string]interface{}{
+			"thinkingBudget":  reasoningEffortToGeminiBudget(effort),
+			"includeThoughts": true,
+		}
+	}
+	if format := req.Get("response_format"); format.Exists() {
+		switch format.Get("type").String() {
+		case "json_object":
+			genConfig["responseMimeType"] = "application/json"
+		case "json_schema":
+			genConfig["responseMimeType"] = "application/json"
+			if schema := format.Get("json_schema.schema"); schema.Exists() {
+				genConfig["responseSchema"] = cleanGeminiSchema(schema.Value())
+			}
+		}
+	}
+	if len(genConfig) > 0 {
+		out["generationConfig"] = genConfig
+	}
+
+	if tools := req.Get("tools"); tools.IsArray() && len(tools.Array()) > 0 {
+		declarations := make([]interface{}, 0, len(tools.Array()))
+		for _, tool := range tools.Array() {
+			fn := tool.Get("function")
+			decl := map[string]interface{}{"name": fn.Get("name").String()}
+			if desc := fn.Get("description").String(); desc != "" {
+				decl["description"] = desc
+			}
+			if params := fn.Get("parameters"); params.Exists() {
+				decl["parameters"] = cleanGeminiSchema(params.Value())
+			}
+			declarations = append(declarations, decl)
+		}
+		out["tools"] = []interface{}{map[string]interface{}{"functionDeclarations": declarations}}
+	}
+
+	if choice := req.Get("tool_choice"); choice.Exists() {
+		config := map[string]interface{}{}
+		switch {
+		case choice.String() == "auto":
+			config["mode"] = "AUTO"
+		case choice.String() == "none":
+			config["mode"] = "NONE"
+		case choice.String() == "required":
+			config["mode"] = "ANY"
+		case choice.Get("function.name").Exists():
+			config["mode"] = "ANY"
+			config["allowedFunctionNames"] = []string{choice.Get("function.name").String()}
+		}
+		if len(config) > 0 {
+			out["toolConfig"] = map[string]interface{}{"functionCallingConfig": config}
+		}
+	}
+
+	return json.Marshal(out)
+}
+
+// appendGeminiContent 追加一条 content，与上一条角色相同时合并 parts（Gemini 要求角色交替）
+func appendGeminiContent(contents []map[string]interface{}, role string, parts []interface{}) []map[string]interface{} {
+	if len(parts) == 0 {
+		return contents
+	}
+	if n := len(contents); n > 0 && contents[n-1]["role"] == role {
+		contents[n-1]["parts"] = append(contents[n-1]["parts"].([]interface{}), parts...)
+		return contents
+	}
+	return append(contents, map[string]interface{}{"role": role, "parts": parts})
+}
+
+// chatContentText 提取 Chat 消息内容中的文本（字符串或 text 分段）
+func chatContentText(content gjson.Result) string {
+	if content.Type == gjson.String {
+		return content.String()
+	}
+	var texts []string
+	for _, part := range content.Array() {
+		if part.Get("type").String() == "text" {
+			texts = append(texts, part.Get("text").String())
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+// chatContentToGeminiParts 转换用户消息内容，data URL 图片转为 inlineData
+func chatContentToGeminiParts(content gjson.Result) []interface{} {
+	if content.Type == gjson.String {
+		if content.String() == "" {
+			return nil
+		}
+		return []interface{}{map[string]interface{}{"text": content.String()}}
+	}
+
+	var parts []interface{}
+	for _, part := range content.Array() {
+		switch part.Get("type").String() {
+		case "text":
+			parts = append(parts, map[string]interface{}{"text": part.Get("text").String()})
+		case "image_url":
+			url := part.Get("image_url.url").String()
+			if mimeType, data, ok := parseDataURL(url); ok {
+				parts = append(parts, map[string]interface{}{
+					"inlineData": map[string]interface{}{"mimeType": mimeType, "data": data},
+				})
+			} else if url != "" {
+				parts = append(parts, map[string]interface{}{
+					"fileData": map[string]interface{}{"fileUri": url},
+				})
+			}
+		}
+	}
+	return parts
+}
+
+// parseDataURL 解析 data:<mime>;base64,<data> 形式的 URL
+func parseDataURL(url string) (mimeType string, data string, ok bool) {
+	if !strings.HasPrefix(url, "data:") {
+		return "", "", false
+	}
+	meta, payload, found := strings.Cut(strings.TrimPrefix(url, "data:"), ",")
+	if !found || !strings.HasSuffix(meta, ";base64") {
+		return "", "", false
+	}
+	return strings.TrimSuffix(meta, ";base64"), payload, true
+}
+
+// reasoningEffortToGeminiBudget 将 reasoning_effort 映射为 Gemini thinkingBudget
+func reasoningEffortToGeminiBudget(effort string) int {
+	switch effort {
+	case "low", "minimal":
+		return 1024
+	case "medium":
+		return 8192
+	default:
+		return 24576
+	}
+}
+
+// geminiSchemaKeys Gemini functionDeclarations.parameters 支持的 JSON Schema 字段
+// 其余字段（$schema、additionalProperties 等）会导致上游返回 400，需要剔除
+var geminiSchemaKeys = map[string]bool{
+	"type": true, "format": true, "title": true, "description": true, "nullable": true,
+	"enum": true, "items": true, "properties": true, "required": true, "anyOf": true,
+	"minItems": true, "maxItems": true, "minimum": true, "maximum": true,
+	"minLength": true, "maxLength": true, "pattern": true, "propertyOrdering": true,
+}
+
+// cleanGeminiSchema 递归清理 JSON Schema，仅保留 Gemini 支持的字段
+// type 为数组时（如 ["string","null"]）取第一个非 null 类型并标记 nullable
+func cleanGeminiSchema(schema interface{}) interface{} {
+	obj, ok := schema.(map[string]interface{})
+	if !ok {
+		return schema
+	}
+
+	cleaned := make(map[string]interface{}, len(obj))
+	for key, value := range obj {
+		if !geminiSchemaKeys[key] {
+			continue
+		}
+		switch key {
+		case "type":
+			if types, isArray := value.([]interface{}); isArray {
+				for _, t := range types {
+					if t == "null" {
+						cleaned["nullable"] = true
+					} else if _, exists := cleaned["type"]; !exists {
+						cleaned["type"] = t
+					}
+				}
+				continue
+			}
+			cleaned[key] = value
+		case "properties":
+			props, _ := value.(map[string]interface{})
+			cleanedProps := make(map[string]interface{}, len(props))
+			for name, prop := range props {
+				cleanedProps[name] = cleanGeminiSchema(prop)
+			}
+			cleaned[key] = cleanedProps
+		case "items":
+			cleaned[key] = cleanGeminiSchema(value)
+		case "anyOf":
+			items, _ := value.([]interface{})
+			cleanedItems := make([]interface{}, 0, len(items))
+			for _, item := range items {
+				cleanedItems = append(cleanedItems, cleanGeminiSchema(item))
+			}
+			cleaned[key] = cleanedItems
+		default:
+			cleaned[key] = value
+		}
+	}
+	return cleaned
+}
+
+// ==================== Gemini generateContent -> OpenAI Chat Completions（响应） ====================
+
+// geminiFinishToChat 映射结束原因
+func geminiFinishToChat(reason string, hasToolCalls bool) string {
+	if hasToolCalls {
+		return "tool_calls"
+	}
+	switch reason {
+	case "":
+		return ""
+	case "MAX_TOKENS":
+		return "length"
+	case "SAFETY", "RECITATION", "BLOCKLIST", "PROHIBITED_CONTENT", "SPII":
+		return "content_filter"
+	default:
+		return "stop"
+	}
+}
+
+// geminiUsageToChat 将 usageMetadata 转换为 Chat usage
+// promptTokenCount 已包含缓存命中部分；completion_tokens 按 Chat 语义包含思考 tokens
+func geminiUsageToChat(usage gjson.Result) map[string]interface{} {
+	candidates := usage.Get("candidatesTokenCount").Int()
+	thoughts := usage.Get("thoughtsTokenCount").Int()
+	return map[string]interface{}{
+		"prompt_tokens":             usage.Get("promptTokenCount").Int(),
+		"completion_tokens":         candidates + thoughts,
+		"total_tokens":              usage.Get("totalTokenCount").Int(),
+		"prompt_tokens_details":     map[string]interface{}{"cached_tokens": usage.Get("cachedContentTokenCount").Int()},
+		"completion_tokens_details": map[string]interface{}{"reasoning_tokens": thoughts},
+	}
+}
+
+// geminiPartsToChat 拆分 parts：思考文本、正文、函数调用
+func geminiPartsToChat(parts []gjson.Result, callSeq *int) (reasoning string, text string, toolCalls []interface{}) {
+	var reasoningBuf, textBuf strings.Builder
+	for _, part := range parts {
+		if fc := part.Get("functionCall"); fc.Exists() {
+			callID := fc.Get("id").String()
+			if callID == "" {
+				callID = fmt.Sprintf("call_%d_%d", time.Now().UnixNano(), *callSeq)
+			}
+			args := fc.Get("args").Raw
+			if args == "" {
+				args = "{}"
+			}
+			toolCalls = append(toolCalls, map[string]interface{}{
+				"index": *callSeq,
+				"id":    callID,
+				"type":  "function",
+				"function": map[string]interface{}{
+					"name":      fc.Get("name").String(),
+					"arguments": args,
+				},
+			})
+			*callSeq++
+			continue
+		}
+		if part.Get("thought").Bool() {
+			reasoningBuf.WriteString(part.Get("text").String())
+		} else {
+			textBuf.WriteString(part.Get("text").String())
+		}
+	}
+	return reasoningBuf.String(), textBuf.String(), toolCalls
+}
+
+// geminiToChatResponse 将非流式 Gemini 响应转换为 Chat Completions 响应
+func geminiToChatResponse(data []byte) ([]byte, error) {
+	resp := gjson.ParseBytes(data)
+	if !resp.IsObject() {
+		return nil, fmt.Errorf("上游响应不是合法的 JSON 对象")
+	}
+
+	callSeq := 0
+	candidate := resp.Get("candidates.0")
+	reasoning, text, toolCalls := geminiPartsToChat(candidate.Get("content.parts").Array(), &callSeq)
+
+	message := map[string]interface{}{"role": "assistant", "content": text}
+	if reasoning != "" {
+		message["reasoning_content"] = reasoning
+	}
+	if len(toolCalls) > 0 {
+		message["tool_calls"] = toolCalls
+	}
+
+	finish := geminiFinishToChat(candidate.Get("finishReason").String(), len(toolCalls) > 0)
+	if finish == "" {
+		finish = "stop"
+	}
+
+	out := map[string]interface{}{
+		"id":      chatCompletionID(resp.Get("responseId").String()),
+		"object":  "chat.completion",
+		"created": time.Now().Unix(),
+		"model":   resp.Get("modelVersion").String(),
+		"choices": []interface{}{map[string]interface{}{
+			"index":         0,
+			"message":       message,
+			"finish_reason": finish,
+		}},
+		"usage": geminiUsageToChat(resp.Get("usageMetadata")),
+	}
+	return json.Marshal(out)
+}
+
+// ==================== Gemini streamGenerateContent -> OpenAI Chat Completions（流式） ====================
+
+// geminiToChatStream 将 Gemini SSE chunk 转换为 Chat Completions SSE chunk
+// Gemini 每个 chunk 携带累计 usageMetadata，只在流结束时输出最后一次用量
+type geminiToChatStream struct {
+	chatChunkBuilder
+	callSeq      int
+	hasToolCalls bool
+	finishReason string
+	usage        gjson.Result
+	finished     bool
+}
+
+func newGeminiToChatStream() *geminiToChatStream {
+	return &geminiToChatStream{chatChunkBuilder: chatChunkBuilder{created: time.Now().Unix()}}
+}
+
+func (s *geminiToChatStream) onEvent(event string, data string) []byte {
+	if s.finished {
+		return nil
+	}
+	resp := gjson.Parse(data)
+	if errObj := resp.Get("error"); errObj.Exists() {
+		s.finished = true
+		return formatSSEEvent("", map[string]interface{}{"error": errObj.Value()})
+	}
+
+	if s.id == "" {
+		s.id = chatCompletionID(resp.Get("responseId").String())
+	}
+	if model := resp.Get("modelVersion").String(); model != "" {
+		s.model = model
+	}
+	if usage := resp.Get("usageMetadata"); usage.Exists() {
+		s.usage = usage
+	}
+
+	candidate := resp.Get("candidates.0")
+	reasoning, text, toolCalls := geminiPartsToChat(candidate.Get("content.parts").Array(), &s.callSeq)
+
+	var out []byte
+	if reasoning != "" {
+		out = append(out, s.chunk(map[string]interface{}{"reasoning_content": reasoning}, nil)...)
+	}
+	if text != "" {
+		out = append(out, s.chunk(map[string]interface{}{"content": text}, nil)...)
+	}
+	if len(toolCalls) > 0 {
+		s.hasToolCalls = true
+		out = append(out, s.chunk(map[string]interface{}{"tool_calls": toolCalls}, nil)...)
+	}
+	if reason := candidate.Get("finishReason").String(); reason != "" {
+		s.finishReason = reason
+	}
+	return out
+}
+
+func (s *geminiToChatStream) finish() []byte {
+	if s.finished {
+		return nil
+	}
+	s.finished = true
+	if s.id == "" {
+		s.id = chatCompletionID("")
+	}
+
+	finish := geminiFinishToChat(s.finishReason, s.hasToolCalls)
+	if finish == "" {
+		finish = "stop"
+	}
+	out := s.chunk(map[string]interface{}{}, finish)
+	out = append(out, s.usageChunk(geminiUsageToChat(s.usage))...)
+	return append(out, chatStreamDone...)
+}
@@ -0,0 +1,645 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+// ==================== OpenAI Responses -> OpenAI Chat Completions（请求） ====================
+
+// responsesToChatRequest 将 Responses API 请求体转换为 Chat Completions 请求体
+// 仅支持 function 类型的工具；内置工具（web_search、local_shell 等）无法在 Chat Completions 中表达，直接丢弃
+func responsesToChatRequest(body []byte, isStream bool) ([]byte, error) {
+	req := gjson.ParseBytes(body)
+
+	messages := make([]interface{}, 0)
+	if instructions := req.Get("instructions").String(); instructions != "" {
+		messages = append(messages, map[string]interface{}{
+			"role":    "system",
+			"content": instructions,
+		})
+	}
+
+	input := req.Get("input")
+	if input.Type == gjson.String {
+		messages = append(messages, map[string]interface{}{"role": "user", "content": input.String()})
+	} else {
+		messages = appendResponsesInputItems(messages, input.Array())
+	}
+
+	out := map[string]interface{}{
+		"model":    req.Get("model").String(),
+		"messages": messages,
+	}
+
+	if v := req.Get("max_output_tokens"); v.Exists() {
+		out["max_tokens"] = v.Int()
+	}
+	if v := req.Get("temperature"); v.Exists() {
+		out["temperature"] = v.Float()
+	}
+	if v := req.Get("top_p"); v.Exists() {
+		out["top_p"] = v.Float()
+	}
+	if v := req.Get("user"); v.Exists() && v.String() != "" {
+		out["user"] = v.String()
+	}
+	if v := req.Get("parallel_tool_calls"); v.Exists() {
+		out["parallel_tool_calls"] = v.Bool()
+	}
+
+	// reasoning.effort -> reasoning_effort（minimal 不是 Chat Completions 的通用取值，降为 low）
+	if effort := req.Get("reasoning.effort").String(); effort != "" {
+		if effort == "minimal" {
+			effort = "low"
+		}
+		out["reasoning_effort"] = effort
+	}
+
+	// text.format -> response_format
+	if format := req.Get("text.format"); format.Get("type").String() == "json_schema" {
+		schema := map[string]interface{}{
+			"name":   format.Get("name").String(),
+			"schema": json.RawMessage(format.Get("schema").Raw),
+		}
+		if strict := format.Get("strict"); strict.Exists() {
+			schema["strict"] = strict.Bool()
+		}
+		out["response_format"] = map[string]interface{}{"type": "json_schema", "json_schema": schema}
+	} else if format.Get("type").String() == "json_object" {
+		out["response_format"] = map[string]interface{}{"type": "json_object"}
+	}
+
+	// tools / tool_choice
+	if tools := req.Get("tools"); tools.IsArray() {
+		chatTools := make([]interface{}, 0, len(tools.Array()))
+		for _, tool := range tools.Array() {
+			if tool.Get("type").String() != "function" {
+				continue
+			}
+			fn := map[string]interface{}{"name": tool.Get("name").String()}
+			if desc := tool.Get("description").String(); desc != "" {
+				fn["description"] = desc
+			}
+			if params := tool.Get("parameters"); params.Exists() {
+				fn["parameters"] = json.RawMessage(params.Raw)
+			}
+			chatTools = append(chatTools, map[string]interface{}{
+				"type":     "function",
+				"function": fn,
+			})
+		}
+		if len(chatTools) > 0 {
+			out["tools"] = chatTools
+		}
+	}
+	if choice := req.Get("tool_choice"); choice.Exists() {
+		if choice.Type == gjson.String {
+			out["tool_choice"] = choice.String()
+		} else if choice.Get("type").String() == "function" {
+			out["tool_choice"] = map[string]interface{}{
+				"type":     "function",
+				"function": map[string]interface{}{"name": choice.Get("name").String()},
+			}
+		}
+	}
+
+	if isStream {
+		out["stream"] = true
+		out["stream_options"] = map[string]interface{}{"include_usage": true}
+	}
+
+	return json.Marshal(out)
+}
+
+// appendResponsesInputItems 将 Responses input 数组转换为 Chat 消息
+// 连续的 function_call 合并到同一条 assistant 消息的 tool_calls 中
+func appendResponsesInputItems(messages []interface{}, items []gjson.Result) []interface{} {
+	for _, item := range items {
+		itemType := item.Get("type").String()
+		if itemType == "" && item.Get("role").Exists() {
+			itemType = "message"
+		}
+
+		switch itemType {
+		case "message":
+			role := item.Get("role").String()
+			if role == "developer" {
+				role = "system"
+			}
+			messages = append(messages, map[string]interface{}{
+				"role":    role,
+				"content": responsesContentToChat(item.Get("content")),
+			})
+
+		case "function_call":
+			toolCall := map[string]interface{}{
+				"id":   item.Get("call_id").String(),
+				"type": "function",
+				"function": map[string]interface{}{
+					"name":      item.Get("name").String(),
+					"arguments": item.Get("arguments").String(),
+				},
+			}
+			// 紧跟在 assistant 消息后的 function_call 归并到该消息
+			if n := len(messages); n > 0 {
+				if last, ok := messages[n-1].(map[string]interface{}); ok && last["role"] == "assistant" {
+					calls, _ := last["tool_calls"].([]interface{})
+					last["tool_calls"] = append(calls, toolCall)
+					continue
+				}
+			}
+			messages = append(messages, map[string]interface{}{
+				"role":       "assistant",
+				"content":    nil,
+				"tool_calls": []interface{}{toolCall},
+			})
+
+		case "function_call_output":
+			output := item.Get("output")
+			text := output.String()
+			if output.IsArray() {
+				text = responsesContentText(output)
+			}
+			messages = append(messages, map[string]interface{}{
+				"role":         "tool",
+				"tool_call_id": item.Get("call_id").String(),
+				"content":      text,
+			})
+
+		default:
+			// reasoning 等条目上游无法识别，直接丢弃
+		}
+	}
+	return messages
+}
+
+// responsesContentToChat 转换消息内容：纯文本合并为字符串，含图片时使用数组形式
+func responsesContentToChat(content gjson.Result) interface{} {
+	if content.Type == gjson.String {
+		return content.String()
+	}
+
+	var parts []map[string]interface{}
+	for _, part := range content.Array() {
+		switch part.Get("type").String() {
+		case "input_text", "output_text", "text":
+			parts = append(parts, map[string]interface{}{"type": "text", "text": part.Get("text").String()})
+		case "input_image":
+			url := part.Get("image_url").String()
+			if url == "" {
+				continue
+			}
+			imageURL := map[string]interface{}{"url": url}
+			if detail := part.Get("detail").String(); detail != "" {
+				imageURL["detail"] = detail
+			}
+			parts = append(parts, map[string]interface{}{"type": "image_url", "image_url": imageURL})
+		}
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return simplifyChatParts(parts)
+}
+
+// responsesContentText 提取内容数组中的文本
+func responsesContentText(content gjson.Result) string {
+	var texts []string
+	for _, part := range content.Array() {
+		if text := part.Get("text").String(); text != "" {
+			texts = append(texts, text)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+// ==================== OpenAI Chat Completions -> OpenAI Responses（响应） ====================
+
+// recordResponses 按 Responses 语义将用量累加到 requestLog（input_tokens 包含缓存命中部分）
+// 与 CodexParseTokenUsageFromResponse 的统计口径保持一致
+func (u chatUsage) recordResponses(requestLog *RequestLog) {
+	if requestLog == nil {
+		return
+	}
+	requestLog.InputTokens += u.InputTokens + u.CacheCreateTokens + u.CacheReadTokens
+	requestLog.OutputTokens += u.OutputTokens
+	requestLog.CacheReadTokens += u.CacheReadTokens
+	requestLog.ReasoningTokens += u.ReasoningTokens
+}
+
+// responsesUsagePayload 生成 Responses usage 对象
+func (u chatUsage) responsesUsagePayload() map[string]interface{} {
+	input := u.InputTokens + u.CacheCreateTokens + u.CacheReadTokens
+	return map[string]interface{}{
+		"input_tokens":          input,
+		"input_tokens_details":  map[string]interface{}{"cached_tokens": u.CacheReadTokens},
+		"output_tokens":         u.OutputTokens,
+		"output_tokens_details": map[string]interface{}{"reasoning_tokens": u.ReasoningTokens},
+		"total_tokens":          input + u.OutputTokens,
+	}
+}
+
+// responsesID 生成 Responses 风格的 ID
+func responsesID(prefix, id string) string {
+	if id == "" {
+		return fmt.Sprintf("%s_%d", prefix, time.Now().UnixNano())
+	}
+	if strings.HasPrefix(id, prefix+"_") {
+		return id
+	}
+	return prefix + "_" + id
+}
+
+// responsesStatus 根据 finish_reason 返回响应状态和未完成原因
+func responsesStatus(finishReason string) (string, interface{}) {
+	if finishReason == "length" {
+		return "incomplete", map[string]interface{}{"reason": "max_output_tokens"}
+	}
+	return "completed", nil
+}
+
+// newResponsesObject 构造 response 对象
+func newResponsesObject(id, model string, createdAt int64, status string, output []interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"id":                  id,
+		"object":              "response",
+		"created_at":          createdAt,
+		"status":              status,
+		"model":               model,
+		"output":              output,
+		"parallel_tool_calls": true,
+		"tool_choice":         "auto",
+		"tools":               []interface{}{},
+	}
+}
+
+// responsesMessageItem 构造 assistant 文本输出条目
+func responsesMessageItem(id, text, status string) map[string]interface{} {
+	content := []interface{}{}
+	if status == "completed" {
+		content = append(content, map[string]interface{}{"type": "output_text", "text": text, "annotations": []interface{}{}})
+	}
+	return map[string]interface{}{
+		"id":      id,
+		"type":    "message",
+		"status":  status,
+		"role":    "assistant",
+		"content": content,
+	}
+}
+
+// responsesFunctionCallItem 构造函数调用输出条目
+func responsesFunctionCallItem(id, callID, name, arguments, status string) map[string]interface{} {
+	return map[string]interface{}{
+		"id":        id,
+		"type":      "function_call",
+		"status":    status,
+		"call_id":   callID,
+		"name":      name,
+		"arguments": arguments,
+	}
+}
+
+// responsesReasoningItem 构造推理输出条目（以 summary 形式返回推理文本）
+func responsesReasoningItem(id, text string) map[string]interface{} {
+	summary := []interface{}{}
+	if text != "" {
+		summary = append(summary, map[string]interface{}{"type": "summary_text", "text": text})
+	}
+	return map[string]interface{}{
+		"id":      id,
+		"type":    "reasoning",
+		"summary": summary,
+	}
+}
+
+// chatToResponsesResponse 将非流式 Chat Completions 响应转换为 Responses 响应，并记录用量
+func chatToResponsesResponse(data []byte, requestLog *RequestLog) ([]byte, error) {
+	resp := gjson.ParseBytes(data)
+	if !resp.IsObject() {
+		return nil, fmt.Errorf("上游响应不是合法的 JSON 对象")
+	}
+
+	id := resp.Get("id").String()
+	message := resp.Get("choices.0.message")
+	output := make([]interface{}, 0, 2)
+
+	reasoning := message.Get("reasoning_content").String()
+	if reasoning == "" {
+		reasoning = message.Get("reasoning").String()
+	}
+	if reasoning != "" {
+		output = append(output, responsesReasoningItem(responsesID("rs", id), reasoning))
+	}
+	if text := message.Get("content").String(); text != "" {
+		output = append(output, responsesMessageItem(responsesID("msg", id), text, "completed"))
+	}
+	for _, tc := range message.Get("tool_calls").Array() {
+		callID := tc.Get("id").String()
+		output = append(output, responsesFunctionCallItem(responsesID("fc", callID), callID,
+			tc.Get("function.name").String(), tc.Get("function.arguments").String(), "completed"))
+	}
+
+	usage := parseChatUsage(resp.Get("usage"))
+	usage.recordResponses(requestLog)
+
+	createdAt := resp.Get("created").Int()
+	if createdAt == 0 {
+		createdAt = time.Now().Unix()
+	}
+	status, incomplete := responsesStatus(resp.Get("choices.0.finish_reason").String())
+	out := newResponsesObject(responsesID("resp", id), resp.Get("model").String(), createdAt, status, output)
+	out["usage"] = usage.responsesUsagePayload()
+	if incomplete != nil {
+		out["incomplete_details"] = incomplete
+	}
+	return json.Marshal(out)
+}
+
+// ==================== OpenAI Chat Completions -> OpenAI Responses（流式） ====================
+
+// responsesStreamItem 流式转换中正在输出的条目
+type responsesStreamItem struct {
+	kind      string // reasoning / message / function_call
+	id        string
+	callID    string
+	name      string
+	text      strings.Builder
+	toolIndex int64
+}
+
+// chatToResponsesStream 将 Chat Completions SSE chunk 转换为 Responses SSE 事件
+type chatToResponsesStream struct {
+	requestLog   *RequestLog
+	started      bool
+	finished     bool
+	respID       string
+	model        string
+	createdAt    int64
+	seq          int
+	current      *responsesStreamItem
+	output       []interface{} // 已完成的输出条目（用于 response.completed）
+	finishReason string
+	usage        chatUsage
+}
+
+func newChatToResponsesStream(requestLog *RequestLog) *chatToResponsesStream {
+	return &chatToResponsesStream{requestLog: requestLog}
+}
+
+// emit 输出一个 Responses 事件（event 名与 data.type 一致）
+func (s *chatToResponsesStream) emit(eventType string, payload map[string]interface{}) []byte {
+	payload["type"] = eventType
+	payload["sequence_number"] = s.seq
+	s.seq++
+	return formatSSEEvent(eventType, payload)
+}
+
+func (s *chatToResponsesStream) onEvent(event string, data string) []byte {
+	if s.finished {
+		return nil
+	}
+	if strings.TrimSpace(data) == "[DONE]" {
+		return s.finishStream()
+	}
+
+	chunk := gjson.Parse(data)
+	if errObj := chunk.Get("error"); errObj.Exists() {
+		return s.failStream(errObj)
+	}
+
+	var out []byte
+	if !s.started {
+		out = append(out, s.startResponse(chunk.Get("id").String(), chunk.Get("model").String(), chunk.Get("created").Int())...)
+	}
+
+	if usage := chunk.Get("usage"); usage.IsObject() {
+		s.usage = parseChatUsage(usage)
+	}
+
+	choice := chunk.Get("choices.0")
+	if !choice.Exists() {
+		return out
+	}
+	delta := choice.Get("delta")
+
+	reasoning := delta.Get("reasoning_content").String()
+	if reasoning == "" {
+		reasoning = delta.Get("reasoning").String()
+	}
+	if reasoning != "" {
+		out = append(out, s.ensureItem("reasoning", -1, "", "")...)
+		s.current.text.WriteString(reasoning)
+		out = append(out, s.emit("response.reasoning_summary_text.delta", map[string]interface{}{
+			"item_id":       s.current.id,
+			"output_index":  len(s.output),
+			"summary_index": 0,
+			"delta":         reasoning,
+		})...)
+	}
+
+	if text := delta.Get("content").String(); text != "" {
+		out = append(out, s.ensureItem("message", -1, "", "")...)
+		s.current.text.WriteString(text)
+		out = append(out, s.emit("response.output_text.delta", map[string]interface{}{
+			"item_id":       s.current.id,
+			"output_index":  len(s.output),
+			"content_index": 0,
+			"delta":         text,
+		})...)
+	}
+
+	for _, tc := range delta.Get("tool_calls").Array() {
+		out = append(out, s.ensureItem("function_call", tc.Get("index").Int(), tc.Get("id").String(), tc.Get("function.name").String())...)
+		if args := tc.Get("function.arguments").String(); args != "" {
+			s.current.text.WriteString(args)
+			out = append(out, s.emit("response.function_call_arguments.delta", map[string]interface{}{
+				"item_id":      s.current.id,
+				"output_index": len(s.output),
+				"delta":        args,
+			})...)
+		}
+	}
+
+	if reason := choice.Get("finish_reason").String(); reason != "" {
+		s.finishReason = reason
+	}
+	return out
+}
+
+func (s *chatToResponsesStream) finish() []byte {
+	if s.finished {
+		return nil
+	}
+	return s.finishStream()
+}
+
+// startResponse 输出 response.created 与 response.in_progress
+func (s *chatToResponsesStream) startResponse(id, model string, createdAt int64) []byte {
+	s.started = true
+	s.respID = responsesID("resp", id)
+	s.model = model
+	s.createdAt = createdAt
+	if s.createdAt == 0 {
+		s.createdAt = time.Now().Unix()
+	}
+	out := s.emit("response.created", map[string]interface{}{
+		"response": newResponsesObject(s.respID, s.model, s.createdAt, "in_progress", []interface{}{}),
+	})
+	return append(out, s.emit("response.in_progress", map[string]interface{}{
+		"response": newResponsesObject(s.respID, s.model, s.createdAt, "in_progress", []interface{}{}),
+	})...)
+}
+
+// ensureItem 确保当前输出条目为指定类型，必要时关闭旧条目并打开新条目
+// 函数调用按 Chat tool_calls 的 index 区分
+func (s *chatToResponsesStream) ensureItem(kind string, toolIndex int64, callID, name string) []byte {
+	if s.current != nil && s.current.kind == kind && s.current.toolIndex == toolIndex {
+		return nil
+	}
+	out := s.closeItem()
+
+	item := &responsesStreamItem{kind: kind, toolIndex: toolIndex, callID: callID, name: name}
+	outputIndex := len(s.output)
+	var added map[string]interface{}
+	switch kind {
+	case "reasoning":
+		item.id = responsesID("rs", fmt.Sprintf("%s_%d", s.respID, outputIndex))
+		added = responsesReasoningItem(item.id, "")
+	case "message":
+		item.id = responsesID("msg", fmt.Sprintf("%s_%d", s.respID, outputIndex))
+		added = responsesMessageItem(item.id, "", "in_progress")
+	case "function_call":
+		if item.callID == "" {
+			item.callID = fmt.Sprintf("call_%s_%d", s.respID, outputIndex)
+		}
+		item.id = responsesID("fc", item.callID)
+		added = responsesFunctionCallItem(item.id, item.callID, item.name, "", "in_progress")
+	}
+	s.current = item
+
+	out = append(out, s.emit("response.output_item.added", map[string]interface{}{
+		"output_index": outputIndex,
+		"item":         added,
+	})...)
+	switch kind {
+	case "reasoning":
+		out = append(out, s.emit("response.reasoning_summary_part.added", map[string]interface{}{
+			"item_id":       item.id,
+			"output_index":  outputIndex,
+			"summary_index": 0,
+			"part":          map[string]interface{}{"type": "summary_text", "text": ""},
+		})...)
+	case "message":
+		out = append(out, s.emit("response.content_part.added", map[string]interface{}{
+			"item_id":       item.id,
+			"output_index":  outputIndex,
+			"content_index": 0,
+			"part":          map[string]interface{}{"type": "output_text", "text": "", "annotations": []interface{}{}},
+		})...)
+	}
+	return out
+}
+
+// closeItem 输出当前条目的 done 系列事件，并加入已完成列表
+func (s *chatToResponsesStream) closeItem() []byte {
+	item := s.current
+	if item == nil {
+		return nil
+	}
+	s.current = nil
+	outputIndex := len(s.output)
+	text := item.text.String()
+
+	var out []byte
+	var done map[string]interface{}
+	switch item.kind {
+	case "reasoning":
+		out = append(out, s.emit("response.reasoning_summary_text.done", map[string]interface{}{
+			"item_id":       item.id,
+			"output_index":  outputIndex,
+			"summary_index": 0,
+			"text":          text,
+		})...)
+		out = append(out, s.emit("response.reasoning_summary_part.done", map[string]interface{}{
+			"item_id":       item.id,
+			"output_index":  outputIndex,
+			"summary_index": 0,
+			"part":          map[string]interface{}{"type": "summary_text", "text": text},
+		})...)
+		done = responsesReasoningItem(item.id, text)
+	case "message":
+		part := map[string]interface{}{"type": "output_text", "text": text, "annotations": []interface{}{}}
+		out = append(out, s.emit("response.output_text.done", map[string]interface{}{
+			"item_id":       item.id,
+			"output_index":  outputIndex,
+			"content_index": 0,
+			"text":          text,
+		})...)
+		out = append(out, s.emit("response.content_part.done", map[string]interface{}{
+			"item_id":       item.id,
+			"output_index":  outputIndex,
+			"content_index": 0,
+			"part":          part,
+		})...)
+		done = responsesMessageItem(item.id, text, "completed")
+	case "function_call":
+		out = append(out, s.emit("response.function_call_arguments.done", map[string]interface{}{
+			"item_id":      item.id,
+			"output_index": outputIndex,
+			"arguments":    text,
+		})...)
+		done = responsesFunctionCallItem(item.id, item.callID, item.name, text, "completed")
+	}
+
+	out = append(out, s.emit("response.output_item.done", map[string]interface{}{
+		"output_index": outputIndex,
+		"item":         done,
+	})...)
+	s.output = append(s.output, done)
+	return out
+}
+
+// finishStream 关闭未完成条目并输出 response.completed（含用量）
+func (s *chatToResponsesStream) finishStream() []byte {
+	s.finished = true
+	s.usage.recordResponses(s.requestLog)
+
+	var out []byte
+	if !s.started {
+		out = append(out, s.startResponse("", "", 0)...)
+	}
+	out = append(out, s.closeItem()...)
+
+	status, incomplete := responsesStatus(s.finishReason)
+	resp := newResponsesObject(s.respID, s.model, s.createdAt, status, s.output)
+	resp["usage"] = s.usage.responsesUsagePayload()
+	eventType := "response.completed"
+	if incomplete != nil {
+		resp["incomplete_details"] = incomplete
+		eventType = "response.incomplete"
+	}
+	return append(out, s.emit(eventType, map[string]interface{}{"response": resp})...)
+}
+
+// failStream 上游流内报错时输出 response.failed
+func (s *chatToResponsesStream) failStream(errObj gjson.Result) []byte {
+	s.finished = true
+	msg := errObj.Get("message").String()
+	if msg == "" {
+		msg = errObj.String()
+	}
+
+	var out []byte
+	if !s.started {
+		out = append(out, s.startResponse("", "", 0)...)
+	}
+	resp := newResponsesObject(s.respID, s.model, s.createdAt, "failed", s.output)
+	resp["error"] = map[string]interface{}{"code": "server_error", "message": msg}
+	return append(out, s.emit("response.failed", map[string]interface{}{"response": resp})...)
+}
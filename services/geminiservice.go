@@ -22,20 +22,27 @@ const (
 
 // GeminiProvider Gemini 供应商配置
 type GeminiProvider struct {
-	ID                  string            `json:"id"`
-	Name                string            `json:"name"`
-	WebsiteURL          string            `json:"websiteUrl,omitempty"`
-	APIKeyURL           string            `json:"apiKeyUrl,omitempty"`
-	BaseURL             string            `json:"baseUrl,omitempty"`
-	APIKey              string            `json:"apiKey,omitempty"`
-	Model               string            `json:"model,omitempty"`
-	Description         string            `json:"description,omitempty"`
-	Category            string            `json:"category,omitempty"`            // official, third_party, custom
-	PartnerPromotionKey string            `json:"partnerPromotionKey,omitempty"` // 用于识别供应商类型
-	Enabled             bool              `json:"enabled"`
-	Level               int               `json:"level,omitempty"`               // 优先级分组 (1-10, 默认 1)
-	EnvConfig           map[string]string `json:"envConfig,omitempty"`           // .env 配置
-	SettingsConfig      map[string]any    `json:"settingsConfig,omitempty"`      // settings.json 配置
+	ID                      string            `json:"id"`
+	Name                    string            `json:"name"`
+	WebsiteURL              string            `json:"websiteUrl,omitempty"`
+	APIKeyURL               string            `json:"apiKeyUrl,omitempty"`
+	BaseURL                 string            `json:"baseUrl,omitempty"`
+	APIKey                  string            `json:"apiKey,omitempty"`
+	Model                   string            `json:"model,omitempty"`
+	Description             string            `json:"description,omitempty"`
+	Category                string            `json:"category,omitempty"`            // official, third_party, custom
+	PartnerPromotionKey     string            `json:"partnerPromotionKey,omitempty"` // 用于识别供应商类型
+	Enabled                 bool              `json:"enabled"`
+	Level                   int               `json:"level,omitempty"`                   // 优先级分组 (1-10, 默认 1)
+	Weight                  int               `json:"weight,omitempty"`                  // 负载均衡权重（默认 1）
+	PriceMultiplier         float64           `json:"priceMultiplier,omitempty"`         // 价格倍率（相对官方定价，默认 1）
+	MaxConcurrency          int               `json:"maxConcurrency,omitempty"`          // 最大并发数（0 = 不限制）
+	ConcurrencyQueueSize    int               `json:"concurrencyQueueSize,omitempty"`    // 并发已满时的排队长度（0 = 直接切换）
+	ConcurrencyQueueTimeout int               `json:"concurrencyQueueTimeout,omitempty"` // 排队超时秒数（默认 30）
+	Network                 *NetworkConfig    `json:"network,omitempty"`                 // 出站网络配置（代理 / CA / 客户端证书 / SNI / 固定 IP，可选）
+	Timeouts                *TimeoutConfig    `json:"timeouts,omitempty"`                // 上游超时（连接 / 首字节 / 流式空闲 / 总超时，可选）
+	EnvConfig               map[string]string `json:"envConfig,omitempty"`               // .env 配置
+	SettingsConfig          map[string]any    `json:"settingsConfig,omitempty"`          // settings.json 配置
 }
 
 // GeminiPreset 预设供应商
@@ -156,6 +163,13 @@ func (s *GeminiService) AddProvider(provider GeminiProvider) error {
 		}
 	}
 
+	if errs := provider.Network.Validate(); len(errs) > 0 {
+		return fmt.Errorf("网络配置无效: %s", strings.Join(errs, "; "))
+	}
+	if errs := provider.Timeouts.Validate(); len(errs) > 0 {
+		return fmt.Errorf("超时配置无效: %s", strings.Join(errs, "; "))
+	}
+
 	// 生成 ID（如果没有）
 	if provider.ID == "" {
 		provider.ID = fmt.Sprintf("gemini-%d", len(s.providers)+1)
@@ -170,6 +184,13 @@ func (s *GeminiService) UpdateProvider(provider GeminiProvider) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	if errs := provider.Network.Validate(); len(errs) > 0 {
+		return fmt.Errorf("网络配置无效: %s", strings.Join(errs, "; "))
+	}
+	if errs := provider.Timeouts.Validate(); len(errs) > 0 {
+		return fmt.Errorf("超时配置无效: %s", strings.Join(errs, "; "))
+	}
+
 	for i, p := range s.providers {
 		if p.ID == provider.ID {
 			// 允许更新 API Key；若未提供（空/全空白）则保留旧值，避免误清空
@@ -933,7 +954,17 @@ func (s *GeminiService) DuplicateProvider(sourceID string) (*GeminiProvider, err
 		Enabled:             false, // 默认禁用，避免与源供应商冲突
 	}
 
-	// 4. 深拷贝 map（避免共享引用）
+	// 4. 深拷贝 map / 指针（避免共享引用）
+	if source.Network != nil {
+		network := *source.Network
+		cloned.Network = &network
+	}
+
+	if source.Timeouts != nil {
+		timeouts := *source.Timeouts
+		cloned.Timeouts = &timeouts
+	}
+
 	if source.EnvConfig != nil {
 		cloned.EnvConfig = make(map[string]string, len(source.EnvConfig))
 		for k, v := range source.EnvConfig {
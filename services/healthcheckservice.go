@@ -557,26 +557,26 @@ func (hcs *HealthCheckService) checkProvider(ctx context.Context, provider Provi
 
 	// 设置 Headers
 	req.Header.Set("Content-Type", "application/json")
-	if provider.APIKey != "" {
+	if apiKey := provider.primaryAPIKey(); apiKey != "" {
 		// 根据认证方式设置请求头
 		authType := strings.ToLower(strings.TrimSpace(provider.ConnectivityAuthType))
 		switch authType {
 		case "auto", "":
 			// 自动检测：使用平台默认（claude: x-api-key, codex: bearer）
 			if strings.ToLower(platform) == "claude" {
-				req.Header.Set("x-api-key", provider.APIKey)
+				req.Header.Set("x-api-key", apiKey)
 				req.Header.Set("anthropic-version", GetAnthropicAPIVersion())
 			} else {
-				req.Header.Set("Authorization", "Bearer "+provider.APIKey)
+				req.Header.Set("Authorization", "Bearer "+apiKey)
 			}
 		case "x-api-key":
-			req.Header.Set("x-api-key", provider.APIKey)
+			req.Header.Set("x-api-key", apiKey)
 			req.Header.Set("anthropic-version", GetAnthropicAPIVersion())
 		case "bearer":
-			req.Header.Set("Authorization", "Bearer "+provider.APIKey)
+			req.Header.Set("Authorization", "Bearer "+apiKey)
 		default:
 			// 未知值回退到 Bearer（与 providerrelay.go 保持一致）
-			req.Header.Set("Authorization", "Bearer "+provider.APIKey)
+			req.Header.Set("Authorization", "Bearer "+apiKey)
 		}
 	}
 
@@ -588,7 +588,12 @@ func (hcs *HealthCheckService) checkProvider(ctx context.Context, provider Provi
 	defer cancelReq()
 	req = req.WithContext(reqCtx)
 
-	resp, err := hcs.client.Do(req)
+	client, err := providerHTTPClient(&provider, hcs.client)
+	if err != nil {
+		result.ErrorMessage = err.Error()
+		return result
+	}
+	resp, err := client.Do(req)
 	latencyMs := int(time.Since(start).Milliseconds())
 	result.LatencyMs = latencyMs
 
@@ -0,0 +1,226 @@
+package services
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+)
+
+// LoadBalanceStrategy 同 Level 内 providers 的负载均衡策略
+type LoadBalanceStrategy string
+
+const (
+	LoadBalanceOrder          LoadBalanceStrategy = "order"           // 顺序降级：始终按用户排序从第一个开始
+	LoadBalanceRoundRobin     LoadBalanceStrategy = "round_robin"     // 轮询：每次请求轮换起始 provider
+	LoadBalanceWeightedRandom LoadBalanceStrategy = "weighted_random" // 加权随机：按 weight 比例随机选择起始 provider
+	LoadBalanceLeastInFlight  LoadBalanceStrategy = "least_inflight"  // 最少并发：优先选择进行中请求最少的 provider（按 weight 折算）
+	LoadBalanceAdaptive       LoadBalanceStrategy = "adaptive"        // 自适应：按观测到的首字节耗时与错误率评分排序，并定期探索其他 provider
+	LoadBalanceCost           LoadBalanceStrategy = "cost"            // 成本优先：按映射后模型的官方单价 × 价格倍率估算费用，便宜的优先
+)
+
+// IsValid 检查策略名是否合法
+func (s LoadBalanceStrategy) IsValid() bool {
+	switch s {
+	case LoadBalanceOrder, LoadBalanceRoundRobin, LoadBalanceWeightedRandom, LoadBalanceLeastInFlight, LoadBalanceAdaptive, LoadBalanceCost:
+		return true
+	}
+	return false
+}
+
+// normalizeWeight 未配置或非法的权重视为 1
+func normalizeWeight(weight int) int {
+	if weight <= 0 {
+		return 1
+	}
+	return weight
+}
+
+// levelStrategy 返回指定 Level 使用的负载均衡策略
+// 优先级：拉黑模式（固定顺序，需要同 Provider 重试）> Level 单独配置 > 旧版轮询开关 > 顺序降级
+func (prs *ProviderRelayService) levelStrategy(level int) LoadBalanceStrategy {
+	if prs.appSettings == nil || prs.blacklistService.ShouldUseFixedMode() {
+		return LoadBalanceOrder
+	}
+	settings, err := prs.appSettings.GetAppSettings()
+	if err != nil {
+		return LoadBalanceOrder
+	}
+	if strategy := LoadBalanceStrategy(settings.LevelStrategies[level]); strategy.IsValid() {
+		return strategy
+	}
+	if settings.EnableRoundRobin {
+		return LoadBalanceRoundRobin
+	}
+	return LoadBalanceOrder
+}
+
+// orderLevelProviders 按 Level 策略排列同 Level providers 的尝试顺序（返回新切片）
+// requestedModel 为客户端请求的模型，自适应 / 成本优先策略按各 provider 映射后的实际模型计算；bodyBytes 用于估算请求费用
+func (prs *ProviderRelayService) orderLevelProviders(platform string, level int, strategy LoadBalanceStrategy, requestedModel string, bodyBytes []byte, providers []Provider) []Provider {
+	if len(providers) <= 1 {
+		return providers
+	}
+	if strategy == LoadBalanceRoundRobin {
+		return prs.roundRobinOrder(platform, level, providers)
+	}
+	names := make([]string, len(providers))
+	models := make([]string, len(providers))
+	weights := make([]int, len(providers))
+	multipliers := make([]float64, len(providers))
+	for i, p := range providers {
+		names[i] = p.Name
+		models[i] = p.GetEffectiveModel(requestedModel)
+		weights[i] = p.Weight
+		multipliers[i] = p.PriceMultiplier
+	}
+	if order := prs.strategyIndexes(platform, strategy, names, models, weights, multipliers, bodyBytes); order != nil {
+		return reorderByIndex(providers, order)
+	}
+	return providers
+}
+
+// orderLevelGeminiProviders 按 Level 策略排列 Gemini providers（与 orderLevelProviders 逻辑一致）
+// model 为 endpoint 中的模型名，为空时使用各 provider 配置的默认模型
+func (prs *ProviderRelayService) orderLevelGeminiProviders(level int, strategy LoadBalanceStrategy, model string, bodyBytes []byte, providers []GeminiProvider) []GeminiProvider {
+	if len(providers) <= 1 {
+		return providers
+	}
+	if strategy == LoadBalanceRoundRobin {
+		return prs.roundRobinOrderGemini(level, providers)
+	}
+	names := make([]string, len(providers))
+	models := make([]string, len(providers))
+	weights := make([]int, len(providers))
+	multipliers := make([]float64, len(providers))
+	for i, p := range providers {
+		names[i] = p.Name
+		models[i] = model
+		if models[i] == "" {
+			models[i] = p.Model
+		}
+		weights[i] = p.Weight
+		multipliers[i] = p.PriceMultiplier
+	}
+	if order := prs.strategyIndexes("gemini", strategy, names, models, weights, multipliers, bodyBytes); order != nil {
+		return reorderByIndex(providers, order)
+	}
+	return providers
+}
+
+// strategyIndexes 按策略计算同 Level providers 的尝试顺序（下标序列），顺序降级等无需重排的策略返回 nil
+// names / models / weights / multipliers 按下标对应各 provider 的名称、实际模型、权重与价格倍率
+func (prs *ProviderRelayService) strategyIndexes(platform string, strategy LoadBalanceStrategy, names []string, models []string, weights []int, multipliers []float64, bodyBytes []byte) []int {
+	switch strategy {
+	case LoadBalanceAdaptive:
+		return prs.scores.adaptiveOrder(platform, names, models)
+	case LoadBalanceCost:
+		return costIndexes(models, multipliers, bodyBytes)
+	case LoadBalanceWeightedRandom, LoadBalanceLeastInFlight:
+		normalized := make([]int, len(weights))
+		for i, w := range weights {
+			normalized[i] = normalizeWeight(w)
+		}
+		return prs.balanceIndexes(platform, strategy, names, normalized)
+	}
+	return nil
+}
+
+// balanceIndexes 计算加权随机 / 最少并发策略下的尝试顺序（下标序列）
+func (prs *ProviderRelayService) balanceIndexes(platform string, strategy LoadBalanceStrategy, names []string, weights []int) []int {
+	if strategy == LoadBalanceLeastInFlight {
+		counts := make([]int, len(names))
+		for i, name := range names {
+			counts[i] = prs.inFlight.count(platform, name)
+		}
+		return leastInFlightOrder(counts, weights)
+	}
+	return weightedRandomOrder(weights, rand.Intn)
+}
+
+// weightedRandomOrder 按权重做不放回抽样，得到完整的尝试顺序
+// 第一个 provider 被选中的概率 = weight / 总权重，失败后在剩余 provider 中继续按权重抽取
+func weightedRandomOrder(weights []int, intn func(int) int) []int {
+	remaining := make([]int, len(weights))
+	total := 0
+	for i, w := range weights {
+		remaining[i] = i
+		total += w
+	}
+
+	order := make([]int, 0, len(weights))
+	for len(remaining) > 0 {
+		pick := intn(total)
+		for j, idx := range remaining {
+			if pick < weights[idx] {
+				order = append(order, idx)
+				total -= weights[idx]
+				remaining = append(remaining[:j], remaining[j+1:]...)
+				break
+			}
+			pick -= weights[idx]
+		}
+	}
+	return order
+}
+
+// leastInFlightOrder 按 进行中请求数/权重 升序排列，负载相同时保持用户排序
+func leastInFlightOrder(counts []int, weights []int) []int {
+	order := make([]int, len(counts))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		ia, ib := order[a], order[b]
+		// 交叉相乘比较 counts[ia]/weights[ia] < counts[ib]/weights[ib]，避免浮点误差
+		return counts[ia]*weights[ib] < counts[ib]*weights[ia]
+	})
+	return order
+}
+
+// reorderByIndex 按下标序列返回重新排列后的新切片
+func reorderByIndex[T any](items []T, order []int) []T {
+	result := make([]T, len(order))
+	for i, idx := range order {
+		result[i] = items[idx]
+	}
+	return result
+}
+
+// inFlightTracker 记录每个 provider 进行中的请求数（key="platform:providerName"）
+type inFlightTracker struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+func newInFlightTracker() *inFlightTracker {
+	return &inFlightTracker{counts: make(map[string]int)}
+}
+
+// acquire 进行中请求数 +1，返回的函数用于请求结束时 -1
+func (t *inFlightTracker) acquire(platform string, providerName string) func() {
+	key := fmt.Sprintf("%s:%s", platform, providerName)
+	t.mu.Lock()
+	t.counts[key]++
+	t.mu.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			if t.counts[key] <= 1 {
+				delete(t.counts, key)
+				return
+			}
+			t.counts[key]--
+		})
+	}
+}
+
+// count 返回 provider 当前进行中的请求数
+func (t *inFlightTracker) count(platform string, providerName string) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.counts[fmt.Sprintf("%s:%s", platform, providerName)]
+}
@@ -0,0 +1,128 @@
+package services
+
+import (
+	"math"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+// ==================== 负载均衡策略测试 ====================
+
+func TestWeightedRandomOrder_Permutation(t *testing.T) {
+	tests := []struct {
+		name     string
+		weights  []int
+		picks    []int // 每轮 intn 返回值
+		expected []int
+	}{
+		{"首轮落在第一个区间", []int{3, 2, 1}, []int{0, 0, 0}, []int{0, 1, 2}},
+		{"首轮落在第二个区间", []int{3, 2, 1}, []int{3, 0, 0}, []int{1, 0, 2}},
+		{"首轮落在最后一个区间", []int{3, 2, 1}, []int{5, 4, 0}, []int{2, 1, 0}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			round := 0
+			intn := func(n int) int {
+				v := tt.picks[round]
+				round++
+				if v >= n {
+					t.Fatalf("第 %d 轮抽样值 %d 超出总权重 %d", round, v, n)
+				}
+				return v
+			}
+			if got := weightedRandomOrder(tt.weights, intn); !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("期望顺序 %v，实际 %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestWeightedRandomOrder_Distribution(t *testing.T) {
+	weights := []int{3, 2, 1}
+	rng := rand.New(rand.NewSource(42))
+	firstPicks := make([]int, len(weights))
+	const rounds = 6000
+
+	for i := 0; i < rounds; i++ {
+		order := weightedRandomOrder(weights, rng.Intn)
+		if len(order) != len(weights) {
+			t.Fatalf("尝试顺序应包含全部 provider，实际 %v", order)
+		}
+		firstPicks[order[0]]++
+	}
+
+	for i, w := range weights {
+		expected := float64(w) / 6
+		actual := float64(firstPicks[i]) / rounds
+		if math.Abs(actual-expected) > 0.03 {
+			t.Errorf("provider %d（weight=%d）首选比例期望约 %.2f，实际 %.2f", i, w, expected, actual)
+		}
+	}
+}
+
+func TestLeastInFlightOrder(t *testing.T) {
+	tests := []struct {
+		name     string
+		counts   []int
+		weights  []int
+		expected []int
+	}{
+		{"并发最少的优先", []int{2, 0, 1}, []int{1, 1, 1}, []int{1, 2, 0}},
+		{"负载相同保持原顺序", []int{1, 1, 1}, []int{1, 1, 1}, []int{0, 1, 2}},
+		{"按权重折算负载", []int{2, 1, 0}, []int{4, 1, 1}, []int{2, 0, 1}}, // 2/4 < 1/1
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := leastInFlightOrder(tt.counts, tt.weights); !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("期望顺序 %v，实际 %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestOrderLevelProviders_LeastInFlight(t *testing.T) {
+	relayService := NewProviderRelayService(NewProviderService(), nil, nil, nil, nil, "")
+	providers := []Provider{{Name: "a"}, {Name: "b"}, {Name: "c", Weight: 2}}
+
+	releaseA := relayService.inFlight.acquire("claude", "a")
+	defer releaseA()
+	releaseC1 := relayService.inFlight.acquire("claude", "c")
+	defer releaseC1()
+
+	ordered := relayService.orderLevelProviders("claude", 1, LoadBalanceLeastInFlight, "", nil, providers)
+	names := []string{ordered[0].Name, ordered[1].Name, ordered[2].Name}
+	if !reflect.DeepEqual(names, []string{"b", "c", "a"}) {
+		t.Errorf("期望顺序 [b c a]，实际 %v", names)
+	}
+
+	// 顺序策略不改变用户排序
+	if got := relayService.orderLevelProviders("claude", 1, LoadBalanceOrder, "", nil, providers); got[0].Name != "a" {
+		t.Errorf("顺序策略应保持原顺序，实际首个为 %s", got[0].Name)
+	}
+}
+
+func TestInFlightTracker(t *testing.T) {
+	tracker := newInFlightTracker()
+
+	release1 := tracker.acquire("claude", "p1")
+	release2 := tracker.acquire("claude", "p1")
+	if got := tracker.count("claude", "p1"); got != 2 {
+		t.Fatalf("期望进行中 2 个请求，实际 %d", got)
+	}
+	if got := tracker.count("codex", "p1"); got != 0 {
+		t.Errorf("不同平台应分开计数，实际 %d", got)
+	}
+
+	release1()
+	release1() // 重复释放不应重复扣减
+	if got := tracker.count("claude", "p1"); got != 1 {
+		t.Errorf("释放一次后期望 1，实际 %d", got)
+	}
+	release2()
+	if got := tracker.count("claude", "p1"); got != 0 {
+		t.Errorf("全部释放后期望 0，实际 %d", got)
+	}
+}
@@ -67,6 +67,8 @@ func (ls *LogService) ListRequestLogs(platform string, provider string, limit in
 			ReasoningTokens:   record.GetInt("reasoning_tokens"),
 			CreatedAt:         record.GetString("created_at"),
 			IsStream:          record.GetBool("is_stream"),
+			Hedged:            record.GetBool("hedged"),
+			APIKeyLabel:       record.GetString("api_key_label"),
 			DurationSec:       record.GetFloat64("duration_sec"),
 		}
 		ls.decorateCost(&logEntry)
@@ -480,6 +482,88 @@ func (ls *LogService) ProviderDailyStats(platform string) ([]ProviderDailyStat,
 	return stats, nil
 }
 
+// APIKeyUsage 按 key 汇总的用量（来自 request_log）
+type APIKeyUsage struct {
+	Label        string  `json:"label"` // 为空表示未使用 key 池（单个 APIKey）
+	Requests     int     `json:"requests"`
+	Failures     int     `json:"failures"`
+	InputTokens  int     `json:"input_tokens"`
+	OutputTokens int     `json:"output_tokens"`
+	TotalCost    float64 `json:"total_cost"`
+}
+
+// APIKeyUsageStats 按 key 标签汇总 provider 最近 days 天的请求数、用量与费用
+func (ls *LogService) APIKeyUsageStats(platform string, provider string, days int) ([]APIKeyUsage, error) {
+	if days <= 0 {
+		days = 1
+	}
+	queryStart := startOfDay(time.Now()).AddDate(0, 0, -(days - 1))
+	model := xdb.New("request_log")
+	records, err := model.Selects(
+		xdb.WhereGte("created_at", queryStart.Format(timeLayout)),
+		xdb.WhereEq("platform", platform),
+		xdb.WhereEq("provider", provider),
+		xdb.Field(
+			"model",
+			"api_key_label",
+			"http_code",
+			"input_tokens",
+			"output_tokens",
+			"reasoning_tokens",
+			"cache_create_tokens",
+			"cache_read_tokens",
+		),
+	)
+	if err != nil {
+		if errors.Is(err, xdb.ErrNotFound) || isNoSuchTableErr(err) {
+			return []APIKeyUsage{}, nil
+		}
+		return nil, err
+	}
+	logs := make([]RequestLog, 0, len(records))
+	for _, record := range records {
+		logEntry := RequestLog{
+			Model:             record.GetString("model"),
+			APIKeyLabel:       record.GetString("api_key_label"),
+			HttpCode:          record.GetInt("http_code"),
+			InputTokens:       record.GetInt("input_tokens"),
+			OutputTokens:      record.GetInt("output_tokens"),
+			ReasoningTokens:   record.GetInt("reasoning_tokens"),
+			CacheCreateTokens: record.GetInt("cache_create_tokens"),
+			CacheReadTokens:   record.GetInt("cache_read_tokens"),
+		}
+		ls.decorateCost(&logEntry)
+		logs = append(logs, logEntry)
+	}
+	return summarizeAPIKeyUsage(logs), nil
+}
+
+// summarizeAPIKeyUsage 按 key 标签汇总请求日志
+func summarizeAPIKeyUsage(logs []RequestLog) []APIKeyUsage {
+	byLabel := make(map[string]*APIKeyUsage)
+	for _, logEntry := range logs {
+		usage := byLabel[logEntry.APIKeyLabel]
+		if usage == nil {
+			usage = &APIKeyUsage{Label: logEntry.APIKeyLabel}
+			byLabel[logEntry.APIKeyLabel] = usage
+		}
+		usage.Requests++
+		// 只有 HTTP 200-299 才算成功，其他（包括 0）都算失败
+		if logEntry.HttpCode < 200 || logEntry.HttpCode >= 300 {
+			usage.Failures++
+		}
+		usage.InputTokens += logEntry.InputTokens
+		usage.OutputTokens += logEntry.OutputTokens
+		usage.TotalCost += logEntry.TotalCost
+	}
+	result := make([]APIKeyUsage, 0, len(byLabel))
+	for _, usage := range byLabel {
+		result = append(result, *usage)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Label < result[j].Label })
+	return result
+}
+
 func (ls *LogService) decorateCost(logEntry *RequestLog) {
 	if ls == nil || ls.pricing == nil || logEntry == nil {
 		return
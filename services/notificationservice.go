@@ -197,3 +197,42 @@ func (ns *NotificationService) emitBlacklistEvent(platform, providerName string,
 		"timestamp":       time.Now().UnixMilli(),
 	})
 }
+
+// NotifyBudgetThreshold 预算使用达到通知阈值时发送系统通知
+func (ns *NotificationService) NotifyBudgetThreshold(status BudgetStatus, threshold int) {
+	if !ns.isEnabled() {
+		return
+	}
+
+	go func() {
+		title := "Code Switch"
+		body := fmt.Sprintf("预算 %s 已使用 %d%%（%s）", status.Name, threshold, formatBudgetSpend(status))
+		if status.Exhausted {
+			body = fmt.Sprintf("预算 %s 已用尽（%s）", status.Name, formatBudgetSpend(status))
+		}
+
+		ns.emitBudgetEvent(status, threshold)
+
+		if err := beeep.Notify(title, body, ns.iconPath); err != nil {
+			log.Printf("[Notification] 发送预算通知失败: %v", err)
+		} else {
+			log.Printf("[Notification] 已发送预算通知: %s (%d%%)", status.Name, threshold)
+		}
+	}()
+}
+
+// emitBudgetEvent 发送预算阈值事件到前端
+func (ns *NotificationService) emitBudgetEvent(status BudgetStatus, threshold int) {
+	if ns.app == nil {
+		return
+	}
+	ns.app.Event.Emit("budget:threshold", map[string]interface{}{
+		"name":      status.Name,
+		"threshold": threshold,
+		"spent":     status.Spent,
+		"amount":    status.Amount,
+		"currency":  status.Currency,
+		"exhausted": status.Exhausted,
+		"timestamp": time.Now().UnixMilli(),
+	})
+}
@@ -0,0 +1,231 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// NetworkConfig 供应商出站网络配置（可选）
+// 未配置时使用共享的直连客户端；配置后每种配置组合使用独立的连接池
+type NetworkConfig struct {
+	ProxyURL      string `json:"proxyUrl,omitempty"`      // 代理地址：http:// https:// socks5:// socks5h://（可带 user:pass@）
+	ProxyUsername string `json:"proxyUsername,omitempty"` // 代理用户名（优先于 URL 中的凭据）
+	ProxyPassword string `json:"proxyPassword,omitempty"` // 代理密码
+	CACert        string `json:"caCert,omitempty"`        // 额外信任的 CA 证书（PEM 内容或文件路径），与系统根证书同时生效
+	ClientCert    string `json:"clientCert,omitempty"`    // 客户端证书（PEM 内容或文件路径，mTLS）
+	ClientKey     string `json:"clientKey,omitempty"`     // 客户端私钥（PEM 内容或文件路径）
+	ServerName    string `json:"serverName,omitempty"`    // 覆盖 TLS SNI 与证书校验使用的主机名
+	ResolveIP     string `json:"resolveIp,omitempty"`     // 固定连接的 IP（跳过 DNS 解析，端口沿用 URL 中的端口）
+}
+
+// isZero 是否未配置任何网络选项
+func (n *NetworkConfig) isZero() bool {
+	return n == nil || *n == NetworkConfig{}
+}
+
+// Validate 校验网络配置（只做格式校验，证书内容在创建连接池时加载）
+func (n *NetworkConfig) Validate() []string {
+	if n.isZero() {
+		return nil
+	}
+	errors := make([]string, 0)
+	if n.ProxyURL != "" {
+		if _, err := n.proxy(); err != nil {
+			errors = append(errors, err.Error())
+		}
+	}
+	if (n.ClientCert == "") != (n.ClientKey == "") {
+		errors = append(errors, "客户端证书与私钥必须同时配置")
+	}
+	if n.ResolveIP != "" {
+		if net.ParseIP(n.ResolveIP) == nil {
+			errors = append(errors, fmt.Sprintf("固定 IP 无效：'%s'", n.ResolveIP))
+		}
+		if n.ProxyURL != "" {
+			// 经代理时由代理解析域名，固定 IP 不会生效
+			errors = append(errors, "固定 IP 不能与代理同时配置")
+		}
+	}
+	return errors
+}
+
+// proxy 解析代理地址
+func (n *NetworkConfig) proxy() (*url.URL, error) {
+	proxyURL, err := url.Parse(strings.TrimSpace(n.ProxyURL))
+	if err != nil || proxyURL.Host == "" {
+		return nil, fmt.Errorf("代理地址无效：'%s'", n.ProxyURL)
+	}
+	switch proxyURL.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("代理协议无效：'%s'（支持 http / https / socks5 / socks5h）", proxyURL.Scheme)
+	}
+	if n.ProxyUsername != "" {
+		proxyURL.User = url.UserPassword(n.ProxyUsername, n.ProxyPassword)
+	}
+	return proxyURL, nil
+}
+
+// loadPEM 读取 PEM 内容：以 -----BEGIN 开头时视为内容，否则视为文件路径
+func loadPEM(value string) ([]byte, error) {
+	value = strings.TrimSpace(value)
+	if strings.HasPrefix(value, "-----BEGIN") {
+		return []byte(value), nil
+	}
+	return os.ReadFile(value)
+}
+
+// newTransport 按网络配置创建连接池（参数与共享客户端一致）
+// connectTimeout 为 0 时使用默认连接超时，否则同时限制 TCP 连接与 TLS 握手
+func (n *NetworkConfig) newTransport(connectTimeout time.Duration) (*http.Transport, error) {
+	dialTimeout := defaultConnectTimeout
+	if connectTimeout > 0 {
+		dialTimeout = connectTimeout
+	}
+	dialer := &net.Dialer{Timeout: dialTimeout, KeepAlive: 30 * time.Second}
+	transport := &http.Transport{
+		MaxIdleConns:        100,
+		IdleConnTimeout:     90 * time.Second,
+		MaxIdleConnsPerHost: 10,
+		DialContext:         dialer.DialContext,
+		TLSHandshakeTimeout: dialTimeout,
+	}
+
+	if n.ProxyURL != "" {
+		proxyURL, err := n.proxy()
+		if err != nil {
+			return nil, err
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	if n.ResolveIP != "" {
+		ip := n.ResolveIP
+		transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			_, port, err := net.SplitHostPort(addr)
+			if err != nil {
+				return nil, err
+			}
+			return dialer.DialContext(ctx, network, net.JoinHostPort(ip, port))
+		}
+	}
+
+	if n.CACert != "" || n.ClientCert != "" || n.ServerName != "" {
+		tlsConfig := &tls.Config{ServerName: n.ServerName}
+		if n.CACert != "" {
+			caPEM, err := loadPEM(n.CACert)
+			if err != nil {
+				return nil, fmt.Errorf("读取 CA 证书失败: %w", err)
+			}
+			pool, err := x509.SystemCertPool()
+			if err != nil || pool == nil {
+				pool = x509.NewCertPool()
+			}
+			if !pool.AppendCertsFromPEM(caPEM) {
+				return nil, fmt.Errorf("CA 证书中没有有效的 PEM 证书")
+			}
+			tlsConfig.RootCAs = pool
+		}
+		if n.ClientCert != "" {
+			certPEM, err := loadPEM(n.ClientCert)
+			if err != nil {
+				return nil, fmt.Errorf("读取客户端证书失败: %w", err)
+			}
+			keyPEM, err := loadPEM(n.ClientKey)
+			if err != nil {
+				return nil, fmt.Errorf("读取客户端私钥失败: %w", err)
+			}
+			cert, err := tls.X509KeyPair(certPEM, keyPEM)
+			if err != nil {
+				return nil, fmt.Errorf("客户端证书与私钥不匹配: %w", err)
+			}
+			tlsConfig.Certificates = []tls.Certificate{cert}
+		}
+		transport.TLSClientConfig = tlsConfig
+		// 自定义 TLSClientConfig 后需显式开启 HTTP/2
+		transport.ForceAttemptHTTP2 = true
+	}
+
+	return transport, nil
+}
+
+// transportPool 按网络配置与连接超时组合缓存带网络级重试的客户端（相同配置的 provider 共享连接池）
+type transportPool struct {
+	mu      sync.Mutex
+	clients map[string]*http.Client
+}
+
+var providerTransports = &transportPool{clients: make(map[string]*http.Client)}
+
+// networkConfigKey 网络配置的缓存键（取哈希，避免代理密码等以明文作为 map key 常驻内存）
+func networkConfigKey(n *NetworkConfig, connectTimeout time.Duration) string {
+	data, _ := json.Marshal(n)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]) + "/" + connectTimeout.String()
+}
+
+// client 返回网络配置对应的客户端（首次使用时创建，配置变更后自动使用新的连接池）
+func (p *transportPool) client(n *NetworkConfig, connectTimeout time.Duration) (*http.Client, error) {
+	if n == nil {
+		n = &NetworkConfig{}
+	}
+	key := networkConfigKey(n, connectTimeout)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if client := p.clients[key]; client != nil {
+		return client, nil
+	}
+	transport, err := n.newTransport(connectTimeout)
+	if err != nil {
+		return nil, err
+	}
+	client := &http.Client{
+		Transport: &RetryTransport{
+			Base:       transport,
+			MaxRetries: 1,
+			RetryDelay: 500 * time.Millisecond,
+		},
+	}
+	p.clients[key] = client
+	return client, nil
+}
+
+// providerHTTPClient 返回 provider 的出站客户端：未配置网络设置与连接超时时返回 fallback
+// 否则返回对应连接池的客户端，并沿用 fallback 的 Timeout
+func providerHTTPClient(provider *Provider, fallback *http.Client) (*http.Client, error) {
+	return networkHTTPClient(provider.Name, provider.Network, provider.Timeouts.connect(), fallback)
+}
+
+// geminiHTTPClient 返回 Gemini provider 的出站客户端，规则同 providerHTTPClient
+func geminiHTTPClient(provider *GeminiProvider, fallback *http.Client) (*http.Client, error) {
+	return networkHTTPClient(provider.Name, provider.Network, provider.Timeouts.connect(), fallback)
+}
+
+func networkHTTPClient(name string, network *NetworkConfig, connectTimeout time.Duration, fallback *http.Client) (*http.Client, error) {
+	if network.isZero() && connectTimeout == 0 {
+		return fallback, nil
+	}
+	client, err := providerTransports.client(network, connectTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("provider %s 网络配置无效: %w", name, err)
+	}
+	if fallback == nil || fallback.Timeout == 0 {
+		return client, nil
+	}
+	withTimeout := *client
+	withTimeout.Timeout = fallback.Timeout
+	return &withTimeout, nil
+}
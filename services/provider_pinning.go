@@ -0,0 +1,144 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// providerPinHeader 指定本次请求使用的 provider（可通过 ANTHROPIC_CUSTOM_HEADERS 设置），转发前会被移除
+const providerPinHeader = "X-CodeSwitch-Provider"
+
+// resolveProviderPin 解析请求固定的 provider：优先 X-CodeSwitch-Provider 请求头，其次 model@provider 后缀
+// 只有 @ 后的部分是已配置的 provider 名时才视为后缀（避免误伤 claude-3-5-sonnet@20240620 这类模型名）
+// 返回 provider 名（为空表示未固定）、去掉后缀后的模型名和请求体
+func resolveProviderPin(header http.Header, requestedModel string, bodyBytes []byte, providers []Provider) (string, string, []byte) {
+	pinned := strings.TrimSpace(header.Get(providerPinHeader))
+
+	if idx := strings.LastIndex(requestedModel, "@"); idx > 0 {
+		suffix := requestedModel[idx+1:]
+		for _, provider := range providers {
+			if provider.Name != suffix {
+				continue
+			}
+			baseModel := requestedModel[:idx]
+			if modifiedBody, err := ReplaceModelInRequestBody(bodyBytes, baseModel); err == nil {
+				bodyBytes = modifiedBody
+				requestedModel = baseModel
+				if pinned == "" {
+					pinned = suffix
+				}
+			} else {
+				fmt.Printf("[WARN] 去除模型后缀失败: %v\n", err)
+			}
+			break
+		}
+	}
+
+	return pinned, requestedModel, bodyBytes
+}
+
+// pinnedProviderUnavailable 检查固定的 provider 当前能否接收请求，返回面向客户端的错误信息（空表示可用）
+func (prs *ProviderRelayService) pinnedProviderUnavailable(kind string, provider *Provider, requestedModel string, estimatedTokens int) string {
+	if !provider.Enabled || provider.APIURL == "" || !provider.hasAPIKey() {
+		return fmt.Sprintf("pinned provider '%s' is disabled or missing credentials", provider.Name)
+	}
+	if errs := provider.ValidateConfiguration(); len(errs) > 0 {
+		return fmt.Sprintf("pinned provider '%s' has invalid config: %v", provider.Name, errs)
+	}
+	if requestedModel != "" && !provider.IsModelSupported(requestedModel) {
+		return fmt.Sprintf("pinned provider '%s' does not support model '%s'", provider.Name, requestedModel)
+	}
+	effectiveModel := provider.GetEffectiveModel(requestedModel)
+	if window, exceeded := exceedsContextWindow(provider, effectiveModel, estimatedTokens); exceeded {
+		return fmt.Sprintf("pinned provider '%s' context window (%d tokens) is too small for this request (~%d tokens)", provider.Name, window, estimatedTokens)
+	}
+	if blacklisted, until := prs.blacklistService.IsBlacklisted(kind, provider.Name); blacklisted {
+		return fmt.Sprintf("pinned provider '%s' is blacklisted until %s", provider.Name, until.Format("15:04:05"))
+	}
+	if budget := prs.exhaustedBudget(kind, provider.Name, effectiveModel); budget != nil {
+		return fmt.Sprintf("pinned provider '%s' is unavailable: %s", provider.Name, budgetExhaustedMessage(budget))
+	}
+	if balance := prs.lowBalanceProvider(kind, provider); balance != nil {
+		return fmt.Sprintf("pinned provider '%s' balance %.2f is below the configured floor %.2f", provider.Name, balance.Balance, provider.BalanceProbe.MinBalance)
+	}
+	if coolingDown, until := prs.blacklistService.IsCoolingDown(kind, provider.Name, effectiveModel); coolingDown {
+		return fmt.Sprintf("pinned provider '%s' is cooling down until %s (upstream rate limited or overloaded)", provider.Name, until.Format("15:04:05"))
+	}
+	return ""
+}
+
+// forwardPinned 将请求转发到固定的 provider：跳过 Level 排序、负载均衡和同源缓存，只尝试一次
+// 模型映射、端点覆盖与 Header 规则照常生效；失败时直接把错误返回给客户端
+func (prs *ProviderRelayService) forwardPinned(
+	c *gin.Context,
+	kind string,
+	pinned string,
+	providers []Provider,
+	endpoint string,
+	bodyBytes []byte,
+	isStream bool,
+	requestedModel string,
+) {
+	var provider *Provider
+	for i := range providers {
+		if providers[i].Name == pinned {
+			provider = &providers[i]
+			break
+		}
+	}
+	if provider == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("pinned provider '%s' not found for %s", pinned, formatKind(kind))})
+		return
+	}
+	if errMsg := prs.pinnedProviderUnavailable(kind, provider, requestedModel, estimateRequestTokens(bodyBytes)); errMsg != "" {
+		fmt.Printf("[WARN] 📌 %s\n", errMsg)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": errMsg, "pinnedProvider": pinned})
+		return
+	}
+
+	c.Set(requestedModelKey, requestedModel)
+	target, err := prepareForwardTarget(c, *provider, endpoint, requestedModel, bodyBytes)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("failed to map model for pinned provider '%s': %v", pinned, err)})
+		return
+	}
+
+	fmt.Printf("[INFO] 📌 请求固定到 Provider: %s | Model: %s（跳过 Level 与同源缓存）\n", pinned, target.model)
+
+	query := flattenQuery(c.Request.URL.Query())
+	clientHeaders := cloneHeaders(c.Request.Header)
+	startTime := time.Now()
+	ok, err := prs.forwardRequest(c, kind, target.provider, target.endpoint, query, clientHeaders, target.body, isStream, target.model, target.authMethod)
+	duration := time.Since(startTime)
+
+	if ok {
+		fmt.Printf("[INFO] ✓ 固定 Provider 成功: %s | 耗时: %.2fs\n", pinned, duration.Seconds())
+		prs.recordDelivered(kind, pinned, target.model, err)
+		prs.setLastUsedProvider(kind, pinned)
+		return
+	}
+
+	errorMsg := "未知错误"
+	if err != nil {
+		errorMsg = err.Error()
+	}
+	fmt.Printf("[WARN] ✗ 固定 Provider 失败: %s | 错误: %s | 耗时: %.2fs\n", pinned, errorMsg, duration.Seconds())
+
+	switch {
+	case errors.Is(err, errClientAbort):
+		return
+	case errors.Is(err, errProviderSkipped):
+		c.JSON(http.StatusTooManyRequests, gin.H{
+			"error":          fmt.Sprintf("pinned provider '%s' is at its concurrency or rate limit, retry later", pinned),
+			"pinnedProvider": pinned,
+		})
+	default:
+		failure := prs.recordProviderFailure(kind, pinned, target.model, err)
+		writeUpstreamError(c, failure.class, err)
+	}
+}
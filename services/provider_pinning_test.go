@@ -0,0 +1,132 @@
+package services
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+// ==================== 固定 Provider 测试 ====================
+
+func TestResolveProviderPin(t *testing.T) {
+	providers := []Provider{{Name: "relay-a"}, {Name: "relay-b"}}
+
+	tests := []struct {
+		name          string
+		header        string
+		model         string
+		expectedPin   string
+		expectedModel string
+		bodyHasSuffix bool
+	}{
+		{"未固定", "", "claude-sonnet-4", "", "claude-sonnet-4", false},
+		{"请求头固定", "relay-a", "claude-sonnet-4", "relay-a", "claude-sonnet-4", false},
+		{"模型后缀固定", "", "claude-sonnet-4@relay-b", "relay-b", "claude-sonnet-4", false},
+		{"请求头优先于后缀", "relay-a", "claude-sonnet-4@relay-b", "relay-a", "claude-sonnet-4", false},
+		{"后缀不是 provider 名时保留模型名", "", "claude-3-5-sonnet@20240620", "", "claude-3-5-sonnet@20240620", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			if tt.header != "" {
+				header.Set("x-codeswitch-provider", tt.header)
+			}
+			body := []byte(`{"model":"` + tt.model + `"}`)
+			pinned, model, newBody := resolveProviderPin(header, tt.model, body, providers)
+			if pinned != tt.expectedPin || model != tt.expectedModel {
+				t.Errorf("期望 pin=%q model=%q，实际 pin=%q model=%q", tt.expectedPin, tt.expectedModel, pinned, model)
+			}
+			if got := gjson.GetBytes(newBody, "model").String(); got != tt.expectedModel {
+				t.Errorf("请求体中的模型名应为 %q，实际 %q", tt.expectedModel, got)
+			}
+			if strings.Contains(string(newBody), "@") != tt.bodyHasSuffix {
+				t.Errorf("请求体后缀处理不符合预期: %s", newBody)
+			}
+		})
+	}
+}
+
+func TestCloneHeaders_StripsProviderPin(t *testing.T) {
+	original := http.Header{}
+	original.Set("X-CodeSwitch-Provider", "relay-a")
+	original.Set("Anthropic-Beta", "tools-2024")
+	cloned := cloneHeaders(original)
+	if cloned.Get("X-CodeSwitch-Provider") != "" {
+		t.Errorf("控制头不应转发给上游")
+	}
+	if cloned.Get("Anthropic-Beta") != "tools-2024" {
+		t.Errorf("其他请求头应保留")
+	}
+}
+
+// TestForwardPinned 固定 provider 时只请求该 provider，模型映射生效，控制头不转发
+func TestForwardPinned(t *testing.T) {
+	var gotModel, gotPinHeader string
+	pinnedServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		gotModel = gjson.GetBytes(data, "model").String()
+		gotPinHeader = r.Header.Get("X-CodeSwitch-Provider")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id":"msg_1","type":"message"}`))
+	}))
+	defer pinnedServer.Close()
+
+	blacklistService := NewBlacklistService(NewSettingsService(), NewNotificationService(nil))
+	relayService := NewProviderRelayService(NewProviderService(), nil, blacklistService, nil, nil, "")
+	providers := []Provider{
+		{Name: "primary", APIURL: "http://127.0.0.1:1", APIKey: "k", Enabled: true, Level: 1},
+		{Name: "pinned", APIURL: pinnedServer.URL, APIKey: "k", Enabled: true, Level: 3,
+			ModelMapping: map[string]string{"claude-sonnet-4": "vendor-sonnet"}},
+	}
+
+	c, w := newHedgeTestContext()
+	c.Request.Header.Set("X-CodeSwitch-Provider", "pinned")
+	relayService.forwardPinned(c, "claude", "pinned", providers, "/v1/messages",
+		[]byte(`{"model":"claude-sonnet-4"}`), false, "claude-sonnet-4")
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("期望 200，实际 %d: %s", w.Code, w.Body.String())
+	}
+	if gotModel != "vendor-sonnet" {
+		t.Errorf("模型映射应生效，实际 %q", gotModel)
+	}
+	if gotPinHeader != "" {
+		t.Errorf("控制头不应转发给上游")
+	}
+}
+
+func TestForwardPinned_Unavailable(t *testing.T) {
+	blacklistService := NewBlacklistService(NewSettingsService(), NewNotificationService(nil))
+	relayService := NewProviderRelayService(NewProviderService(), nil, blacklistService, nil, nil, "")
+	providers := []Provider{
+		{Name: "disabled", APIURL: "http://127.0.0.1:1", APIKey: "k", Enabled: false},
+		{Name: "cooling", APIURL: "http://127.0.0.1:1", APIKey: "k", Enabled: true},
+	}
+	blacklistService.RecordCooldown("claude", "cooling", "", time.Minute, statusOverloaded)
+
+	tests := []struct {
+		name     string
+		pinned   string
+		status   int
+		contains string
+	}{
+		{"不存在", "missing", http.StatusNotFound, "not found"},
+		{"已禁用", "disabled", http.StatusServiceUnavailable, "disabled"},
+		{"冷却中", "cooling", http.StatusServiceUnavailable, "cooling down"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, w := newHedgeTestContext()
+			relayService.forwardPinned(c, "claude", tt.pinned, providers, "/v1/messages",
+				[]byte(`{"model":"claude-sonnet-4"}`), false, "claude-sonnet-4")
+			if w.Code != tt.status || !strings.Contains(w.Body.String(), tt.contains) {
+				t.Errorf("期望 %d 且包含 %q，实际 %d %s", tt.status, tt.contains, w.Code, w.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,213 @@
+package services
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	scoreEWMAAlpha       = 0.3             // EWMA 平滑系数：越大越看重最近的请求
+	scoreErrorPenaltyMs  = 10000.0         // 错误率惩罚：错误率 100% 相当于首字节多等 10 秒
+	scoreMinSamples      = 3               // 样本数不足时视为冷启动，不参与评分排序
+	scoreExploreInterval = 1 * time.Minute // 超过该时间未被尝试的 provider 会被优先探索一次
+)
+
+// ProviderScore 自适应路由评分（前端展示用）
+type ProviderScore struct {
+	Platform    string  `json:"platform"`
+	Provider    string  `json:"provider"`
+	Model       string  `json:"model"`
+	TTFBMs      float64 `json:"ttfb_ms"`      // 首字节耗时 EWMA（毫秒）
+	ErrorRate   float64 `json:"error_rate"`   // 错误率 EWMA（0-1）
+	Score       float64 `json:"score"`        // 综合评分（越小越优先）
+	Samples     int     `json:"samples"`      // 已观测的请求数
+	Cold        bool    `json:"cold"`         // 样本不足，按用户排序并定期探索
+	LastAttempt int64   `json:"last_attempt"` // 最近一次尝试时间（毫秒）
+}
+
+// providerScoreStats 单个 provider+model 的观测数据
+type providerScoreStats struct {
+	platform    string
+	provider    string
+	model       string
+	ttfbMs      float64
+	errorRate   float64
+	samples     int
+	lastAttempt time.Time
+}
+
+func (s *providerScoreStats) score() float64 {
+	return s.ttfbMs + s.errorRate*scoreErrorPenaltyMs
+}
+
+func (s *providerScoreStats) cold() bool {
+	return s.samples < scoreMinSamples
+}
+
+// providerScoreBoard 按 provider+model 维护首字节耗时与错误率的 EWMA（仅内存，重启后重新学习）
+type providerScoreBoard struct {
+	mu    sync.Mutex
+	stats map[string]*providerScoreStats
+	now   func() time.Time
+}
+
+func newProviderScoreBoard() *providerScoreBoard {
+	return &providerScoreBoard{stats: make(map[string]*providerScoreStats), now: time.Now}
+}
+
+func providerScoreKey(platform, provider, model string) string {
+	return platform + "|" + provider + "|" + model
+}
+
+// record 记录一次请求结果：成功时更新首字节耗时，成功/失败都会更新错误率
+func (b *providerScoreBoard) record(platform, provider, model string, ttfb time.Duration, success bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	stats := b.entryLocked(platform, provider, model)
+
+	errorSample := 1.0
+	if success {
+		errorSample = 0
+	}
+	if stats.samples == 0 {
+		stats.errorRate = errorSample
+		if success {
+			stats.ttfbMs = float64(ttfb.Milliseconds())
+		}
+	} else {
+		stats.errorRate = ewma(stats.errorRate, errorSample)
+		if success {
+			if stats.ttfbMs == 0 {
+				stats.ttfbMs = float64(ttfb.Milliseconds())
+			} else {
+				stats.ttfbMs = ewma(stats.ttfbMs, float64(ttfb.Milliseconds()))
+			}
+		}
+	}
+	stats.samples++
+	stats.lastAttempt = b.now()
+}
+
+// entryLocked 获取（不存在时创建）provider+model 的观测数据，调用方需持有锁
+func (b *providerScoreBoard) entryLocked(platform, provider, model string) *providerScoreStats {
+	key := providerScoreKey(platform, provider, model)
+	stats := b.stats[key]
+	if stats == nil {
+		stats = &providerScoreStats{platform: platform, provider: provider, model: model}
+		b.stats[key] = stats
+	}
+	return stats
+}
+
+func ewma(current, sample float64) float64 {
+	return scoreEWMAAlpha*sample + (1-scoreEWMAAlpha)*current
+}
+
+// adaptiveOrder 按评分计算尝试顺序（下标序列）
+//  1. 有足够样本的 provider 按评分升序，冷启动的 provider 排在其后并保持用户排序
+//  2. 每次最多把一个"待探索"的 provider（冷启动或超过 scoreExploreInterval 未被尝试）提到最前，
+//     并立即刷新其尝试时间，避免并发请求同时涌向同一个探索对象
+func (b *providerScoreBoard) adaptiveOrder(platform string, names []string, models []string) []int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	order := make([]int, len(names))
+	entries := make([]*providerScoreStats, len(names))
+	for i := range names {
+		order[i] = i
+		entries[i] = b.stats[providerScoreKey(platform, names[i], models[i])]
+	}
+
+	sort.SliceStable(order, func(a, c int) bool {
+		ea, ec := entries[order[a]], entries[order[c]]
+		aWarm := ea != nil && !ea.cold()
+		cWarm := ec != nil && !ec.cold()
+		if aWarm != cWarm {
+			return aWarm
+		}
+		if !aWarm {
+			return false
+		}
+		return ea.score() < ec.score()
+	})
+
+	// 首选已经是最优 provider，探索排在其后的一个待探索 provider
+	explore := -1
+	var oldest time.Time
+	for pos, idx := range order {
+		if pos == 0 {
+			continue
+		}
+		entry := entries[idx]
+		var last time.Time
+		if entry != nil {
+			last = entry.lastAttempt
+		}
+		if now.Sub(last) < scoreExploreInterval {
+			continue
+		}
+		if explore == -1 || last.Before(oldest) {
+			explore = pos
+			oldest = last
+		}
+	}
+	if explore > 0 {
+		idx := order[explore]
+		copy(order[1:explore+1], order[:explore])
+		order[0] = idx
+		b.entryLocked(platform, names[idx], models[idx]).lastAttempt = now
+	}
+	return order
+}
+
+// snapshot 返回平台下所有 provider+model 的评分（platform 为空时返回全部）
+func (b *providerScoreBoard) snapshot(platform string) []ProviderScore {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	result := make([]ProviderScore, 0, len(b.stats))
+	for _, stats := range b.stats {
+		if stats.samples == 0 || (platform != "" && stats.platform != platform) {
+			continue
+		}
+		result = append(result, ProviderScore{
+			Platform:    stats.platform,
+			Provider:    stats.provider,
+			Model:       stats.model,
+			TTFBMs:      stats.ttfbMs,
+			ErrorRate:   stats.errorRate,
+			Score:       stats.score(),
+			Samples:     stats.samples,
+			Cold:        stats.cold(),
+			LastAttempt: stats.lastAttempt.UnixMilli(),
+		})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Provider != result[j].Provider {
+			return result[i].Provider < result[j].Provider
+		}
+		return result[i].Model < result[j].Model
+	})
+	return result
+}
+
+// recordProviderScore 记录一次转发结果到自适应评分
+// 对冲落败、客户端中断、并发已满/限流用尽、客户端请求错误（4xx）不是 provider 的问题，不计入评分
+func (prs *ProviderRelayService) recordProviderScore(platform, provider, model string, ttfb time.Duration, ok bool, err error) {
+	if errors.Is(err, errHedgeCancelled) || errors.Is(err, errClientAbort) || errors.Is(err, errProviderSkipped) {
+		return
+	}
+	if !ok && err != nil && classifyUpstreamError(err) == ErrorClassClient {
+		return
+	}
+	prs.scores.record(platform, provider, model, ttfb, ok)
+}
+
+// GetProviderScores 返回自适应路由评分（platform 为空时返回全部平台）
+func (prs *ProviderRelayService) GetProviderScores(platform string) []ProviderScore {
+	return prs.scores.snapshot(platform)
+}
@@ -0,0 +1,153 @@
+package services
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// ==================== 自适应路由评分测试 ====================
+
+// newTestScoreBoard 创建使用可控时钟的评分表
+func newTestScoreBoard(now *time.Time) *providerScoreBoard {
+	board := newProviderScoreBoard()
+	board.now = func() time.Time { return *now }
+	return board
+}
+
+func TestProviderScoreBoard_Record(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	board := newTestScoreBoard(&now)
+
+	board.record("claude", "p1", "m", 1000*time.Millisecond, true)
+	board.record("claude", "p1", "m", 2000*time.Millisecond, true)
+	board.record("claude", "p1", "m", 0, false)
+
+	scores := board.snapshot("claude")
+	if len(scores) != 1 {
+		t.Fatalf("期望 1 条评分，实际 %d", len(scores))
+	}
+	got := scores[0]
+	// TTFB: 1000 → 0.3*2000+0.7*1000 = 1300，失败不更新 TTFB
+	if math.Abs(got.TTFBMs-1300) > 0.001 {
+		t.Errorf("TTFB 期望 1300ms，实际 %.3f", got.TTFBMs)
+	}
+	// 错误率: 0 → 0 → 0.3
+	if math.Abs(got.ErrorRate-0.3) > 0.001 {
+		t.Errorf("错误率期望 0.3，实际 %.3f", got.ErrorRate)
+	}
+	if math.Abs(got.Score-(1300+0.3*scoreErrorPenaltyMs)) > 0.001 {
+		t.Errorf("综合评分计算错误，实际 %.3f", got.Score)
+	}
+	if got.Samples != 3 || got.Cold {
+		t.Errorf("期望 3 个样本且非冷启动，实际 samples=%d cold=%v", got.Samples, got.Cold)
+	}
+	if got.Provider != "p1" || got.Model != "m" || got.Platform != "claude" {
+		t.Errorf("评分归属错误: %+v", got)
+	}
+
+	if other := board.snapshot("codex"); len(other) != 0 {
+		t.Errorf("不同平台的评分应分开，实际 %v", other)
+	}
+}
+
+func TestProviderScoreBoard_AdaptiveOrder(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	board := newTestScoreBoard(&now)
+	names := []string{"slow", "fast", "flaky", "new"}
+	models := []string{"m", "m", "m", "m"}
+
+	warm := func(name string, ttfb time.Duration, success bool) {
+		for i := 0; i < scoreMinSamples; i++ {
+			board.record("claude", name, "m", ttfb, success)
+		}
+	}
+	warm("slow", 3*time.Second, true)
+	warm("fast", 500*time.Millisecond, true)
+	warm("flaky", 200*time.Millisecond, false)
+
+	// 刚观测过的 provider 不会被探索，冷启动的 "new" 从未尝试过，被提到最前探索一次
+	order := board.adaptiveOrder("claude", names, models)
+	if !reflect.DeepEqual(order, []int{3, 1, 0, 2}) {
+		t.Fatalf("首次排序期望 [3 1 0 2]（探索 new），实际 %v", order)
+	}
+
+	// 探索后立即刷新尝试时间，下一次按评分排序，冷启动排在最后
+	order = board.adaptiveOrder("claude", names, models)
+	if !reflect.DeepEqual(order, []int{1, 0, 2, 3}) {
+		t.Fatalf("期望按评分排序 [1 0 2 3]，实际 %v", order)
+	}
+
+	// 超过探索间隔后，最久未尝试的 provider 被提到最前（每次只探索一个）
+	now = now.Add(scoreExploreInterval + time.Second)
+	board.record("claude", "fast", "m", 500*time.Millisecond, true)
+	board.record("claude", "slow", "m", 3*time.Second, true)
+	order = board.adaptiveOrder("claude", names, models)
+	if order[0] != 2 {
+		t.Errorf("期望探索最久未尝试的 flaky，实际顺序 %v", order)
+	}
+	order = board.adaptiveOrder("claude", names, models)
+	if order[0] != 3 {
+		t.Errorf("期望接着探索 new，实际顺序 %v", order)
+	}
+	order = board.adaptiveOrder("claude", names, models)
+	if order[0] != 1 {
+		t.Errorf("探索完毕后应回到评分最优的 fast，实际顺序 %v", order)
+	}
+}
+
+func TestOrderLevelProviders_Adaptive(t *testing.T) {
+	relayService := NewProviderRelayService(NewProviderService(), nil, nil, nil, nil, "")
+	providers := []Provider{
+		{Name: "a"},
+		{Name: "b", ModelMapping: map[string]string{"claude-sonnet-4": "mapped-sonnet"}},
+	}
+
+	// b 的评分按映射后的实际模型记录
+	for i := 0; i < scoreMinSamples; i++ {
+		relayService.scores.record("claude", "a", "claude-sonnet-4", 2*time.Second, true)
+		relayService.scores.record("claude", "b", "mapped-sonnet", 100*time.Millisecond, true)
+	}
+
+	ordered := relayService.orderLevelProviders("claude", 1, LoadBalanceAdaptive, "claude-sonnet-4", nil, providers)
+	if ordered[0].Name != "b" || ordered[1].Name != "a" {
+		t.Errorf("期望首字节更快的 b 优先，实际 [%s %s]", ordered[0].Name, ordered[1].Name)
+	}
+}
+
+// TestRecordProviderScore_SkipsNonProviderErrors 客户端请求错误、对冲落败等不计入评分
+func TestRecordProviderScore_SkipsNonProviderErrors(t *testing.T) {
+	relayService := NewProviderRelayService(NewProviderService(), nil, nil, nil, nil, "")
+	relayService.recordProviderScore("claude", "p", "m", 0, false, &upstreamStatusError{status: http.StatusBadRequest})
+	relayService.recordProviderScore("claude", "p", "m", 0, false, errHedgeCancelled)
+	if scores := relayService.GetProviderScores("claude"); len(scores) != 0 {
+		t.Errorf("客户端错误不应计入评分，实际 %+v", scores)
+	}
+
+	relayService.recordProviderScore("claude", "p", "m", 0, false, &upstreamStatusError{status: http.StatusInternalServerError})
+	if scores := relayService.GetProviderScores("claude"); len(scores) != 1 || scores[0].ErrorRate == 0 {
+		t.Errorf("上游 5xx 应计入错误率，实际 %+v", scores)
+	}
+}
+
+// TestForwardGeminiRequest_ClientErrorNotScored Gemini 上游返回 4xx 客户端错误时不计入评分
+func TestForwardGeminiRequest_ClientErrorNotScored(t *testing.T) {
+	upstreamServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":{"code":400,"message":"invalid argument"}}`))
+	}))
+	defer upstreamServer.Close()
+
+	blacklistService := NewBlacklistService(NewSettingsService(), NewNotificationService(nil))
+	relayService := NewProviderRelayService(NewProviderService(), nil, blacklistService, nil, nil, "")
+	c, _ := newHedgeTestContext()
+	provider := &GeminiProvider{Name: "g", BaseURL: upstreamServer.URL, APIKey: "k", Enabled: true}
+	relayService.forwardGeminiRequest(c, provider, "/v1beta/models/gemini-2.5-pro:generateContent", []byte(`{}`), false, &RequestLog{})
+
+	if scores := relayService.GetProviderScores("gemini"); len(scores) != 0 {
+		t.Errorf("客户端错误不应计入评分，实际 %+v", scores)
+	}
+}
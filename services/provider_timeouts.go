@@ -0,0 +1,199 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 默认超时：总超时给模型足够思考时间，连接超时与共享客户端一致；首字节与空闲超时默认不限制
+const (
+	defaultTotalTimeout   = 32 * time.Hour
+	defaultConnectTimeout = 30 * time.Second
+)
+
+// errUpstreamStalled 上游在首字节超时或流式空闲超时内没有发送任何数据（分类为 stall，参与故障转移与拉黑）
+var errUpstreamStalled = errors.New("upstream stalled")
+
+// TimeoutConfig 上游请求超时（秒，0 = 使用默认值）
+// 可在 provider 上配置，路由规则按字段覆盖
+type TimeoutConfig struct {
+	ConnectSeconds   int `json:"connectSeconds,omitempty"`   // 建立连接（TCP + TLS 握手）超时，默认 30 秒
+	FirstByteSeconds int `json:"firstByteSeconds,omitempty"` // 发出请求到收到响应头（流式为首个内容事件）的超时，默认不限制
+	IdleSeconds      int `json:"idleSeconds,omitempty"`      // 流式响应两次数据之间的最大间隔，超过视为上游停滞并中断，默认不限制
+	TotalSeconds     int `json:"totalSeconds,omitempty"`     // 单次请求总超时，默认 32 小时
+}
+
+// isZero 是否未配置任何超时
+func (t *TimeoutConfig) isZero() bool {
+	return t == nil || *t == TimeoutConfig{}
+}
+
+// Validate 校验超时配置
+func (t *TimeoutConfig) Validate() []string {
+	if t.isZero() {
+		return nil
+	}
+	errors := make([]string, 0)
+	if t.ConnectSeconds < 0 || t.FirstByteSeconds < 0 || t.IdleSeconds < 0 || t.TotalSeconds < 0 {
+		errors = append(errors, "超时不能为负数")
+	}
+	if t.TotalSeconds > 0 && t.FirstByteSeconds > t.TotalSeconds {
+		errors = append(errors, fmt.Sprintf("首字节超时（%d 秒）不能大于总超时（%d 秒）", t.FirstByteSeconds, t.TotalSeconds))
+	}
+	return errors
+}
+
+// overlay 用 override 中已配置的字段覆盖当前配置
+func (t TimeoutConfig) overlay(override *TimeoutConfig) TimeoutConfig {
+	if override == nil {
+		return t
+	}
+	if override.ConnectSeconds > 0 {
+		t.ConnectSeconds = override.ConnectSeconds
+	}
+	if override.FirstByteSeconds > 0 {
+		t.FirstByteSeconds = override.FirstByteSeconds
+	}
+	if override.IdleSeconds > 0 {
+		t.IdleSeconds = override.IdleSeconds
+	}
+	if override.TotalSeconds > 0 {
+		t.TotalSeconds = override.TotalSeconds
+	}
+	return t
+}
+
+// connect 连接超时（0 = 使用默认值）
+func (t *TimeoutConfig) connect() time.Duration {
+	if t == nil {
+		return 0
+	}
+	return time.Duration(t.ConnectSeconds) * time.Second
+}
+
+// firstByte 首字节超时（0 = 不限制）
+func (t *TimeoutConfig) firstByte() time.Duration {
+	return time.Duration(t.FirstByteSeconds) * time.Second
+}
+
+// idle 流式空闲超时（0 = 不限制）
+func (t *TimeoutConfig) idle() time.Duration {
+	return time.Duration(t.IdleSeconds) * time.Second
+}
+
+// total 总超时
+func (t *TimeoutConfig) total() time.Duration {
+	if t.TotalSeconds > 0 {
+		return time.Duration(t.TotalSeconds) * time.Second
+	}
+	return defaultTotalTimeout
+}
+
+// requestTimeouts 本次请求生效的超时：provider 配置，再由命中的路由规则按字段覆盖
+func requestTimeouts(c *gin.Context, provider *Provider) TimeoutConfig {
+	var timeouts TimeoutConfig
+	if provider.Timeouts != nil {
+		timeouts = *provider.Timeouts
+	}
+	if v, ok := c.Get(routeTimeoutKey); ok {
+		if override, ok := v.(*TimeoutConfig); ok {
+			timeouts = timeouts.overlay(override)
+		}
+	}
+	return timeouts
+}
+
+// stallWatchdog 首字节与流式空闲超时的看门狗：到期时以 errUpstreamStalled 为原因取消请求 context
+// 取消后底层连接被关闭，阻塞中的读取随即返回，不会无限挂起客户端
+type stallWatchdog struct {
+	cancel context.CancelCauseFunc
+
+	mu        sync.Mutex
+	firstByte *time.Timer
+	idle      *time.Timer
+}
+
+// startFirstByte 开始等待首字节（timeout 为 0 时不启动）
+func (w *stallWatchdog) startFirstByte(timeout time.Duration) {
+	if timeout <= 0 {
+		return
+	}
+	cause := fmt.Errorf("%w: %s 内未收到响应", errUpstreamStalled, timeout)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.firstByte = time.AfterFunc(timeout, func() { w.cancel(cause) })
+}
+
+// firstByteReceived 首字节已到达，停止首字节计时
+func (w *stallWatchdog) firstByteReceived() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.firstByte != nil {
+		w.firstByte.Stop()
+	}
+}
+
+// watchIdle 为流式响应体加上空闲超时：每次读到数据后重新计时（idle 为 0 时原样返回）
+func (w *stallWatchdog) watchIdle(body io.ReadCloser, idle time.Duration) io.ReadCloser {
+	if body == nil || idle <= 0 {
+		return body
+	}
+	cause := fmt.Errorf("%w: 流式响应超过 %s 未收到数据", errUpstreamStalled, idle)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.idle = time.AfterFunc(idle, func() { w.cancel(cause) })
+	return &idleTimeoutBody{ReadCloser: body, timer: w.idle, idle: idle}
+}
+
+// stop 请求结束时停止全部计时
+func (w *stallWatchdog) stop() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, timer := range []*time.Timer{w.firstByte, w.idle} {
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+// idleTimeoutBody 带空闲超时的流式响应体
+type idleTimeoutBody struct {
+	io.ReadCloser
+	timer *time.Timer
+	idle  time.Duration
+}
+
+func (b *idleTimeoutBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		b.timer.Reset(b.idle)
+	}
+	return n, err
+}
+
+// stallCause 请求是否因上游停滞被中断，返回带超时说明的错误
+func stallCause(ctx context.Context) error {
+	if cause := context.Cause(ctx); errors.Is(cause, errUpstreamStalled) {
+		return cause
+	}
+	return nil
+}
+
+// recordDelivered 响应已写给客户端后记录 provider 结果：
+// 流中途停滞时已无法故障转移，按 stall 分类计入失败（可能触发拉黑），否则清零连续失败计数
+func (prs *ProviderRelayService) recordDelivered(kind string, providerName string, model string, err error) {
+	if errors.Is(err, errUpstreamStalled) {
+		fmt.Printf("[WARN] Provider %s 流式响应中途停滞，已中断: %v\n", providerName, err)
+		prs.recordProviderFailure(kind, providerName, model, err)
+		return
+	}
+	if recErr := prs.blacklistService.RecordSuccess(kind, providerName); recErr != nil {
+		fmt.Printf("[WARN] 清零失败计数失败: %v\n", recErr)
+	}
+}
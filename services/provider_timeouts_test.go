@@ -0,0 +1,200 @@
+package services
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// ==================== 上游超时测试 ====================
+
+func TestTimeoutConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name     string
+		timeouts *TimeoutConfig
+		wantErr  string
+	}{
+		{"未配置", nil, ""},
+		{"完整配置", &TimeoutConfig{ConnectSeconds: 10, FirstByteSeconds: 120, IdleSeconds: 60, TotalSeconds: 3600}, ""},
+		{"只配置空闲超时", &TimeoutConfig{IdleSeconds: 90}, ""},
+		{"负数", &TimeoutConfig{IdleSeconds: -1}, "超时不能为负数"},
+		{"首字节超时大于总超时", &TimeoutConfig{FirstByteSeconds: 600, TotalSeconds: 300}, "不能大于总超时"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := Provider{Name: "p", Timeouts: tt.timeouts}
+			errs := strings.Join(provider.ValidateConfiguration(), "; ")
+			if tt.wantErr == "" && errs != "" {
+				t.Errorf("期望验证通过，实际 %s", errs)
+			}
+			if tt.wantErr != "" && !strings.Contains(errs, tt.wantErr) {
+				t.Errorf("期望错误包含 %q，实际 %q", tt.wantErr, errs)
+			}
+		})
+	}
+}
+
+// TestRequestTimeouts 路由规则按字段覆盖 provider 超时
+func TestRequestTimeouts(t *testing.T) {
+	provider := &Provider{Name: "relay", Timeouts: &TimeoutConfig{ConnectSeconds: 5, IdleSeconds: 60}}
+
+	c, _ := newHedgeTestContext()
+	if got := requestTimeouts(c, provider); got != *provider.Timeouts || got.total() != defaultTotalTimeout {
+		t.Errorf("未命中规则时应使用 provider 配置与默认总超时，实际 %+v", got)
+	}
+
+	rule := &RoutingRule{Name: "background", Providers: []string{"relay"}, Timeouts: &TimeoutConfig{IdleSeconds: 30, TotalSeconds: 120}}
+	applyRoutingRule(c, rule, []Provider{*provider}, "claude-sonnet-4", []byte(`{"model":"claude-sonnet-4"}`))
+	want := TimeoutConfig{ConnectSeconds: 5, IdleSeconds: 30, TotalSeconds: 120}
+	if got := requestTimeouts(c, provider); got != want {
+		t.Errorf("期望 %+v，实际 %+v", want, got)
+	}
+
+	if (&RoutingRule{Name: "plain"}).timeouts() != nil {
+		t.Errorf("未配置超时的规则不应覆盖")
+	}
+}
+
+// TestForwardRequest_FirstByteTimeout 上游迟迟不返回响应头时以 stall 分类失败，可故障转移
+func TestForwardRequest_FirstByteTimeout(t *testing.T) {
+	release := make(chan struct{})
+	upstreamServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer upstreamServer.Close()
+	defer close(release)
+
+	relayService := NewProviderRelayService(NewProviderService(), nil, nil, nil, nil, "")
+	c, w := newHedgeTestContext()
+	provider := Provider{Name: "slow", APIURL: upstreamServer.URL, APIKey: "k", Enabled: true, Timeouts: &TimeoutConfig{FirstByteSeconds: 1}}
+
+	start := time.Now()
+	ok, err := relayService.forwardRequest(c, "claude", provider, "/v1/messages", nil, http.Header{},
+		[]byte(`{"model":"claude-sonnet-4"}`), false, "claude-sonnet-4", AuthMethodXAPIKey)
+
+	if ok || !errors.Is(err, errUpstreamStalled) {
+		t.Fatalf("期望以 errUpstreamStalled 失败，实际 ok=%v err=%v", ok, err)
+	}
+	if classifyUpstreamError(err) != ErrorClassStall {
+		t.Errorf("首字节超时应分类为 stall，实际 %s", classifyUpstreamError(err))
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("应在首字节超时后立即中断，实际耗时 %s", elapsed)
+	}
+	if c.Writer.Written() || w.Body.Len() > 0 {
+		t.Errorf("失败前不应向客户端写出数据，实际: %q", w.Body.String())
+	}
+}
+
+// TestForwardRequest_StreamIdleTimeout 流式响应中途停滞时中断连接，已写出的内容保留并返回停滞错误
+func TestForwardRequest_StreamIdleTimeout(t *testing.T) {
+	release := make(chan struct{})
+	upstreamServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"delta\":{\"type\":\"text_delta\",\"text\":\"hi\"}}\n\n"))
+		w.(http.Flusher).Flush()
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer upstreamServer.Close()
+	defer close(release)
+
+	relayService := NewProviderRelayService(NewProviderService(), nil, nil, nil, nil, "")
+	c, w := newHedgeTestContext()
+	provider := Provider{Name: "stalled", APIURL: upstreamServer.URL, APIKey: "k", Enabled: true, Timeouts: &TimeoutConfig{IdleSeconds: 1}}
+
+	start := time.Now()
+	ok, err := relayService.forwardRequest(c, "claude", provider, "/v1/messages", nil, http.Header{},
+		[]byte(`{"model":"claude-sonnet-4","stream":true}`), true, "claude-sonnet-4", AuthMethodXAPIKey)
+
+	if !ok || !errors.Is(err, errUpstreamStalled) {
+		t.Fatalf("期望响应已写出并返回 errUpstreamStalled，实际 ok=%v err=%v", ok, err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("应在空闲超时后立即中断，实际耗时 %s", elapsed)
+	}
+	if !strings.Contains(w.Body.String(), `"text":"hi"`) {
+		t.Errorf("停滞前的内容应已转发给客户端，实际: %q", w.Body.String())
+	}
+}
+
+// TestForwardGeminiRequest_FirstByteTimeout Gemini 上游迟迟不返回响应头时按 stall 中断，可故障转移
+func TestForwardGeminiRequest_FirstByteTimeout(t *testing.T) {
+	release := make(chan struct{})
+	upstreamServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer upstreamServer.Close()
+	defer close(release)
+
+	blacklistService := NewBlacklistService(NewSettingsService(), NewNotificationService(nil))
+	relayService := NewProviderRelayService(NewProviderService(), nil, blacklistService, nil, nil, "")
+	c, w := newHedgeTestContext()
+	provider := &GeminiProvider{Name: "slow", BaseURL: upstreamServer.URL, APIKey: "k", Enabled: true, Timeouts: &TimeoutConfig{FirstByteSeconds: 1}}
+	requestLog := &RequestLog{}
+
+	start := time.Now()
+	success, errMsg, responseWritten := relayService.forwardGeminiRequest(c, provider, "/v1beta/models/gemini-2.5-pro:generateContent", []byte(`{}`), false, requestLog)
+
+	if success || responseWritten || !strings.Contains(errMsg, errUpstreamStalled.Error()) {
+		t.Fatalf("期望以停滞失败且可故障转移，实际 success=%v responseWritten=%v err=%s", success, responseWritten, errMsg)
+	}
+	if requestLog.HttpCode != http.StatusGatewayTimeout {
+		t.Errorf("停滞应记为 504，实际 %d", requestLog.HttpCode)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("应在首字节超时后立即中断，实际耗时 %s", elapsed)
+	}
+	if c.Writer.Written() || w.Body.Len() > 0 {
+		t.Errorf("失败前不应向客户端写出数据，实际: %q", w.Body.String())
+	}
+}
+
+// TestForwardGeminiRequest_StreamIdleTimeout Gemini 流式响应中途停滞时中断连接，已写出的内容保留
+func TestForwardGeminiRequest_StreamIdleTimeout(t *testing.T) {
+	release := make(chan struct{})
+	upstreamServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("data: {\"candidates\":[{\"content\":{\"parts\":[{\"text\":\"hi\"}]}}]}\n\n"))
+		w.(http.Flusher).Flush()
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer upstreamServer.Close()
+	defer close(release)
+
+	blacklistService := NewBlacklistService(NewSettingsService(), NewNotificationService(nil))
+	relayService := NewProviderRelayService(NewProviderService(), nil, blacklistService, nil, nil, "")
+	c, w := newHedgeTestContext()
+	provider := &GeminiProvider{Name: "stalled", BaseURL: upstreamServer.URL, APIKey: "k", Enabled: true, Timeouts: &TimeoutConfig{IdleSeconds: 1}}
+	requestLog := &RequestLog{}
+
+	start := time.Now()
+	success, errMsg, responseWritten := relayService.forwardGeminiRequest(c, provider, "/v1beta/models/gemini-2.5-pro:streamGenerateContent", []byte(`{}`), true, requestLog)
+
+	if success || !responseWritten || !strings.Contains(errMsg, errUpstreamStalled.Error()) {
+		t.Fatalf("期望响应已写出并以停滞失败，实际 success=%v responseWritten=%v err=%s", success, responseWritten, errMsg)
+	}
+	if requestLog.HttpCode != http.StatusGatewayTimeout {
+		t.Errorf("停滞应记为 504，实际 %d", requestLog.HttpCode)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("应在空闲超时后立即中断，实际耗时 %s", elapsed)
+	}
+	if !strings.Contains(w.Body.String(), `"text":"hi"`) {
+		t.Errorf("停滞前的内容应已转发给客户端，实际: %q", w.Body.String())
+	}
+}
@@ -48,22 +48,202 @@ type ProviderRelayService struct {
 	lastUsedMu          sync.RWMutex                 // 保护 lastUsed 的锁
 	rrMu                sync.Mutex                   // 轮询状态锁
 	rrLastStart         map[string]string            // 轮询状态：key="platform:level" → value=上次起始 Provider Name
+	inFlight            *inFlightTracker             // 各 provider 进行中的请求数（最少并发策略）
+	scores              *providerScoreBoard          // 各 provider+model 的首字节耗时与错误率（自适应策略）
+	rateLimits          *rateLimiter                 // 各 provider+model 的 RPM / 输入 TPM 令牌桶
+	concurrency         *concurrencyLimiter          // 各 provider 的并发限制与排队
+	apiKeys             *apiKeyPool                  // 各 provider key 池的轮换与暂停状态
+	budgets             *BudgetService               // 花费预算（为 nil 时不检查）
+	balances            *BalanceService              // 余额查询结果（为 nil 时不检查）
 }
 
 // errClientAbort 表示客户端中断连接，不应计入 provider 失败次数
 var errClientAbort = errors.New("client aborted, skip failure count")
 
+// errProviderSkipped 表示 provider 暂时无法接收请求（并发已满、本地限流用尽），切换到下一个 provider，不计入失败次数
+var errProviderSkipped = errors.New("provider temporarily skipped")
+
 // skipReasons tracks the count of providers skipped during filtering
 type skipReasons struct {
 	disabled         int // disabled or missing URL/APIKey
 	configInvalid    int // configuration validation failed
 	modelUnsupported int // does not support requested model
 	blacklisted      int // temporarily unavailable (blacklisted)
+	rateLimited      int // local RPM/TPM bucket exhausted
+	coolingDown      int // upstream 429/529 cooldown (Retry-After)
+	contextTooSmall  int // context window smaller than the estimated prompt
+	overBudget       int // spend budget exhausted for this period
+	lowBalance       int // last probed balance below the configured floor
 }
 
 // total returns the total count of all skip reasons
 func (s *skipReasons) total() int {
-	return s.disabled + s.configInvalid + s.modelUnsupported + s.blacklisted
+	return s.disabled + s.configInvalid + s.modelUnsupported + s.blacklisted + s.rateLimited + s.coolingDown + s.contextTooSmall + s.overBudget + s.lowBalance
+}
+
+// skipReason identifies why a candidate provider was filtered out
+type skipReason int
+
+const (
+	skipNone skipReason = iota
+	skipDisabled
+	skipConfigInvalid
+	skipModelUnsupported
+	skipContextTooSmall
+	skipBlacklisted
+	skipOverBudget
+	skipLowBalance
+	skipRateLimited
+	skipCoolingDown
+)
+
+// add counts one skipped provider under the given reason
+func (s *skipReasons) add(reason skipReason) {
+	switch reason {
+	case skipDisabled:
+		s.disabled++
+	case skipConfigInvalid:
+		s.configInvalid++
+	case skipModelUnsupported:
+		s.modelUnsupported++
+	case skipContextTooSmall:
+		s.contextTooSmall++
+	case skipBlacklisted:
+		s.blacklisted++
+	case skipOverBudget:
+		s.overBudget++
+	case skipLowBalance:
+		s.lowBalance++
+	case skipRateLimited:
+		s.rateLimited++
+	case skipCoolingDown:
+		s.coolingDown++
+	}
+}
+
+// candidateSkipReason 检查 provider 能否接收本次请求，返回跳过原因（skipNone 表示可用）
+// 预算用尽且动作为 reject 时返回该预算，调用方应直接以 402 拒绝整个请求
+func (prs *ProviderRelayService) candidateSkipReason(kind string, logPrefix string, provider *Provider, requestedModel string, estimatedTokens int) (skipReason, *BudgetStatus) {
+	// Basic filter: enabled, URL, APIKey
+	if !provider.Enabled || provider.APIURL == "" || !provider.hasAPIKey() {
+		return skipDisabled, nil
+	}
+
+	// Config validation: auto-skip on failure
+	if errs := provider.ValidateConfiguration(); len(errs) > 0 {
+		fmt.Printf("%s[WARN] Provider %s config validation failed, skipped: %v\n", logPrefix, provider.Name, errs)
+		return skipConfigInvalid, nil
+	}
+
+	// Model filter: only keep providers supporting the requested model
+	if requestedModel != "" && !provider.IsModelSupported(requestedModel) {
+		fmt.Printf("%s[INFO] Provider %s does not support model %s, skipped\n", logPrefix, provider.Name, requestedModel)
+		return skipModelUnsupported, nil
+	}
+
+	// Context window check: skip providers that cannot fit the estimated prompt
+	effectiveModel := provider.GetEffectiveModel(requestedModel)
+	if window, exceeded := exceedsContextWindow(provider, effectiveModel, estimatedTokens); exceeded {
+		fmt.Printf("%s[INFO] Provider %s context window %d < estimated %d tokens, skipped\n", logPrefix, provider.Name, window, estimatedTokens)
+		return skipContextTooSmall, nil
+	}
+
+	// Blacklist check: skip blacklisted providers
+	if isBlacklisted, until := prs.blacklistService.IsBlacklisted(kind, provider.Name); isBlacklisted {
+		fmt.Printf("%s[INFO] ⛔ Provider %s blacklisted until %v\n", logPrefix, provider.Name, until.Format("15:04:05"))
+		return skipBlacklisted, nil
+	}
+
+	// Budget check: skip providers over spend budget, or reject the request outright
+	if budget := prs.exhaustedBudget(kind, provider.Name, effectiveModel); budget != nil {
+		if budget.Action == BudgetActionReject {
+			fmt.Printf("%s[WARN] 💰 Request rejected, budget %s exhausted (%s)\n", logPrefix, budget.Name, formatBudgetSpend(*budget))
+			return skipOverBudget, budget
+		}
+		fmt.Printf("%s[INFO] Provider %s over budget %s (%s), skipped\n", logPrefix, provider.Name, budget.Name, formatBudgetSpend(*budget))
+		return skipOverBudget, nil
+	}
+
+	// Balance check: skip providers whose last probed balance is below the floor
+	if balance := prs.lowBalanceProvider(kind, provider); balance != nil {
+		fmt.Printf("%s[INFO] Provider %s balance %.2f below floor %.2f, skipped\n", logPrefix, provider.Name, balance.Balance, provider.BalanceProbe.MinBalance)
+		return skipLowBalance, nil
+	}
+
+	// Rate limit check: skip providers whose local RPM/TPM bucket is exhausted
+	if err := prs.rateLimits.check(kind, provider.Name, effectiveModel, provider.rateLimitFor(effectiveModel), estimatedTokens); err != nil {
+		fmt.Printf("%s[INFO] Provider %s rate limited, skipped: %v\n", logPrefix, provider.Name, err)
+		return skipRateLimited, nil
+	}
+
+	// Cooldown check: skip providers (or models) cooling down after upstream 429/529
+	if coolingDown, until := prs.blacklistService.IsCoolingDown(kind, provider.Name, effectiveModel); coolingDown {
+		fmt.Printf("%s[INFO] Provider %s cooling down until %v, skipped\n", logPrefix, provider.Name, until.Format("15:04:05"))
+		return skipCoolingDown, nil
+	}
+
+	return skipNone, nil
+}
+
+// attemptFailureAction 一次转发失败后的处理方式
+type attemptFailureAction int
+
+const (
+	failureRetry        attemptFailureAction = iota // 重试同一 provider
+	failureNextProvider                             // 切换到下一个 provider
+	failureReturn                                   // 请求已结束（客户端中断或错误已直接返回给客户端）
+)
+
+// handleAttemptFailure 按错误分类处理一次转发失败，决定重试、切换 provider 还是结束请求
+// willRetry 为 true 时先等待 retryWait 再重试
+func (prs *ProviderRelayService) handleAttemptFailure(
+	c *gin.Context,
+	kind string,
+	logPrefix string,
+	provider *Provider,
+	effectiveModel string,
+	err error,
+	willRetry bool,
+	retryWait time.Duration,
+) attemptFailureAction {
+	// 客户端中断不计入失败次数，直接返回
+	if errors.Is(err, errClientAbort) {
+		fmt.Printf("%s[INFO] 客户端中断，停止重试\n", logPrefix)
+		return failureReturn
+	}
+
+	// 并发已满或限流用尽不是故障，不计入失败次数，直接切换到下一个
+	if errors.Is(err, errProviderSkipped) {
+		return failureNextProvider
+	}
+
+	// 按错误分类处理：客户端错误等直接返回，冷却时切换到下一个，其余计入失败次数（可能触发拉黑）
+	failure := prs.recordProviderFailure(kind, provider.Name, effectiveModel, err)
+	if !failure.policy.Failover {
+		respondWithoutFailover(c, failure.class, err)
+		return failureReturn
+	}
+	if failure.coolingDown {
+		fmt.Printf("%s[INFO] 🧊 Provider %s 进入冷却（%s），切换到下一个\n", logPrefix, provider.Name, failure.class)
+		return failureNextProvider
+	}
+
+	// 检查是否刚被拉黑
+	if blacklisted, _ := prs.blacklistService.IsBlacklisted(kind, provider.Name); blacklisted {
+		fmt.Printf("%s[INFO] 🚫 Provider %s 达到失败阈值，已被拉黑，切换到下一个\n", logPrefix, provider.Name)
+		return failureNextProvider
+	}
+
+	// 等待后重试（除非是最后一次）
+	if willRetry {
+		fmt.Printf("%s[INFO] ⏳ 等待 %d 秒后重试...\n", logPrefix, int(retryWait/time.Second))
+		// 等待期间保活帧照常发送；客户端已断开则不再重试
+		if !waitBeforeRetry(c, retryWait) {
+			fmt.Printf("%s[INFO] 客户端中断，停止重试\n", logPrefix)
+			return failureReturn
+		}
+	}
+	return failureRetry
 }
 
 // formatKind formats the kind parameter for user-friendly display
@@ -89,9 +269,24 @@ func buildNoProviderError(requestedModel string, kind string, reasons skipReason
 	if reasons.modelUnsupported > 0 {
 		details = append(details, fmt.Sprintf("%d not supporting this model", reasons.modelUnsupported))
 	}
+	if reasons.contextTooSmall > 0 {
+		details = append(details, fmt.Sprintf("%d with context window too small for this request", reasons.contextTooSmall))
+	}
 	if reasons.blacklisted > 0 {
 		details = append(details, fmt.Sprintf("%d temporarily unavailable (blacklisted, retry later or check quota)", reasons.blacklisted))
 	}
+	if reasons.overBudget > 0 {
+		details = append(details, fmt.Sprintf("%d over spend budget (daily/monthly budget exhausted)", reasons.overBudget))
+	}
+	if reasons.lowBalance > 0 {
+		details = append(details, fmt.Sprintf("%d with balance below the configured floor (top up or lower the floor)", reasons.lowBalance))
+	}
+	if reasons.rateLimited > 0 {
+		details = append(details, fmt.Sprintf("%d rate limited (local RPM/TPM limit reached, retry later)", reasons.rateLimited))
+	}
+	if reasons.coolingDown > 0 {
+		details = append(details, fmt.Sprintf("%d cooling down (upstream rate limited or overloaded, retry later)", reasons.coolingDown))
+	}
 	if reasons.configInvalid > 0 {
 		details = append(details, fmt.Sprintf("%d with invalid config", reasons.configInvalid))
 	}
@@ -112,6 +307,7 @@ type AuthMethod int
 const (
 	AuthMethodBearer  AuthMethod = iota // Authorization: Bearer xxx
 	AuthMethodXAPIKey                   // x-api-key: xxx
+	AuthMethodGoogAPIKey                // x-goog-api-key: xxx（Gemini 原生上游）
 )
 
 // detectAuthMethod 检测原始请求使用的认证方式
@@ -386,6 +582,11 @@ func NewProviderRelayService(providerService *ProviderService, geminiService *Ge
 			"gemini": nil,
 		},
 		rrLastStart: make(map[string]string),
+		inFlight:    newInFlightTracker(),
+		scores:      newProviderScoreBoard(),
+		rateLimits:  newRateLimiter(),
+		concurrency: newConcurrencyLimiter(),
+		apiKeys:     newAPIKeyPool(),
 	}
 }
 
@@ -421,25 +622,6 @@ func (prs *ProviderRelayService) GetAllLastUsedProviders() map[string]*LastUsedP
 	return result
 }
 
-// isRoundRobinEnabled 检查轮询功能是否启用
-// 条件：1. 应用设置开关启用 2. 拉黑模式关闭（Fixed Mode 跳过轮询）
-func (prs *ProviderRelayService) isRoundRobinEnabled() bool {
-	// 检查拉黑模式是否启用（Fixed Mode 优先级高于轮询）
-	if prs.blacklistService.ShouldUseFixedMode() {
-		return false
-	}
-
-	// 检查应用设置开关
-	if prs.appSettings == nil {
-		return false
-	}
-	settings, err := prs.appSettings.GetAppSettings()
-	if err != nil {
-		return false
-	}
-	return settings.EnableRoundRobin
-}
-
 // roundRobinOrder 对同 Level 的 providers 进行轮询排序
 // 算法：基于 name 追踪，将上次起始 provider 移到末尾，实现轮询效果
 // 参数：
@@ -647,8 +829,15 @@ func (prs *ProviderRelayService) Addr() string {
 
 func (prs *ProviderRelayService) registerRoutes(router gin.IRouter) {
 	router.POST("/v1/messages", prs.proxyHandler("claude", "/v1/messages"))
+	router.POST("/v1/messages/count_tokens", prs.countTokensHandler("claude"))
 	router.POST("/responses", prs.proxyHandler("codex", "/responses"))
 
+	// OpenAI 兼容的 Chat Completions 入口（转换为各 provider 的原生协议）
+	// /v1/chat/completions 按模型名自动选择平台，也可通过 /claude、/codex 前缀指定
+	router.POST("/v1/chat/completions", prs.chatCompletionsHandler(""))
+	router.POST("/claude/v1/chat/completions", prs.chatCompletionsHandler("claude"))
+	router.POST("/codex/v1/chat/completions", prs.chatCompletionsHandler("codex"))
+
 	// /v1/models 端点（OpenAI-compatible API）
 	// 支持 Claude 和 Codex 平台
 	router.GET("/v1/models", prs.modelsHandler("claude"))
@@ -660,6 +849,8 @@ func (prs *ProviderRelayService) registerRoutes(router gin.IRouter) {
 	// 自定义 CLI 工具端点（路由格式: /custom/:toolId/v1/messages）
 	// toolId 用于区分不同的 CLI 工具，对应 provider kind 为 "custom:{toolId}"
 	router.POST("/custom/:toolId/v1/messages", prs.customCliProxyHandler())
+	router.POST("/custom/:toolId/v1/messages/count_tokens", prs.customCountTokensHandler())
+	router.POST("/custom/:toolId/v1/chat/completions", prs.customChatCompletionsHandler())
 
 	// 自定义 CLI 工具的 /v1/models 端点
 	router.GET("/custom/:toolId/v1/models", prs.customModelsHandler())
@@ -681,6 +872,12 @@ func (prs *ProviderRelayService) proxyHandler(kind string, endpoint string) gin.
 		isStream := gjson.GetBytes(bodyBytes, "stream").Bool()
 		requestedModel := gjson.GetBytes(bodyBytes, "model").String()
 
+		// 【流式保活】等待上游首包、重试或切换 provider 期间定期向客户端发送保活帧
+		if isStream {
+			keepAlive := prs.startStreamKeepAlive(c, clientWireFormat(c, kind))
+			defer keepAlive.stop()
+		}
+
 		// 如果未指定模型，记录警告但不拦截
 		if requestedModel == "" {
 			fmt.Printf("[WARN] 请求未指定模型名，无法执行模型智能降级\n")
@@ -696,41 +893,43 @@ func (prs *ProviderRelayService) proxyHandler(kind string, endpoint string) gin.
 			return
 		}
 
+		// 【固定 Provider】X-CodeSwitch-Provider 请求头或 model@provider 后缀：跳过 Level 与同源缓存
+		if pinned, model, body := resolveProviderPin(c.Request.Header, requestedModel, bodyBytes, providers); pinned != "" {
+			prs.forwardPinned(c, kind, pinned, providers, endpoint, body, isStream, model)
+			return
+		}
+
+		// 记录客户端请求的模型名，供 provider 开启响应模型名还原时使用（路由规则改写模型前）
+		c.Set(requestedModelKey, requestedModel)
+
+		// 【路由规则】按顺序匹配，命中的规则限定候选 provider，并可改写模型名和超时
+		routingRule := prs.matchRoutingRule(kind, c, requestedModel, bodyBytes)
+		if routingRule != nil {
+			providers, requestedModel, bodyBytes = applyRoutingRule(c, routingRule, providers, requestedModel, bodyBytes)
+			affinityKey = GenerateAffinityKey(userID, kind, requestedModel)
+		}
+
 		active := make([]Provider, 0, len(providers))
 		reasons := skipReasons{} // track skip reasons
+		estimatedTokens := estimateRequestTokens(bodyBytes)
 		for _, provider := range providers {
-			// Basic filter: enabled, URL, APIKey
-			if !provider.Enabled || provider.APIURL == "" || provider.APIKey == "" {
-				reasons.disabled++
-				continue
-			}
-
-			// Config validation: auto-skip on failure
-			if errs := provider.ValidateConfiguration(); len(errs) > 0 {
-				fmt.Printf("[WARN] Provider %s config validation failed, skipped: %v\n", provider.Name, errs)
-				reasons.configInvalid++
-				continue
-			}
-
-			// Model filter: only keep providers supporting the requested model
-			if requestedModel != "" && !provider.IsModelSupported(requestedModel) {
-				fmt.Printf("[INFO] Provider %s does not support model %s, skipped\n", provider.Name, requestedModel)
-				reasons.modelUnsupported++
-				continue
+			reason, rejected := prs.candidateSkipReason(kind, "", &provider, requestedModel, estimatedTokens)
+			if rejected != nil {
+				c.JSON(http.StatusPaymentRequired, gin.H{"error": budgetExhaustedMessage(rejected)})
+				return
 			}
-
-			// Blacklist check: skip blacklisted providers
-			if isBlacklisted, until := prs.blacklistService.IsBlacklisted(kind, provider.Name); isBlacklisted {
-				fmt.Printf("⛔ Provider %s blacklisted until %v\n", provider.Name, until.Format("15:04:05"))
-				reasons.blacklisted++
+			if reason != skipNone {
+				reasons.add(reason)
 				continue
 			}
-
 			active = append(active, provider)
 		}
 
 		if len(active) == 0 {
 			errMsg := buildNoProviderError(requestedModel, kind, reasons)
+			if routingRule != nil {
+				errMsg += fmt.Sprintf(" [routing rule: %s]", routingRule.Name)
+			}
 			c.JSON(http.StatusNotFound, gin.H{"error": errMsg})
 			return
 		}
@@ -740,9 +939,9 @@ func (prs *ProviderRelayService) proxyHandler(kind string, endpoint string) gin.
 		for i, p := range active {
 			providerNames[i] = p.Name
 		}
-		fmt.Printf("[INFO] Providers: total=%d, active=%d, skipped=%d (disabled=%d, config=%d, model=%d, blacklist=%d): %s\n",
+		fmt.Printf("[INFO] Providers: total=%d, active=%d, skipped=%d (disabled=%d, config=%d, model=%d, context=%d, blacklist=%d, ratelimit=%d, cooldown=%d, budget=%d, balance=%d): %s\n",
 			len(providers), len(active), reasons.total(),
-			reasons.disabled, reasons.configInvalid, reasons.modelUnsupported, reasons.blacklisted,
+			reasons.disabled, reasons.configInvalid, reasons.modelUnsupported, reasons.contextTooSmall, reasons.blacklisted, reasons.rateLimited, reasons.coolingDown, reasons.overBudget, reasons.lowBalance,
 			strings.Join(providerNames, ", "))
 		fmt.Println()
 
@@ -809,6 +1008,10 @@ func (prs *ProviderRelayService) proxyHandler(kind string, endpoint string) gin.
 
 					// 获取实际模型名
 					effectiveModel := provider.GetEffectiveModel(requestedModel)
+					if coolingDown, until := prs.blacklistService.IsCoolingDown(kind, provider.Name, effectiveModel); coolingDown {
+						fmt.Printf("[INFO] ⏭️ 跳过冷却中的 Provider: %s (冷却结束: %v)\n", provider.Name, until)
+						continue
+					}
 					currentBodyBytes := bodyBytes
 					if effectiveModel != requestedModel && requestedModel != "" {
 						fmt.Printf("[INFO] Provider %s 映射模型: %s -> %s\n", provider.Name, requestedModel, effectiveModel)
@@ -845,9 +1048,7 @@ func (prs *ProviderRelayService) proxyHandler(kind string, endpoint string) gin.
 						if ok {
 							fmt.Printf("[INFO] ✓ 成功: %s | 尝试 %d 次 | 耗时: %.2fs\n",
 								provider.Name, retryCount+1, duration.Seconds())
-							if err := prs.blacklistService.RecordSuccess(kind, provider.Name); err != nil {
-								fmt.Printf("[WARN] 清零失败计数失败: %v\n", err)
-							}
+							prs.recordDelivered(kind, provider.Name, effectiveModel, err)
 							prs.setLastUsedProvider(kind, provider.Name)
 							return
 						}
@@ -863,28 +1064,14 @@ func (prs *ProviderRelayService) proxyHandler(kind string, endpoint string) gin.
 						fmt.Printf("[WARN] ✗ 失败: %s | 尝试 %d/%d | 错误: %s | 耗时: %.2fs\n",
 							provider.Name, retryCount+1, maxRetryPerProvider, errorMsg, duration.Seconds())
 
-						// 客户端中断不计入失败次数，直接返回
-						if errors.Is(err, errClientAbort) {
-							fmt.Printf("[INFO] 客户端中断，停止重试\n")
+						action := prs.handleAttemptFailure(c, kind, "", &provider, effectiveModel, err,
+							retryCount < maxRetryPerProvider-1, time.Duration(retryWaitSeconds)*time.Second)
+						if action == failureReturn {
 							return
 						}
-
-						// 记录失败次数（可能触发拉黑）
-						if err := prs.blacklistService.RecordFailure(kind, provider.Name); err != nil {
-							fmt.Printf("[ERROR] 记录失败到黑名单失败: %v\n", err)
-						}
-
-						// 检查是否刚被拉黑
-						if blacklisted, _ := prs.blacklistService.IsBlacklisted(kind, provider.Name); blacklisted {
-							fmt.Printf("[INFO] 🚫 Provider %s 达到失败阈值，已被拉黑，切换到下一个\n", provider.Name)
+						if action == failureNextProvider {
 							break
 						}
-
-						// 等待后重试（除非是最后一次）
-						if retryCount < maxRetryPerProvider-1 {
-							fmt.Printf("[INFO] ⏳ 等待 %d 秒后重试...\n", retryWaitSeconds)
-							time.Sleep(time.Duration(retryWaitSeconds) * time.Second)
-						}
 					}
 				}
 			}
@@ -907,11 +1094,13 @@ func (prs *ProviderRelayService) proxyHandler(kind string, endpoint string) gin.
 		}
 
 		// 【降级模式】：拉黑功能关闭，失败自动尝试下一个 provider
-		roundRobinEnabled := prs.isRoundRobinEnabled()
-		if roundRobinEnabled {
-			fmt.Printf("[INFO] 🔄 降级模式 + 轮询负载均衡\n")
-		} else {
-			fmt.Printf("[INFO] 🔄 降级模式（顺序降级）\n")
+		fmt.Printf("[INFO] 🔄 降级模式（失败自动切换，同 Level 按负载均衡策略排序）\n")
+
+		// 【对冲请求】已作为对冲对象尝试过的 provider 不再单独请求
+		hedgeDelay := prs.hedgeDelay()
+		hedgedProviders := map[string]bool{cachedProviderName: true}
+		if hedgeDelay > 0 {
+			fmt.Printf("[INFO] ⏱️ 对冲请求已开启（延迟 %.1fs）\n", hedgeDelay.Seconds())
 		}
 
 		var lastError error
@@ -939,12 +1128,11 @@ func (prs *ProviderRelayService) proxyHandler(kind string, endpoint string) gin.
 		for _, level := range levels {
 			providersInLevel := levelGroups[level]
 
-			// 如果启用轮询，对同 Level 的 providers 进行轮询排序
-			if roundRobinEnabled {
-				providersInLevel = prs.roundRobinOrder(kind, level, providersInLevel)
-			}
+			// 按 Level 负载均衡策略排列同 Level 的 providers
+			strategy := prs.levelStrategy(level)
+			providersInLevel = prs.orderLevelProviders(kind, level, strategy, requestedModel, bodyBytes, providersInLevel)
 
-			fmt.Printf("[INFO] === 尝试 Level %d（%d 个 provider）===\n", level, len(providersInLevel))
+			fmt.Printf("[INFO] === 尝试 Level %d（%d 个 provider，策略: %s）===\n", level, len(providersInLevel), strategy)
 
 			for i, provider := range providersInLevel {
 				// 【5分钟同源缓存】跳过已经尝试过的缓存 provider
@@ -952,6 +1140,10 @@ func (prs *ProviderRelayService) proxyHandler(kind string, endpoint string) gin.
 					fmt.Printf("[INFO]   跳过已尝试的缓存 provider: %s\n", provider.Name)
 					continue
 				}
+				if hedgedProviders[provider.Name] {
+					fmt.Printf("[INFO]   跳过已作为对冲对象尝试的 provider: %s\n", provider.Name)
+					continue
+				}
 
 				totalAttempts++
 
@@ -980,6 +1172,24 @@ func (prs *ProviderRelayService) proxyHandler(kind string, endpoint string) gin.
 				startTime := time.Now()
 				// 根据 Provider 配置决定认证方式（auto 时自动检测原始请求）
 				authMethod := determineAuthMethod(&provider, c.Request.Header)
+
+				// 【对冲请求】primary 超过对冲延迟仍未响应时，并发请求同 Level 的下一个 provider，先响应者胜出
+				if hedgeDelay > 0 {
+					primary := forwardTarget{provider: provider, endpoint: effectiveEndpoint, body: currentBodyBytes, model: effectiveModel, authMethod: authMethod}
+					if hedge := prs.tryLevelWithHedge(c, kind, affinityKey, providersInLevel, i, primary, endpoint, requestedModel, bodyBytes, query, clientHeaders, isStream, hedgeDelay, hedgedProviders); hedge.launched {
+						totalAttempts += hedge.extraAttempts
+						if hedge.handled {
+							return // 成功或已直接返回错误
+						}
+						if hedge.failure != nil {
+							lastError = hedge.failure.err
+							lastProvider = hedge.failure.target.provider.Name
+							lastDuration = hedge.failure.duration
+						}
+						continue
+					}
+				}
+
 				ok, err := prs.forwardRequest(c, kind, provider, effectiveEndpoint, query, clientHeaders, currentBodyBytes, isStream, effectiveModel, authMethod)
 				duration := time.Since(startTime)
 
@@ -991,10 +1201,8 @@ func (prs *ProviderRelayService) proxyHandler(kind string, endpoint string) gin.
 						prs.affinityManager.Set(affinityKey, provider.Name)
 					}
 
-					// 成功：清零连续失败计数
-					if err := prs.blacklistService.RecordSuccess(kind, provider.Name); err != nil {
-						fmt.Printf("[WARN] 清零失败计数失败: %v\n", err)
-					}
+					// 成功：清零连续失败计数（流中途停滞则计入失败）
+					prs.recordDelivered(kind, provider.Name, effectiveModel, err)
 
 					// 记录最后使用的供应商
 					prs.setLastUsedProvider(kind, provider.Name)
@@ -1017,8 +1225,12 @@ func (prs *ProviderRelayService) proxyHandler(kind string, endpoint string) gin.
 				// 客户端中断不计入失败次数
 				if errors.Is(err, errClientAbort) {
 					fmt.Printf("[INFO] 客户端中断，跳过失败计数: %s\n", provider.Name)
-				} else if err := prs.blacklistService.RecordFailure(kind, provider.Name); err != nil {
-					fmt.Printf("[ERROR] 记录失败到黑名单失败: %v\n", err)
+				} else if errors.Is(err, errProviderSkipped) {
+					fmt.Printf("[INFO] 并发已满或限流用尽，跳过失败计数: %s\n", provider.Name)
+				} else if failure := prs.recordProviderFailure(kind, provider.Name, effectiveModel, err); !failure.policy.Failover {
+					// 客户端错误等：换 provider 也不会成功，直接返回给客户端
+					respondWithoutFailover(c, failure.class, err)
+					return
 				}
 
 				// 发送切换通知：检查是否有下一个可用的 provider
@@ -1079,6 +1291,96 @@ func (prs *ProviderRelayService) forwardRequest(
 	model string,
 	authMethod AuthMethod,
 ) (bool, error) {
+	return prs.forwardRequestAttempt(c, nil, kind, provider, endpoint, query, clientHeaders, bodyBytes, isStream, model, authMethod)
+}
+
+// forwardRequestAttempt 转发请求到指定 provider
+// attempt 非空时表示参与对冲竞速：只有抢到胜者资格的尝试才会写出响应，落败方返回 errHedgeCancelled
+// 【key 池】按权重轮换 key；某个 key 认证失败或被限流时只暂停该 key，换下一个 key 重试同一 provider
+func (prs *ProviderRelayService) forwardRequestAttempt(
+	c *gin.Context,
+	attempt *hedgeAttempt,
+	kind string,
+	provider Provider,
+	endpoint string,
+	query map[string]string,
+	clientHeaders http.Header,
+	bodyBytes []byte,
+	isStream bool,
+	model string,
+	authMethod AuthMethod,
+) (bool, error) {
+	for {
+		apiKey, err := prs.apiKeys.pick(kind, &provider)
+		if err != nil {
+			fmt.Printf("[INFO] Provider %s 无可用 key: %v\n", provider.Name, err)
+			return false, err
+		}
+		ok, err := prs.forwardRequestWithKey(c, attempt, kind, provider, apiKey, endpoint, query, clientHeaders, bodyBytes, isStream, model, authMethod)
+		if ok {
+			prs.apiKeys.report(kind, &provider, apiKey, nil)
+			return true, err
+		}
+		if !prs.apiKeys.report(kind, &provider, apiKey, err) {
+			return false, err
+		}
+		fmt.Printf("[INFO] Provider %s 换用下一个 key 重试\n", provider.Name)
+	}
+}
+
+// forwardRequestWithKey 使用指定的 key 转发一次请求
+func (prs *ProviderRelayService) forwardRequestWithKey(
+	c *gin.Context,
+	attempt *hedgeAttempt,
+	kind string,
+	provider Provider,
+	apiKey apiKeyChoice,
+	endpoint string,
+	query map[string]string,
+	clientHeaders http.Header,
+	bodyBytes []byte,
+	isStream bool,
+	model string,
+	authMethod AuthMethod,
+) (ok bool, err error) {
+	// 【协议转换】上游协议与客户端不同时（如 Anthropic -> Chat Completions），转换请求体
+	bridge := newRequestFormatBridge(c, kind, &provider, endpoint)
+	if bridge != nil {
+		convertedBody, convErr := bridge.convertRequest(bodyBytes, isStream)
+		if convErr != nil {
+			return false, fmt.Errorf("协议转换失败(%s): %w", bridge, convErr)
+		}
+		bodyBytes = convertedBody
+		authMethod = bridge.authMethod(&provider, authMethod)
+		endpoint, query = bridge.upstreamEndpoint(endpoint, query, model, isStream)
+	}
+
+	// 【本地限流】按 provider+model 的 RPM / 输入 TPM 令牌桶预扣额度，用尽时切换到下一个 provider
+	rateReservation, rateErr := prs.rateLimits.reserve(kind, provider.Name, model, provider.rateLimitFor(model), estimateRequestTokens(bodyBytes))
+	if rateErr != nil {
+		fmt.Printf("[INFO] Provider %s 本地限流: %v\n", provider.Name, rateErr)
+		return false, rateErr
+	}
+
+	// 【并发限制】超过 maxConcurrency 时排队等待，排队已满或超时则切换到下一个 provider
+	waitCtx := c.Request.Context()
+	if attempt != nil {
+		waitCtx = attempt.ctx
+	}
+	releaseSlot, slotErr := prs.acquireProviderSlot(waitCtx, kind, provider.Name, provider.concurrencyPolicy())
+	if slotErr != nil {
+		rateReservation.cancel()
+		if attempt != nil && attempt.lost() {
+			return false, errHedgeCancelled
+		}
+		return false, slotErr
+	}
+	defer releaseSlot()
+
+	// 【负载均衡】记录进行中的请求数（最少并发策略使用）
+	release := prs.inFlight.acquire(kind, provider.Name)
+	defer release()
+
 	targetURL := joinURL(provider.APIURL, endpoint)
 
 	// 添加查询参数（使用 url.Parse 进行正确的 URL 操作）
@@ -1097,6 +1399,14 @@ func (prs *ProviderRelayService) forwardRequest(
 	// 使用 buildForwardHeaders 构建转发请求头（支持 Provider 级别 Header 配置）
 	// 按优先级处理：原请求 Headers → StripHeaders → OverrideHeaders → ExtraHeaders
 	headers := buildForwardHeaders(clientHeaders, &provider)
+	if bridge != nil {
+		bridge.prepareHeaders(headers)
+	}
+
+	// 【请求体改写】按 Provider 配置的规则改写最终发往上游的请求体（及逗号分隔的 Header 值）
+	if len(provider.BodyRules) > 0 {
+		bodyBytes = applyBodyRules(provider.BodyRules, bodyBytes, headers)
+	}
 
 	// 根据原始请求的认证方式设置转发请求头
 	// 原始请求用 Authorization 就用 Authorization，原始请求用 x-api-key 就用 x-api-key
@@ -1104,12 +1414,17 @@ func (prs *ProviderRelayService) forwardRequest(
 	switch authMethod {
 	case AuthMethodXAPIKey:
 		// 原始请求使用 x-api-key，转发也使用 x-api-key
-		headers.Set("X-Api-Key", provider.APIKey)
+		headers.Set("X-Api-Key", apiKey.key)
 		// 删除可能存在的 Authorization 头
 		headers.Del("Authorization")
+	case AuthMethodGoogAPIKey:
+		// Gemini 原生上游使用 x-goog-api-key
+		headers.Set("X-Goog-Api-Key", apiKey.key)
+		headers.Del("Authorization")
+		headers.Del("X-Api-Key")
 	default:
 		// 原始请求使用 Authorization Bearer，转发也使用 Authorization
-		headers.Set("Authorization", fmt.Sprintf("Bearer %s", provider.APIKey))
+		headers.Set("Authorization", fmt.Sprintf("Bearer %s", apiKey.key))
 		// 删除可能存在的 x-api-key 头
 		headers.Del("X-Api-Key")
 	}
@@ -1119,11 +1434,16 @@ func (prs *ProviderRelayService) forwardRequest(
 	}
 
 	requestLog := &RequestLog{
-		Platform: kind,
-		Provider: provider.Name,
-		Model:    model,
-		IsStream: isStream,
+		Platform:    kind,
+		Provider:    provider.Name,
+		Model:       model,
+		IsStream:    isStream,
+		APIKeyLabel: apiKey.label,
 	}
+	// 【本地限流】用上游返回的真实输入用量修正预扣的 TPM 额度
+	defer func() {
+		rateReservation.settle(requestLog.InputTokens + requestLog.CacheCreateTokens)
+	}()
 
 	// 【请求详情缓存】准备响应收集器
 	var responseCollector *strings.Builder
@@ -1132,8 +1452,15 @@ func (prs *ProviderRelayService) forwardRequest(
 		GlobalRequestDetailCache.GetMode() != RequestDetailModeOff
 
 	start := time.Now()
+	// 【自适应路由】记录首字节耗时与成败，用于同 Level 评分排序
+	var ttfb time.Duration
+	defer func() {
+		prs.recordProviderScore(kind, provider.Name, model, ttfb, ok, err)
+	}()
 	defer func() {
 		requestLog.DurationSec = time.Since(start).Seconds()
+		// 【对冲请求】真正发起了对冲时，竞速双方都带 hedged 标记
+		requestLog.Hedged = attempt != nil && attempt.race.hedged()
 
 		// 【修复】判空保护：避免队列未初始化时 panic
 		if GlobalDBQueueLogs == nil {
@@ -1149,8 +1476,8 @@ func (prs *ProviderRelayService) forwardRequest(
 			INSERT INTO request_log (
 				platform, model, provider, http_code,
 				input_tokens, output_tokens, cache_create_tokens, cache_read_tokens,
-				reasoning_tokens, is_stream, duration_sec
-			) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+				reasoning_tokens, is_stream, duration_sec, hedged, api_key_label
+			) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 		`,
 			requestLog.Platform,
 			requestLog.Model,
@@ -1163,6 +1490,8 @@ func (prs *ProviderRelayService) forwardRequest(
 			requestLog.ReasoningTokens,
 			boolToInt(requestLog.IsStream),
 			requestLog.DurationSec,
+			boolToInt(requestLog.Hedged),
+			requestLog.APIKeyLabel,
 		)
 
 		if err != nil {
@@ -1227,12 +1556,24 @@ func (prs *ProviderRelayService) forwardRequest(
 		responseCollector = &strings.Builder{}
 	}
 
-	requestTimeout := 32 * time.Hour // 给模型足够思考时间
+	// 【超时】provider 配置的连接 / 首字节 / 流式空闲 / 总超时，路由规则可按字段覆盖
+	// 总超时默认 32 小时（给模型足够思考时间），首字节与空闲超时到期时以 errUpstreamStalled 中断请求
+	timeouts := requestTimeouts(c, &provider)
+	provider.Timeouts = &timeouts
 
 	// 使用标准 http.Client + http.NewRequestWithContext
 	// 这能确保 context 取消时请求真正被中断
-	reqCtx, cancelFunc := context.WithTimeout(c.Request.Context(), requestTimeout)
+	// 对冲竞速的尝试使用独立的可取消 context，落败时由胜者取消
+	parentCtx := c.Request.Context()
+	if attempt != nil {
+		parentCtx = attempt.ctx
+	}
+	totalCtx, cancelFunc := context.WithTimeout(parentCtx, timeouts.total())
 	defer cancelFunc()
+	reqCtx, cancelStall := context.WithCancelCause(totalCtx)
+	defer cancelStall(nil)
+	watchdog := &stallWatchdog{cancel: cancelStall}
+	defer watchdog.stop()
 
 	httpReq, reqErr := http.NewRequestWithContext(reqCtx, "POST", targetURL, bytes.NewReader(bodyBytes))
 	if reqErr != nil {
@@ -1253,7 +1594,12 @@ func (prs *ProviderRelayService) forwardRequest(
 	// 使用带网络级重试的 http.Client（超时由 context 控制）
 	// 网络级重试：1 次，间隔 500ms，仅针对瞬时网络错误（TCP reset、DNS 失败等）
 	// 应用层重试由外层 BlacklistService 控制，处理 API 级别错误
-	httpClient := newRetryHTTPClient(1, 500*time.Millisecond)
+	// 配置了网络设置（代理 / CA / 客户端证书 / SNI / 固定 IP）的 provider 使用独立连接池
+	httpClient, err := providerHTTPClient(&provider, newRetryHTTPClient(1, 500*time.Millisecond))
+	if err != nil {
+		return false, err
+	}
+	watchdog.startFirstByte(timeouts.firstByte())
 	httpResp, err := httpClient.Do(httpReq)
 
 	// 无论成功失败，先尝试记录 HttpCode
@@ -1262,6 +1608,15 @@ func (prs *ProviderRelayService) forwardRequest(
 	}
 
 	if err != nil {
+		// 对冲竞速落败被取消，不是 provider 的问题
+		if attempt != nil && attempt.lost() {
+			return false, errHedgeCancelled
+		}
+		// 首字节超时：上游迟迟不返回响应头
+		if stallErr := stallCause(reqCtx); stallErr != nil {
+			fmt.Printf("[INFO] Provider %s 首字节超时: %v\n", provider.Name, stallErr)
+			return false, stallErr
+		}
 		// 检查是否是 context 超时或取消
 		if errors.Is(err, context.DeadlineExceeded) {
 			fmt.Printf("[INFO] Provider %s 请求超时（context deadline exceeded）\n", provider.Name)
@@ -1298,6 +1653,14 @@ func (prs *ProviderRelayService) forwardRequest(
 	status := httpResp.StatusCode
 	requestLog.HttpCode = status
 
+	// 非流式响应收到响应头即视为首字节到达；流式响应等到首个内容事件，之后由空闲超时接管
+	isSuccessStatus := status == 0 || (status >= http.StatusOK && status < http.StatusMultipleChoices)
+	if !isStream || !isSuccessStatus {
+		watchdog.firstByteReceived()
+	} else {
+		httpResp.Body = watchdog.watchIdle(httpResp.Body, timeouts.idle())
+	}
+
 	// 状态码为 0 且无错误：当作成功处理
 	// 注意：status=0 通常发生在以下场景：
 	// 1. HTTP/2 或某些代理服务器在特殊情况下可能不设置状态码
@@ -1306,7 +1669,29 @@ func (prs *ProviderRelayService) forwardRequest(
 	// 这是防御性编程，确保即使遇到异常状态码也能正常处理
 	if status == 0 {
 		fmt.Printf("[WARN] Provider %s 返回状态码 0，但无错误，当作成功处理\n", provider.Name)
-		if copyErr := writeProxiedResponseWithCollector(c, httpResp, kind, requestLog, responseCollector); copyErr != nil {
+		if acceptErr := acceptUpstreamResponse(c, attempt, httpResp, isStream, responseCollector); acceptErr != nil {
+			if stallErr := stallCause(reqCtx); stallErr != nil && !errors.Is(acceptErr, errClientAbort) && !errors.Is(acceptErr, errHedgeCancelled) {
+				requestLog.HttpCode = http.StatusGatewayTimeout
+				return false, stallErr
+			}
+			if errors.Is(acceptErr, errStreamBeforeContent) {
+				requestLog.HttpCode = http.StatusBadGateway
+			}
+			return false, acceptErr
+		}
+		watchdog.firstByteReceived()
+		ttfb = time.Since(start)
+		if bridge != nil {
+			if wrapErr := bridge.wrapHTTPResponse(httpResp, isStream, requestLog); wrapErr != nil {
+				return false, fmt.Errorf("协议转换失败(%s): %w", bridge, wrapErr)
+			}
+		}
+		copyErr := writeForwardedResponse(c, httpResp, kind, bridge, requestLog, responseCollector, responseModelAlias(c, &provider))
+		// 上游中途停滞：响应头已写出无法故障转移，返回停滞错误由调用方计入失败
+		if stallErr := stallCause(reqCtx); stallErr != nil {
+			return true, stallErr
+		}
+		if copyErr != nil {
 			fmt.Printf("[WARN] 复制响应到客户端失败（不影响provider成功判定）: %v\n", copyErr)
 		}
 		// responseWritten: always true after writeProxiedResponseWithCollector returns
@@ -1314,7 +1699,32 @@ func (prs *ProviderRelayService) forwardRequest(
 	}
 
 	if status >= http.StatusOK && status < http.StatusMultipleChoices {
-		if copyErr := writeProxiedResponseWithCollector(c, httpResp, kind, requestLog, responseCollector); copyErr != nil {
+		// 【流式首包】收到首个内容事件后才写出响应头，此前流出错仍可故障转移（日志记为 502）
+		// 【对冲请求】抢到胜者资格后才写出响应，落败方直接丢弃
+		if acceptErr := acceptUpstreamResponse(c, attempt, httpResp, isStream, responseCollector); acceptErr != nil {
+			if stallErr := stallCause(reqCtx); stallErr != nil && !errors.Is(acceptErr, errClientAbort) && !errors.Is(acceptErr, errHedgeCancelled) {
+				requestLog.HttpCode = http.StatusGatewayTimeout
+				return false, stallErr
+			}
+			if errors.Is(acceptErr, errStreamBeforeContent) {
+				requestLog.HttpCode = http.StatusBadGateway
+			}
+			return false, acceptErr
+		}
+		watchdog.firstByteReceived()
+		ttfb = time.Since(start)
+		// 【协议转换】响应头写出前完成包装，转换失败时仍可故障转移到下一个 provider
+		if bridge != nil {
+			if wrapErr := bridge.wrapHTTPResponse(httpResp, isStream, requestLog); wrapErr != nil {
+				return false, fmt.Errorf("协议转换失败(%s): %w", bridge, wrapErr)
+			}
+		}
+		copyErr := writeForwardedResponse(c, httpResp, kind, bridge, requestLog, responseCollector, responseModelAlias(c, &provider))
+		// 上游中途停滞：响应头已写出无法故障转移，返回停滞错误由调用方计入失败
+		if stallErr := stallCause(reqCtx); stallErr != nil {
+			return true, stallErr
+		}
+		if copyErr != nil {
 			fmt.Printf("[WARN] 复制响应到客户端失败（不影响provider成功判定）: %v\n", copyErr)
 		}
 		// 只要provider返回了2xx状态码，就算成功（复制失败是客户端问题，不是provider问题）
@@ -1334,15 +1744,28 @@ func (prs *ProviderRelayService) forwardRequest(
 	}
 
 	// 返回错误信息时包含上游响应体，便于调试和日志分析
-	if len(respBody) > 0 {
+	// 同时带上 Retry-After 提示，429 / 529 由调用方转为冷却而不是失败计数
+	errBody, contentEncoding := decodeErrorBody(httpResp.Header.Get("Content-Encoding"), respBody)
+	statusErr := &upstreamStatusError{
+		status:          status,
+		retryAfter:      parseRetryAfter(httpResp.Header, time.Now()),
+		respBody:        errBody,
+		contentType:     httpResp.Header.Get("Content-Type"),
+		contentEncoding: contentEncoding,
+	}
+	// 协议转换时错误体是上游协议的格式，返回给客户端前需转换
+	if bridge != nil {
+		statusErr.clientFormat = bridge.ingress
+	}
+	if len(errBody) > 0 {
 		// 限制错误信息中的响应体长度，避免日志过长
-		bodyPreview := string(respBody)
+		bodyPreview := string(errBody)
 		if len(bodyPreview) > 500 {
 			bodyPreview = bodyPreview[:500] + "...(truncated)"
 		}
-		return false, fmt.Errorf("upstream status %d: %s", status, bodyPreview)
+		statusErr.body = bodyPreview
 	}
-	return false, fmt.Errorf("upstream status %d", status)
+	return false, statusErr
 }
 
 // writeProxiedResponse 写入代理响应（复制响应头、状态码、响应体）
@@ -1369,6 +1792,34 @@ func writeProxiedResponseWithCollector(c *gin.Context, httpResp *http.Response,
 	return copyResponseBodyWithHookAndCollector(httpResp.Body, c.Writer, hook, collector)
 }
 
+// writeForwardedResponse 写入 forwardRequest 的成功响应
+// 经过协议转换的响应已由转换器记录用量，不再挂 RequestLogHook，避免重复统计
+// modelAlias 非空时将响应中的模型名改写回客户端请求的模型名（响应体长度会变化，移除 Content-Length）
+func writeForwardedResponse(c *gin.Context, httpResp *http.Response, kind string, bridge *formatBridge, requestLog *RequestLog, collector *strings.Builder, modelAlias string) error {
+	rewriter := newResponseModelRewriter(modelAlias, httpResp.Header)
+	if bridge == nil && rewriter == nil {
+		return writeProxiedResponseWithCollector(c, httpResp, kind, requestLog, collector)
+	}
+	for k, vv := range httpResp.Header {
+		if isHopByHopHeader(k) {
+			continue
+		}
+		for _, v := range vv {
+			c.Writer.Header().Add(k, v)
+		}
+	}
+	var hook func([]byte) []byte
+	if bridge == nil {
+		hook = RequestLogHook(c, kind, requestLog)
+	}
+	if rewriter != nil {
+		c.Writer.Header().Del("Content-Length")
+		hook = rewriter.wrap(hook)
+	}
+	c.Writer.WriteHeader(httpResp.StatusCode)
+	return copyResponseBodyWithHookAndCollector(httpResp.Body, c.Writer, hook, collector)
+}
+
 // copyResponseBodyWithHook 流式复制响应 body 到 writer，同时调用 hook 处理数据
 // 用于在流式传输过程中解析 SSE 数据提取 token 用量
 func copyResponseBodyWithHook(body io.Reader, writer io.Writer, hook func([]byte) []byte) error {
@@ -1376,11 +1827,36 @@ func copyResponseBodyWithHook(body io.Reader, writer io.Writer, hook func([]byte
 }
 
 // copyResponseBodyWithHookAndCollector 流式复制响应 body，同时可选地收集响应内容
+// 读取结束（EOF）时会以空数据再调用一次 hook，缓冲型 hook（如响应模型名改写）借此输出剩余数据
 func copyResponseBodyWithHookAndCollector(body io.Reader, writer io.Writer, hook func([]byte) []byte, collector *strings.Builder) error {
 	buf := make([]byte, responseBufferSize)
 	collectedSize := 0
 	maxCollectSize := MaxStreamResponseSize
 
+	emit := func(data []byte) error {
+		// 收集响应内容（用于请求详情缓存）
+		if collector != nil && collectedSize < maxCollectSize {
+			remaining := maxCollectSize - collectedSize
+			toWrite := len(data)
+			if toWrite > remaining {
+				toWrite = remaining
+			}
+			collector.Write(data[:toWrite])
+			collectedSize += toWrite
+		}
+
+		// 写入客户端
+		if _, writeErr := writer.Write(data); writeErr != nil {
+			return writeErr
+		}
+
+		// 对于流式响应，立即 flush
+		if flusher, ok := writer.(http.Flusher); ok {
+			flusher.Flush()
+		}
+		return nil
+	}
+
 	for {
 		n, readErr := body.Read(buf)
 		if n > 0 {
@@ -1392,29 +1868,19 @@ func copyResponseBodyWithHookAndCollector(body io.Reader, writer io.Writer, hook
 				data = safeCallHook(hook, data)
 			}
 
-			// 收集响应内容（用于请求详情缓存）
-			if collector != nil && collectedSize < maxCollectSize {
-				remaining := maxCollectSize - collectedSize
-				toWrite := len(data)
-				if toWrite > remaining {
-					toWrite = remaining
+			if len(data) > 0 {
+				if writeErr := emit(data); writeErr != nil {
+					return writeErr
 				}
-				collector.Write(data[:toWrite])
-				collectedSize += toWrite
-			}
-
-			// 写入客户端
-			if _, writeErr := writer.Write(data); writeErr != nil {
-				return writeErr
-			}
-
-			// 对于流式响应，立即 flush
-			if flusher, ok := writer.(http.Flusher); ok {
-				flusher.Flush()
 			}
 		}
 		if readErr != nil {
 			if readErr == io.EOF {
+				if hook != nil {
+					if rest := safeCallHook(hook, nil); len(rest) > 0 {
+						return emit(rest)
+					}
+				}
 				return nil
 			}
 			return readErr
@@ -1453,7 +1919,7 @@ func (prs *ProviderRelayService) extractUserID(c *gin.Context) string {
 
 // AffinityTryResult 缓存亲和性尝试结果
 type AffinityTryResult struct {
-	Handled      bool          // 是否已处理完成（成功或客户端中断）
+	Handled      bool          // 是否已处理完成（成功、客户端中断或错误已直接返回给客户端）
 	UsedProvider string        // 使用的 provider 名称
 	LastError    error         // 最后的错误
 	Duration     time.Duration // 耗时
@@ -1527,9 +1993,7 @@ func (prs *ProviderRelayService) tryAffinityProvider(
 			prs.affinityManager.Set(affinityKey, cachedProvider.Name)
 		}
 
-		if recErr := prs.blacklistService.RecordSuccess(kind, cachedProvider.Name); recErr != nil {
-			fmt.Printf("[WARN] 清零失败计数失败: %v\n", recErr)
-		}
+		prs.recordDelivered(kind, cachedProvider.Name, effectiveModel, err)
 		prs.setLastUsedProvider(kind, cachedProvider.Name)
 		result.Handled = true
 		return result
@@ -1549,9 +2013,15 @@ func (prs *ProviderRelayService) tryAffinityProvider(
 		result.Handled = true // 客户端中断，不再继续降级
 		return result
 	}
+	// 并发已满或限流用尽不计入失败次数，继续降级
+	if errors.Is(err, errProviderSkipped) {
+		return result
+	}
 
-	if recErr := prs.blacklistService.RecordFailure(kind, cachedProvider.Name); recErr != nil {
-		fmt.Printf("[ERROR] 记录失败到黑名单失败: %v\n", recErr)
+	// 客户端错误等不切换 provider 的错误：直接返回给客户端，不再继续降级
+	if failure := prs.recordProviderFailure(kind, cachedProvider.Name, effectiveModel, err); !failure.policy.Failover {
+		respondWithoutFailover(c, failure.class, err)
+		result.Handled = true
 	}
 
 	return result
@@ -1604,7 +2074,15 @@ func (prs *ProviderRelayService) tryGeminiAffinityProvider(
 	requestLog.Provider = cachedProvider.Name
 	requestLog.Model = cachedProvider.Model
 
+	// 【并发限制】并发已满时跳过缓存的 provider，继续降级（不计入失败次数）
+	releaseSlot, slotErr := prs.acquireProviderSlot(c.Request.Context(), "gemini", cachedProvider.Name, cachedProvider.concurrencyPolicy())
+	if slotErr != nil {
+		result.LastError = slotErr.Error()
+		result.Handled = errors.Is(slotErr, errClientAbort)
+		return result
+	}
 	ok, errMsg, responseWritten := prs.forwardGeminiRequest(c, cachedProvider, endpoint, bodyBytes, isStream, requestLog)
+	releaseSlot()
 	result.ResponseWritten = responseWritten
 
 	if ok {
@@ -1624,7 +2102,7 @@ func (prs *ProviderRelayService) tryGeminiAffinityProvider(
 	if prs.affinityManager != nil {
 		prs.affinityManager.Invalidate(affinityKey)
 	}
-	_ = prs.blacklistService.RecordFailure("gemini", cachedProvider.Name)
+	prs.recordGeminiFailure(cachedProvider.Name, requestLog.Model, requestLog.HttpCode, errMsg)
 	result.LastError = errMsg
 
 	if responseWritten {
@@ -1635,6 +2113,33 @@ func (prs *ProviderRelayService) tryGeminiAffinityProvider(
 	return result
 }
 
+// recordGeminiStall 按 stall 分类记录 Gemini 上游停滞（首字节超时或流式空闲超时），返回错误信息
+// 日志记为 504，调用方的 recordGeminiFailure 不再重复记录
+func (prs *ProviderRelayService) recordGeminiStall(provider *GeminiProvider, requestLog *RequestLog, stallErr error) string {
+	fmt.Printf("[Gemini]   ✗ 上游停滞: %s | %v\n", provider.Name, stallErr)
+	requestLog.HttpCode = http.StatusGatewayTimeout
+	prs.recordProviderFailure("gemini", provider.Name, requestLog.Model, stallErr)
+	return fmt.Sprintf("上游停滞: %v", stallErr)
+}
+
+// recordGeminiFailure 记录 Gemini provider 失败，返回 true 表示 provider 已进入冷却
+// 上游非 2xx 响应与停滞已在 forwardGeminiRequest 中按错误分类处理，这里只处理网络错误、流中断等没有错误状态码的失败
+func (prs *ProviderRelayService) recordGeminiFailure(providerName string, model string, status int, errMsg string) bool {
+	if status >= http.StatusMultipleChoices {
+		coolingDown, _ := prs.blacklistService.IsCoolingDown("gemini", providerName, model)
+		return coolingDown
+	}
+	return prs.recordProviderFailure("gemini", providerName, model, errors.New(errMsg)).coolingDown
+}
+
+// geminiRequestModel 返回请求实际使用的模型（与 forwardGeminiRequest 记录日志的规则一致）
+func geminiRequestModel(provider *GeminiProvider, endpoint string) string {
+	if extractedModel := extractGeminiModelFromEndpoint(endpoint); extractedModel != "" {
+		return extractedModel
+	}
+	return provider.Model
+}
+
 // cloneHeaders 克隆请求头，返回 http.Header 类型
 // 过滤掉认证相关的头（Authorization, x-api-key, x-goog-api-key），因为转发时会根据原始请求的认证方式重新设置
 // 同时过滤掉 hop-by-hop headers
@@ -1655,6 +2160,11 @@ func cloneHeaders(header http.Header) http.Header {
 			continue
 		}
 
+		// 跳过 CodeSwitch 控制头（仅用于本地路由，不转发给上游）
+		if canonicalKey == http.CanonicalHeaderKey(providerPinHeader) {
+			continue
+		}
+
 		// 复制所有值
 		cloned[canonicalKey] = append([]string(nil), values...)
 	}
@@ -1779,6 +2289,12 @@ func ensureRequestLogTableWithDB(db *sql.DB) error {
 	if err := ensureRequestLogColumn(db, "duration_sec", "REAL DEFAULT 0"); err != nil {
 		return err
 	}
+	if err := ensureRequestLogColumn(db, "hedged", "INTEGER DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := ensureRequestLogColumn(db, "api_key_label", "TEXT DEFAULT ''"); err != nil {
+		return err
+	}
 
 	return nil
 }
@@ -1824,6 +2340,8 @@ type RequestLog struct {
 	ReasoningTokens   int     `json:"reasoning_tokens"`
 	IsStream          bool    `json:"is_stream"`
 	DurationSec       float64 `json:"duration_sec"`
+	Hedged            bool    `json:"hedged"`                  // 是否为对冲请求（竞速双方都会标记）
+	APIKeyLabel       string  `json:"api_key_label,omitempty"` // 使用的 key 池标签（未配置 key 池时为空）
 	CreatedAt         string  `json:"created_at"`
 	InputCost         float64 `json:"input_cost"`
 	OutputCost        float64 `json:"output_cost"`
@@ -2037,6 +2555,12 @@ func (prs *ProviderRelayService) geminiProxyHandler(apiVersion string) gin.Handl
 		// 判断是否为流式请求
 		isStream := strings.Contains(endpoint, ":streamGenerateContent") || strings.Contains(query, "alt=sse")
 
+		// 【流式保活】仅 SSE 格式（alt=sse）可插入注释帧，JSON 数组流不做保活
+		if isStream && strings.Contains(query, "alt=sse") {
+			keepAlive := prs.startStreamKeepAlive(c, WireFormatGemini)
+			defer keepAlive.stop()
+		}
+
 		// 【5分钟同源缓存】提取 user_id 和模型名
 		userID := prs.extractUserID(c)
 		geminiModel := extractGeminiModelFromEndpoint(endpoint)
@@ -2060,6 +2584,21 @@ func (prs *ProviderRelayService) geminiProxyHandler(apiVersion string) gin.Handl
 				fmt.Printf("[Gemini] ⛔ Provider %s 已拉黑，过期时间: %v\n", p.Name, until.Format("15:04:05"))
 				continue
 			}
+			// 检查上游限流 / 过载冷却
+			if coolingDown, until := prs.blacklistService.IsCoolingDown("gemini", p.Name, geminiRequestModel(&p, endpoint)); coolingDown {
+				fmt.Printf("[Gemini] 🧊 Provider %s 冷却中，结束时间: %v\n", p.Name, until.Format("15:04:05"))
+				continue
+			}
+			// 检查花费预算：用尽时跳过该 provider，reject 预算直接拒绝请求
+			if budget := prs.exhaustedBudget("gemini", p.Name, geminiRequestModel(&p, endpoint)); budget != nil {
+				if budget.Action == BudgetActionReject {
+					fmt.Printf("[Gemini] 💰 预算 %s 已用尽（%s），拒绝请求\n", budget.Name, formatBudgetSpend(*budget))
+					c.JSON(http.StatusPaymentRequired, gin.H{"error": budgetExhaustedMessage(budget)})
+					return
+				}
+				fmt.Printf("[Gemini] 💰 Provider %s 超出预算 %s（%s），跳过\n", p.Name, budget.Name, formatBudgetSpend(*budget))
+				continue
+			}
 			// Level 默认值处理
 			if p.Level <= 0 {
 				p.Level = 1
@@ -2068,7 +2607,7 @@ func (prs *ProviderRelayService) geminiProxyHandler(apiVersion string) gin.Handl
 		}
 
 		if len(activeProviders) == 0 {
-			c.JSON(http.StatusNotFound, gin.H{"error": "no active gemini provider (all disabled or blacklisted)"})
+			c.JSON(http.StatusNotFound, gin.H{"error": "no active gemini provider (all disabled, blacklisted, cooling down or over budget)"})
 			return
 		}
 
@@ -2165,7 +2704,18 @@ func (prs *ProviderRelayService) geminiProxyHandler(apiVersion string) gin.Handl
 						fmt.Printf("[Gemini] [拉黑模式] Provider: %s (Level %d) | 尝试 %d/%d\n",
 							provider.Name, level, retryCount+1, maxRetryPerProvider)
 
+						// 【并发限制】并发已满不计入失败次数，直接切换到下一个
+						releaseSlot, slotErr := prs.acquireProviderSlot(c.Request.Context(), "gemini", provider.Name, provider.concurrencyPolicy())
+						if slotErr != nil {
+							if errors.Is(slotErr, errClientAbort) {
+								return
+							}
+							lastError = slotErr.Error()
+							lastProvider = provider.Name
+							break
+						}
 						ok, errMsg, responseWritten := prs.forwardGeminiRequest(c, &provider, endpoint, bodyBytes, isStream, requestLog)
+						releaseSlot()
 						if ok {
 							fmt.Printf("[Gemini] ✓ 成功: %s | 尝试 %d 次\n", provider.Name, retryCount+1)
 							_ = prs.blacklistService.RecordSuccess("gemini", provider.Name)
@@ -2176,7 +2726,7 @@ func (prs *ProviderRelayService) geminiProxyHandler(apiVersion string) gin.Handl
 						// 【关键修复】如果响应已写入客户端，不能重试或降级，直接返回
 						if responseWritten {
 							fmt.Printf("[Gemini] ⚠️ 响应已部分写入，无法重试: %s | 错误: %s\n", provider.Name, errMsg)
-							_ = prs.blacklistService.RecordFailure("gemini", provider.Name)
+							prs.recordGeminiFailure(provider.Name, requestLog.Model, requestLog.HttpCode, errMsg)
 							return
 						}
 
@@ -2187,8 +2737,11 @@ func (prs *ProviderRelayService) geminiProxyHandler(apiVersion string) gin.Handl
 						fmt.Printf("[Gemini] ✗ 失败: %s | 尝试 %d/%d | 错误: %s\n",
 							provider.Name, retryCount+1, maxRetryPerProvider, errMsg)
 
-						// 记录失败次数（可能触发拉黑）
-						_ = prs.blacklistService.RecordFailure("gemini", provider.Name)
+						// 记录失败次数（可能触发拉黑）；上游限流 / 过载已进入冷却，不再重试同一 provider
+						if prs.recordGeminiFailure(provider.Name, requestLog.Model, requestLog.HttpCode, errMsg) {
+							fmt.Printf("[Gemini] 🧊 Provider %s 上游限流或过载，冷却中，切换到下一个\n", provider.Name)
+							break
+						}
 
 						// 检查是否刚被拉黑
 						if blacklisted, _ := prs.blacklistService.IsBlacklisted("gemini", provider.Name); blacklisted {
@@ -2199,7 +2752,11 @@ func (prs *ProviderRelayService) geminiProxyHandler(apiVersion string) gin.Handl
 						// 等待后重试（除非是最后一次）
 						if retryCount < maxRetryPerProvider-1 {
 							fmt.Printf("[Gemini] ⏳ 等待 %d 秒后重试...\n", retryWaitSeconds)
-							time.Sleep(time.Duration(retryWaitSeconds) * time.Second)
+							// 等待期间保活帧照常发送；客户端已断开则不再重试
+							if !waitBeforeRetry(c, time.Duration(retryWaitSeconds)*time.Second) {
+								fmt.Printf("[Gemini] 客户端中断，停止重试\n")
+								return
+							}
 						}
 					}
 				}
@@ -2222,12 +2779,7 @@ func (prs *ProviderRelayService) geminiProxyHandler(apiVersion string) gin.Handl
 		}
 
 		// 【降级模式】：按 Level 顺序尝试所有 provider
-		roundRobinEnabled := prs.isRoundRobinEnabled()
-		if roundRobinEnabled {
-			fmt.Printf("[Gemini] 🔄 降级模式 + 轮询负载均衡\n")
-		} else {
-			fmt.Printf("[Gemini] 🔄 降级模式（顺序降级）\n")
-		}
+		fmt.Printf("[Gemini] 🔄 降级模式（失败自动切换，同 Level 按负载均衡策略排序）\n")
 
 		var lastError string
 
@@ -2254,12 +2806,11 @@ func (prs *ProviderRelayService) geminiProxyHandler(apiVersion string) gin.Handl
 		for _, level := range sortedLevels {
 			providersInLevel := levelGroups[level]
 
-			// 如果启用轮询，对同 Level 的 providers 进行轮询排序
-			if roundRobinEnabled {
-				providersInLevel = prs.roundRobinOrderGemini(level, providersInLevel)
-			}
+			// 按 Level 负载均衡策略排列同 Level 的 providers
+			strategy := prs.levelStrategy(level)
+			providersInLevel = prs.orderLevelGeminiProviders(level, strategy, geminiModel, bodyBytes, providersInLevel)
 
-			fmt.Printf("[Gemini] === 尝试 Level %d（%d 个 provider）===\n", level, len(providersInLevel))
+			fmt.Printf("[Gemini] === 尝试 Level %d（%d 个 provider，策略: %s）===\n", level, len(providersInLevel), strategy)
 
 			for idx, provider := range providersInLevel {
 				// 【5分钟同源缓存】跳过已经尝试过的缓存 provider
@@ -2274,7 +2825,17 @@ func (prs *ProviderRelayService) geminiProxyHandler(apiVersion string) gin.Handl
 				requestLog.Provider = provider.Name
 				requestLog.Model = provider.Model
 
+				// 【并发限制】并发已满不计入失败次数，直接尝试下一个
+				releaseSlot, slotErr := prs.acquireProviderSlot(c.Request.Context(), "gemini", provider.Name, provider.concurrencyPolicy())
+				if slotErr != nil {
+					if errors.Is(slotErr, errClientAbort) {
+						return
+					}
+					lastError = slotErr.Error()
+					continue
+				}
 				ok, errMsg, responseWritten := prs.forwardGeminiRequest(c, &provider, endpoint, bodyBytes, isStream, requestLog)
+				releaseSlot()
 				if ok {
 					// 【5分钟同源缓存】设置缓存亲和性
 					if prs.affinityManager != nil {
@@ -2290,13 +2851,13 @@ func (prs *ProviderRelayService) geminiProxyHandler(apiVersion string) gin.Handl
 				// 【关键修复】如果响应已写入客户端，不能降级到其他 provider，直接返回
 				if responseWritten {
 					fmt.Printf("[Gemini] ⚠️ 响应已部分写入，无法降级: %s | 错误: %s\n", provider.Name, errMsg)
-					_ = prs.blacklistService.RecordFailure("gemini", provider.Name)
+					prs.recordGeminiFailure(provider.Name, requestLog.Model, requestLog.HttpCode, errMsg)
 					return
 				}
 
 				// 失败，记录并继续
 				lastError = errMsg
-				_ = prs.blacklistService.RecordFailure("gemini", provider.Name)
+				prs.recordGeminiFailure(provider.Name, requestLog.Model, requestLog.HttpCode, errMsg)
 			}
 
 			fmt.Printf("[Gemini] Level %d 的所有 %d 个 provider 均失败，尝试下一 Level\n", level, len(providersInLevel))
@@ -2353,6 +2914,10 @@ func (prs *ProviderRelayService) forwardGeminiRequest(
 ) (success bool, errMsg string, responseWritten bool) {
 	providerStart := time.Now()
 
+	// 【负载均衡】记录进行中的请求数（最少并发策略使用）
+	release := prs.inFlight.acquire("gemini", provider.Name)
+	defer release()
+
 	// 构建目标 URL
 	targetURL := strings.TrimSuffix(provider.BaseURL, "/") + endpoint
 
@@ -2361,14 +2926,33 @@ func (prs *ProviderRelayService) forwardGeminiRequest(
 	// 【修复】每次尝试开始前重置 HttpCode，避免重试时沿用上一次的状态码
 	requestLog.HttpCode = 0
 	// 优先从 endpoint 提取模型名（如 gemini-2.5-pro），否则回退到 provider.Model
-	if extractedModel := extractGeminiModelFromEndpoint(endpoint); extractedModel != "" {
-		requestLog.Model = extractedModel
-	} else {
-		requestLog.Model = provider.Model
+	requestLog.Model = geminiRequestModel(provider, endpoint)
+
+	// 【自适应路由】记录首字节耗时与成败（客户端中断、客户端请求错误不计入）
+	var ttfb time.Duration
+	var upstreamErr error
+	defer func() {
+		if !success && c.Request.Context().Err() != nil {
+			return
+		}
+		prs.recordProviderScore("gemini", provider.Name, requestLog.Model, ttfb, success, upstreamErr)
+	}()
+
+	// 【超时】provider 配置的连接 / 首字节 / 流式空闲 / 总超时，与 claude / codex 一致
+	// 首字节与空闲超时到期时以 errUpstreamStalled 中断请求，按 stall 分类计入失败
+	var timeouts TimeoutConfig
+	if provider.Timeouts != nil {
+		timeouts = *provider.Timeouts
 	}
+	totalCtx, cancel := context.WithTimeout(c.Request.Context(), timeouts.total())
+	defer cancel()
+	reqCtx, cancelStall := context.WithCancelCause(totalCtx)
+	defer cancelStall(nil)
+	watchdog := &stallWatchdog{cancel: cancelStall}
+	defer watchdog.stop()
 
 	// 创建 HTTP 请求
-	req, err := http.NewRequest("POST", targetURL, bytes.NewReader(bodyBytes))
+	req, err := http.NewRequestWithContext(reqCtx, "POST", targetURL, bytes.NewReader(bodyBytes))
 	if err != nil {
 		return false, fmt.Sprintf("创建请求失败: %v", err), false
 	}
@@ -2386,17 +2970,23 @@ func (prs *ProviderRelayService) forwardGeminiRequest(
 	}
 
 	// 发送请求
-	// 使用带网络级重试的 HTTP 客户端，处理瞬时网络错误
+	// 使用带网络级重试的 HTTP 客户端，处理瞬时网络错误；配置了网络设置的 provider 使用独立连接池
 	// 【修复】使用 context 超时而非直接修改共享客户端的 Timeout 字段，避免影响其他请求
-	client := newRetryHTTPClient(1, 500*time.Millisecond)
-	ctx, cancel := context.WithTimeout(c.Request.Context(), 300*time.Second)
-	defer cancel()
-	req = req.WithContext(ctx)
+	client, err := geminiHTTPClient(provider, newRetryHTTPClient(1, 500*time.Millisecond))
+	if err != nil {
+		fmt.Printf("[Gemini]   ✗ 失败: %s | 错误: %v\n", provider.Name, err)
+		return false, err.Error(), false
+	}
+	watchdog.startFirstByte(timeouts.firstByte())
 	resp, err := client.Do(req)
 	providerDuration := time.Since(providerStart).Seconds()
 
 	if err != nil {
 		fmt.Printf("[Gemini]   ✗ 失败: %s | 错误: %v | 耗时: %.2fs\n", provider.Name, err, providerDuration)
+		// 首字节超时：上游迟迟不返回响应头
+		if stallErr := stallCause(reqCtx); stallErr != nil {
+			return false, prs.recordGeminiStall(provider, requestLog, stallErr), false
+		}
 		return false, fmt.Sprintf("请求失败: %v", err), false
 	}
 	defer resp.Body.Close()
@@ -2404,14 +2994,38 @@ func (prs *ProviderRelayService) forwardGeminiRequest(
 	// 先记录上游状态码，失败场景也能落库
 	requestLog.HttpCode = resp.StatusCode
 
+	// 响应头写出后即无法故障转移，收到响应头即视为首字节到达；流式响应之后由空闲超时接管
+	watchdog.firstByteReceived()
+	if isStream {
+		resp.Body = watchdog.watchIdle(resp.Body, timeouts.idle())
+	}
+
 	// 检查响应状态
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
 		errorBody, _ := io.ReadAll(resp.Body)
+		errorBody, contentEncoding := decodeErrorBody(resp.Header.Get("Content-Encoding"), errorBody)
 		fmt.Printf("[Gemini]   ✗ 失败: %s | HTTP %d | 耗时: %.2fs\n", provider.Name, resp.StatusCode, providerDuration)
-		return false, fmt.Sprintf("HTTP %d: %s", resp.StatusCode, string(errorBody)), false
+		// 按错误分类处理（冷却 / 计入失败次数 / 不处罚），调用方不再重复记录
+		statusErr := &upstreamStatusError{
+			status:          resp.StatusCode,
+			retryAfter:      parseRetryAfter(resp.Header, time.Now()),
+			respBody:        errorBody,
+			contentType:     resp.Header.Get("Content-Type"),
+			contentEncoding: contentEncoding,
+		}
+		upstreamErr = statusErr
+		failure := prs.recordProviderFailure("gemini", provider.Name, requestLog.Model, statusErr)
+		errMsg = fmt.Sprintf("HTTP %d: %s", resp.StatusCode, string(errorBody))
+		// 客户端错误等不切换 provider：原样返回给客户端，调用方按已写入响应处理
+		if !failure.policy.Failover {
+			respondWithoutFailover(c, failure.class, statusErr)
+			return false, errMsg, true
+		}
+		return false, errMsg, false
 	}
 
 	fmt.Printf("[Gemini]   ✓ 连接成功: %s | HTTP %d | 耗时: %.2fs\n", provider.Name, resp.StatusCode, providerDuration)
+	ttfb = time.Since(providerStart)
 
 	// 处理响应
 	if isStream {
@@ -2427,6 +3041,10 @@ func (prs *ProviderRelayService) forwardGeminiRequest(
 		copyErr := streamGeminiResponseWithHook(resp.Body, c.Writer, requestLog)
 		if copyErr != nil {
 			fmt.Printf("[Gemini]   ⚠️ 流式传输中断: %s | 错误: %v\n", provider.Name, copyErr)
+			// 上游中途停滞：已写入部分响应，按 stall 分类计入失败
+			if stallErr := stallCause(reqCtx); stallErr != nil {
+				return false, prs.recordGeminiStall(provider, requestLog, stallErr), true
+			}
 			// 流式传输中断：已写入部分响应，客户端会收到不完整数据
 			return false, fmt.Sprintf("流式传输中断: %v", copyErr), true
 		}
@@ -2498,6 +3116,12 @@ func (prs *ProviderRelayService) customCliProxyHandler() gin.HandlerFunc {
 		isStream := gjson.GetBytes(bodyBytes, "stream").Bool()
 		requestedModel := gjson.GetBytes(bodyBytes, "model").String()
 
+		// 【流式保活】等待上游首包、重试或切换 provider 期间定期向客户端发送保活帧
+		if isStream {
+			keepAlive := prs.startStreamKeepAlive(c, clientWireFormat(c, kind))
+			defer keepAlive.stop()
+		}
+
 		if requestedModel == "" {
 			fmt.Printf("[CustomCLI][WARN] 请求未指定模型名，无法执行模型智能降级\n")
 		}
@@ -2513,39 +3137,45 @@ func (prs *ProviderRelayService) customCliProxyHandler() gin.HandlerFunc {
 			return
 		}
 
+		// 【固定 Provider】X-CodeSwitch-Provider 请求头或 model@provider 后缀：跳过 Level 与同源缓存
+		if pinned, model, body := resolveProviderPin(c.Request.Header, requestedModel, bodyBytes, providers); pinned != "" {
+			fmt.Printf("[CustomCLI] 请求固定到 Provider: %s\n", pinned)
+			prs.forwardPinned(c, kind, pinned, providers, endpoint, body, isStream, model)
+			return
+		}
+
+		// 记录客户端请求的模型名，供 provider 开启响应模型名还原时使用（路由规则改写模型前）
+		c.Set(requestedModelKey, requestedModel)
+
+		// 【路由规则】按顺序匹配，命中的规则限定候选 provider，并可改写模型名和超时
+		routingRule := prs.matchRoutingRule(kind, c, requestedModel, bodyBytes)
+		if routingRule != nil {
+			providers, requestedModel, bodyBytes = applyRoutingRule(c, routingRule, providers, requestedModel, bodyBytes)
+			affinityKey = GenerateAffinityKey(userID, kind, requestedModel)
+		}
+
 		// Filter available providers
 		active := make([]Provider, 0, len(providers))
 		reasons := skipReasons{} // track skip reasons
+		estimatedTokens := estimateRequestTokens(bodyBytes)
 		for _, provider := range providers {
-			if !provider.Enabled || provider.APIURL == "" || provider.APIKey == "" {
-				reasons.disabled++
-				continue
-			}
-
-			if errs := provider.ValidateConfiguration(); len(errs) > 0 {
-				fmt.Printf("[CustomCLI][WARN] Provider %s config validation failed, skipped: %v\n", provider.Name, errs)
-				reasons.configInvalid++
-				continue
-			}
-
-			if requestedModel != "" && !provider.IsModelSupported(requestedModel) {
-				fmt.Printf("[CustomCLI][INFO] Provider %s does not support model %s, skipped\n", provider.Name, requestedModel)
-				reasons.modelUnsupported++
-				continue
+			reason, rejected := prs.candidateSkipReason(kind, "[CustomCLI]", &provider, requestedModel, estimatedTokens)
+			if rejected != nil {
+				c.JSON(http.StatusPaymentRequired, gin.H{"error": budgetExhaustedMessage(rejected)})
+				return
 			}
-
-			// Blacklist check
-			if isBlacklisted, until := prs.blacklistService.IsBlacklisted(kind, provider.Name); isBlacklisted {
-				fmt.Printf("[CustomCLI] ⛔ Provider %s blacklisted until %v\n", provider.Name, until.Format("15:04:05"))
-				reasons.blacklisted++
+			if reason != skipNone {
+				reasons.add(reason)
 				continue
 			}
-
 			active = append(active, provider)
 		}
 
 		if len(active) == 0 {
 			errMsg := buildNoProviderError(requestedModel, kind, reasons)
+			if routingRule != nil {
+				errMsg += fmt.Sprintf(" [routing rule: %s]", routingRule.Name)
+			}
 			c.JSON(http.StatusNotFound, gin.H{"error": errMsg})
 			return
 		}
@@ -2555,9 +3185,9 @@ func (prs *ProviderRelayService) customCliProxyHandler() gin.HandlerFunc {
 		for i, p := range active {
 			providerNames[i] = p.Name
 		}
-		fmt.Printf("[CustomCLI][INFO] Providers: total=%d, active=%d, skipped=%d (disabled=%d, config=%d, model=%d, blacklist=%d): %s\n",
+		fmt.Printf("[CustomCLI][INFO] Providers: total=%d, active=%d, skipped=%d (disabled=%d, config=%d, model=%d, context=%d, blacklist=%d, ratelimit=%d, cooldown=%d, budget=%d, balance=%d): %s\n",
 			len(providers), len(active), reasons.total(),
-			reasons.disabled, reasons.configInvalid, reasons.modelUnsupported, reasons.blacklisted,
+			reasons.disabled, reasons.configInvalid, reasons.modelUnsupported, reasons.contextTooSmall, reasons.blacklisted, reasons.rateLimited, reasons.coolingDown, reasons.overBudget, reasons.lowBalance,
 			strings.Join(providerNames, ", "))
 
 		// 按 Level 分组
@@ -2614,6 +3244,10 @@ func (prs *ProviderRelayService) customCliProxyHandler() gin.HandlerFunc {
 
 					// 获取实际模型名
 					effectiveModel := provider.GetEffectiveModel(requestedModel)
+					if coolingDown, until := prs.blacklistService.IsCoolingDown(kind, provider.Name, effectiveModel); coolingDown {
+						fmt.Printf("[CustomCLI][INFO] ⏭️ 跳过冷却中的 Provider: %s (冷却结束: %v)\n", provider.Name, until)
+						continue
+					}
 					currentBodyBytes := bodyBytes
 					if effectiveModel != requestedModel && requestedModel != "" {
 						fmt.Printf("[CustomCLI][INFO] Provider %s 映射模型: %s -> %s\n", provider.Name, requestedModel, effectiveModel)
@@ -2650,9 +3284,7 @@ func (prs *ProviderRelayService) customCliProxyHandler() gin.HandlerFunc {
 						if ok {
 							fmt.Printf("[CustomCLI][INFO] ✓ 成功: %s | 尝试 %d 次 | 耗时: %.2fs\n",
 								provider.Name, retryCount+1, duration.Seconds())
-							if err := prs.blacklistService.RecordSuccess(kind, provider.Name); err != nil {
-								fmt.Printf("[CustomCLI][WARN] 清零失败计数失败: %v\n", err)
-							}
+							prs.recordDelivered(kind, provider.Name, effectiveModel, err)
 							prs.setLastUsedProvider(kind, provider.Name)
 							return
 						}
@@ -2668,28 +3300,14 @@ func (prs *ProviderRelayService) customCliProxyHandler() gin.HandlerFunc {
 						fmt.Printf("[CustomCLI][WARN] ✗ 失败: %s | 尝试 %d/%d | 错误: %s | 耗时: %.2fs\n",
 							provider.Name, retryCount+1, maxRetryPerProvider, errorMsg, duration.Seconds())
 
-						// 客户端中断不计入失败次数，直接返回
-						if errors.Is(err, errClientAbort) {
-							fmt.Printf("[CustomCLI][INFO] 客户端中断，停止重试\n")
+						action := prs.handleAttemptFailure(c, kind, "[CustomCLI]", &provider, effectiveModel, err,
+							retryCount < maxRetryPerProvider-1, time.Duration(retryWaitSeconds)*time.Second)
+						if action == failureReturn {
 							return
 						}
-
-						// 记录失败次数（可能触发拉黑）
-						if err := prs.blacklistService.RecordFailure(kind, provider.Name); err != nil {
-							fmt.Printf("[CustomCLI][ERROR] 记录失败到黑名单失败: %v\n", err)
-						}
-
-						// 检查是否刚被拉黑
-						if blacklisted, _ := prs.blacklistService.IsBlacklisted(kind, provider.Name); blacklisted {
-							fmt.Printf("[CustomCLI][INFO] 🚫 Provider %s 达到失败阈值，已被拉黑，切换到下一个\n", provider.Name)
+						if action == failureNextProvider {
 							break
 						}
-
-						// 等待后重试（除非是最后一次）
-						if retryCount < maxRetryPerProvider-1 {
-							fmt.Printf("[CustomCLI][INFO] ⏳ 等待 %d 秒后重试...\n", retryWaitSeconds)
-							time.Sleep(time.Duration(retryWaitSeconds) * time.Second)
-						}
 					}
 				}
 			}
@@ -2712,12 +3330,7 @@ func (prs *ProviderRelayService) customCliProxyHandler() gin.HandlerFunc {
 		}
 
 		// 【降级模式】：失败自动尝试下一个 provider
-		roundRobinEnabled := prs.isRoundRobinEnabled()
-		if roundRobinEnabled {
-			fmt.Printf("[CustomCLI][INFO] 🔄 降级模式 + 轮询负载均衡\n")
-		} else {
-			fmt.Printf("[CustomCLI][INFO] 🔄 降级模式（顺序降级）\n")
-		}
+		fmt.Printf("[CustomCLI][INFO] 🔄 降级模式（失败自动切换，同 Level 按负载均衡策略排序）\n")
 
 		var lastError error
 		var lastProvider string
@@ -2730,6 +3343,13 @@ func (prs *ProviderRelayService) customCliProxyHandler() gin.HandlerFunc {
 			cachedProviderName = prs.affinityManager.Get(affinityKey)
 		}
 
+		// 【对冲请求】已作为对冲对象尝试过的 provider 不再单独请求
+		hedgeDelay := prs.hedgeDelay()
+		hedgedProviders := map[string]bool{cachedProviderName: true}
+		if hedgeDelay > 0 {
+			fmt.Printf("[CustomCLI][INFO] ⏱️ 对冲请求已开启（延迟 %.1fs）\n", hedgeDelay.Seconds())
+		}
+
 		// 【5分钟同源缓存】如果有缓存的 provider，优先尝试
 		if cachedProviderName != "" {
 			affinityResult := prs.tryAffinityProvider(
@@ -2750,12 +3370,11 @@ func (prs *ProviderRelayService) customCliProxyHandler() gin.HandlerFunc {
 		for _, level := range levels {
 			providersInLevel := levelGroups[level]
 
-			// 如果启用轮询，对同 Level 的 providers 进行轮询排序
-			if roundRobinEnabled {
-				providersInLevel = prs.roundRobinOrder(kind, level, providersInLevel)
-			}
+			// 按 Level 负载均衡策略排列同 Level 的 providers
+			strategy := prs.levelStrategy(level)
+			providersInLevel = prs.orderLevelProviders(kind, level, strategy, requestedModel, bodyBytes, providersInLevel)
 
-			fmt.Printf("[CustomCLI][INFO] === 尝试 Level %d（%d 个 provider）===\n", level, len(providersInLevel))
+			fmt.Printf("[CustomCLI][INFO] === 尝试 Level %d（%d 个 provider，策略: %s）===\n", level, len(providersInLevel), strategy)
 
 			for i, provider := range providersInLevel {
 				// 【5分钟同源缓存】跳过已经尝试过的缓存 provider
@@ -2763,6 +3382,10 @@ func (prs *ProviderRelayService) customCliProxyHandler() gin.HandlerFunc {
 					fmt.Printf("[CustomCLI][INFO]   跳过已尝试的缓存 provider: %s\n", provider.Name)
 					continue
 				}
+				if hedgedProviders[provider.Name] {
+					fmt.Printf("[CustomCLI][INFO]   跳过已作为对冲对象尝试的 provider: %s\n", provider.Name)
+					continue
+				}
 
 				totalAttempts++
 
@@ -2785,6 +3408,24 @@ func (prs *ProviderRelayService) customCliProxyHandler() gin.HandlerFunc {
 				startTime := time.Now()
 				// 根据 Provider 配置决定认证方式（auto 时自动检测原始请求）
 				authMethod := determineAuthMethod(&provider, c.Request.Header)
+
+				// 【对冲请求】primary 超过对冲延迟仍未响应时，并发请求同 Level 的下一个 provider，先响应者胜出
+				if hedgeDelay > 0 {
+					primary := forwardTarget{provider: provider, endpoint: effectiveEndpoint, body: currentBodyBytes, model: effectiveModel, authMethod: authMethod}
+					if hedge := prs.tryLevelWithHedge(c, kind, affinityKey, providersInLevel, i, primary, endpoint, requestedModel, bodyBytes, query, clientHeaders, isStream, hedgeDelay, hedgedProviders); hedge.launched {
+						totalAttempts += hedge.extraAttempts
+						if hedge.handled {
+							return // 成功或已直接返回错误
+						}
+						if hedge.failure != nil {
+							lastError = hedge.failure.err
+							lastProvider = hedge.failure.target.provider.Name
+							lastDuration = hedge.failure.duration
+						}
+						continue
+					}
+				}
+
 				ok, err := prs.forwardRequest(c, kind, provider, effectiveEndpoint, query, clientHeaders, currentBodyBytes, isStream, effectiveModel, authMethod)
 				duration := time.Since(startTime)
 
@@ -2796,9 +3437,7 @@ func (prs *ProviderRelayService) customCliProxyHandler() gin.HandlerFunc {
 						prs.affinityManager.Set(affinityKey, provider.Name)
 					}
 
-					if err := prs.blacklistService.RecordSuccess(kind, provider.Name); err != nil {
-						fmt.Printf("[CustomCLI][WARN] 清零失败计数失败: %v\n", err)
-					}
+					prs.recordDelivered(kind, provider.Name, effectiveModel, err)
 					prs.setLastUsedProvider(kind, provider.Name)
 					return
 				}
@@ -2816,8 +3455,12 @@ func (prs *ProviderRelayService) customCliProxyHandler() gin.HandlerFunc {
 
 				if errors.Is(err, errClientAbort) {
 					fmt.Printf("[CustomCLI][INFO] 客户端中断，跳过失败计数: %s\n", provider.Name)
-				} else if err := prs.blacklistService.RecordFailure(kind, provider.Name); err != nil {
-					fmt.Printf("[CustomCLI][ERROR] 记录失败到黑名单失败: %v\n", err)
+				} else if errors.Is(err, errProviderSkipped) {
+					fmt.Printf("[CustomCLI][INFO] 并发已满或限流用尽，跳过失败计数: %s\n", provider.Name)
+				} else if failure := prs.recordProviderFailure(kind, provider.Name, effectiveModel, err); !failure.policy.Failover {
+					// 客户端错误等：换 provider 也不会成功，直接返回给客户端
+					respondWithoutFailover(c, failure.class, err)
+					return
 				}
 
 				// 发送切换通知
@@ -2883,7 +3526,7 @@ func (prs *ProviderRelayService) forwardModelsRequest(
 	// 过滤可用的 providers（启用 + URL + APIKey）
 	var activeProviders []Provider
 	for _, provider := range providers {
-		if !provider.Enabled || provider.APIURL == "" || provider.APIKey == "" {
+		if !provider.Enabled || provider.APIURL == "" || !provider.hasAPIKey() {
 			continue
 		}
 
@@ -2952,17 +3595,25 @@ func (prs *ProviderRelayService) forwardModelsRequest(
 		}
 	}
 
+	// 与 forwardRequest 一样从 key 池轮换选 key
+	apiKey, err := prs.apiKeys.pick(kind, selectedProvider)
+	if err != nil {
+		fmt.Printf("[%s] ✗ Provider %s 无可用 key: %v\n", logPrefix, selectedProvider.Name, err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return err
+	}
+
 	// 检测原始请求的认证方式，转发时使用相同方式
 	authMethod := detectAuthMethod(c.Request.Header)
 	switch authMethod {
 	case AuthMethodXAPIKey:
 		// 原始请求使用 x-api-key，转发也使用 x-api-key
-		req.Header.Set("X-Api-Key", selectedProvider.APIKey)
+		req.Header.Set("X-Api-Key", apiKey.key)
 		// 删除可能存在的 Authorization 头
 		req.Header.Del("Authorization")
 	default:
 		// 原始请求使用 Authorization Bearer，转发也使用 Authorization
-		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", selectedProvider.APIKey))
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", apiKey.key))
 		// 删除可能存在的 x-api-key 头
 		req.Header.Del("X-Api-Key")
 	}
@@ -2973,7 +3624,11 @@ func (prs *ProviderRelayService) forwardModelsRequest(
 	}
 
 	// 发送请求
-	client := &http.Client{Timeout: 30 * time.Second}
+	client, err := providerHTTPClient(selectedProvider, &http.Client{Timeout: 30 * time.Second})
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return err
+	}
 	resp, err := client.Do(req)
 	if err != nil {
 		fmt.Printf("[%s] ✗ 请求失败: %s | 错误: %v\n", logPrefix, selectedProvider.Name, err)
@@ -2981,6 +3636,7 @@ func (prs *ProviderRelayService) forwardModelsRequest(
 		return fmt.Errorf("request failed: %w", err)
 	}
 	defer resp.Body.Close()
+	prs.apiKeys.report(kind, selectedProvider, apiKey, responseStatusError(resp))
 
 	// 读取响应
 	body, err := io.ReadAll(resp.Body)
@@ -0,0 +1,91 @@
+package services
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+// openAIModelPattern 按模型名归入 codex 平台的 OpenAI 模型（gpt-*、o1/o3/o4 系列、codex 系列）
+var openAIModelPattern = regexp.MustCompile(`^(gpt-|chatgpt-|o\d+(-|$))|codex`)
+
+// chatCompletionsHandler 处理 OpenAI 兼容的 /v1/chat/completions 请求
+// 请求经过与原生入口相同的 Level 分组、拉黑、亲和缓存逻辑，转发时转换为各 provider 的原生协议
+// platform 为空时按模型名自动选择 claude 或 codex 平台
+func (prs *ProviderRelayService) chatCompletionsHandler(platform string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var bodyBytes []byte
+		if c.Request.Body != nil {
+			data, err := io.ReadAll(c.Request.Body)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+				return
+			}
+			bodyBytes = data
+			c.Request.Body = io.NopCloser(bytes.NewReader(bodyBytes))
+		}
+
+		kind := platform
+		if kind == "" {
+			kind = prs.resolveChatPlatform(gjson.GetBytes(bodyBytes, "model").String())
+		}
+		fmt.Printf("[ChatCompletions] 收到请求: model=%s, 平台=%s\n", gjson.GetBytes(bodyBytes, "model").String(), kind)
+
+		c.Set(clientWireFormatKey, WireFormatOpenAIChat)
+		prs.proxyHandler(kind, defaultEndpointForWireFormat(ingressWireFormat(kind)))(c)
+	}
+}
+
+// customChatCompletionsHandler 处理自定义 CLI 工具的 Chat Completions 请求
+// 路由格式: /custom/:toolId/v1/chat/completions
+func (prs *ProviderRelayService) customChatCompletionsHandler() gin.HandlerFunc {
+	proxy := prs.customCliProxyHandler()
+	return func(c *gin.Context) {
+		c.Set(clientWireFormatKey, WireFormatOpenAIChat)
+		proxy(c)
+	}
+}
+
+// resolveChatPlatform 为未指定平台的 Chat Completions 请求选择平台
+// 1. 只有一个平台的启用 provider 显式声明支持该模型（supportedModels / modelMapping）时，使用该平台
+// 2. 否则按模型名判断：OpenAI 模型归 codex，其余归 claude
+func (prs *ProviderRelayService) resolveChatPlatform(model string) string {
+	if model != "" {
+		claudeDeclared := prs.platformDeclaresModel("claude", model)
+		codexDeclared := prs.platformDeclaresModel("codex", model)
+		if codexDeclared && !claudeDeclared {
+			return "codex"
+		}
+		if claudeDeclared && !codexDeclared {
+			return "claude"
+		}
+	}
+	if openAIModelPattern.MatchString(strings.ToLower(model)) {
+		return "codex"
+	}
+	return "claude"
+}
+
+// platformDeclaresModel 判断平台下是否有启用的 provider 显式配置了该模型
+// 未配置白名单和映射的 provider 视为"支持所有模型"，不作为判断依据
+func (prs *ProviderRelayService) platformDeclaresModel(kind string, model string) bool {
+	providers, err := prs.providerService.LoadProviders(kind)
+	if err != nil {
+		return false
+	}
+	for _, provider := range providers {
+		if !provider.Enabled || (len(provider.SupportedModels) == 0 && len(provider.ModelMapping) == 0) {
+			continue
+		}
+		if provider.IsModelSupported(model) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,102 @@
+package services
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+// TestChatCompletionsHandler_AnthropicProvider Chat Completions 请求转发到 Claude provider 时转换为 Anthropic 协议
+func TestChatCompletionsHandler_AnthropicProvider(t *testing.T) {
+	t.Cleanup(func() {
+		cleanupProviderFile(t, "claude")
+	})
+
+	upstreamServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/messages" {
+			t.Errorf("期望路径 /v1/messages，收到 %s", r.URL.Path)
+		}
+		if r.Header.Get("X-Api-Key") != "test-api-key" || r.Header.Get("Authorization") != "" {
+			t.Errorf("认证头错误: x-api-key=%q authorization=%q", r.Header.Get("X-Api-Key"), r.Header.Get("Authorization"))
+		}
+		if r.Header.Get("Anthropic-Version") == "" {
+			t.Errorf("缺少 anthropic-version 请求头")
+		}
+		body, _ := io.ReadAll(r.Body)
+		if gjson.GetBytes(body, "system").String() != "be brief" || gjson.GetBytes(body, "max_tokens").Int() == 0 {
+			t.Errorf("请求未转换为 Anthropic 格式: %s", string(body))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id":"msg_1","type":"message","role":"assistant","model":"claude-sonnet-4","content":[{"type":"text","text":"你好"}],"stop_reason":"end_turn","usage":{"input_tokens":12,"output_tokens":3}}`))
+	}))
+	defer upstreamServer.Close()
+
+	router := newCountTokensTestRouter(t, []Provider{{
+		ID:      1,
+		Name:    "ClaudeProvider",
+		APIURL:  upstreamServer.URL,
+		APIKey:  "test-api-key",
+		Enabled: true,
+		Level:   1,
+	}})
+
+	req := httptest.NewRequest("POST", "/v1/chat/completions",
+		strings.NewReader(`{"model":"claude-sonnet-4","messages":[{"role":"system","content":"be brief"},{"role":"user","content":"hello"}]}`))
+	req.Header.Set("Authorization", "Bearer client-key")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("期望状态码 200，收到 %d: %s", w.Code, w.Body.String())
+	}
+	resp := gjson.Parse(w.Body.String())
+	if resp.Get("object").String() != "chat.completion" || resp.Get("choices.0.message.content").String() != "你好" {
+		t.Errorf("响应未转换为 Chat Completions 格式: %s", w.Body.String())
+	}
+	if resp.Get("choices.0.finish_reason").String() != "stop" || resp.Get("usage.prompt_tokens").Int() != 12 {
+		t.Errorf("finish_reason/usage 错误: %s", w.Body.String())
+	}
+}
+
+// ==================== Chat Completions 平台选择测试 ====================
+
+func TestResolveChatPlatform(t *testing.T) {
+	t.Cleanup(func() {
+		cleanupProviderFile(t, "claude")
+		cleanupProviderFile(t, "codex")
+	})
+
+	providerService := NewProviderService()
+	if err := providerService.SaveProviders("codex", []Provider{{
+		ID: 1, Name: "codex", APIURL: "https://example.com", APIKey: "k", Enabled: true,
+		SupportedModels: map[string]bool{"qwen3-coder*": true},
+	}}); err != nil {
+		t.Fatalf("保存 provider 配置失败: %v", err)
+	}
+	relayService := NewProviderRelayService(providerService, nil, nil, nil, nil, "")
+
+	tests := []struct {
+		model    string
+		expected string
+	}{
+		{"claude-sonnet-4", "claude"},
+		{"gpt-5", "codex"},
+		{"o3-mini", "codex"},
+		{"gpt-5-codex", "codex"},
+		{"qwen3-coder-plus", "codex"}, // codex provider 显式声明
+		{"deepseek-chat", "claude"},
+		{"", "claude"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.model, func(t *testing.T) {
+			if got := relayService.resolveChatPlatform(tt.model); got != tt.expected {
+				t.Errorf("模型 %s 期望平台 %s，实际 %s", tt.model, tt.expected, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,214 @@
+package services
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+// countTokensTimeout count_tokens 请求超时（只计算 token，不需要长等待）
+const countTokensTimeout = 30 * time.Second
+
+// countTokensHandler 处理 /v1/messages/count_tokens 请求
+// 与 /v1/messages 使用相同的 provider 选择、模型映射和 Header 规则
+// 上游不支持或失败时返回本地估算值，保证客户端不会看到错误
+func (prs *ProviderRelayService) countTokensHandler(kind string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		prs.forwardCountTokensRequest(c, kind, "CountTokens")
+	}
+}
+
+// customCountTokensHandler 处理自定义 CLI 工具的 count_tokens 请求
+// 路由格式: /custom/:toolId/v1/messages/count_tokens
+func (prs *ProviderRelayService) customCountTokensHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		toolId := c.Param("toolId")
+		if toolId == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "toolId is required"})
+			return
+		}
+		prs.forwardCountTokensRequest(c, "custom:"+toolId, "CustomCountTokens")
+	}
+}
+
+// forwardCountTokensRequest 转发 count_tokens 请求到首选 provider，失败时回退本地估算
+func (prs *ProviderRelayService) forwardCountTokensRequest(c *gin.Context, kind string, logPrefix string) {
+	var bodyBytes []byte
+	if c.Request.Body != nil {
+		data, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+			return
+		}
+		bodyBytes = data
+	}
+	requestedModel := gjson.GetBytes(bodyBytes, "model").String()
+
+	provider := prs.selectCountTokensProvider(c, kind, requestedModel)
+	if provider == nil {
+		fmt.Printf("[%s] 无可用 Provider，使用本地估算\n", logPrefix)
+		writeLocalTokenEstimate(c, bodyBytes)
+		return
+	}
+
+	// 非 Anthropic 协议的上游（Chat Completions / Gemini 等）没有 count_tokens 接口
+	endpoint := provider.GetEffectiveEndpoint("/v1/messages")
+	if upstreamWireFormat(kind, provider, endpoint) != WireFormatAnthropic {
+		fmt.Printf("[%s] Provider %s 上游协议不支持 count_tokens，使用本地估算\n", logPrefix, provider.Name)
+		writeLocalTokenEstimate(c, bodyBytes)
+		return
+	}
+
+	// 模型映射
+	effectiveModel := provider.GetEffectiveModel(requestedModel)
+	currentBodyBytes := bodyBytes
+	if effectiveModel != requestedModel && requestedModel != "" {
+		if modifiedBody, err := ReplaceModelInRequestBody(bodyBytes, effectiveModel); err == nil {
+			currentBodyBytes = modifiedBody
+		}
+	}
+
+	targetURL := joinURL(provider.APIURL, countTokensEndpoint(endpoint))
+	if rawQuery := c.Request.URL.RawQuery; rawQuery != "" {
+		targetURL += "?" + rawQuery
+	}
+
+	req, err := http.NewRequestWithContext(c.Request.Context(), "POST", targetURL, bytes.NewReader(currentBodyBytes))
+	if err != nil {
+		writeLocalTokenEstimate(c, bodyBytes)
+		return
+	}
+
+	// 与 forwardRequest 一样从 key 池轮换选 key，避免所有 count_tokens 都压在同一个 key 上
+	apiKey, err := prs.apiKeys.pick(kind, provider)
+	if err != nil {
+		fmt.Printf("[%s] Provider %s 无可用 key: %v，使用本地估算\n", logPrefix, provider.Name, err)
+		writeLocalTokenEstimate(c, bodyBytes)
+		return
+	}
+
+	// Header 规则与 forwardRequest 一致：StripHeaders → OverrideHeaders → ExtraHeaders → 认证头
+	headers := buildForwardHeaders(cloneHeaders(c.Request.Header), provider)
+	switch determineAuthMethod(provider, c.Request.Header) {
+	case AuthMethodXAPIKey:
+		headers.Set("X-Api-Key", apiKey.key)
+		headers.Del("Authorization")
+	default:
+		headers.Set("Authorization", fmt.Sprintf("Bearer %s", apiKey.key))
+		headers.Del("X-Api-Key")
+	}
+	if headers.Get("Accept") == "" {
+		headers.Set("Accept", "application/json")
+	}
+	req.Header = headers
+
+	client, err := providerHTTPClient(provider, &http.Client{Timeout: countTokensTimeout})
+	if err != nil {
+		fmt.Printf("[%s] ✗ %v，使用本地估算\n", logPrefix, err)
+		writeLocalTokenEstimate(c, bodyBytes)
+		return
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		fmt.Printf("[%s] ✗ 请求失败: %s | 错误: %v，使用本地估算\n", logPrefix, provider.Name, err)
+		writeLocalTokenEstimate(c, bodyBytes)
+		return
+	}
+	defer resp.Body.Close()
+	prs.apiKeys.report(kind, provider, apiKey, responseStatusError(resp))
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil || resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices ||
+		!gjson.GetBytes(body, "input_tokens").Exists() {
+		// count_tokens 失败不计入 provider 失败次数（不影响拉黑）
+		fmt.Printf("[%s] ✗ Provider %s 不支持 count_tokens（HTTP %d），使用本地估算\n", logPrefix, provider.Name, resp.StatusCode)
+		writeLocalTokenEstimate(c, bodyBytes)
+		return
+	}
+
+	fmt.Printf("[%s] ✓ 成功: %s | HTTP %d\n", logPrefix, provider.Name, resp.StatusCode)
+	for key, values := range resp.Header {
+		if isHopByHopHeader(key) || strings.EqualFold(key, "Content-Length") || strings.EqualFold(key, "Content-Encoding") {
+			continue
+		}
+		for _, value := range values {
+			c.Writer.Header().Add(key, value)
+		}
+	}
+	c.Data(resp.StatusCode, "application/json", body)
+}
+
+// selectCountTokensProvider 按 /v1/messages 的规则选出首选 provider
+// 过滤规则相同（启用、配置校验、模型支持、黑名单），优先使用亲和缓存中的 provider，否则取最高优先级 Level 的第一个
+// 不推进轮询状态，避免 count_tokens 干扰真实请求的负载分配
+func (prs *ProviderRelayService) selectCountTokensProvider(c *gin.Context, kind string, requestedModel string) *Provider {
+	providers, err := prs.providerService.LoadProviders(kind)
+	if err != nil {
+		return nil
+	}
+
+	active := make([]Provider, 0, len(providers))
+	for _, provider := range providers {
+		if !provider.Enabled || provider.APIURL == "" || !provider.hasAPIKey() {
+			continue
+		}
+		if errs := provider.ValidateConfiguration(); len(errs) > 0 {
+			continue
+		}
+		if requestedModel != "" && !provider.IsModelSupported(requestedModel) {
+			continue
+		}
+		if isBlacklisted, _ := prs.blacklistService.IsBlacklisted(kind, provider.Name); isBlacklisted {
+			continue
+		}
+		active = append(active, provider)
+	}
+	if len(active) == 0 {
+		return nil
+	}
+
+	// 亲和缓存：与随后的 /v1/messages 请求命中同一个 provider
+	if prs.affinityManager != nil {
+		affinityKey := GenerateAffinityKey(prs.extractUserID(c), kind, requestedModel)
+		if cached := prs.affinityManager.Get(affinityKey); cached != "" {
+			for i := range active {
+				if active[i].Name == cached {
+					return &active[i]
+				}
+			}
+		}
+	}
+
+	// 按 Level 升序（稳定排序保留同 Level 内的配置顺序）
+	sort.SliceStable(active, func(i, j int) bool {
+		return normalizedLevel(active[i].Level) < normalizedLevel(active[j].Level)
+	})
+	return &active[0]
+}
+
+// normalizedLevel 未配置或零值时默认为 Level 1
+func normalizedLevel(level int) int {
+	if level <= 0 {
+		return 1
+	}
+	return level
+}
+
+// countTokensEndpoint 根据 messages 端点推导 count_tokens 端点
+// 自定义端点（如 /api/anthropic/v1/messages）同样追加 /count_tokens
+func countTokensEndpoint(messagesEndpoint string) string {
+	return strings.TrimSuffix(messagesEndpoint, "/") + "/count_tokens"
+}
+
+// writeLocalTokenEstimate 返回本地估算的 token 数（Anthropic count_tokens 响应格式）
+func writeLocalTokenEstimate(c *gin.Context, bodyBytes []byte) {
+	c.Header("X-CodeSwitch-Token-Estimate", "local")
+	c.JSON(http.StatusOK, gin.H{"input_tokens": estimateRequestTokens(bodyBytes)})
+}
@@ -0,0 +1,195 @@
+package services
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+// newCountTokensTestRouter 保存 provider 配置并创建注册了中转路由的 gin 实例
+func newCountTokensTestRouter(t *testing.T, providers []Provider) *gin.Engine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	providerService := NewProviderService()
+	settingsService := NewSettingsService()
+	notificationService := NewNotificationService(nil)
+	blacklistService := NewBlacklistService(settingsService, notificationService)
+
+	if err := providerService.SaveProviders("claude", providers); err != nil {
+		t.Fatalf("保存 provider 配置失败: %v", err)
+	}
+
+	relayService := NewProviderRelayService(providerService, nil, blacklistService, notificationService, nil, "")
+	router := gin.New()
+	relayService.registerRoutes(router)
+	return router
+}
+
+// TestCountTokensHandler_Upstream 上游支持 count_tokens 时透传结果，并应用模型映射与 Header 规则
+func TestCountTokensHandler_Upstream(t *testing.T) {
+	t.Cleanup(func() {
+		cleanupProviderFile(t, "claude")
+	})
+
+	upstreamServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/messages/count_tokens" {
+			t.Errorf("期望路径 /v1/messages/count_tokens，收到 %s", r.URL.Path)
+		}
+		if r.Header.Get("X-Api-Key") != "test-api-key" {
+			t.Errorf("X-Api-Key 不正确: %s", r.Header.Get("X-Api-Key"))
+		}
+		if r.Header.Get("X-Extra") != "yes" {
+			t.Errorf("ExtraHeaders 未生效")
+		}
+		body, _ := io.ReadAll(r.Body)
+		if model := gjson.GetBytes(body, "model").String(); model != "upstream-model" {
+			t.Errorf("模型映射未生效，收到 %s", model)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"input_tokens": 42}`))
+	}))
+	defer upstreamServer.Close()
+
+	router := newCountTokensTestRouter(t, []Provider{{
+		ID:           1,
+		Name:         "TestProvider",
+		APIURL:       upstreamServer.URL,
+		APIKey:       "test-api-key",
+		Enabled:      true,
+		Level:        1,
+		ModelMapping: map[string]string{"claude-sonnet-4": "upstream-model"},
+		ExtraHeaders: map[string]string{"X-Extra": "yes"},
+	}})
+
+	req := httptest.NewRequest("POST", "/v1/messages/count_tokens",
+		strings.NewReader(`{"model":"claude-sonnet-4","messages":[{"role":"user","content":"hello"}]}`))
+	req.Header.Set("X-Api-Key", "client-key")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("期望状态码 200，收到 %d: %s", w.Code, w.Body.String())
+	}
+	if got := gjson.Get(w.Body.String(), "input_tokens").Int(); got != 42 {
+		t.Errorf("期望透传上游结果 42，收到 %d", got)
+	}
+	if w.Header().Get("X-CodeSwitch-Token-Estimate") != "" {
+		t.Errorf("上游成功时不应使用本地估算")
+	}
+}
+
+// TestCountTokensHandler_Fallback 上游不支持 count_tokens 时返回本地估算
+func TestCountTokensHandler_Fallback(t *testing.T) {
+	t.Cleanup(func() {
+		cleanupProviderFile(t, "claude")
+	})
+
+	upstreamServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.NotFound(w, r)
+	}))
+	defer upstreamServer.Close()
+
+	router := newCountTokensTestRouter(t, []Provider{{
+		ID:      1,
+		Name:    "NoCountTokens",
+		APIURL:  upstreamServer.URL,
+		APIKey:  "test-api-key",
+		Enabled: true,
+		Level:   1,
+	}})
+
+	req := httptest.NewRequest("POST", "/v1/messages/count_tokens",
+		strings.NewReader(`{"model":"claude-sonnet-4","system":"You are helpful","messages":[{"role":"user","content":"请帮我写一个排序函数"}]}`))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("期望状态码 200，收到 %d: %s", w.Code, w.Body.String())
+	}
+	if w.Header().Get("X-CodeSwitch-Token-Estimate") != "local" {
+		t.Errorf("期望使用本地估算")
+	}
+	if got := gjson.Get(w.Body.String(), "input_tokens").Int(); got <= 0 {
+		t.Errorf("本地估算应大于 0，收到 %d", got)
+	}
+}
+
+// TestCountTokensHandler_APIKeyPool count_tokens 与 /v1/messages 一样从 key 池轮换选 key，并暂停失效的 key
+func TestCountTokensHandler_APIKeyPool(t *testing.T) {
+	t.Cleanup(func() {
+		cleanupProviderFile(t, "claude")
+	})
+
+	var seen []string
+	upstreamServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("X-Api-Key")
+		seen = append(seen, key)
+		if key == "expired" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"input_tokens": 7}`))
+	}))
+	defer upstreamServer.Close()
+
+	router := newCountTokensTestRouter(t, []Provider{{
+		ID:      1,
+		Name:    "Pooled",
+		APIURL:  upstreamServer.URL,
+		Enabled: true,
+		Level:   1,
+		APIKeys: []APIKeyEntry{
+			{Label: "old", Key: "expired", Weight: 2, Enabled: true},
+			{Label: "new", Key: "valid", Enabled: true},
+		},
+	}})
+
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest("POST", "/v1/messages/count_tokens",
+			strings.NewReader(`{"model":"claude-sonnet-4","messages":[{"role":"user","content":"hello"}]}`))
+		req.Header.Set("X-Api-Key", "client-key")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("期望状态码 200，收到 %d: %s", w.Code, w.Body.String())
+		}
+	}
+
+	// 第一次选中权重更高的失效 key（回退本地估算并暂停该 key），第二次换用下一个 key
+	if strings.Join(seen, ",") != "expired,valid" {
+		t.Errorf("应从 key 池选 key 并跳过已暂停的 key，实际 %v", seen)
+	}
+}
+
+// ==================== 本地 token 估算测试 ====================
+
+func TestEstimateRequestTokens(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		min  int
+		max  int
+	}{
+		{"空请求体", ``, 0, 0},
+		{"英文文本", `{"messages":[{"role":"user","content":"` + strings.Repeat("abcd", 100) + `"}]}`, 100, 120},
+		{"中文文本", `{"messages":[{"role":"user","content":"` + strings.Repeat("你好", 50) + `"}]}`, 100, 120},
+		{"图片按固定开销", `{"messages":[{"role":"user","content":[{"type":"image","source":{"type":"base64","media_type":"image/png","data":"` + strings.Repeat("A", 4096) + `"}}]}]}`, estimateImageTokens, estimateImageTokens + 20},
+		{"采样参数不计入", `{"model":"claude-sonnet-4","max_tokens":1024,"temperature":0.5}`, 0, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := estimateRequestTokens([]byte(tt.body))
+			if got < tt.min || got > tt.max {
+				t.Errorf("估算值 %d 不在 [%d, %d] 范围内", got, tt.min, tt.max)
+			}
+		})
+	}
+}
@@ -0,0 +1,311 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultHedgeDelay 对冲延迟未配置时的默认值
+const defaultHedgeDelay = 5 * time.Second
+
+// errHedgeCancelled 对冲竞速中落败的一方被取消（不计入 provider 失败次数）
+var errHedgeCancelled = errors.New("hedged request cancelled: another provider responded first")
+
+// forwardTarget 一次转发所需的 provider 级参数（已完成模型映射与端点解析）
+type forwardTarget struct {
+	provider   Provider
+	endpoint   string
+	body       []byte
+	model      string
+	authMethod AuthMethod
+}
+
+// hedgeRace 一次对冲竞速的共享状态
+// 第一个拿到 2xx 响应（流式请求需收到首个内容事件）的尝试胜出，其余尝试立即取消
+type hedgeRace struct {
+	mu       sync.Mutex
+	winner   int
+	attempts int
+	cancels  map[int]context.CancelFunc
+}
+
+func newHedgeRace() *hedgeRace {
+	return &hedgeRace{winner: -1, cancels: make(map[int]context.CancelFunc)}
+}
+
+// register 登记一次尝试，竞速已决出胜者时返回 false（不再发起新的尝试）
+func (r *hedgeRace) register(index int, cancel context.CancelFunc) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.winner >= 0 {
+		return false
+	}
+	r.cancels[index] = cancel
+	r.attempts++
+	return true
+}
+
+// hedged 是否真正发起了对冲（primary 在延迟内响应时只有一次尝试，不算对冲）
+func (r *hedgeRace) hedged() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.attempts > 1
+}
+
+// decided 是否已决出胜者
+func (r *hedgeRace) decided() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.winner >= 0
+}
+
+// hedgeAttempt 参与竞速的一次转发尝试
+type hedgeAttempt struct {
+	race  *hedgeRace
+	index int
+	ctx   context.Context
+}
+
+// claim 尝试成为胜者：成功时取消其他尝试；已有其他胜者时返回 false
+func (a *hedgeAttempt) claim() bool {
+	a.race.mu.Lock()
+	defer a.race.mu.Unlock()
+	if a.race.winner >= 0 {
+		return a.race.winner == a.index
+	}
+	a.race.winner = a.index
+	for index, cancel := range a.race.cancels {
+		if index != a.index {
+			cancel()
+		}
+	}
+	return true
+}
+
+// lost 是否已被其他尝试抢先
+func (a *hedgeAttempt) lost() bool {
+	a.race.mu.Lock()
+	defer a.race.mu.Unlock()
+	return a.race.winner >= 0 && a.race.winner != a.index
+}
+
+// hedgeOutcome 对冲竞速中单个尝试的结果
+type hedgeOutcome struct {
+	target   forwardTarget
+	ok       bool
+	err      error
+	duration time.Duration
+	failure  *providerFailure // 计入失败处理时的错误分类与策略（成功、落败取消、跳过时为 nil）
+}
+
+// hedgeDelay 返回对冲延迟，未开启对冲时返回 0
+// 与轮询相同，只在降级模式下生效（拉黑模式需要同 Provider 重试累积失败次数）
+func (prs *ProviderRelayService) hedgeDelay() time.Duration {
+	if prs.appSettings == nil || prs.blacklistService.ShouldUseFixedMode() {
+		return 0
+	}
+	settings, err := prs.appSettings.GetAppSettings()
+	if err != nil || !settings.EnableHedging {
+		return 0
+	}
+	if settings.HedgeDelayMs <= 0 {
+		return defaultHedgeDelay
+	}
+	return time.Duration(settings.HedgeDelayMs) * time.Millisecond
+}
+
+// prepareForwardTarget 为 provider 完成模型映射、端点与认证方式解析
+func prepareForwardTarget(c *gin.Context, provider Provider, endpoint string, requestedModel string, bodyBytes []byte) (forwardTarget, error) {
+	effectiveModel := provider.GetEffectiveModel(requestedModel)
+	currentBodyBytes := bodyBytes
+	if effectiveModel != requestedModel && requestedModel != "" {
+		fmt.Printf("[INFO] Provider %s 映射模型: %s -> %s\n", provider.Name, requestedModel, effectiveModel)
+		modifiedBody, err := ReplaceModelInRequestBody(bodyBytes, effectiveModel)
+		if err != nil {
+			return forwardTarget{}, err
+		}
+		currentBodyBytes = modifiedBody
+	}
+	return forwardTarget{
+		provider:   provider,
+		endpoint:   provider.GetEffectiveEndpoint(endpoint),
+		body:       currentBodyBytes,
+		model:      effectiveModel,
+		authMethod: determineAuthMethod(&provider, c.Request.Header),
+	}, nil
+}
+
+// levelHedgeResult tryLevelWithHedge 的处理结果
+type levelHedgeResult struct {
+	launched      bool          // 是否按对冲方式转发（false 时调用方按普通方式转发 primary）
+	handled       bool          // 已向客户端写出响应（成功，或错误策略不允许切换），调用方直接返回
+	extraAttempts int           // primary 之外额外发起的尝试次数
+	failure       *hedgeOutcome // 最后一个失败的尝试（没有时为 nil）
+}
+
+// tryLevelWithHedge 以同 Level 中 index 之后的 provider 作为 peer 对 primary 发起对冲请求（proxyHandler 与 customCliProxyHandler 共用）
+// 没有可用 peer 时不转发，返回 launched=false；真正发起过的尝试记入 hedged，后续故障转移不再单独请求
+func (prs *ProviderRelayService) tryLevelWithHedge(
+	c *gin.Context,
+	kind string,
+	affinityKey string,
+	providersInLevel []Provider,
+	index int,
+	primary forwardTarget,
+	endpoint string,
+	requestedModel string,
+	bodyBytes []byte,
+	query map[string]string,
+	clientHeaders http.Header,
+	isStream bool,
+	delay time.Duration,
+	hedged map[string]bool,
+) levelHedgeResult {
+	peerIndex := nextHedgePeer(providersInLevel, index, hedged)
+	if peerIndex < 0 {
+		return levelHedgeResult{}
+	}
+	peer, err := prepareForwardTarget(c, providersInLevel[peerIndex], endpoint, requestedModel, bodyBytes)
+	if err != nil {
+		return levelHedgeResult{}
+	}
+
+	outcomes := prs.forwardHedged(c, kind, primary, peer, query, clientHeaders, isStream, delay)
+	// 只标记真正发起过的尝试：primary 在对冲延迟前失败时 peer 未发起，仍需在后续故障转移中尝试
+	for _, outcome := range outcomes {
+		hedged[outcome.target.provider.Name] = true
+	}
+	result := levelHedgeResult{launched: true, extraAttempts: len(outcomes) - 1}
+	winner, failure := prs.settleHedgeOutcomes(kind, affinityKey, outcomes)
+	if winner != nil {
+		result.handled = true
+		return result
+	}
+	result.failure = failure
+	if failure != nil && failure.failure != nil && !failure.failure.policy.Failover {
+		respondWithoutFailover(c, failure.failure.class, failure.err)
+		result.handled = true
+	}
+	return result
+}
+
+// nextHedgePeer 返回同 Level 中可作为对冲对象的下一个 provider 下标，没有时返回 -1
+func nextHedgePeer(providers []Provider, current int, skip map[string]bool) int {
+	for j := current + 1; j < len(providers); j++ {
+		if !skip[providers[j].Name] {
+			return j
+		}
+	}
+	return -1
+}
+
+// forwardHedged 发起对冲请求：先请求 primary，超过 delay 仍未收到响应时并发请求 peer
+// 第一个返回 2xx 的 provider 负责写出响应，另一个被取消；两次尝试都会以 hedged 标记写入 request_log
+// 返回每个已发起尝试的结果（primary 在前）
+func (prs *ProviderRelayService) forwardHedged(
+	c *gin.Context,
+	kind string,
+	primary forwardTarget,
+	peer forwardTarget,
+	query map[string]string,
+	clientHeaders http.Header,
+	isStream bool,
+	delay time.Duration,
+) []hedgeOutcome {
+	race := newHedgeRace()
+	targets := []forwardTarget{primary, peer}
+	outcomes := make([]*hedgeOutcome, len(targets))
+	results := make(chan int, len(targets))
+
+	launch := func(index int) bool {
+		ctx, cancel := context.WithCancel(c.Request.Context())
+		if !race.register(index, cancel) {
+			cancel()
+			return false
+		}
+		target := targets[index]
+		outcomes[index] = &hedgeOutcome{target: target}
+		go func() {
+			defer cancel()
+			start := time.Now()
+			attempt := &hedgeAttempt{race: race, index: index, ctx: ctx}
+			ok, err := prs.forwardRequestAttempt(c, attempt, kind, target.provider, target.endpoint, query, clientHeaders, target.body, isStream, target.model, target.authMethod)
+			outcomes[index].ok = ok
+			outcomes[index].err = err
+			outcomes[index].duration = time.Since(start)
+			results <- index
+		}()
+		return true
+	}
+
+	launch(0)
+	pending := 1
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	for pending > 0 {
+		select {
+		case <-results:
+			pending--
+		case <-timer.C:
+			if outcomes[1] == nil && !race.decided() {
+				fmt.Printf("[INFO] ⏱️ 对冲请求: %s 在 %.1fs 内未响应，同时请求 %s\n",
+					primary.provider.Name, delay.Seconds(), peer.provider.Name)
+				if launch(1) {
+					pending++
+				}
+			}
+		}
+	}
+
+	launched := make([]hedgeOutcome, 0, len(outcomes))
+	for _, outcome := range outcomes {
+		if outcome != nil {
+			launched = append(launched, *outcome)
+		}
+	}
+	return launched
+}
+
+// settleHedgeOutcomes 处理对冲竞速结果：胜者清零失败计数（流中途停滞则计入失败）并更新亲和缓存，真正失败的尝试计入失败次数
+// 返回胜者（没有时为 nil）以及最后一个失败尝试
+func (prs *ProviderRelayService) settleHedgeOutcomes(kind string, affinityKey string, outcomes []hedgeOutcome) (winner *hedgeOutcome, lastFailure *hedgeOutcome) {
+	for i := range outcomes {
+		outcome := &outcomes[i]
+		name := outcome.target.provider.Name
+		switch {
+		case outcome.ok:
+			fmt.Printf("[INFO]   ✓ 对冲成功: %s | 耗时: %.2fs\n", name, outcome.duration.Seconds())
+			if prs.affinityManager != nil {
+				prs.affinityManager.Set(affinityKey, name)
+			}
+			prs.recordDelivered(kind, name, outcome.target.model, outcome.err)
+			prs.setLastUsedProvider(kind, name)
+			winner = outcome
+		case errors.Is(outcome.err, errHedgeCancelled):
+			fmt.Printf("[INFO]   对冲落败已取消: %s | 耗时: %.2fs\n", name, outcome.duration.Seconds())
+		case errors.Is(outcome.err, errClientAbort):
+			fmt.Printf("[INFO] 客户端中断，跳过失败计数: %s\n", name)
+			lastFailure = outcome
+		case errors.Is(outcome.err, errProviderSkipped):
+			fmt.Printf("[INFO] 并发已满或限流用尽，跳过失败计数: %s\n", name)
+			lastFailure = outcome
+		default:
+			errorMsg := "未知错误"
+			if outcome.err != nil {
+				errorMsg = outcome.err.Error()
+			}
+			fmt.Printf("[WARN]   ✗ 对冲尝试失败: %s | 错误: %s | 耗时: %.2fs\n", name, errorMsg, outcome.duration.Seconds())
+			failure := prs.recordProviderFailure(kind, name, outcome.target.model, outcome.err)
+			outcome.failure = &failure
+			lastFailure = outcome
+		}
+	}
+	return winner, lastFailure
+}
@@ -0,0 +1,234 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ==================== 对冲竞速状态测试 ====================
+
+func TestHedgeRace_Claim(t *testing.T) {
+	race := newHedgeRace()
+	ctx0, cancel0 := context.WithCancel(context.Background())
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	defer cancel1()
+
+	if !race.register(0, cancel0) {
+		t.Fatal("首次登记应成功")
+	}
+	if race.hedged() {
+		t.Error("只有一次尝试时不应视为对冲")
+	}
+	if !race.register(1, cancel1) {
+		t.Fatal("决出胜者前登记应成功")
+	}
+	if !race.hedged() {
+		t.Error("两次尝试时应视为对冲")
+	}
+
+	primary := &hedgeAttempt{race: race, index: 0, ctx: ctx0}
+	peer := &hedgeAttempt{race: race, index: 1, ctx: ctx1}
+
+	if !peer.claim() {
+		t.Fatal("peer 应成为胜者")
+	}
+	if !peer.claim() {
+		t.Error("胜者重复 claim 应返回 true")
+	}
+	if primary.claim() {
+		t.Error("落败方 claim 应返回 false")
+	}
+	if !primary.lost() || peer.lost() {
+		t.Errorf("lost 状态错误: primary=%v peer=%v", primary.lost(), peer.lost())
+	}
+	if ctx0.Err() == nil {
+		t.Error("落败方的 context 应被取消")
+	}
+	if ctx1.Err() != nil {
+		t.Error("胜者的 context 不应被取消")
+	}
+	if race.register(2, func() {}) {
+		t.Error("决出胜者后不应再发起新的尝试")
+	}
+}
+
+func TestNextHedgePeer(t *testing.T) {
+	providers := []Provider{{Name: "a"}, {Name: "b"}, {Name: "c"}}
+
+	tests := []struct {
+		name     string
+		current  int
+		skip     map[string]bool
+		expected int
+	}{
+		{"下一个可用", 0, nil, 1},
+		{"跳过已尝试", 0, map[string]bool{"b": true}, 2},
+		{"最后一个没有对冲对象", 2, nil, -1},
+		{"剩余全部跳过", 1, map[string]bool{"c": true}, -1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := nextHedgePeer(providers, tt.current, tt.skip); got != tt.expected {
+				t.Errorf("期望 %d，实际 %d", tt.expected, got)
+			}
+		})
+	}
+}
+
+// ==================== 对冲转发测试 ====================
+
+func newHedgeTestContext() (*gin.Context, *httptest.ResponseRecorder) {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("POST", "/v1/messages", strings.NewReader(`{"model":"claude-sonnet-4"}`))
+	return c, w
+}
+
+func newHedgeTarget(name string, url string) forwardTarget {
+	return forwardTarget{
+		provider:   Provider{Name: name, APIURL: url, APIKey: "k", Enabled: true},
+		endpoint:   "/v1/messages",
+		body:       []byte(`{"model":"claude-sonnet-4"}`),
+		model:      "claude-sonnet-4",
+		authMethod: AuthMethodXAPIKey,
+	}
+}
+
+// TestForwardHedged_PeerWins primary 超过对冲延迟未响应时，先响应的 peer 胜出并取消 primary
+func TestForwardHedged_PeerWins(t *testing.T) {
+	slowServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.ReadAll(r.Body) // 读完请求体后服务端才能感知客户端断开
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+			w.Write([]byte(`{"from":"slow"}`))
+		}
+	}))
+	defer slowServer.Close()
+	fastServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"from":"fast"}`))
+	}))
+	defer fastServer.Close()
+
+	relayService := NewProviderRelayService(NewProviderService(), nil, nil, nil, nil, "")
+	c, w := newHedgeTestContext()
+
+	start := time.Now()
+	outcomes := relayService.forwardHedged(c, "claude",
+		newHedgeTarget("slow", slowServer.URL), newHedgeTarget("fast", fastServer.URL),
+		nil, http.Header{}, false, 50*time.Millisecond)
+
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Fatalf("落败的 primary 未被及时取消，耗时 %v", elapsed)
+	}
+	if len(outcomes) != 2 {
+		t.Fatalf("期望发起 2 次尝试，实际 %d", len(outcomes))
+	}
+	if outcomes[0].ok || !errors.Is(outcomes[0].err, errHedgeCancelled) {
+		t.Errorf("primary 应以 errHedgeCancelled 结束，实际 ok=%v err=%v", outcomes[0].ok, outcomes[0].err)
+	}
+	if !outcomes[1].ok {
+		t.Errorf("peer 应成功，实际 err=%v", outcomes[1].err)
+	}
+	if body := w.Body.String(); body != `{"from":"fast"}` {
+		t.Errorf("客户端应只收到胜者的响应，实际: %s", body)
+	}
+}
+
+// TestForwardHedged_PrimaryWithinDelay primary 在对冲延迟内响应时不发起对冲
+func TestForwardHedged_PrimaryWithinDelay(t *testing.T) {
+	peerCalled := make(chan struct{}, 1)
+	primaryServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"from":"primary"}`))
+	}))
+	defer primaryServer.Close()
+	peerServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		peerCalled <- struct{}{}
+		w.Write([]byte(`{"from":"peer"}`))
+	}))
+	defer peerServer.Close()
+
+	relayService := NewProviderRelayService(NewProviderService(), nil, nil, nil, nil, "")
+	c, w := newHedgeTestContext()
+
+	outcomes := relayService.forwardHedged(c, "claude",
+		newHedgeTarget("primary", primaryServer.URL), newHedgeTarget("peer", peerServer.URL),
+		nil, http.Header{}, false, 2*time.Second)
+
+	if len(outcomes) != 1 || !outcomes[0].ok {
+		t.Fatalf("期望只有 primary 一次成功尝试，实际 %+v", outcomes)
+	}
+	select {
+	case <-peerCalled:
+		t.Error("primary 在延迟内响应时不应请求 peer")
+	default:
+	}
+	if body := w.Body.String(); body != `{"from":"primary"}` {
+		t.Errorf("响应错误: %s", body)
+	}
+}
+
+// TestProxyHandler_HedgePeerNotLaunched primary 在对冲延迟前失败时 peer 未被发起，故障转移仍应尝试它
+func TestProxyHandler_HedgePeerNotLaunched(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+
+	badServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error":"boom"}`))
+	}))
+	defer badServer.Close()
+	goodCalls := 0
+	goodServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		goodCalls++
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"from":"good"}`))
+	}))
+	defer goodServer.Close()
+
+	// 对冲只在降级模式下生效
+	settingsService := NewSettingsService()
+	levelConfig := DefaultBlacklistLevelConfig()
+	levelConfig.FallbackMode = "none"
+	if err := settingsService.SaveBlacklistLevelConfig(levelConfig); err != nil {
+		t.Fatalf("保存拉黑配置失败: %v", err)
+	}
+	blacklistService := NewBlacklistService(settingsService, NewNotificationService(nil))
+	relayService := NewProviderRelayService(NewProviderService(), nil, blacklistService, nil, nil, "")
+	relayService.appSettings = &AppSettingsService{path: filepath.Join(t.TempDir(), "app.json")}
+	if _, err := relayService.appSettings.SaveAppSettings(AppSettings{EnableHedging: true, HedgeDelayMs: 60000}); err != nil {
+		t.Fatalf("保存配置失败: %v", err)
+	}
+	if relayService.hedgeDelay() <= 0 {
+		t.Fatalf("降级模式下应开启对冲")
+	}
+	if err := relayService.providerService.SaveProviders("claude", []Provider{
+		{ID: 1, Name: "bad", APIURL: badServer.URL, APIKey: "k", Enabled: true, Level: 1},
+		{ID: 2, Name: "good", APIURL: goodServer.URL, APIKey: "k", Enabled: true, Level: 1},
+	}); err != nil {
+		t.Fatalf("保存 provider 失败: %v", err)
+	}
+
+	c, w := newHedgeTestContext()
+	relayService.proxyHandler("claude", "/v1/messages")(c)
+
+	if w.Code != http.StatusOK || w.Body.String() != `{"from":"good"}` {
+		t.Errorf("primary 失败后应故障转移到 good，实际 %d %s", w.Code, w.Body.String())
+	}
+	if goodCalls != 1 {
+		t.Errorf("good 应被请求 1 次，实际 %d", goodCalls)
+	}
+}
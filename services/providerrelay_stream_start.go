@@ -0,0 +1,194 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+// streamStartBufferLimit 等待首个内容事件时最多缓冲的字节数，超过后不再等待直接放行
+const streamStartBufferLimit = 256 * 1024
+
+// errStreamBeforeContent 上游返回 2xx 后，流在首个内容事件前出错或结束（计入 provider 失败并故障转移）
+var errStreamBeforeContent = errors.New("upstream stream failed before first content")
+
+// awaitStreamContent 缓冲流式响应的开头，直到收到第一个真正的内容事件
+// 上游返回 2xx 后可能不发任何数据就断开，或把 event: error（如 overloaded）作为第一个事件；
+// 这些情况发生在响应头写给客户端之前，因此仍可透明地切换到下一个 provider
+// 成功时将已缓冲的数据放回 httpResp.Body；失败时把已读取的内容写入 collector 便于请求详情排查
+func awaitStreamContent(httpResp *http.Response, collector *strings.Builder) error {
+	if httpResp.Body == nil {
+		return fmt.Errorf("%w: 响应体为空", errStreamBeforeContent)
+	}
+	// 上游未按 SSE 返回（如忽略 stream 参数返回 JSON）时不做缓冲
+	if contentType := httpResp.Header.Get("Content-Type"); contentType != "" && !strings.Contains(contentType, "event-stream") {
+		return nil
+	}
+	// 压缩的响应体（客户端的 Accept-Encoding 原样转发时）无法按行解析，不做缓冲
+	if encoding := httpResp.Header.Get("Content-Encoding"); encoding != "" && !strings.EqualFold(encoding, "identity") {
+		return nil
+	}
+
+	reader := bufio.NewReaderSize(httpResp.Body, responseBufferSize)
+	var buffered bytes.Buffer
+	var eventName string
+	var data strings.Builder
+
+	fail := func(err error) error {
+		if collector != nil {
+			collector.Write(buffered.Bytes())
+		}
+		return err
+	}
+
+	for buffered.Len() < streamStartBufferLimit {
+		line, readErr := reader.ReadBytes('\n')
+		buffered.Write(line)
+
+		trimmed := strings.TrimRight(string(line), "\r\n")
+		switch {
+		case trimmed == "" && len(line) > 0:
+			// 空行：一个事件结束
+			started, eventErr := classifyStreamStartEvent(eventName, data.String())
+			if eventErr != nil {
+				return fail(eventErr)
+			}
+			if started {
+				return restoreStreamBody(httpResp, &buffered, reader)
+			}
+			eventName = ""
+			data.Reset()
+		case strings.HasPrefix(trimmed, "event:"):
+			eventName = strings.TrimSpace(strings.TrimPrefix(trimmed, "event:"))
+		case strings.HasPrefix(trimmed, "data:"):
+			if data.Len() > 0 {
+				data.WriteByte('\n')
+			}
+			data.WriteString(strings.TrimSpace(strings.TrimPrefix(trimmed, "data:")))
+		}
+
+		if readErr != nil {
+			if readErr != io.EOF {
+				return fail(fmt.Errorf("%w: %v", errStreamBeforeContent, readErr))
+			}
+			// 最后一个事件可能没有结尾空行
+			started, eventErr := classifyStreamStartEvent(eventName, data.String())
+			if eventErr != nil {
+				return fail(eventErr)
+			}
+			if started {
+				return restoreStreamBody(httpResp, &buffered, reader)
+			}
+			return fail(fmt.Errorf("%w: 上游流在首个内容前结束", errStreamBeforeContent))
+		}
+	}
+
+	fmt.Printf("[WARN] 流式响应开头超过 %d 字节仍未出现内容事件，直接放行\n", streamStartBufferLimit)
+	return restoreStreamBody(httpResp, &buffered, reader)
+}
+
+// acceptUpstreamResponse 在响应头写给客户端之前确认 2xx 响应可用
+// 流式响应等到首个内容事件；参与对冲竞速时还需抢到胜者资格
+func acceptUpstreamResponse(c *gin.Context, attempt *hedgeAttempt, httpResp *http.Response, isStream bool, collector *strings.Builder) error {
+	if isStream {
+		if err := awaitStreamContent(httpResp, collector); err != nil {
+			if attempt != nil && attempt.lost() {
+				return errHedgeCancelled
+			}
+			if c.Request.Context().Err() != nil {
+				return fmt.Errorf("%w: %v", errClientAbort, err)
+			}
+			return err
+		}
+	}
+	if attempt != nil && !attempt.claim() {
+		return errHedgeCancelled
+	}
+	return nil
+}
+
+// restoreStreamBody 把已缓冲的数据接回响应体，原始 Body 仍由调用方关闭
+func restoreStreamBody(httpResp *http.Response, buffered *bytes.Buffer, rest io.Reader) error {
+	httpResp.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(buffered.Bytes()), rest), httpResp.Body}
+	return nil
+}
+
+// classifyStreamStartEvent 判断一个 SSE 事件是否意味着流已真正开始输出
+// 兼容 Anthropic、Chat Completions、Responses、Gemini 四种上游格式：
+// - 错误事件（event: error、response.failed、顶层 error 字段）返回错误
+// - 内容增量或正常结束事件返回 true
+// - message_start、ping、response.created、只有 role 的 chunk 等元数据事件返回 false，继续等待
+func classifyStreamStartEvent(eventName string, data string) (bool, error) {
+	if eventName == "" && data == "" {
+		return false, nil
+	}
+	if data == "[DONE]" {
+		return true, nil
+	}
+
+	payload := gjson.Parse(data)
+	eventType := eventName
+	if eventType == "" {
+		eventType = payload.Get("type").String()
+	}
+
+	switch eventType {
+	case "error", "response.failed", "response.error":
+		return false, streamStartError(payload, data)
+	case "message_delta", "message_stop", "response.completed", "response.incomplete":
+		// 正常结束（可能没有任何文本，如 max_tokens=1 的探测请求）
+		return true, nil
+	}
+	if strings.HasSuffix(eventType, ".delta") || strings.HasSuffix(eventType, "_delta") {
+		return true, nil
+	}
+
+	if payload.Get("error").Exists() {
+		return false, streamStartError(payload, data)
+	}
+
+	// Chat Completions：delta 中出现内容、推理（reasoning_content 或 OpenRouter 风格的 reasoning）或工具调用，或带有 finish_reason
+	if choice := payload.Get("choices.0"); choice.Exists() {
+		delta := choice.Get("delta")
+		if delta.Get("content").String() != "" || delta.Get("reasoning_content").String() != "" || delta.Get("reasoning").String() != "" ||
+			delta.Get("tool_calls").Exists() || choice.Get("finish_reason").String() != "" {
+			return true, nil
+		}
+		return false, nil
+	}
+
+	// Gemini：candidates 中出现 parts 或 finishReason
+	if candidate := payload.Get("candidates.0"); candidate.Exists() {
+		return candidate.Get("content.parts.#").Int() > 0 || candidate.Get("finishReason").String() != "", nil
+	}
+
+	return false, nil
+}
+
+// streamStartError 从错误事件中提取上游错误信息
+func streamStartError(payload gjson.Result, data string) error {
+	message := payload.Get("error.message").String()
+	if message == "" {
+		message = payload.Get("response.error.message").String()
+	}
+	if message == "" {
+		message = data
+		if len(message) > 500 {
+			message = message[:500] + "...(truncated)"
+		}
+	}
+	if errType := payload.Get("error.type").String(); errType != "" {
+		message = errType + ": " + message
+	}
+	return fmt.Errorf("%w: %s", errStreamBeforeContent, message)
+}
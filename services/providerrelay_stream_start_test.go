@@ -0,0 +1,148 @@
+package services
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// ==================== 流式首包缓冲测试 ====================
+
+func TestAwaitStreamContent(t *testing.T) {
+	tests := []struct {
+		name            string
+		contentType     string
+		contentEncoding string
+		body            string
+		expectErr       string // 为空表示期望成功
+	}{
+		{
+			name:        "Anthropic 正常流",
+			contentType: "text/event-stream",
+			body: "event: message_start\ndata: {\"type\":\"message_start\",\"message\":{\"id\":\"msg_1\"}}\n\n" +
+				"event: ping\ndata: {\"type\":\"ping\"}\n\n" +
+				"event: content_block_start\ndata: {\"type\":\"content_block_start\",\"index\":0,\"content_block\":{\"type\":\"text\",\"text\":\"\"}}\n\n" +
+				"event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"text_delta\",\"text\":\"hi\"}}\n\n" +
+				"event: message_stop\ndata: {\"type\":\"message_stop\"}\n\n",
+		},
+		{
+			name:        "Anthropic 首个事件为 overloaded",
+			contentType: "text/event-stream",
+			body:        "event: error\ndata: {\"type\":\"error\",\"error\":{\"type\":\"overloaded_error\",\"message\":\"Overloaded\"}}\n\n",
+			expectErr:   "overloaded_error: Overloaded",
+		},
+		{
+			name:        "message_start 后断开",
+			contentType: "text/event-stream",
+			body:        "event: message_start\ndata: {\"type\":\"message_start\"}\n\n",
+			expectErr:   "首个内容前结束",
+		},
+		{
+			name:        "空流",
+			contentType: "text/event-stream",
+			body:        "",
+			expectErr:   "首个内容前结束",
+		},
+		{
+			name:        "Chat Completions 先发 role 再发内容",
+			contentType: "text/event-stream",
+			body: "data: {\"choices\":[{\"index\":0,\"delta\":{\"role\":\"assistant\"}}]}\n\n" +
+				"data: {\"choices\":[{\"index\":0,\"delta\":{\"content\":\"hi\"}}]}\n\n" +
+				"data: [DONE]\n\n",
+		},
+		{
+			name:        "Chat Completions 先发 reasoning（OpenRouter）",
+			contentType: "text/event-stream",
+			body: "data: {\"choices\":[{\"index\":0,\"delta\":{\"role\":\"assistant\",\"reasoning\":\"\"}}]}\n\n" +
+				"data: {\"choices\":[{\"index\":0,\"delta\":{\"reasoning\":\"Let me think\"}}]}\n\n",
+		},
+		{
+			name:        "Chat Completions 错误 chunk",
+			contentType: "text/event-stream",
+			body:        "data: {\"error\":{\"message\":\"upstream busy\",\"type\":\"server_error\"}}\n\n",
+			expectErr:   "upstream busy",
+		},
+		{
+			name:        "Responses 失败事件",
+			contentType: "text/event-stream",
+			body: "event: response.created\ndata: {\"type\":\"response.created\"}\n\n" +
+				"event: response.failed\ndata: {\"type\":\"response.failed\",\"response\":{\"error\":{\"message\":\"quota exceeded\"}}}\n\n",
+			expectErr: "quota exceeded",
+		},
+		{
+			name:        "Gemini 内容块（结尾无空行）",
+			contentType: "text/event-stream",
+			body:        "data: {\"candidates\":[{\"content\":{\"parts\":[{\"text\":\"hi\"}]}}]}",
+		},
+		{
+			name:        "非 SSE 响应不缓冲",
+			contentType: "application/json",
+			body:        "",
+		},
+		{
+			name:            "压缩的 SSE 响应不缓冲",
+			contentType:     "text/event-stream",
+			contentEncoding: "gzip",
+			body:            "\x1f\x8b\x08\x00\x00\x00\x00\x00",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := &http.Response{
+				Header: http.Header{"Content-Type": []string{tt.contentType}},
+				Body:   io.NopCloser(strings.NewReader(tt.body)),
+			}
+			if tt.contentEncoding != "" {
+				resp.Header.Set("Content-Encoding", tt.contentEncoding)
+			}
+			collector := &strings.Builder{}
+			err := awaitStreamContent(resp, collector)
+
+			if tt.expectErr != "" {
+				if !errors.Is(err, errStreamBeforeContent) || !strings.Contains(err.Error(), tt.expectErr) {
+					t.Fatalf("期望错误包含 %q，实际: %v", tt.expectErr, err)
+				}
+				if collector.String() != tt.body {
+					t.Errorf("失败时应把已读取内容写入 collector，实际: %q", collector.String())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("不应返回错误: %v", err)
+			}
+			// 已缓冲的数据必须原样放回响应体
+			body, _ := io.ReadAll(resp.Body)
+			if string(body) != tt.body {
+				t.Errorf("响应体被改变:\n期望: %q\n实际: %q", tt.body, string(body))
+			}
+		})
+	}
+}
+
+// TestForwardRequest_StreamErrorBeforeContent 2xx 流在首个内容前出错时判定失败，且不向客户端写出任何数据
+func TestForwardRequest_StreamErrorBeforeContent(t *testing.T) {
+	upstreamServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("event: message_start\ndata: {\"type\":\"message_start\"}\n\n" +
+			"event: error\ndata: {\"type\":\"error\",\"error\":{\"type\":\"overloaded_error\",\"message\":\"Overloaded\"}}\n\n"))
+	}))
+	defer upstreamServer.Close()
+
+	relayService := NewProviderRelayService(NewProviderService(), nil, nil, nil, nil, "")
+	c, w := newHedgeTestContext()
+	provider := Provider{Name: "overloaded", APIURL: upstreamServer.URL, APIKey: "k", Enabled: true}
+
+	ok, err := relayService.forwardRequest(c, "claude", provider, "/v1/messages", nil, http.Header{},
+		[]byte(`{"model":"claude-sonnet-4","stream":true}`), true, "claude-sonnet-4", AuthMethodXAPIKey)
+
+	if ok || !errors.Is(err, errStreamBeforeContent) {
+		t.Fatalf("期望以 errStreamBeforeContent 失败，实际 ok=%v err=%v", ok, err)
+	}
+	if c.Writer.Written() || w.Body.Len() > 0 {
+		t.Errorf("失败前不应向客户端写出数据，实际: %q", w.Body.String())
+	}
+}
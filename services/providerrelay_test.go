@@ -3,6 +3,7 @@ package services
 import (
 	"encoding/json"
 	"testing"
+	"time"
 
 	"github.com/tidwall/gjson"
 )
@@ -253,6 +254,48 @@ func TestProviderConfigValidation(t *testing.T) {
 	}
 }
 
+// ==================== 候选 provider 过滤测试 ====================
+
+func TestCandidateSkipReason(t *testing.T) {
+	blacklistService := NewBlacklistService(NewSettingsService(), NewNotificationService(nil))
+	relayService := NewProviderRelayService(NewProviderService(), nil, blacklistService, nil, nil, "")
+	blacklistService.RecordCooldown("claude", "cooling", "", time.Minute, statusOverloaded)
+
+	base := Provider{Name: "ok", APIURL: "https://api.example.com", APIKey: "k", Enabled: true}
+	tests := []struct {
+		name     string
+		mutate   func(p *Provider)
+		model    string
+		tokens   int
+		expected skipReason
+	}{
+		{"可用", func(p *Provider) {}, "claude-sonnet-4", 1000, skipNone},
+		{"未启用", func(p *Provider) { p.Enabled = false }, "claude-sonnet-4", 1000, skipDisabled},
+		{"缺少 key", func(p *Provider) { p.APIKey = "" }, "claude-sonnet-4", 1000, skipDisabled},
+		{"不支持模型", func(p *Provider) { p.SupportedModels = map[string]bool{"claude-opus-4": true} }, "claude-sonnet-4", 1000, skipModelUnsupported},
+		{"上下文窗口不足", func(p *Provider) { p.MaxInputTokens = 8000 }, "claude-sonnet-4", 10000, skipContextTooSmall},
+		{"冷却中", func(p *Provider) { p.Name = "cooling" }, "claude-sonnet-4", 1000, skipCoolingDown},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := base
+			tt.mutate(&provider)
+			reason, rejected := relayService.candidateSkipReason("claude", "", &provider, tt.model, tt.tokens)
+			if reason != tt.expected || rejected != nil {
+				t.Errorf("期望跳过原因 %d，实际 %d（rejected=%v）", tt.expected, reason, rejected)
+			}
+		})
+	}
+
+	var reasons skipReasons
+	reasons.add(skipDisabled)
+	reasons.add(skipCoolingDown)
+	reasons.add(skipNone)
+	if reasons.disabled != 1 || reasons.coolingDown != 1 || reasons.total() != 2 {
+		t.Errorf("跳过原因计数不符合预期: %+v", reasons)
+	}
+}
+
 // ==================== 性能测试 ====================
 
 func BenchmarkIsModelSupported(b *testing.B) {
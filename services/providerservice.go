@@ -29,11 +29,29 @@ type Provider struct {
 	Accent  string `json:"accent"`
 	Enabled bool   `json:"enabled"`
 
+	// key 池 - 同一厂商的多个 key（各有独立额度），配置后按权重轮换，替代 APIKey
+	// 单个 key 认证失败或被限流时只暂停该 key，不拉黑整个 provider；request_log 按 key 标签记录用量
+	APIKeys []APIKeyEntry `json:"apiKeys,omitempty"`
+
 	// API 端点路径（可选）- 覆盖平台默认端点
 	// 如：GLM 模型需要使用 /v1/chat/completions 而非 /v1/messages
 	// 留空则使用平台默认（claude: /v1/messages, codex: /responses）
+	// Claude 入口指向 */chat/completions 时，中转会自动做 Anthropic <-> Chat Completions 协议转换
 	APIEndpoint string `json:"apiEndpoint,omitempty"`
 
+	// 出站网络配置（可选）- HTTP/HTTPS/SOCKS5 代理、额外 CA、客户端证书、SNI 覆盖、固定 IP
+	// 转发、健康检查、连通性测试与余额查询都使用该配置，每种配置组合使用独立的连接池
+	Network *NetworkConfig `json:"network,omitempty"`
+
+	// 上游超时（可选）- 连接、首字节、流式空闲间隔与总超时，路由规则可按字段覆盖
+	// 流式响应超过空闲超时未收到数据时中断并按 stall 分类计入失败，避免上游中途停滞让客户端无限等待
+	Timeouts *TimeoutConfig `json:"timeouts,omitempty"`
+
+	// 上游协议格式（可选）- anthropic / openai-chat / openai-responses
+	// 与客户端协议不同时，中转自动做请求/响应转换（如 Codex 使用仅支持 Chat Completions 的供应商）
+	// 留空则自动判断：与平台协议一致，Claude 入口的端点指向 */chat/completions 时视为 openai-chat
+	WireFormat string `json:"wireFormat,omitempty"`
+
 	// 模型白名单 - Provider 原生支持的模型名
 	// 使用 map 实现 O(1) 查找，向后兼容（omitempty）
 	SupportedModels map[string]bool `json:"supportedModels,omitempty"`
@@ -42,10 +60,44 @@ type Provider struct {
 	// 支持精确匹配和通配符（如 "claude-*" -> "anthropic/claude-*"）
 	ModelMapping map[string]string `json:"modelMapping,omitempty"`
 
+	// 响应模型名还原 - 将响应中的 model 字段（JSON 响应体与流式 message_start 等事件）改写回客户端请求的模型名
+	// 用例：映射到 anthropic/claude-sonnet-4 或厂商模型后，客户端显示与费用统计脚本仍使用原模型名
+	RewriteResponseModel bool `json:"rewriteResponseModel,omitempty"`
+
 	// 优先级分组 - 数字越小优先级越高（1-10，默认 1）
 	// 使用 omitempty 确保零值不序列化，向后兼容
 	Level int `json:"level,omitempty"`
 
+	// 标签 - 供路由规则按标签选择 provider（如 long-context、cheap）
+	Tags []string `json:"tags,omitempty"`
+
+	// 负载均衡权重 - 同 Level 使用加权随机 / 最少并发策略时按比例分配请求（默认 1）
+	// 如三个中转账号额度为 3:2:1，可分别设置 weight 为 3、2、1
+	Weight int `json:"weight,omitempty"`
+
+	// 价格倍率 - 相对官方定价的折扣（如中转按官方价 3 折计费则填 0.3，默认 1）
+	// 同 Level 使用成本优先策略时，按 映射后模型的官方单价 × 倍率 估算费用并优先使用便宜的 provider
+	PriceMultiplier float64 `json:"priceMultiplier,omitempty"`
+
+	// 最大并发数 - 超过后新请求排队等待或直接切换到下一个 provider（0 = 不限制）
+	// 用例：中转账号只允许 2-3 个并发流，超出会返回 429 并被误判为故障
+	MaxConcurrency int `json:"maxConcurrency,omitempty"`
+
+	// 并发排队长度 - 并发已满时最多排队等待的请求数（0 = 不排队，直接切换到下一个 provider）
+	ConcurrencyQueueSize int `json:"concurrencyQueueSize,omitempty"`
+
+	// 并发排队超时（秒）- 排队超过该时间仍未轮到则切换到下一个 provider（默认 30）
+	ConcurrencyQueueTimeout int `json:"concurrencyQueueTimeout,omitempty"`
+
+	// 最大输入 tokens - provider 实际支持的上下文窗口（0 = 按内置模型表 max_input_tokens）
+	// 估算的请求输入超过该值与模型上限中较小者时跳过该 provider，避免长会话在不支持的 provider 间反复失败
+	MaxInputTokens int `json:"maxInputTokens,omitempty"`
+
+	// 客户端侧限流 - 按 provider + 实际模型分别维护 RPM / 输入 TPM 令牌桶，用尽时跳过该 provider
+	// ModelRateLimits 按映射后的实际模型单独配置（支持通配符），未匹配时使用 RateLimit
+	RateLimit       *RateLimit           `json:"rateLimit,omitempty"`
+	ModelRateLimits map[string]RateLimit `json:"modelRateLimits,omitempty"`
+
 	// ========== 可用性监控字段（新增 v0.5.0） ==========
 
 	// 可用性监控开关 - 在可用性页面配置
@@ -60,6 +112,10 @@ type Provider struct {
 	// 可用性高级配置 - 可选，在可用性页面的"高级配置"中设置
 	AvailabilityConfig *AvailabilityConfig `json:"availabilityConfig,omitempty"`
 
+	// 余额查询 - 可选，后台定期请求中转站的余额接口并记录历史
+	// 配置 MinBalance 后，余额低于该值时中转会跳过该 provider
+	BalanceProbe *BalanceProbe `json:"balanceProbe,omitempty"`
+
 	// 认证方式 - bearer / x-api-key / 自定义 Header 名
 	// 空值时使用平台默认（claude: x-api-key, codex: bearer）
 	ConnectivityAuthType string `json:"connectivityAuthType,omitempty"`
@@ -80,6 +136,13 @@ type Provider struct {
 	// 用例：移除某些 Provider 不支持或会导致问题的 Header（如 X-Forwarded-For）
 	StripHeaders []string `json:"stripHeaders,omitempty"`
 
+	// ========== 请求体改写 ==========
+
+	// BodyRules - 请求体改写规则（按顺序执行，在模型映射与协议转换之后、发送前应用）
+	// 用例：移除中转不支持的字段（tools 上的 cache_control、thinking.budget_tokens、metadata）
+	// 或从 anthropic-beta 中剔除特定 beta
+	BodyRules []BodyRule `json:"bodyRules,omitempty"`
+
 	// ========== 旧字段（已废弃，仅用于读取迁移） ==========
 	// 这些字段在保存时不再写入，但读取时会自动迁移到新字段
 
@@ -406,17 +469,26 @@ func (ps *ProviderService) DuplicateProvider(kind string, sourceID int64) (*Prov
 
 	// 5. 克隆配置（深拷贝）
 	cloned := &Provider{
-		ID:      newID,
-		Name:    source.Name + " (副本)",
-		APIURL:  source.APIURL,
-		APIKey:  source.APIKey,
-		Site:    source.Site,
-		Icon:    source.Icon,
-		Tint:    source.Tint,
-		Accent:  source.Accent,
-		Enabled: false, // 默认禁用，避免与源供应商冲突
-		Level:   source.Level,
-	APIEndpoint: source.APIEndpoint, // 复制端点配置
+		ID:              newID,
+		Name:            source.Name + " (副本)",
+		APIURL:          source.APIURL,
+		APIKey:          source.APIKey,
+		Site:            source.Site,
+		Icon:            source.Icon,
+		Tint:            source.Tint,
+		Accent:          source.Accent,
+		Enabled:         false, // 默认禁用，避免与源供应商冲突
+		Level:           source.Level,
+		Weight:          source.Weight,
+		PriceMultiplier: source.PriceMultiplier,
+		MaxConcurrency:  source.MaxConcurrency,
+		MaxInputTokens:  source.MaxInputTokens,
+		// 并发排队配置
+		ConcurrencyQueueSize:    source.ConcurrencyQueueSize,
+		ConcurrencyQueueTimeout: source.ConcurrencyQueueTimeout,
+		APIEndpoint:             source.APIEndpoint, // 复制端点配置
+		WireFormat:              source.WireFormat,
+		RewriteResponseModel:    source.RewriteResponseModel,
 		// 可用性监控配置
 		AvailabilityMonitorEnabled: source.AvailabilityMonitorEnabled,
 		ConnectivityAutoBlacklist:  false, // 副本默认关闭自动拉黑
@@ -446,6 +518,60 @@ func (ps *ProviderService) DuplicateProvider(kind string, sourceID int64) (*Prov
 		}
 	}
 
+	if source.Tags != nil {
+		cloned.Tags = append([]string(nil), source.Tags...)
+	}
+
+	if source.APIKeys != nil {
+		cloned.APIKeys = append([]APIKeyEntry(nil), source.APIKeys...)
+	}
+
+	// 深拷贝请求体改写规则
+	if source.BodyRules != nil {
+		cloned.BodyRules = make([]BodyRule, len(source.BodyRules))
+		for i, rule := range source.BodyRules {
+			rule.Value = append(json.RawMessage(nil), rule.Value...)
+			rule.Items = append([]string(nil), rule.Items...)
+			cloned.BodyRules[i] = rule
+		}
+	}
+
+	// 深拷贝网络配置
+	if source.Network != nil {
+		network := *source.Network
+		cloned.Network = &network
+	}
+
+	// 深拷贝超时配置
+	if source.Timeouts != nil {
+		timeouts := *source.Timeouts
+		cloned.Timeouts = &timeouts
+	}
+
+	// 深拷贝余额查询配置
+	if source.BalanceProbe != nil {
+		balanceProbe := *source.BalanceProbe
+		if source.BalanceProbe.Headers != nil {
+			balanceProbe.Headers = make(map[string]string, len(source.BalanceProbe.Headers))
+			for k, v := range source.BalanceProbe.Headers {
+				balanceProbe.Headers[k] = v
+			}
+		}
+		cloned.BalanceProbe = &balanceProbe
+	}
+
+	// 深拷贝限流配置
+	if source.RateLimit != nil {
+		rateLimit := *source.RateLimit
+		cloned.RateLimit = &rateLimit
+	}
+	if source.ModelRateLimits != nil {
+		cloned.ModelRateLimits = make(map[string]RateLimit, len(source.ModelRateLimits))
+		for k, v := range source.ModelRateLimits {
+			cloned.ModelRateLimits[k] = v
+		}
+	}
+
 	// 7. 添加到列表并保存（使用内部方法避免死锁）
 	providers = append(providers, *cloned)
 	if err := ps.saveProvidersLocked(kind, providers); err != nil {
@@ -525,6 +651,10 @@ func (p *Provider) GetEffectiveModel(requestedModel string) string {
 func (p *Provider) GetEffectiveEndpoint(defaultEndpoint string) string {
 	ep := strings.TrimSpace(p.APIEndpoint)
 	if ep == "" {
+		// 显式指定了上游协议时，使用该协议的标准端点
+		if wireEndpoint := defaultEndpointForWireFormat(WireFormat(p.WireFormat)); wireEndpoint != "" {
+			return wireEndpoint
+		}
 		return defaultEndpoint
 	}
 
@@ -585,6 +715,38 @@ func (p *Provider) ValidateConfiguration() []string {
 
 	// 规则 3 移除：自映射不会破坏功能，最多是无效配置，不阻塞保存
 
+	// 规则 4：上游协议格式必须是已知值
+	if p.WireFormat != "" && !isKnownWireFormat(WireFormat(p.WireFormat)) {
+		errors = append(errors, fmt.Sprintf("上游协议格式无效：'%s'", p.WireFormat))
+	}
+
+	// 规则 5：请求体改写规则必须合法
+	for i := range p.BodyRules {
+		if errMsg := p.BodyRules[i].Validate(); errMsg != "" {
+			errors = append(errors, errMsg)
+		}
+	}
+
+	// 规则 6：标签不能为空白
+	for _, tag := range p.Tags {
+		if strings.TrimSpace(tag) == "" {
+			errors = append(errors, "标签不能为空")
+			break
+		}
+	}
+
+	// 规则 7：key 池的标签唯一且 key 不能为空
+	errors = append(errors, p.validateAPIKeys()...)
+
+	// 规则 8：启用余额查询时接口地址与余额路径必须合法
+	errors = append(errors, p.BalanceProbe.Validate()...)
+
+	// 规则 9：代理地址、客户端证书与固定 IP 必须合法
+	errors = append(errors, p.Network.Validate()...)
+
+	// 规则 10：超时不能为负数，首字节超时不能大于总超时
+	errors = append(errors, p.Timeouts.Validate()...)
+
 	p.configErrors = errors
 	return errors
 }
@@ -2,6 +2,7 @@ package services
 
 import (
 	"encoding/json"
+	"reflect"
 	"sort"
 	"testing"
 )
@@ -845,3 +846,55 @@ func TestDuplicateProvider(t *testing.T) {
 		})
 	}
 }
+
+// TestDuplicateProvider_RoundTrip 通过 ProviderService 复制后重新加载，路由相关配置应完整保留
+func TestDuplicateProvider_RoundTrip(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+
+	ps := NewProviderService()
+	source := Provider{
+		ID:             1,
+		Name:           "relay",
+		APIURL:         "https://api.example.com",
+		APIKey:         "sk-test",
+		Enabled:        true,
+		Tags:           []string{"cheap", "long-context"},
+		MaxInputTokens: 128000,
+		BodyRules: []BodyRule{
+			{Op: BodyRuleSet, Path: "temperature", Value: json.RawMessage(`0.2`)},
+			{Op: BodyRuleRemove, Path: "metadata"},
+		},
+		RewriteResponseModel: true,
+	}
+	if err := ps.SaveProviders("claude", []Provider{source}); err != nil {
+		t.Fatalf("保存 provider 失败: %v", err)
+	}
+
+	cloned, err := ps.DuplicateProvider("claude", 1)
+	if err != nil {
+		t.Fatalf("复制失败: %v", err)
+	}
+	providers, err := ps.LoadProviders("claude")
+	if err != nil || len(providers) != 2 {
+		t.Fatalf("重新加载失败: %v（%d 个）", err, len(providers))
+	}
+	loaded := providers[1]
+	if loaded.ID != cloned.ID || loaded.Enabled {
+		t.Fatalf("副本应以新 ID 保存且默认禁用，实际 %+v", loaded)
+	}
+
+	if !reflect.DeepEqual(loaded.Tags, source.Tags) {
+		t.Errorf("Tags 未复制，期望 %v，实际 %v", source.Tags, loaded.Tags)
+	}
+	if loaded.MaxInputTokens != source.MaxInputTokens {
+		t.Errorf("MaxInputTokens 未复制，实际 %d", loaded.MaxInputTokens)
+	}
+	if !reflect.DeepEqual(loaded.BodyRules, source.BodyRules) {
+		t.Errorf("BodyRules 未复制，期望 %+v，实际 %+v", source.BodyRules, loaded.BodyRules)
+	}
+	if !loaded.RewriteResponseModel {
+		t.Errorf("RewriteResponseModel 未复制")
+	}
+}
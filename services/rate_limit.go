@@ -0,0 +1,204 @@
+package services
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// errRateLimited 本地 RPM / 输入 TPM 令牌桶已用尽，切换到下一个 provider，不计入失败次数
+var errRateLimited = fmt.Errorf("%w: local rate limit exhausted", errProviderSkipped)
+
+// RateLimit 客户端侧限流配置（按 provider + 实际模型分别计数）
+// 在请求发出前就避开供应商的 RPM / TPM 限制，而不是等上游返回 429
+type RateLimit struct {
+	RPM      int `json:"rpm,omitempty"`      // 每分钟请求数（0 = 不限制）
+	InputTPM int `json:"inputTpm,omitempty"` // 每分钟输入 tokens（0 = 不限制，含缓存创建，不含缓存读取）
+}
+
+// enabled 是否配置了任一限制
+func (r RateLimit) enabled() bool {
+	return r.RPM > 0 || r.InputTPM > 0
+}
+
+// rateLimitFor 返回指定实际模型的限流配置
+// 优先精确匹配 ModelRateLimits，其次通配符（按模式字典序），最后回退到 provider 级 RateLimit
+func (p *Provider) rateLimitFor(model string) RateLimit {
+	if limit, ok := p.ModelRateLimits[model]; ok {
+		return limit
+	}
+	if len(p.ModelRateLimits) > 0 {
+		patterns := make([]string, 0, len(p.ModelRateLimits))
+		for pattern := range p.ModelRateLimits {
+			patterns = append(patterns, pattern)
+		}
+		sort.Strings(patterns)
+		for _, pattern := range patterns {
+			if matchWildcard(pattern, model) {
+				return p.ModelRateLimits[pattern]
+			}
+		}
+	}
+	if p.RateLimit != nil {
+		return *p.RateLimit
+	}
+	return RateLimit{}
+}
+
+// tokenBucket 令牌桶：容量为每分钟额度，按 容量/60 每秒匀速补充
+// tokens 可以为负（真实用量超过预估时透支），补回正数前不再放行
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// refill 按经过的时间补充令牌（容量变化时截断到新容量）
+func (b *tokenBucket) refill(now time.Time, capacity float64) {
+	if b.updated.IsZero() {
+		b.tokens = capacity
+	} else if elapsed := now.Sub(b.updated); elapsed > 0 {
+		b.tokens += elapsed.Seconds() * capacity / 60
+	}
+	b.tokens = math.Min(b.tokens, capacity)
+	b.updated = now
+}
+
+// waitFor 估算补足 need 个令牌还需等待的时间
+func (b *tokenBucket) waitFor(need float64, capacity float64) time.Duration {
+	if b.tokens >= need || capacity <= 0 {
+		return 0
+	}
+	return time.Duration((need - b.tokens) / capacity * 60 * float64(time.Second))
+}
+
+// rateBuckets 单个 provider+model 的请求数桶与输入 token 桶
+type rateBuckets struct {
+	requests    tokenBucket
+	inputTokens tokenBucket
+}
+
+// rateLimiter 按 provider+model 维护令牌桶（仅内存，重启后额度回满）
+type rateLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*rateBuckets
+	now     func() time.Time
+}
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{buckets: make(map[string]*rateBuckets), now: time.Now}
+}
+
+func rateLimitKey(platform, provider, model string) string {
+	return platform + "|" + provider + "|" + model
+}
+
+// availableLocked 补充令牌后检查额度是否足够（调用方需持有锁）
+// 单个请求的预估 tokens 超过桶容量时，只要桶是满的就放行，避免大请求永远无法发出
+func (l *rateLimiter) availableLocked(key string, limit RateLimit, tokens int) (*rateBuckets, error) {
+	buckets := l.buckets[key]
+	if buckets == nil {
+		buckets = &rateBuckets{}
+		l.buckets[key] = buckets
+	}
+	now := l.now()
+	if limit.RPM > 0 {
+		capacity := float64(limit.RPM)
+		buckets.requests.refill(now, capacity)
+		if buckets.requests.tokens < 1 {
+			return nil, fmt.Errorf("%w: RPM %d 已用尽，约 %s 后恢复", errRateLimited, limit.RPM,
+				buckets.requests.waitFor(1, capacity).Round(time.Second))
+		}
+	}
+	if limit.InputTPM > 0 {
+		capacity := float64(limit.InputTPM)
+		buckets.inputTokens.refill(now, capacity)
+		need := math.Min(float64(tokens), capacity)
+		if buckets.inputTokens.tokens < need || buckets.inputTokens.tokens <= 0 {
+			return nil, fmt.Errorf("%w: 输入 TPM %d 不足（预估 %d tokens），约 %s 后恢复", errRateLimited, limit.InputTPM, tokens,
+				buckets.inputTokens.waitFor(math.Max(need, 1), capacity).Round(time.Second))
+		}
+	}
+	return buckets, nil
+}
+
+// check 只检查额度是否足够，不扣减（用于筛选可用 provider）
+func (l *rateLimiter) check(platform, provider, model string, limit RateLimit, tokens int) error {
+	if !limit.enabled() {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, err := l.availableLocked(rateLimitKey(platform, provider, model), limit, tokens)
+	return err
+}
+
+// reserve 扣减 1 个请求和预估的输入 tokens，返回的预留用于请求结束后按真实用量修正
+// 未配置限流时返回 nil（nil 预留的方法均为空操作）
+func (l *rateLimiter) reserve(platform, provider, model string, limit RateLimit, tokens int) (*rateReservation, error) {
+	if !limit.enabled() {
+		return nil, nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	key := rateLimitKey(platform, provider, model)
+	buckets, err := l.availableLocked(key, limit, tokens)
+	if err != nil {
+		return nil, err
+	}
+	reservation := &rateReservation{limiter: l, key: key}
+	if limit.RPM > 0 {
+		buckets.requests.tokens--
+		reservation.request = true
+	}
+	if limit.InputTPM > 0 {
+		buckets.inputTokens.tokens -= float64(tokens)
+		reservation.tokens = tokens
+	}
+	return reservation, nil
+}
+
+// rateReservation 一次请求预扣的额度
+type rateReservation struct {
+	limiter *rateLimiter
+	key     string
+	request bool // 是否扣减了请求数
+	tokens  int  // 预扣的输入 tokens
+	done    bool
+}
+
+// settle 用真实输入 tokens 修正预扣额度（actual<=0 表示用量未知，保留预估值）
+func (r *rateReservation) settle(actual int) {
+	if r == nil || actual <= 0 || r.tokens == 0 {
+		return
+	}
+	r.adjust(func(b *rateBuckets) {
+		b.inputTokens.tokens += float64(r.tokens - actual)
+	})
+}
+
+// cancel 请求未发出（如并发已满）时退回全部预扣额度
+func (r *rateReservation) cancel() {
+	if r == nil {
+		return
+	}
+	r.adjust(func(b *rateBuckets) {
+		if r.request {
+			b.requests.tokens++
+		}
+		b.inputTokens.tokens += float64(r.tokens)
+	})
+}
+
+func (r *rateReservation) adjust(fn func(b *rateBuckets)) {
+	r.limiter.mu.Lock()
+	defer r.limiter.mu.Unlock()
+	if r.done {
+		return
+	}
+	r.done = true
+	if buckets := r.limiter.buckets[r.key]; buckets != nil {
+		fn(buckets)
+	}
+}
@@ -0,0 +1,156 @@
+package services
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// ==================== 本地限流测试 ====================
+
+// newTestRateLimiter 创建使用可控时钟的限流器
+func newTestRateLimiter(now *time.Time) *rateLimiter {
+	limiter := newRateLimiter()
+	limiter.now = func() time.Time { return *now }
+	return limiter
+}
+
+func TestProvider_RateLimitFor(t *testing.T) {
+	provider := Provider{
+		RateLimit: &RateLimit{RPM: 60},
+		ModelRateLimits: map[string]RateLimit{
+			"claude-opus-4-1": {RPM: 5},
+			"claude-*":        {RPM: 20, InputTPM: 40000},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		model    string
+		expected RateLimit
+	}{
+		{"精确匹配优先", "claude-opus-4-1", RateLimit{RPM: 5}},
+		{"通配符匹配", "claude-sonnet-4", RateLimit{RPM: 20, InputTPM: 40000}},
+		{"回退到 provider 级配置", "gpt-5", RateLimit{RPM: 60}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := provider.rateLimitFor(tt.model); got != tt.expected {
+				t.Errorf("期望 %+v，实际 %+v", tt.expected, got)
+			}
+		})
+	}
+
+	if got := (&Provider{}).rateLimitFor("any"); got.enabled() {
+		t.Errorf("未配置限流时不应启用，实际 %+v", got)
+	}
+}
+
+func TestRateLimiter_RPM(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	limiter := newTestRateLimiter(&now)
+	limit := RateLimit{RPM: 2}
+
+	for i := 0; i < 2; i++ {
+		if _, err := limiter.reserve("claude", "p1", "m", limit, 0); err != nil {
+			t.Fatalf("第 %d 个请求不应被限流: %v", i+1, err)
+		}
+	}
+	if err := limiter.check("claude", "p1", "m", limit, 0); !errors.Is(err, errRateLimited) {
+		t.Fatalf("RPM 用尽后应返回 errRateLimited，实际: %v", err)
+	}
+	if err := limiter.check("claude", "p1", "other-model", limit, 0); err != nil {
+		t.Errorf("不同模型应分开计数: %v", err)
+	}
+
+	// 每 30 秒补充 1 个请求额度
+	now = now.Add(30 * time.Second)
+	if _, err := limiter.reserve("claude", "p1", "m", limit, 0); err != nil {
+		t.Fatalf("补充后应放行: %v", err)
+	}
+	if _, err := limiter.reserve("claude", "p1", "m", limit, 0); !errors.Is(err, errProviderSkipped) {
+		t.Errorf("限流错误应归类为 errProviderSkipped（不计入失败次数），实际: %v", err)
+	}
+}
+
+func TestRateLimiter_InputTPM(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	limiter := newTestRateLimiter(&now)
+	limit := RateLimit{InputTPM: 1000}
+
+	reservation, err := limiter.reserve("claude", "p1", "m", limit, 600)
+	if err != nil {
+		t.Fatalf("首个请求不应被限流: %v", err)
+	}
+	if err := limiter.check("claude", "p1", "m", limit, 600); !errors.Is(err, errRateLimited) {
+		t.Fatalf("剩余 400 tokens 不足以放行 600，实际: %v", err)
+	}
+
+	// 真实用量只有 200，退回多扣的 400
+	reservation.settle(200)
+	reservation.settle(200) // 重复修正不应重复退回
+	if err := limiter.check("claude", "p1", "m", limit, 800); err != nil {
+		t.Errorf("按真实用量修正后应剩余 800 tokens: %v", err)
+	}
+	if err := limiter.check("claude", "p1", "m", limit, 801); !errors.Is(err, errRateLimited) {
+		t.Errorf("重复修正导致额度多退，实际: %v", err)
+	}
+
+	// 取消预留退回全部额度
+	second, err := limiter.reserve("claude", "p1", "m", limit, 800)
+	if err != nil {
+		t.Fatalf("预留失败: %v", err)
+	}
+	second.cancel()
+	if err := limiter.check("claude", "p1", "m", limit, 800); err != nil {
+		t.Errorf("取消后应退回额度: %v", err)
+	}
+
+	// 超过容量的大请求：桶满时放行，随后透支
+	fresh := newTestRateLimiter(&now)
+	if _, err := fresh.reserve("claude", "p1", "m", limit, 5000); err != nil {
+		t.Fatalf("桶满时大请求应放行: %v", err)
+	}
+	now = now.Add(time.Minute)
+	if err := fresh.check("claude", "p1", "m", limit, 10); !errors.Is(err, errRateLimited) {
+		t.Errorf("透支后一分钟内额度仍为负，应继续限流，实际: %v", err)
+	}
+}
+
+func TestBuildNoProviderError_RateLimited(t *testing.T) {
+	msg := buildNoProviderError("claude-sonnet-4", "claude", skipReasons{rateLimited: 2, blacklisted: 1})
+	if !strings.Contains(msg, "2 rate limited") || !strings.Contains(msg, "1 temporarily unavailable") {
+		t.Errorf("错误信息应包含限流与拉黑数量，实际: %s", msg)
+	}
+}
+
+// TestForwardRequest_RateLimited 令牌桶用尽时不请求上游，返回 errRateLimited（由调用方切换到下一个 provider）
+func TestForwardRequest_RateLimited(t *testing.T) {
+	var hits int32
+	upstreamServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer upstreamServer.Close()
+
+	relayService := NewProviderRelayService(NewProviderService(), nil, nil, nil, nil, "")
+	provider := Provider{Name: "limited", APIURL: upstreamServer.URL, APIKey: "k", Enabled: true, RateLimit: &RateLimit{RPM: 1}}
+
+	if _, err := relayService.rateLimits.reserve("claude", provider.Name, "claude-sonnet-4", provider.rateLimitFor("claude-sonnet-4"), 0); err != nil {
+		t.Fatalf("占用额度失败: %v", err)
+	}
+
+	c, _ := newHedgeTestContext()
+	ok, err := relayService.forwardRequest(c, "claude", provider, "/v1/messages", nil, http.Header{},
+		[]byte(`{"model":"claude-sonnet-4"}`), false, "claude-sonnet-4", AuthMethodXAPIKey)
+	if ok || !errors.Is(err, errRateLimited) {
+		t.Fatalf("期望 errRateLimited，实际 ok=%v err=%v", ok, err)
+	}
+	if atomic.LoadInt32(&hits) != 0 {
+		t.Errorf("限流时不应请求上游")
+	}
+}
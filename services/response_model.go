@@ -0,0 +1,133 @@
+package services
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// requestedModelKey gin.Context 中记录客户端请求模型名（模型映射前）的键
+const requestedModelKey = "codeswitch.requestedModel"
+
+// responseModelPaths 响应中可能携带模型名的字段
+// - model：Anthropic / Chat Completions / Responses 的 JSON 响应体，Chat Completions 流式 chunk
+// - message.model：Anthropic 流式 message_start
+// - response.model：Responses 流式 response.created / response.completed 等事件
+var responseModelPaths = []string{"model", "message.model", "response.model"}
+
+// responseModelAlias 返回需要还原到响应中的模型名（空表示不改写）
+func responseModelAlias(c *gin.Context, provider *Provider) string {
+	if !provider.RewriteResponseModel {
+		return ""
+	}
+	if v, ok := c.Get(requestedModelKey); ok {
+		if alias, ok := v.(string); ok {
+			return alias
+		}
+	}
+	return ""
+}
+
+// responseModelRewriter 将响应中的模型名改写回客户端请求的别名
+// 流式响应按行处理（跨 chunk 的半行缓冲到下一次），非流式响应缓冲完整响应体后一次性改写
+type responseModelRewriter struct {
+	alias   string
+	stream  bool
+	pending []byte
+}
+
+// newResponseModelRewriter 创建响应模型名改写器
+// 别名为空或响应体被压缩（无法按明文改写）时返回 nil
+func newResponseModelRewriter(alias string, header http.Header) *responseModelRewriter {
+	if alias == "" {
+		return nil
+	}
+	if encoding := header.Get("Content-Encoding"); encoding != "" && !strings.EqualFold(encoding, "identity") {
+		return nil
+	}
+	return &responseModelRewriter{
+		alias:  alias,
+		stream: strings.Contains(strings.ToLower(header.Get("Content-Type")), "text/event-stream"),
+	}
+}
+
+// wrap 在原 hook（用量解析，需要看到上游原始数据）之后执行改写
+// 读取结束时 copyResponseBodyWithHookAndCollector 以空数据调用 hook，此时输出缓冲的剩余数据
+func (r *responseModelRewriter) wrap(next func([]byte) []byte) func([]byte) []byte {
+	return func(data []byte) []byte {
+		if next != nil {
+			data = next(data)
+		}
+		return r.rewrite(data)
+	}
+}
+
+func (r *responseModelRewriter) rewrite(data []byte) []byte {
+	if !r.stream {
+		if len(data) > 0 {
+			r.pending = append(r.pending, data...)
+			return nil
+		}
+		body := r.pending
+		r.pending = nil
+		return rewriteModelInJSON(body, r.alias)
+	}
+
+	buf := append(r.pending, data...)
+	end := len(buf)
+	if len(data) > 0 {
+		// 只处理完整的行，最后一个换行之后的半行留到下一个 chunk
+		end = bytes.LastIndexByte(buf, '\n') + 1
+	}
+	r.pending = append([]byte(nil), buf[end:]...)
+	if end == 0 {
+		return nil
+	}
+	return rewriteModelInSSE(buf[:end], r.alias)
+}
+
+// rewriteModelInSSE 改写 SSE 数据中每个 data: 行携带的模型名
+func rewriteModelInSSE(chunk []byte, alias string) []byte {
+	if !bytes.Contains(chunk, []byte(`"model"`)) {
+		return chunk
+	}
+	lines := bytes.SplitAfter(chunk, []byte("\n"))
+	for i, line := range lines {
+		content := bytes.TrimRight(line, "\r\n")
+		if !bytes.HasPrefix(content, []byte("data:")) || !bytes.Contains(content, []byte(`"model"`)) {
+			continue
+		}
+		payload := bytes.TrimLeft(content[len("data:"):], " ")
+		rewritten := rewriteModelInJSON(payload, alias)
+		if bytes.Equal(rewritten, payload) {
+			continue
+		}
+		newLine := make([]byte, 0, len(line)+len(alias))
+		newLine = append(newLine, content[:len(content)-len(payload)]...)
+		newLine = append(newLine, rewritten...)
+		newLine = append(newLine, line[len(content):]...)
+		lines[i] = newLine
+	}
+	return bytes.Join(lines, nil)
+}
+
+// rewriteModelInJSON 将 JSON 中已存在的模型名字段改写为别名，非 JSON 原样返回
+func rewriteModelInJSON(body []byte, alias string) []byte {
+	if !gjson.ValidBytes(body) {
+		return body
+	}
+	for _, path := range responseModelPaths {
+		value := gjson.GetBytes(body, path)
+		if value.Type != gjson.String || value.String() == alias {
+			continue
+		}
+		if modified, err := sjson.SetBytes(body, path, alias); err == nil {
+			body = modified
+		}
+	}
+	return body
+}
@@ -0,0 +1,139 @@
+package services
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+// ==================== 响应模型名还原测试 ====================
+
+func TestRewriteModelInJSON(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		path   string
+		expect string
+	}{
+		{"Anthropic 响应体", `{"id":"msg_1","model":"anthropic/claude-sonnet-4","content":[]}`, "model", "claude-sonnet-4"},
+		{"message_start 事件", `{"type":"message_start","message":{"model":"vendor-sonnet"}}`, "message.model", "claude-sonnet-4"},
+		{"Responses 事件", `{"type":"response.created","response":{"model":"vendor-sonnet"}}`, "response.model", "claude-sonnet-4"},
+		{"不含模型名的事件不变", `{"type":"content_block_delta","delta":{"text":"\"model\""}}`, "delta.text", `"model"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := rewriteModelInJSON([]byte(tt.body), "claude-sonnet-4")
+			if value := gjson.GetBytes(got, tt.path).String(); value != tt.expect {
+				t.Errorf("%s 期望 %q，实际 %q（%s）", tt.path, tt.expect, value, got)
+			}
+		})
+	}
+
+	if got := rewriteModelInJSON([]byte("not json"), "claude-sonnet-4"); string(got) != "not json" {
+		t.Errorf("非 JSON 响应不应改写，实际 %s", got)
+	}
+}
+
+// TestResponseModelRewriter_Stream SSE 行跨 chunk 拆分时也能完整改写
+func TestResponseModelRewriter_Stream(t *testing.T) {
+	header := http.Header{}
+	header.Set("Content-Type", "text/event-stream")
+	rewriter := newResponseModelRewriter("claude-sonnet-4", header)
+	if rewriter == nil {
+		t.Fatalf("别名非空时应创建改写器")
+	}
+
+	stream := "event: message_start\r\ndata: {\"type\":\"message_start\",\"message\":{\"id\":\"msg_1\",\"model\":\"anthropic/claude-sonnet-4\"}}\r\n\r\n" +
+		"event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"delta\":{\"text\":\"hi\"}}\n\n" +
+		"data: [DONE]"
+	var out bytes.Buffer
+	err := copyResponseBodyWithHookAndCollector(&chunkedReader{data: []byte(stream), size: 7}, &out, rewriter.wrap(nil), nil)
+	if err != nil {
+		t.Fatalf("复制失败: %v", err)
+	}
+
+	expected := strings.Replace(stream, "anthropic/claude-sonnet-4", "claude-sonnet-4", 1)
+	if out.String() != expected {
+		t.Errorf("改写结果不符合预期:\n%s", out.String())
+	}
+}
+
+func TestResponseModelRewriter_JSON(t *testing.T) {
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+	rewriter := newResponseModelRewriter("gpt-5", header)
+
+	var out bytes.Buffer
+	body := `{"id":"resp_1","model":"vendor/gpt-5-2025-08-07","output":[]}`
+	if err := copyResponseBodyWithHookAndCollector(&chunkedReader{data: []byte(body), size: 5}, &out, rewriter.wrap(nil), nil); err != nil {
+		t.Fatalf("复制失败: %v", err)
+	}
+	if gjson.Get(out.String(), "model").String() != "gpt-5" || gjson.Get(out.String(), "id").String() != "resp_1" {
+		t.Errorf("改写结果不符合预期: %s", out.String())
+	}
+
+	compressed := http.Header{}
+	compressed.Set("Content-Encoding", "gzip")
+	if newResponseModelRewriter("gpt-5", compressed) != nil {
+		t.Errorf("压缩的响应体不应改写")
+	}
+	if newResponseModelRewriter("", header) != nil {
+		t.Errorf("别名为空时不应改写")
+	}
+}
+
+// TestForwardRequest_RewriteResponseModel 开启选项后响应中的模型名还原为客户端请求的模型名
+func TestForwardRequest_RewriteResponseModel(t *testing.T) {
+	upstreamServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id":"msg_1","model":"anthropic/claude-sonnet-4","usage":{"input_tokens":3,"output_tokens":5}}`))
+	}))
+	defer upstreamServer.Close()
+
+	relayService := NewProviderRelayService(NewProviderService(), nil, nil, nil, nil, "")
+	for _, enabled := range []bool{true, false} {
+		provider := Provider{Name: "relay", APIURL: upstreamServer.URL, APIKey: "k", Enabled: true, RewriteResponseModel: enabled}
+		c, w := newHedgeTestContext()
+		c.Set(requestedModelKey, "claude-sonnet-4")
+		ok, err := relayService.forwardRequest(c, "claude", provider, "/v1/messages", nil, http.Header{},
+			[]byte(`{"model":"anthropic/claude-sonnet-4"}`), false, "anthropic/claude-sonnet-4", AuthMethodXAPIKey)
+		if !ok {
+			t.Fatalf("请求应成功: %v", err)
+		}
+
+		expected := "anthropic/claude-sonnet-4"
+		if enabled {
+			expected = "claude-sonnet-4"
+		}
+		if got := gjson.Get(w.Body.String(), "model").String(); got != expected {
+			t.Errorf("enabled=%v 期望响应模型名 %q，实际 %q", enabled, expected, got)
+		}
+	}
+}
+
+// chunkedReader 每次最多返回 size 字节，模拟上游按任意边界分块
+type chunkedReader struct {
+	data []byte
+	size int
+}
+
+func (r *chunkedReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, io.EOF
+	}
+	n := r.size
+	if n > len(r.data) {
+		n = len(r.data)
+	}
+	if n > len(p) {
+		n = len(p)
+	}
+	copy(p, r.data[:n])
+	r.data = r.data[n:]
+	return n, nil
+}
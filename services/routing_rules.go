@@ -0,0 +1,385 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+// RoutingRule 声明式路由规则：按顺序匹配，第一条命中的规则决定候选 provider 集合
+// 候选集合内仍按 Level、负载均衡策略与同源缓存选择 provider
+// 示例：超过 150k tokens 的请求只发往 long-context 标签的 provider；haiku 后台请求发往 cheap 标签
+type RoutingRule struct {
+	Name    string       `json:"name"`
+	Enabled bool         `json:"enabled"`
+	Match   RoutingMatch `json:"match"`
+
+	// 目标：provider 名与 provider 标签（Provider.Tags）取并集，至少配置一项
+	Providers []string `json:"providers,omitempty"`
+	Tags      []string `json:"tags,omitempty"`
+
+	// 覆盖（可选）
+	Model string `json:"model,omitempty"` // 改写请求模型名（之后仍按各 provider 的 modelMapping 映射）
+
+	// 按字段覆盖 provider 的超时配置（连接 / 首字节 / 流式空闲 / 总超时）
+	Timeouts *TimeoutConfig `json:"timeouts,omitempty"`
+}
+
+// RoutingMatch 规则匹配条件，所有已配置的条件都满足才算命中（未配置的条件不参与匹配）
+type RoutingMatch struct {
+	Platforms      []string `json:"platforms,omitempty"`      // claude / codex / custom:{toolId}
+	Models         []string `json:"models,omitempty"`         // 请求模型名通配符，如 "claude-*haiku*"
+	Thinking       *bool    `json:"thinking,omitempty"`       // 是否开启 thinking / reasoning
+	Tools          *bool    `json:"tools,omitempty"`          // 是否携带 tools
+	Images         *bool    `json:"images,omitempty"`         // 是否包含图片 / 文件
+	MinInputTokens int      `json:"minInputTokens,omitempty"` // 估算输入 tokens 下限（含）
+	MaxInputTokens int      `json:"maxInputTokens,omitempty"` // 估算输入 tokens 上限（含）
+	UserAgents     []string `json:"userAgents,omitempty"`     // 客户端 User-Agent 通配符（不区分大小写）
+}
+
+type routingRulesEnvelope struct {
+	Rules []RoutingRule `json:"rules"`
+}
+
+// routeTimeoutKey gin.Context 中记录路由规则超时覆盖（*TimeoutConfig）的键
+const routeTimeoutKey = "codeswitch.routeTimeout"
+
+func routingRulesFilePath() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(home, ".code-switch")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "routing-rules.json"), nil
+}
+
+// LoadRoutingRules 读取路由规则（与 provider 配置存放在同一目录）
+func (ps *ProviderService) LoadRoutingRules() ([]RoutingRule, error) {
+	path, err := routingRulesFilePath()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []RoutingRule{}, nil
+		}
+		return nil, err
+	}
+	if len(data) == 0 {
+		return []RoutingRule{}, nil
+	}
+	var envelope routingRulesEnvelope
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return nil, err
+	}
+	return envelope.Rules, nil
+}
+
+// SaveRoutingRules 校验并保存路由规则
+func (ps *ProviderService) SaveRoutingRules(rules []RoutingRule) error {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	validationErrors := make([]string, 0)
+	for i := range rules {
+		label := rules[i].Name
+		if label == "" {
+			label = fmt.Sprintf("#%d", i+1)
+		}
+		for _, errMsg := range rules[i].Validate() {
+			validationErrors = append(validationErrors, fmt.Sprintf("[%s] %s", label, errMsg))
+		}
+		for _, errMsg := range ps.validateRoutingTargets(&rules[i]) {
+			validationErrors = append(validationErrors, fmt.Sprintf("[%s] %s", label, errMsg))
+		}
+	}
+	if len(validationErrors) > 0 {
+		return fmt.Errorf("路由规则验证失败：\n  - %s", strings.Join(validationErrors, "\n  - "))
+	}
+
+	path, err := routingRulesFilePath()
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(routingRulesEnvelope{Rules: rules}, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Validate 校验规则本身（不检查引用的 provider 是否存在）
+func (r *RoutingRule) Validate() []string {
+	errors := make([]string, 0)
+	if strings.TrimSpace(r.Name) == "" {
+		errors = append(errors, "规则名称不能为空")
+	}
+	if len(r.Providers) == 0 && len(r.Tags) == 0 {
+		errors = append(errors, "至少需要指定一个目标 provider 或标签")
+	}
+	for _, platform := range r.Match.Platforms {
+		if !isRoutablePlatform(platform) {
+			errors = append(errors, fmt.Sprintf("平台无效：'%s'（支持 claude / codex / custom:{toolId}）", platform))
+		}
+	}
+	for _, pattern := range r.Match.Models {
+		if strings.TrimSpace(pattern) == "" {
+			errors = append(errors, "模型通配符不能为空")
+		}
+	}
+	for _, pattern := range r.Match.UserAgents {
+		if strings.TrimSpace(pattern) == "" {
+			errors = append(errors, "User-Agent 通配符不能为空")
+		}
+	}
+	if r.Match.MinInputTokens < 0 || r.Match.MaxInputTokens < 0 {
+		errors = append(errors, "输入 tokens 范围不能为负数")
+	}
+	if r.Match.MaxInputTokens > 0 && r.Match.MinInputTokens > r.Match.MaxInputTokens {
+		errors = append(errors, fmt.Sprintf("输入 tokens 范围无效：%d > %d", r.Match.MinInputTokens, r.Match.MaxInputTokens))
+	}
+	errors = append(errors, r.Timeouts.Validate()...)
+	return errors
+}
+
+// isRoutablePlatform 路由规则支持的平台（Gemini 使用独立的转发链路，暂不支持）
+func isRoutablePlatform(platform string) bool {
+	switch platform {
+	case "claude", "codex":
+		return true
+	}
+	toolID, ok := strings.CutPrefix(platform, "custom:")
+	return ok && toolID != ""
+}
+
+// validateRoutingTargets 检查规则引用的 provider 名和标签在其适用的平台中存在
+// 调用方必须已持有 ps.mu
+func (ps *ProviderService) validateRoutingTargets(r *RoutingRule) []string {
+	platforms := r.Match.Platforms
+	if len(platforms) == 0 {
+		platforms = []string{"claude", "codex"}
+	}
+	names := make(map[string]bool)
+	tags := make(map[string]bool)
+	for _, platform := range platforms {
+		providers, err := ps.loadProvidersRaw(platform)
+		if err != nil {
+			return []string{fmt.Sprintf("读取 %s 的 provider 配置失败: %v", platform, err)}
+		}
+		for _, provider := range providers {
+			names[provider.Name] = true
+			for _, tag := range provider.Tags {
+				tags[tag] = true
+			}
+		}
+	}
+
+	errors := make([]string, 0)
+	for _, name := range r.Providers {
+		if !names[name] {
+			errors = append(errors, fmt.Sprintf("provider '%s' 不存在", name))
+		}
+	}
+	for _, tag := range r.Tags {
+		if !tags[tag] {
+			errors = append(errors, fmt.Sprintf("没有 provider 带有标签 '%s'", tag))
+		}
+	}
+	return errors
+}
+
+// routingRequest 规则匹配所需的请求特征
+type routingRequest struct {
+	platform    string
+	model       string
+	userAgent   string
+	thinking    bool
+	tools       bool
+	images      bool
+	inputTokens int
+}
+
+func newRoutingRequest(platform string, model string, userAgent string, body []byte) routingRequest {
+	root := gjson.ParseBytes(body)
+	return routingRequest{
+		platform:    platform,
+		model:       model,
+		userAgent:   userAgent,
+		thinking:    hasThinking(root),
+		tools:       len(root.Get("tools").Array()) > 0,
+		images:      containsImage(root),
+		inputTokens: estimateRequestTokens(body),
+	}
+}
+
+// hasThinking 请求是否开启 thinking（Anthropic thinking / Responses reasoning / Chat reasoning_effort）
+func hasThinking(root gjson.Result) bool {
+	if thinking := root.Get("thinking"); thinking.Exists() {
+		return thinking.Get("type").String() != "disabled"
+	}
+	return root.Get("reasoning.effort").Exists() || root.Get("reasoning_effort").Exists()
+}
+
+// containsImage 递归检查请求体中是否包含图片 / 文件内容块
+func containsImage(value gjson.Result) bool {
+	if value.IsObject() && isImageValue(value) {
+		return true
+	}
+	if !value.IsObject() && !value.IsArray() {
+		return false
+	}
+	found := false
+	value.ForEach(func(_, v gjson.Result) bool {
+		found = containsImage(v)
+		return !found
+	})
+	return found
+}
+
+// matches 检查规则是否命中请求
+func (r *RoutingRule) matches(req routingRequest) bool {
+	m := r.Match
+	if len(m.Platforms) > 0 && !slices.Contains(m.Platforms, req.platform) {
+		return false
+	}
+	if len(m.Models) > 0 && !matchAnyGlob(m.Models, req.model, false) {
+		return false
+	}
+	if m.Thinking != nil && *m.Thinking != req.thinking {
+		return false
+	}
+	if m.Tools != nil && *m.Tools != req.tools {
+		return false
+	}
+	if m.Images != nil && *m.Images != req.images {
+		return false
+	}
+	if m.MinInputTokens > 0 && req.inputTokens < m.MinInputTokens {
+		return false
+	}
+	if m.MaxInputTokens > 0 && req.inputTokens > m.MaxInputTokens {
+		return false
+	}
+	if len(m.UserAgents) > 0 && !matchAnyGlob(m.UserAgents, req.userAgent, true) {
+		return false
+	}
+	return true
+}
+
+// selects 规则目标是否包含该 provider
+func (r *RoutingRule) selects(provider *Provider) bool {
+	if slices.Contains(r.Providers, provider.Name) {
+		return true
+	}
+	for _, tag := range provider.Tags {
+		if slices.Contains(r.Tags, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// timeouts 规则的超时覆盖（nil = 未设置）
+func (r *RoutingRule) timeouts() *TimeoutConfig {
+	if r.Timeouts.isZero() {
+		return nil
+	}
+	timeouts := *r.Timeouts
+	return &timeouts
+}
+
+func matchAnyGlob(patterns []string, s string, ignoreCase bool) bool {
+	if ignoreCase {
+		s = strings.ToLower(s)
+	}
+	for _, pattern := range patterns {
+		if ignoreCase {
+			pattern = strings.ToLower(pattern)
+		}
+		if matchGlob(pattern, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchGlob 通配符匹配，支持任意多个 *（matchWildcard 只支持单个 *，用于模型映射）
+func matchGlob(pattern, s string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == s
+	}
+	if !strings.HasPrefix(s, parts[0]) {
+		return false
+	}
+	s = s[len(parts[0]):]
+	for _, part := range parts[1 : len(parts)-1] {
+		idx := strings.Index(s, part)
+		if idx < 0 {
+			return false
+		}
+		s = s[idx+len(part):]
+	}
+	return strings.HasSuffix(s, parts[len(parts)-1])
+}
+
+// matchRoutingRule 按顺序返回第一条命中的启用规则（没有命中时返回 nil）
+func (prs *ProviderRelayService) matchRoutingRule(kind string, c *gin.Context, requestedModel string, bodyBytes []byte) *RoutingRule {
+	rules, err := prs.providerService.LoadRoutingRules()
+	if err != nil {
+		fmt.Printf("[WARN] 读取路由规则失败，按默认路由处理: %v\n", err)
+		return nil
+	}
+	if len(rules) == 0 {
+		return nil
+	}
+	req := newRoutingRequest(kind, requestedModel, c.Request.UserAgent(), bodyBytes)
+	for i := range rules {
+		if rules[i].Enabled && rules[i].matches(req) {
+			return &rules[i]
+		}
+	}
+	return nil
+}
+
+// applyRoutingRule 应用命中的规则：筛选候选 provider、改写模型名、记录超时覆盖
+// 返回筛选后的 providers 与（可能改写后的）模型名和请求体
+func applyRoutingRule(c *gin.Context, rule *RoutingRule, providers []Provider, requestedModel string, bodyBytes []byte) ([]Provider, string, []byte) {
+	selected := make([]Provider, 0, len(providers))
+	for i := range providers {
+		if rule.selects(&providers[i]) {
+			selected = append(selected, providers[i])
+		}
+	}
+
+	if rule.Model != "" && rule.Model != requestedModel {
+		if modifiedBody, err := ReplaceModelInRequestBody(bodyBytes, rule.Model); err == nil {
+			fmt.Printf("[INFO] 路由规则 %s 改写模型: %s -> %s\n", rule.Name, requestedModel, rule.Model)
+			requestedModel = rule.Model
+			bodyBytes = modifiedBody
+		} else {
+			fmt.Printf("[WARN] 路由规则 %s 改写模型失败: %v\n", rule.Name, err)
+		}
+	}
+
+	if timeouts := rule.timeouts(); timeouts != nil {
+		c.Set(routeTimeoutKey, timeouts)
+	}
+
+	fmt.Printf("[INFO] 🧭 命中路由规则: %s（候选 provider %d 个）\n", rule.Name, len(selected))
+	return selected, requestedModel, bodyBytes
+}
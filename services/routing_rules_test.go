@@ -0,0 +1,178 @@
+package services
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+// ==================== 路由规则测试 ====================
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		s        string
+		expected bool
+	}{
+		{"claude-sonnet-4", "claude-sonnet-4", true},
+		{"claude-*", "claude-haiku-4-5", true},
+		{"claude-*haiku*", "claude-3-5-haiku-20241022", true},
+		{"claude-*haiku*", "claude-sonnet-4", false},
+		{"*opus*", "claude-opus-4-1", true},
+		{"gpt-*-mini", "gpt-5-mini", true},
+		{"gpt-*-mini", "gpt-5-nano", false},
+		{"*", "", true},
+	}
+	for _, tt := range tests {
+		if got := matchGlob(tt.pattern, tt.s); got != tt.expected {
+			t.Errorf("matchGlob(%q, %q) 期望 %v，实际 %v", tt.pattern, tt.s, tt.expected, got)
+		}
+	}
+}
+
+func TestNewRoutingRequest(t *testing.T) {
+	body := []byte(`{
+		"model": "claude-sonnet-4",
+		"thinking": {"type": "enabled", "budget_tokens": 1024},
+		"tools": [{"name": "bash"}],
+		"messages": [{"role": "user", "content": [
+			{"type": "text", "text": "看看这张图"},
+			{"type": "image", "source": {"type": "base64", "media_type": "image/png", "data": "iVBORw0KGgo="}}
+		]}]
+	}`)
+	req := newRoutingRequest("claude", "claude-sonnet-4", "claude-cli/2.0.0", body)
+	if !req.thinking || !req.tools || !req.images || req.inputTokens == 0 {
+		t.Errorf("请求特征提取不符合预期: %+v", req)
+	}
+
+	plain := newRoutingRequest("codex", "gpt-5", "", []byte(`{"model":"gpt-5","input":"hi","thinking":{"type":"disabled"}}`))
+	if plain.thinking || plain.tools || plain.images {
+		t.Errorf("普通请求不应识别出 thinking / tools / 图片: %+v", plain)
+	}
+
+	reasoning := newRoutingRequest("codex", "gpt-5", "", []byte(`{"model":"gpt-5","reasoning":{"effort":"high"}}`))
+	if !reasoning.thinking {
+		t.Errorf("Responses reasoning 应视为开启 thinking")
+	}
+}
+
+func TestRoutingRule_Matches(t *testing.T) {
+	yes, no := true, false
+	req := routingRequest{
+		platform:    "claude",
+		model:       "claude-3-5-haiku-20241022",
+		userAgent:   "claude-cli/2.0.0 (external, cli)",
+		tools:       true,
+		inputTokens: 2000,
+	}
+
+	tests := []struct {
+		name     string
+		match    RoutingMatch
+		expected bool
+	}{
+		{"空条件匹配所有请求", RoutingMatch{}, true},
+		{"平台匹配", RoutingMatch{Platforms: []string{"codex", "claude"}}, true},
+		{"平台不匹配", RoutingMatch{Platforms: []string{"codex"}}, false},
+		{"模型通配符", RoutingMatch{Models: []string{"claude-*haiku*"}}, true},
+		{"模型不匹配", RoutingMatch{Models: []string{"*opus*"}}, false},
+		{"要求 thinking", RoutingMatch{Thinking: &yes}, false},
+		{"要求携带 tools", RoutingMatch{Tools: &yes}, true},
+		{"要求不含图片", RoutingMatch{Images: &no}, true},
+		{"tokens 下限", RoutingMatch{MinInputTokens: 150000}, false},
+		{"tokens 上限", RoutingMatch{MaxInputTokens: 4000}, true},
+		{"User-Agent 不区分大小写", RoutingMatch{UserAgents: []string{"Claude-CLI/*"}}, true},
+		{"多个条件需同时满足", RoutingMatch{Models: []string{"claude-*haiku*"}, Thinking: &yes}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule := RoutingRule{Name: tt.name, Enabled: true, Match: tt.match}
+			if got := rule.matches(req); got != tt.expected {
+				t.Errorf("期望 %v，实际 %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestRoutingRule_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		rule    RoutingRule
+		wantErr string
+	}{
+		{"合法规则", RoutingRule{Name: "long", Tags: []string{"long-context"}, Match: RoutingMatch{MinInputTokens: 150000}}, ""},
+		{"缺少名称", RoutingRule{Tags: []string{"cheap"}}, "规则名称不能为空"},
+		{"缺少目标", RoutingRule{Name: "x"}, "至少需要指定一个目标"},
+		{"平台无效", RoutingRule{Name: "x", Tags: []string{"cheap"}, Match: RoutingMatch{Platforms: []string{"gemini"}}}, "平台无效"},
+		{"tokens 范围无效", RoutingRule{Name: "x", Tags: []string{"cheap"}, Match: RoutingMatch{MinInputTokens: 10, MaxInputTokens: 5}}, "范围无效"},
+		{"超时为负数", RoutingRule{Name: "x", Tags: []string{"cheap"}, Timeouts: &TimeoutConfig{TotalSeconds: -1}}, "超时不能为负数"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errs := tt.rule.Validate()
+			if tt.wantErr == "" {
+				if len(errs) > 0 {
+					t.Errorf("期望验证通过，实际 %v", errs)
+				}
+				return
+			}
+			if !strings.Contains(strings.Join(errs, "; "), tt.wantErr) {
+				t.Errorf("期望错误包含 %q，实际 %v", tt.wantErr, errs)
+			}
+		})
+	}
+}
+
+func TestApplyRoutingRule(t *testing.T) {
+	providers := []Provider{
+		{Name: "official", Tags: []string{"long-context"}},
+		{Name: "relay-a", Tags: []string{"cheap"}},
+		{Name: "relay-b"},
+	}
+	rule := &RoutingRule{
+		Name:      "background",
+		Tags:      []string{"cheap"},
+		Providers: []string{"relay-b"},
+		Model:     "claude-haiku-4-5",
+		Timeouts:  &TimeoutConfig{TotalSeconds: 60},
+	}
+
+	c, _ := newHedgeTestContext()
+	selected, model, body := applyRoutingRule(c, rule, providers, "claude-3-5-haiku-20241022", []byte(`{"model":"claude-3-5-haiku-20241022"}`))
+	if len(selected) != 2 || selected[0].Name != "relay-a" || selected[1].Name != "relay-b" {
+		t.Errorf("应按标签与名称取并集并保持原顺序，实际 %+v", selected)
+	}
+	if model != "claude-haiku-4-5" || gjson.GetBytes(body, "model").String() != "claude-haiku-4-5" {
+		t.Errorf("模型改写不符合预期: %s %s", model, body)
+	}
+	if v, ok := c.Get(routeTimeoutKey); !ok || v.(*TimeoutConfig).total() != time.Minute {
+		t.Errorf("应记录超时覆盖，实际 %v", v)
+	}
+}
+
+func TestProviderService_SaveRoutingRules(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+
+	ps := NewProviderService()
+	if err := ps.SaveProviders("claude", []Provider{{ID: 1, Name: "official", Tags: []string{"long-context"}}}); err != nil {
+		t.Fatalf("保存 provider 失败: %v", err)
+	}
+
+	err := ps.SaveRoutingRules([]RoutingRule{{Name: "cheap", Enabled: true, Tags: []string{"cheap"}, Match: RoutingMatch{Platforms: []string{"claude"}}}})
+	if err == nil || !strings.Contains(err.Error(), "cheap") {
+		t.Fatalf("引用不存在的标签应校验失败，实际 %v", err)
+	}
+
+	rules := []RoutingRule{{Name: "long", Enabled: true, Tags: []string{"long-context"}, Match: RoutingMatch{Platforms: []string{"claude"}, MinInputTokens: 150000}}}
+	if err := ps.SaveRoutingRules(rules); err != nil {
+		t.Fatalf("保存路由规则失败: %v", err)
+	}
+	loaded, err := ps.LoadRoutingRules()
+	if err != nil || len(loaded) != 1 || loaded[0].Match.MinInputTokens != 150000 {
+		t.Errorf("读取路由规则不符合预期: %+v, %v", loaded, err)
+	}
+}
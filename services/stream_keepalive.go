@@ -0,0 +1,293 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+// defaultStreamKeepAliveInterval 流式请求等待上游期间发送保活帧的默认间隔
+// 需短于常见企业代理 / WSL 网络的空闲断开时间（多为 30-60 秒）
+const defaultStreamKeepAliveInterval = 15 * time.Second
+
+// streamKeepAlive 流式请求等待上游（首包前、重试或切换 provider 期间）时向客户端发送保活帧
+// Anthropic 客户端发送 event: ping，Codex / Chat Completions / Gemini 客户端发送 SSE 注释
+// 以包装 c.Writer 的方式接入：首个保活帧写出 200 与 text/event-stream 响应头，中转写出真实响应后停止保活；
+// 响应头已写出后中转返回的错误（c.JSON / c.Data）转为对应协议的 SSE 错误事件，客户端仍能看到错误原因
+// 中转在写出前设置的响应头先暂存，开始写出时再应用，避免与保活协程并发修改同一个 Header
+type streamKeepAlive struct {
+	gin.ResponseWriter
+	format   WireFormat
+	interval time.Duration
+
+	mu        sync.Mutex
+	committed bool        // 已由保活写出响应头
+	relaying  bool        // 中转已开始写出真实响应（WriteHeader / Write），不再发送保活帧
+	errStatus int         // 响应头写出后中转设置的错误状态码
+	staged    http.Header // 中转开始写出前设置的 Header
+	discarded http.Header // 响应头写出后中转设置的 Header（已无法生效）
+
+	stopOnce sync.Once
+	stopCh   chan struct{}
+	done     chan struct{}
+}
+
+// streamKeepAliveInterval 保活间隔（0 = 关闭，默认关闭）
+func (prs *ProviderRelayService) streamKeepAliveInterval() time.Duration {
+	if prs.appSettings == nil {
+		return 0
+	}
+	settings, err := prs.appSettings.GetAppSettings()
+	if err != nil || !settings.EnableStreamKeepAlive {
+		return 0
+	}
+	if settings.StreamKeepAliveSeconds <= 0 {
+		return defaultStreamKeepAliveInterval
+	}
+	return time.Duration(settings.StreamKeepAliveSeconds) * time.Second
+}
+
+// startStreamKeepAlive 为流式请求开启保活，返回的对象需在处理结束时 stop（未开启时返回 nil，stop 可安全调用）
+func (prs *ProviderRelayService) startStreamKeepAlive(c *gin.Context, format WireFormat) *streamKeepAlive {
+	interval := prs.streamKeepAliveInterval()
+	if interval <= 0 {
+		return nil
+	}
+	return newStreamKeepAlive(c, format, interval)
+}
+
+func newStreamKeepAlive(c *gin.Context, format WireFormat, interval time.Duration) *streamKeepAlive {
+	k := &streamKeepAlive{
+		ResponseWriter: c.Writer,
+		format:         format,
+		interval:       interval,
+		staged:         c.Writer.Header().Clone(),
+		stopCh:         make(chan struct{}),
+		done:           make(chan struct{}),
+	}
+	c.Writer = k
+	go k.loop(c.Request.Context().Done())
+	return k
+}
+
+func (k *streamKeepAlive) loop(clientGone <-chan struct{}) {
+	defer close(k.done)
+	ticker := time.NewTicker(k.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-k.stopCh:
+			return
+		case <-clientGone:
+			return
+		case <-ticker.C:
+			if !k.ping() {
+				return
+			}
+		}
+	}
+}
+
+// ping 发送一个保活帧，中转已开始写出响应或写入失败时返回 false
+func (k *streamKeepAlive) ping() bool {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.relaying {
+		return false
+	}
+	if !k.committed {
+		fmt.Printf("[INFO] 💓 上游尚未响应，开始向客户端发送保活帧（每 %s）\n", k.interval)
+		header := k.ResponseWriter.Header()
+		header.Set("Content-Type", "text/event-stream")
+		header.Set("Cache-Control", "no-cache")
+		header.Set("X-Accel-Buffering", "no")
+		header.Del("Content-Length")
+		k.ResponseWriter.WriteHeader(http.StatusOK)
+		k.committed = true
+	}
+	if _, err := k.ResponseWriter.Write(keepAliveFrame(k.format)); err != nil {
+		return false
+	}
+	k.ResponseWriter.Flush()
+	return true
+}
+
+// stop 停止发送保活帧并等待保活协程退出
+func (k *streamKeepAlive) stop() {
+	if k == nil {
+		return
+	}
+	k.stopOnce.Do(func() { close(k.stopCh) })
+	<-k.done
+}
+
+// Header 读取或设置响应头不影响保活：开始写出前返回暂存的 Header，响应头已由保活写出时返回一个不生效的副本
+func (k *streamKeepAlive) Header() http.Header {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.committed {
+		if k.discarded == nil {
+			k.discarded = make(http.Header)
+		}
+		return k.discarded
+	}
+	if k.relaying {
+		return k.ResponseWriter.Header()
+	}
+	return k.staged
+}
+
+// startRelayingLocked 中转开始写出响应：停止保活，并把暂存的响应头应用到底层 Writer（需持有锁）
+func (k *streamKeepAlive) startRelayingLocked() {
+	if k.relaying {
+		return
+	}
+	k.relaying = true
+	if k.committed {
+		return
+	}
+	header := k.ResponseWriter.Header()
+	for key := range header {
+		delete(header, key)
+	}
+	for key, values := range k.staged {
+		header[key] = values
+	}
+}
+
+func (k *streamKeepAlive) WriteHeader(code int) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.startRelayingLocked()
+	if k.committed {
+		if code >= http.StatusBadRequest {
+			k.errStatus = code
+		}
+		return
+	}
+	k.ResponseWriter.WriteHeader(code)
+}
+
+func (k *streamKeepAlive) WriteHeaderNow() {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.startRelayingLocked()
+	if !k.committed {
+		k.ResponseWriter.WriteHeaderNow()
+	}
+}
+
+// Write 响应头已由保活写出且中转返回错误时，把错误响应体（gin 一次写出）转为 SSE 错误事件
+func (k *streamKeepAlive) Write(data []byte) (int, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.startRelayingLocked()
+	if k.committed && k.errStatus != 0 {
+		if _, err := k.ResponseWriter.Write(keepAliveErrorFrame(k.format, k.errStatus, data)); err != nil {
+			return 0, err
+		}
+		return len(data), nil
+	}
+	return k.ResponseWriter.Write(data)
+}
+
+func (k *streamKeepAlive) WriteString(s string) (int, error) {
+	return k.Write([]byte(s))
+}
+
+func (k *streamKeepAlive) Flush() {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.startRelayingLocked()
+	k.ResponseWriter.Flush()
+}
+
+// keepAliveFrame 各协议的保活帧：Anthropic 客户端使用官方的 ping 事件，其他协议使用会被解析器忽略的 SSE 注释
+func keepAliveFrame(format WireFormat) []byte {
+	if format == WireFormatAnthropic {
+		return []byte("event: ping\ndata: {\"type\": \"ping\"}\n\n")
+	}
+	return []byte(": keep-alive\n\n")
+}
+
+// keepAliveErrorFrame 把错误响应转为对应协议的 SSE 错误事件
+func keepAliveErrorFrame(format WireFormat, status int, body []byte) []byte {
+	message := errorMessageFromBody(body)
+	var event string
+	var payload any = clientErrorPayload(format, status, message)
+	switch format {
+	case WireFormatAnthropic:
+		event = "error"
+	case WireFormatResponses:
+		event = "response.failed"
+		payload = gin.H{"type": "response.failed", "response": gin.H{
+			"status": "failed",
+			"error":  gin.H{"code": fmt.Sprintf("http_%d", status), "message": message},
+		}}
+	}
+
+	data, _ := json.Marshal(payload)
+	var buf bytes.Buffer
+	if event != "" {
+		buf.WriteString("event: " + event + "\n")
+	}
+	buf.WriteString("data: ")
+	buf.Write(data)
+	buf.WriteString("\n\n")
+	return buf.Bytes()
+}
+
+// errorMessageFromBody 从错误响应体中提取错误信息（兼容 error.message / error / message 字段）
+func errorMessageFromBody(body []byte) string {
+	if gjson.ValidBytes(body) {
+		for _, path := range []string{"error.message", "error", "message"} {
+			if value := gjson.GetBytes(body, path); value.Type == gjson.String && value.String() != "" {
+				return value.String()
+			}
+		}
+	}
+	return strings.TrimSpace(string(body))
+}
+
+// anthropicErrorType 按状态码返回 Anthropic 错误类型（Claude Code 据此决定是否重试）
+func anthropicErrorType(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return "invalid_request_error"
+	case http.StatusUnauthorized:
+		return "authentication_error"
+	case http.StatusPaymentRequired:
+		return "billing_error"
+	case http.StatusForbidden:
+		return "permission_error"
+	case http.StatusNotFound:
+		return "not_found_error"
+	case http.StatusRequestEntityTooLarge:
+		return "request_too_large"
+	case http.StatusTooManyRequests:
+		return "rate_limit_error"
+	case statusOverloaded, http.StatusServiceUnavailable:
+		return "overloaded_error"
+	default:
+		return "api_error"
+	}
+}
+
+// waitBeforeRetry 重试前等待（期间保活帧照常发送），客户端断开时提前返回 false
+func waitBeforeRetry(c *gin.Context, wait time.Duration) bool {
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-c.Request.Context().Done():
+		return false
+	}
+}
@@ -0,0 +1,134 @@
+package services
+
+import (
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+// ==================== 流式保活测试 ====================
+
+// TestStreamKeepAlive_PingThenRelay 等待上游期间发送 ping，真实响应开始后不再插入保活帧
+func TestStreamKeepAlive_PingThenRelay(t *testing.T) {
+	c, w := newHedgeTestContext()
+	keepAlive := newStreamKeepAlive(c, WireFormatAnthropic, 20*time.Millisecond)
+	time.Sleep(70 * time.Millisecond)
+
+	upstream := "event: message_start\ndata: {\"type\":\"message_start\"}\n\n"
+	c.Writer.Header().Set("Content-Type", "application/json")
+	c.Writer.WriteHeader(http.StatusOK)
+	c.Writer.Write([]byte(upstream))
+	time.Sleep(50 * time.Millisecond)
+	keepAlive.stop()
+
+	body := w.Body.String()
+	ping := string(keepAliveFrame(WireFormatAnthropic))
+	if !strings.HasPrefix(body, ping) {
+		t.Fatalf("等待期间应先发送 ping 事件，实际: %q", body)
+	}
+	if !strings.HasSuffix(body, upstream) {
+		t.Errorf("真实响应开始后不应再插入保活帧，实际: %q", body)
+	}
+	if got := w.Result().Header.Get("Content-Type"); got != "text/event-stream" {
+		t.Errorf("保活应写出 SSE 响应头，实际 %q", got)
+	}
+}
+
+// TestStreamKeepAlive_ErrorAfterCommit 响应头已写出后的错误转为各协议的 SSE 错误事件
+func TestStreamKeepAlive_ErrorAfterCommit(t *testing.T) {
+	tests := []struct {
+		name      string
+		format    WireFormat
+		status    int
+		wantEvent string
+		msgPath   string
+	}{
+		{"Anthropic 过载", WireFormatAnthropic, statusOverloaded, "event: error\n", "error.message"},
+		{"Anthropic 全部失败", WireFormatAnthropic, http.StatusBadGateway, "event: error\n", "error.message"},
+		{"Responses", WireFormatResponses, http.StatusBadGateway, "event: response.failed\n", "response.error.message"},
+		{"Chat Completions", WireFormatOpenAIChat, http.StatusBadGateway, "data: ", "error.message"},
+		{"Gemini", WireFormatGemini, http.StatusServiceUnavailable, "data: ", "error.message"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, w := newHedgeTestContext()
+			keepAlive := newStreamKeepAlive(c, tt.format, 10*time.Millisecond)
+			time.Sleep(30 * time.Millisecond)
+			c.JSON(tt.status, gin.H{"error": "all providers failed"})
+			keepAlive.stop()
+
+			if w.Code != http.StatusOK {
+				t.Errorf("响应头已由保活写出，状态码应为 200，实际 %d", w.Code)
+			}
+			body := w.Body.String()
+			if !strings.HasPrefix(body, string(keepAliveFrame(tt.format))) {
+				t.Errorf("错误前应已发送对应协议的保活帧，实际: %q", body)
+			}
+			idx := strings.LastIndex(body, tt.wantEvent)
+			if idx < 0 {
+				t.Fatalf("缺少错误事件 %q，实际: %q", tt.wantEvent, body)
+			}
+			data := strings.TrimSpace(body[strings.LastIndex(body, "data: ")+len("data: "):])
+			if msg := gjson.Get(data, tt.msgPath).String(); msg != "all providers failed" {
+				t.Errorf("错误信息不符，实际 %q（%s）", msg, data)
+			}
+			if tt.status == statusOverloaded && gjson.Get(data, "error.type").String() != "overloaded_error" {
+				t.Errorf("529 应转为 overloaded_error，实际 %s", data)
+			}
+		})
+	}
+}
+
+// TestStreamKeepAlive_FastResponse 上游在保活间隔内响应时不写任何保活帧，错误状态码原样返回
+func TestStreamKeepAlive_FastResponse(t *testing.T) {
+	c, w := newHedgeTestContext()
+	keepAlive := newStreamKeepAlive(c, WireFormatAnthropic, time.Hour)
+	c.JSON(http.StatusTooManyRequests, gin.H{"error": "rate limited"})
+	keepAlive.stop()
+
+	if w.Code != http.StatusTooManyRequests || strings.Contains(w.Body.String(), "ping") {
+		t.Errorf("应原样返回 429，实际 %d %q", w.Code, w.Body.String())
+	}
+	if got := w.Result().Header.Get("Content-Type"); !strings.HasPrefix(got, "application/json") {
+		t.Errorf("中转设置的响应头应生效，实际 %q", got)
+	}
+
+	var disabled *streamKeepAlive
+	disabled.stop()
+}
+
+// TestStreamKeepAlive_HeaderReadKeepsPinging 只读取响应头（如检查 Content-Type）不应停止保活
+func TestStreamKeepAlive_HeaderReadKeepsPinging(t *testing.T) {
+	c, w := newHedgeTestContext()
+	keepAlive := newStreamKeepAlive(c, WireFormatAnthropic, 20*time.Millisecond)
+	_ = c.Writer.Header().Get("Content-Type")
+	time.Sleep(70 * time.Millisecond)
+	keepAlive.stop()
+
+	if !strings.HasPrefix(w.Body.String(), string(keepAliveFrame(WireFormatAnthropic))) {
+		t.Errorf("读取响应头后仍应发送保活帧，实际: %q", w.Body.String())
+	}
+}
+
+// TestStreamKeepAliveInterval 流式保活默认关闭
+func TestStreamKeepAliveInterval(t *testing.T) {
+	relayService := NewProviderRelayService(NewProviderService(), nil, nil, nil, nil, "")
+	if got := relayService.streamKeepAliveInterval(); got != 0 {
+		t.Errorf("未加载配置时应关闭保活，实际 %s", got)
+	}
+	relayService.appSettings = &AppSettingsService{path: filepath.Join(t.TempDir(), "app.json")}
+	if got := relayService.streamKeepAliveInterval(); got != 0 {
+		t.Errorf("默认配置应关闭保活，实际 %s", got)
+	}
+	if _, err := relayService.appSettings.SaveAppSettings(AppSettings{EnableStreamKeepAlive: true}); err != nil {
+		t.Fatalf("保存配置失败: %v", err)
+	}
+	if got := relayService.streamKeepAliveInterval(); got != defaultStreamKeepAliveInterval {
+		t.Errorf("开启后未配置间隔时应使用默认间隔，实际 %s", got)
+	}
+}
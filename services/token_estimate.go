@@ -0,0 +1,106 @@
+package services
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"github.com/tidwall/gjson"
+)
+
+// 本地 token 估算（不依赖上游 tokenizer）
+// 用于 count_tokens 兜底等只需要近似值的场景：
+// - ASCII 字符约 4 个算 1 个 token
+// - 非 ASCII 字符（中文、日文等）约 1 个字符算 1 个 token
+// - 图片按固定开销计算（无法得知分辨率）
+
+const (
+	// estimateImageTokens 单张图片的估算 token 数（约等于 1092x1092 图片的 Claude 计费）
+	estimateImageTokens = 1600
+	// estimateMessageOverhead 每条消息/内容块的结构开销
+	estimateMessageOverhead = 4
+)
+
+// estimateTextTokens 估算一段文本的 token 数
+func estimateTextTokens(text string) int {
+	if text == "" {
+		return 0
+	}
+	ascii := 0
+	other := 0
+	for _, r := range text {
+		if r < utf8.RuneSelf {
+			ascii++
+		} else {
+			other++
+		}
+	}
+	return (ascii+3)/4 + other
+}
+
+// estimateRequestTokens 估算请求体的输入 token 数
+// 通用遍历 JSON：适用于 Anthropic / Chat Completions / Responses / Gemini 请求体
+// 只统计字符串值（键名与结构按固定开销计算），图片/文件的 base64 数据按固定开销计算
+func estimateRequestTokens(body []byte) int {
+	if len(body) == 0 || !gjson.ValidBytes(body) {
+		return 0
+	}
+	root := gjson.ParseBytes(body)
+	total := 0
+	root.ForEach(func(key, value gjson.Result) bool {
+		switch key.String() {
+		case "model", "stream", "max_tokens", "max_output_tokens", "temperature", "top_p", "top_k",
+			"metadata", "stream_options", "reasoning", "thinking", "generationConfig":
+			// 采样参数等不计入输入
+			return true
+		}
+		total += estimateValueTokens(key.String(), value)
+		return true
+	})
+	return total
+}
+
+// estimateValueTokens 递归估算 JSON 值的 token 数
+func estimateValueTokens(key string, value gjson.Result) int {
+	switch {
+	case value.IsObject():
+		// 图片：Anthropic image / Chat image_url / Responses input_image / Gemini inlineData
+		if isImageValue(value) {
+			return estimateImageTokens
+		}
+		total := estimateMessageOverhead
+		value.ForEach(func(k, v gjson.Result) bool {
+			total += estimateValueTokens(k.String(), v)
+			return true
+		})
+		return total
+	case value.IsArray():
+		total := 0
+		value.ForEach(func(_, v gjson.Result) bool {
+			total += estimateValueTokens(key, v)
+			return true
+		})
+		return total
+	case value.Type == gjson.String:
+		switch key {
+		case "type", "role", "id", "tool_use_id", "tool_call_id", "call_id", "media_type", "mimeType", "signature":
+			// 结构性字段只计固定开销
+			return 1
+		case "data", "url", "image_url":
+			if strings.HasPrefix(value.Str, "data:") || len(value.Str) > 1024 {
+				return estimateImageTokens
+			}
+		}
+		return estimateTextTokens(value.Str)
+	default:
+		return 1
+	}
+}
+
+// isImageValue 判断对象是否为图片/文件内容块
+func isImageValue(value gjson.Result) bool {
+	switch value.Get("type").String() {
+	case "image", "image_url", "input_image", "document", "input_file":
+		return true
+	}
+	return value.Get("inlineData").Exists() || value.Get("mimeType").Exists() && value.Get("data").Exists()
+}
@@ -0,0 +1,246 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 上游限流 / 过载冷却时长
+const (
+	defaultRateLimitCooldown = 30 * time.Second // 429 未给出 Retry-After 时
+	defaultOverloadCooldown  = 15 * time.Second // 529 未给出 Retry-After 时
+	maxUpstreamCooldown      = 10 * time.Minute // 上游提示过长时截断，避免长时间不可用
+)
+
+// statusOverloaded Anthropic 过载状态码（overloaded_error）
+const statusOverloaded = 529
+
+// upstreamStatusError 上游返回的非 2xx 响应
+type upstreamStatusError struct {
+	status      int
+	retryAfter  time.Duration // 上游 Retry-After 提示（0 = 未提供）
+	body        string        // 响应体预览
+	respBody    []byte        // 完整响应体（不切换 provider 时原样返回给客户端）
+	contentType string
+	// contentEncoding 响应体无法解压时保留的原始编码，原样返回时需带上（已解压为空）
+	contentEncoding string
+	// clientFormat 协议转换时客户端使用的协议格式，错误需转换为该格式后返回（未转换为空）
+	clientFormat WireFormat
+}
+
+func (e *upstreamStatusError) Error() string {
+	if e.body != "" {
+		return fmt.Sprintf("upstream status %d: %s", e.status, e.body)
+	}
+	return fmt.Sprintf("upstream status %d", e.status)
+}
+
+// parseRetryAfter 解析上游的重试提示：优先 retry-after-ms，其次 Retry-After（秒数或 HTTP 日期）
+func parseRetryAfter(header http.Header, now time.Time) time.Duration {
+	if ms, err := strconv.ParseFloat(strings.TrimSpace(header.Get("retry-after-ms")), 64); err == nil && ms > 0 {
+		return time.Duration(ms * float64(time.Millisecond))
+	}
+	value := strings.TrimSpace(header.Get("Retry-After"))
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		if seconds <= 0 {
+			return 0
+		}
+		return time.Duration(seconds * float64(time.Second))
+	}
+	if at, err := http.ParseTime(value); err == nil && at.After(now) {
+		return at.Sub(now)
+	}
+	return 0
+}
+
+// upstreamCooldown 一次上游限流 / 过载响应对应的冷却
+type upstreamCooldown struct {
+	modelScoped bool // true = 只冷却该模型，false = 冷却整个 provider
+	duration    time.Duration
+	statusCode  int
+}
+
+// cooldownForStatus 根据上游状态码决定冷却：
+//   - 429：额度通常按模型计算，只冷却该模型
+//   - 529 / 带 Retry-After 的 503：上游整体过载，冷却整个 provider
+//
+// 其他状态码返回 ok=false，按普通失败处理
+func cooldownForStatus(status int, retryAfter time.Duration) (cooldown upstreamCooldown, ok bool) {
+	switch {
+	case status == http.StatusTooManyRequests:
+		cooldown = upstreamCooldown{modelScoped: true, duration: defaultRateLimitCooldown, statusCode: status}
+	case status == statusOverloaded:
+		cooldown = upstreamCooldown{duration: defaultOverloadCooldown, statusCode: status}
+	case status == http.StatusServiceUnavailable && retryAfter > 0:
+		cooldown = upstreamCooldown{statusCode: status}
+	default:
+		return upstreamCooldown{}, false
+	}
+	if retryAfter > 0 {
+		cooldown.duration = retryAfter
+	}
+	if cooldown.duration > maxUpstreamCooldown {
+		cooldown.duration = maxUpstreamCooldown
+	}
+	return cooldown, true
+}
+
+// upstreamCooldownFromError 从 forwardRequest 的错误中提取冷却
+func upstreamCooldownFromError(err error) (upstreamCooldown, bool) {
+	var statusErr *upstreamStatusError
+	if !errors.As(err, &statusErr) {
+		return upstreamCooldown{}, false
+	}
+	return cooldownForStatus(statusErr.status, statusErr.retryAfter)
+}
+
+// CooldownStatus 冷却状态（用于前端展示）
+type CooldownStatus struct {
+	Model            string    `json:"model"` // 为空表示整个 provider 冷却
+	StatusCode       int       `json:"statusCode"`
+	Until            time.Time `json:"until"`
+	RemainingSeconds int       `json:"remainingSeconds"`
+}
+
+// cooldownEntry 单个 provider（或 provider+model）的冷却
+type cooldownEntry struct {
+	until      time.Time
+	statusCode int
+}
+
+// cooldownTracker 上游限流 / 过载冷却（仅内存，冷却时长通常只有几十秒，重启后无需恢复）
+// key 为 "platform|provider"，内层 key 为模型名（"" 表示整个 provider）
+type cooldownTracker struct {
+	mu      sync.Mutex
+	entries map[string]map[string]cooldownEntry
+	now     func() time.Time
+}
+
+func newCooldownTracker() *cooldownTracker {
+	return &cooldownTracker{entries: make(map[string]map[string]cooldownEntry), now: time.Now}
+}
+
+func cooldownKey(platform, providerName string) string {
+	return platform + "|" + providerName
+}
+
+// set 记录冷却（已有更长的冷却时保留较长者），返回冷却结束时间
+func (t *cooldownTracker) set(platform, providerName, model string, duration time.Duration, statusCode int) time.Time {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	key := cooldownKey(platform, providerName)
+	if t.entries[key] == nil {
+		t.entries[key] = make(map[string]cooldownEntry)
+	}
+	until := t.now().Add(duration)
+	if existing, ok := t.entries[key][model]; ok && existing.until.After(until) {
+		return existing.until
+	}
+	t.entries[key][model] = cooldownEntry{until: until, statusCode: statusCode}
+	return until
+}
+
+// active 返回 provider 对指定模型是否在冷却中（整个 provider 冷却或该模型冷却），以及最晚的结束时间
+func (t *cooldownTracker) active(platform, providerName, model string) (bool, *time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	key := cooldownKey(platform, providerName)
+	now := t.now()
+	var latest *time.Time
+	for _, scope := range []string{"", model} {
+		entry, ok := t.entries[key][scope]
+		if !ok {
+			continue
+		}
+		if !entry.until.After(now) {
+			delete(t.entries[key], scope)
+			continue
+		}
+		if latest == nil || entry.until.After(*latest) {
+			until := entry.until
+			latest = &until
+		}
+		if scope == model {
+			break // model 为空时两次查的是同一项
+		}
+	}
+	if len(t.entries[key]) == 0 {
+		delete(t.entries, key)
+	}
+	return latest != nil, latest
+}
+
+// list 返回平台下各 provider 未过期的冷却（provider 级排在前面，其余按模型名排序）
+func (t *cooldownTracker) list(platform string) map[string][]CooldownStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	prefix := platform + "|"
+	result := make(map[string][]CooldownStatus)
+	for key, models := range t.entries {
+		providerName, ok := strings.CutPrefix(key, prefix)
+		if !ok {
+			continue
+		}
+		for model, entry := range models {
+			if !entry.until.After(now) {
+				continue
+			}
+			result[providerName] = append(result[providerName], CooldownStatus{
+				Model:            model,
+				StatusCode:       entry.statusCode,
+				Until:            entry.until,
+				RemainingSeconds: int(entry.until.Sub(now).Seconds()),
+			})
+		}
+		sort.Slice(result[providerName], func(i, j int) bool {
+			return result[providerName][i].Model < result[providerName][j].Model
+		})
+	}
+	return result
+}
+
+// clear 清除 provider 的所有冷却
+func (t *cooldownTracker) clear(platform, providerName string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	key := cooldownKey(platform, providerName)
+	_, ok := t.entries[key]
+	delete(t.entries, key)
+	return ok
+}
+
+// RecordCooldown 上游限流 / 过载时让 provider（或只让该模型）冷却，不增加失败计数和拉黑等级
+// 返回是否实际记录了冷却（拉黑功能关闭时不记录）
+func (bs *BlacklistService) RecordCooldown(platform string, providerName string, model string, duration time.Duration, statusCode int) bool {
+	if !bs.settingsService.IsBlacklistEnabled() {
+		log.Printf("🚫 拉黑功能已关闭，跳过 provider %s/%s 的冷却记录", platform, providerName)
+		return false
+	}
+	until := bs.cooldowns.set(platform, providerName, model, duration, statusCode)
+	scope := "整个 provider"
+	if model != "" {
+		scope = "模型 " + model
+	}
+	log.Printf("🧊 Provider %s/%s 上游返回 %d，%s冷却至 %s（不计入失败次数）",
+		platform, providerName, statusCode, scope, until.Format("15:04:05"))
+	return true
+}
+
+// IsCoolingDown 检查 provider 对指定模型是否在冷却中
+func (bs *BlacklistService) IsCoolingDown(platform string, providerName string, model string) (bool, *time.Time) {
+	if !bs.settingsService.IsBlacklistEnabled() {
+		return false, nil
+	}
+	return bs.cooldowns.active(platform, providerName, model)
+}
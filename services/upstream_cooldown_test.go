@@ -0,0 +1,176 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// ==================== 上游限流冷却测试 ====================
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		headers  map[string]string
+		expected time.Duration
+	}{
+		{"未提供", nil, 0},
+		{"秒数", map[string]string{"Retry-After": "20"}, 20 * time.Second},
+		{"HTTP 日期", map[string]string{"Retry-After": now.Add(90 * time.Second).Format(http.TimeFormat)}, 90 * time.Second},
+		{"过去的日期", map[string]string{"Retry-After": now.Add(-time.Minute).Format(http.TimeFormat)}, 0},
+		{"毫秒优先", map[string]string{"Retry-After": "20", "retry-after-ms": "1500"}, 1500 * time.Millisecond},
+		{"无法解析", map[string]string{"Retry-After": "soon"}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			for k, v := range tt.headers {
+				header.Set(k, v)
+			}
+			if got := parseRetryAfter(header, now); got != tt.expected {
+				t.Errorf("期望 %v，实际 %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestCooldownForStatus(t *testing.T) {
+	tests := []struct {
+		name        string
+		status      int
+		retryAfter  time.Duration
+		ok          bool
+		modelScoped bool
+		duration    time.Duration
+	}{
+		{"429 使用 Retry-After", http.StatusTooManyRequests, 20 * time.Second, true, true, 20 * time.Second},
+		{"429 默认时长", http.StatusTooManyRequests, 0, true, true, defaultRateLimitCooldown},
+		{"529 冷却整个 provider", statusOverloaded, 0, true, false, defaultOverloadCooldown},
+		{"503 带 Retry-After", http.StatusServiceUnavailable, 5 * time.Second, true, false, 5 * time.Second},
+		{"503 无 Retry-After 按普通失败", http.StatusServiceUnavailable, 0, false, false, 0},
+		{"500 按普通失败", http.StatusInternalServerError, 10 * time.Second, false, false, 0},
+		{"提示过长时截断", http.StatusTooManyRequests, time.Hour, true, true, maxUpstreamCooldown},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cooldown, ok := cooldownForStatus(tt.status, tt.retryAfter)
+			if ok != tt.ok {
+				t.Fatalf("期望 ok=%v，实际 %v", tt.ok, ok)
+			}
+			if !ok {
+				return
+			}
+			if cooldown.modelScoped != tt.modelScoped || cooldown.duration != tt.duration || cooldown.statusCode != tt.status {
+				t.Errorf("冷却不符合预期: %+v", cooldown)
+			}
+		})
+	}
+
+	// 包装后的错误同样能识别
+	wrapped := fmt.Errorf("attempt failed: %w", &upstreamStatusError{status: http.StatusTooManyRequests, retryAfter: 3 * time.Second})
+	if cooldown, ok := upstreamCooldownFromError(wrapped); !ok || cooldown.duration != 3*time.Second {
+		t.Errorf("应从包装错误中识别冷却，实际 %+v ok=%v", cooldown, ok)
+	}
+	if _, ok := upstreamCooldownFromError(errors.New("connection reset")); ok {
+		t.Errorf("网络错误不应进入冷却")
+	}
+}
+
+func TestCooldownTracker(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	tracker := newCooldownTracker()
+	tracker.now = func() time.Time { return now }
+
+	tracker.set("claude", "p1", "claude-opus-4-1", 20*time.Second, http.StatusTooManyRequests)
+	if active, _ := tracker.active("claude", "p1", "claude-opus-4-1"); !active {
+		t.Fatalf("该模型应在冷却中")
+	}
+	if active, _ := tracker.active("claude", "p1", "claude-sonnet-4"); active {
+		t.Errorf("模型级冷却不应影响其他模型")
+	}
+
+	// 更短的冷却不覆盖已有的更长冷却
+	if until := tracker.set("claude", "p1", "claude-opus-4-1", 5*time.Second, http.StatusTooManyRequests); !until.Equal(now.Add(20 * time.Second)) {
+		t.Errorf("应保留较长的冷却，实际结束时间 %v", until)
+	}
+
+	tracker.set("claude", "p2", "", 10*time.Second, statusOverloaded)
+	if active, until := tracker.active("claude", "p2", "any-model"); !active || !until.Equal(now.Add(10*time.Second)) {
+		t.Errorf("provider 级冷却应覆盖所有模型，实际 active=%v until=%v", active, until)
+	}
+
+	statuses := tracker.list("claude")
+	if len(statuses) != 2 || statuses["p1"][0].Model != "claude-opus-4-1" || statuses["p2"][0].StatusCode != statusOverloaded {
+		t.Errorf("冷却列表不符合预期: %+v", statuses)
+	}
+	if len(tracker.list("codex")) != 0 {
+		t.Errorf("不同平台应分开记录")
+	}
+
+	now = now.Add(15 * time.Second)
+	if active, _ := tracker.active("claude", "p2", "any-model"); active {
+		t.Errorf("冷却到期后应自动解除")
+	}
+	if !tracker.clear("claude", "p1") {
+		t.Errorf("清除存在的冷却应返回 true")
+	}
+	if active, _ := tracker.active("claude", "p1", "claude-opus-4-1"); active {
+		t.Errorf("手动清除后不应再冷却")
+	}
+}
+
+// TestRecordProviderFailure_Cooldown 429 只冷却该模型，529 冷却整个 provider，都不计入失败次数
+func TestRecordProviderFailure_Cooldown(t *testing.T) {
+	blacklistService := NewBlacklistService(NewSettingsService(), NewNotificationService(nil))
+	relayService := NewProviderRelayService(NewProviderService(), nil, blacklistService, nil, nil, "")
+
+	rateLimited := &upstreamStatusError{status: http.StatusTooManyRequests, retryAfter: 20 * time.Second}
+	if !relayService.recordProviderFailure("claude", "p1", "claude-opus-4-1", rateLimited).coolingDown {
+		t.Fatalf("429 应进入冷却")
+	}
+	if coolingDown, _ := blacklistService.IsCoolingDown("claude", "p1", "claude-opus-4-1"); !coolingDown {
+		t.Errorf("429 后该模型应在冷却中")
+	}
+	if coolingDown, _ := blacklistService.IsCoolingDown("claude", "p1", "claude-haiku-4-5"); coolingDown {
+		t.Errorf("429 不应冷却其他模型")
+	}
+
+	overloaded := &upstreamStatusError{status: statusOverloaded}
+	if !relayService.recordProviderFailure("claude", "p2", "claude-opus-4-1", overloaded).coolingDown {
+		t.Fatalf("529 应进入冷却")
+	}
+	if coolingDown, _ := blacklistService.IsCoolingDown("claude", "p2", "claude-haiku-4-5"); !coolingDown {
+		t.Errorf("529 应冷却整个 provider")
+	}
+}
+
+// TestForwardRequest_RetryAfter 非 2xx 响应返回 upstreamStatusError，并带上 Retry-After 提示
+func TestForwardRequest_RetryAfter(t *testing.T) {
+	upstreamServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "20")
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte(`{"type":"error","error":{"type":"rate_limit_error"}}`))
+	}))
+	defer upstreamServer.Close()
+
+	relayService := NewProviderRelayService(NewProviderService(), nil, nil, nil, nil, "")
+	provider := Provider{Name: "limited", APIURL: upstreamServer.URL, APIKey: "k", Enabled: true}
+
+	c, _ := newHedgeTestContext()
+	ok, err := relayService.forwardRequest(c, "claude", provider, "/v1/messages", nil, http.Header{},
+		[]byte(`{"model":"claude-sonnet-4"}`), false, "claude-sonnet-4", AuthMethodXAPIKey)
+	if ok {
+		t.Fatalf("429 不应判定为成功")
+	}
+	var statusErr *upstreamStatusError
+	if !errors.As(err, &statusErr) {
+		t.Fatalf("期望 upstreamStatusError，实际 %T: %v", err, err)
+	}
+	if statusErr.status != http.StatusTooManyRequests || statusErr.retryAfter != 20*time.Second {
+		t.Errorf("状态码或 Retry-After 不符合预期: %+v", statusErr)
+	}
+}